@@ -0,0 +1,713 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAuditLogPath is where auditd writes its log.
+	DefaultAuditLogPath = "/var/log/audit/audit.log"
+	// DefaultAuditTimeout is how long the records of an audit event that
+	// was not terminated by an EOE record are collected.
+	DefaultAuditTimeout = 2 * time.Second
+)
+
+var errAuditRecord = errors.New("audit: malformed record")
+
+// auditTypes maps the record type names written by auditd to their
+// numbers, which become the event ID.
+var auditTypes = map[string]uint32{
+	"LOGIN":               1006,
+	"USER_AUTH":           1100,
+	"USER_ACCT":           1101,
+	"USER_MGMT":           1102,
+	"CRED_ACQ":            1103,
+	"CRED_DISP":           1104,
+	"USER_START":          1105,
+	"USER_END":            1106,
+	"USER_AVC":            1107,
+	"USER_CHAUTHTOK":      1108,
+	"USER_ERR":            1109,
+	"CRED_REFR":           1110,
+	"USYS_CONFIG":         1111,
+	"USER_LOGIN":          1112,
+	"USER_LOGOUT":         1113,
+	"ADD_USER":            1114,
+	"DEL_USER":            1115,
+	"ADD_GROUP":           1116,
+	"DEL_GROUP":           1117,
+	"USER_CMD":            1123,
+	"USER_TTY":            1124,
+	"CHGRP_ID":            1125,
+	"SERVICE_START":       1130,
+	"SERVICE_STOP":        1131,
+	"DAEMON_START":        1200,
+	"DAEMON_END":          1201,
+	"DAEMON_ABORT":        1202,
+	"DAEMON_CONFIG":       1203,
+	"DAEMON_ROTATE":       1205,
+	"DAEMON_RESUME":       1206,
+	"SYSCALL":             1300,
+	"PATH":                1302,
+	"IPC":                 1303,
+	"SOCKETCALL":          1304,
+	"CONFIG_CHANGE":       1305,
+	"SOCKADDR":            1306,
+	"CWD":                 1307,
+	"EXECVE":              1309,
+	"EOE":                 1320,
+	"BPRM_FCAPS":          1321,
+	"CAPSET":              1322,
+	"MMAP":                1323,
+	"NETFILTER_PKT":       1324,
+	"NETFILTER_CFG":       1325,
+	"SECCOMP":             1326,
+	"PROCTITLE":           1327,
+	"KERN_MODULE":         1330,
+	"BPF":                 1334,
+	"AVC":                 1400,
+	"SELINUX_ERR":         1401,
+	"MAC_STATUS":          1404,
+	"ANOM_PROMISCUOUS":    1700,
+	"ANOM_ABEND":          1701,
+	"ANOM_LINK":           1702,
+	"ANOM_LOGIN_FAILURES": 2100,
+	"ANOM_LOGIN_TIME":     2101,
+	"ANOM_EXEC":           2114,
+	"USER_ROLE_CHANGE":    2300,
+	"ROLE_ASSIGN":         2301,
+	"ROLE_REMOVE":         2302,
+	"VIRT_CONTROL":        2500,
+	"VIRT_RESOURCE":       2501,
+	"VIRT_MACHINE_ID":     2502,
+}
+
+// auditHexFields are the fields holding untrusted strings, which auditd
+// writes hex-encoded instead of quoted when they contain spaces, quotes or
+// control characters.
+var auditHexFields = map[string]bool{
+	"acct":      true,
+	"cmd":       true,
+	"comm":      true,
+	"cwd":       true,
+	"data":      true,
+	"exe":       true,
+	"key":       true,
+	"name":      true,
+	"ocomm":     true,
+	"path":      true,
+	"proctitle": true,
+	"root_dir":  true,
+}
+
+// AuditRecord is one line of an audit log, such as
+//
+//	type=SYSCALL msg=audit(1364481363.243:24287): arch=c000003e syscall=2 ...
+//
+// Fields of the nested msg='...' of user space records are flattened into
+// Fields, hex-encoded strings are decoded and EXECVE arguments split over
+// several aN[M] fields are joined.
+type AuditRecord struct {
+	Type      string
+	Node      string
+	Timestamp time.Time
+	Serial    uint64
+	Fields    []EventData
+	Raw       string
+}
+
+// Field returns the value of the first field called name.
+func (r *AuditRecord) Field(name string) (string, bool) {
+	for _, f := range r.Fields {
+		if f.Name == name {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// TypeID returns the number of the record type, or 0 when it is not known.
+// Types without a name are written as "UNKNOWN[1334]".
+func (r *AuditRecord) TypeID() uint32 {
+	if id, ok := auditTypes[r.Type]; ok {
+		return id
+	}
+	if strings.HasPrefix(r.Type, "UNKNOWN[") && strings.HasSuffix(r.Type, "]") {
+		if id, err := strconv.ParseUint(r.Type[8:len(r.Type)-1], 10, 32); err == nil {
+			return uint32(id)
+		}
+	}
+	return 0
+}
+
+// standalone reports whether the record is a whole event by itself: user
+// space and daemon messages are never followed by an EOE record.
+func (r *AuditRecord) standalone() bool {
+	id := r.TypeID()
+	return id >= 1100 && id < 1300 || id >= 2100 && id < 3000
+}
+
+type auditField struct {
+	name, value string
+	quoted      bool
+}
+
+// splitAuditFields splits s into name=value pairs. Values are either
+// unquoted, "double quoted" or 'single quoted'; the GS character
+// separating the fields interpreted by enriched logs counts as a space.
+func splitAuditFields(s string) []auditField {
+	var fields []auditField
+	isSpace := func(c byte) bool { return c == ' ' || c == '\x1d' }
+	for i := 0; i < len(s); {
+		if isSpace(s[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(s) && s[i] != '=' && !isSpace(s[i]) {
+			i++
+		}
+		if i == len(s) || s[i] != '=' {
+			continue // a bare word, e.g. of a free-form msg
+		}
+		f := auditField{name: s[start:i]}
+		i++
+		if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+			q := s[i]
+			end := strings.IndexByte(s[i+1:], q)
+			if end < 0 {
+				end = len(s) - i - 1
+			}
+			f.value, f.quoted = s[i+1:i+1+end], true
+			i += end + 2
+		} else {
+			start = i
+			for i < len(s) && !isSpace(s[i]) {
+				i++
+			}
+			f.value = s[start:i]
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// ParseAuditRecord parses one audit log line.
+func ParseAuditRecord(line []byte) (*AuditRecord, error) {
+	r := &AuditRecord{Raw: strings.TrimRight(string(line), "\r\n")}
+	fields := splitAuditFields(r.Raw)
+	header := false
+	for len(fields) > 0 && !header {
+		f := fields[0]
+		fields = fields[1:]
+		switch f.name {
+		case "node":
+			r.Node = f.value
+		case "type":
+			r.Type = f.value
+		case "msg":
+			if err := r.parseHeader(f.value); err != nil {
+				return nil, err
+			}
+			header = true
+		default:
+			return nil, errAuditRecord
+		}
+	}
+	if !header || r.Type == "" {
+		return nil, errAuditRecord
+	}
+	r.addFields(fields)
+	return r, nil
+}
+
+// parseHeader parses "audit(1364481363.243:24287):".
+func (r *AuditRecord) parseHeader(v string) error {
+	v = strings.TrimSuffix(v, ":")
+	if !strings.HasPrefix(v, "audit(") || !strings.HasSuffix(v, ")") {
+		return errAuditRecord
+	}
+	v = v[6 : len(v)-1]
+	colon := strings.IndexByte(v, ':')
+	if colon < 0 {
+		return errAuditRecord
+	}
+	serial, err := strconv.ParseUint(v[colon+1:], 10, 64)
+	if err != nil {
+		return errAuditRecord
+	}
+	sec, frac := v[:colon], "0"
+	if dot := strings.IndexByte(sec, '.'); dot >= 0 {
+		sec, frac = sec[:dot], sec[dot+1:]
+	}
+	secs, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil || len(frac) > 9 || !isDigits(frac) {
+		return errAuditRecord
+	}
+	nsec, _ := strconv.Atoi((frac + "000000000")[:9])
+	r.Timestamp = time.Unix(secs, int64(nsec))
+	r.Serial = serial
+	return nil
+}
+
+func (r *AuditRecord) addFields(fields []auditField) {
+	for _, f := range fields {
+		if f.name == "msg" && f.quoted {
+			r.addFields(splitAuditFields(f.value))
+			continue
+		}
+		value := f.value
+		if !f.quoted && auditHexFields[f.name] {
+			value = decodeAuditHex(value)
+		}
+		name := f.name
+		if r.Type == "EXECVE" && isExecveArg(name) {
+			if !f.quoted {
+				value = decodeAuditHex(value)
+			}
+			if i := strings.IndexByte(name, '['); i > 0 {
+				// A long argument is split as a1_len=N a1[0]=... a1[1]=...
+				name = name[:i]
+				if r.appendField(name, value) {
+					continue
+				}
+			}
+		}
+		if r.Type == "PROCTITLE" && name == "proctitle" {
+			value = strings.TrimRight(strings.ReplaceAll(value, "\x00", " "), " ")
+		}
+		r.Fields = append(r.Fields, EventData{Name: name, Value: value})
+	}
+}
+
+// isExecveArg reports whether name is "aN" or a part "aN[M]" of a long
+// argument.
+func isExecveArg(name string) bool {
+	if i := strings.IndexByte(name, '['); i > 0 && strings.HasSuffix(name, "]") {
+		if !isDigits(name[i+1 : len(name)-1]) {
+			return false
+		}
+		name = name[:i]
+	}
+	return len(name) > 1 && name[0] == 'a' && isDigits(name[1:])
+}
+
+func (r *AuditRecord) appendField(name, value string) bool {
+	for i := range r.Fields {
+		if r.Fields[i].Name == name {
+			r.Fields[i].Value += value
+			return true
+		}
+	}
+	return false
+}
+
+// decodeAuditHex decodes v when it is hex-encoded and returns it unchanged
+// otherwise, as for "(null)" or quoted-looking values.
+func decodeAuditHex(v string) string {
+	if len(v) == 0 || len(v)%2 != 0 {
+		return v
+	}
+	b, err := hex.DecodeString(v)
+	if err != nil {
+		return v
+	}
+	return string(b)
+}
+
+// AuditEvent is the group of records sharing an audit(timestamp:serial)
+// identifier, in log order. The terminating EOE record is not included.
+type AuditEvent struct {
+	Node      string
+	Timestamp time.Time
+	Serial    uint64
+	Records   []*AuditRecord
+}
+
+// Raw returns the log lines of the event.
+func (e *AuditEvent) Raw() []byte {
+	var b strings.Builder
+	for i, r := range e.Records {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(r.Raw)
+	}
+	return []byte(b.String())
+}
+
+// Command returns the command line of the audited process from the EXECVE
+// record, or else from the PROCTITLE record, which the kernel truncates.
+func (e *AuditEvent) Command() string {
+	var title string
+	for _, r := range e.Records {
+		switch r.Type {
+		case "EXECVE":
+			argc, _ := r.Field("argc")
+			n, _ := strconv.Atoi(argc)
+			args := make([]string, 0, n)
+			for i := 0; i < n; i++ {
+				a, _ := r.Field("a" + strconv.Itoa(i))
+				args = append(args, a)
+			}
+			return strings.Join(args, " ")
+		case "PROCTITLE":
+			title, _ = r.Field("proctitle")
+		}
+	}
+	return title
+}
+
+// Event converts e to an Event from the "auditd" provider whose event ID
+// is the type of the first record. The fields of the first record are
+// kept as data under their own names, those of the others prefixed with
+// the record type, e.g. "CWD.cwd", and with the item number or position
+// for repeated types, e.g. "PATH[1].name". Results reported by success=
+// or res= set the audit success or failure keyword.
+func (e *AuditEvent) Event() *Event {
+	ev := &Event{
+		Provider:    "auditd",
+		Level:       LevelInfo,
+		Computer:    e.Node,
+		TimeCreated: e.Timestamp,
+		RecordID:    e.Serial,
+	}
+	if len(e.Records) == 0 {
+		return ev
+	}
+	first := e.Records[0]
+	ev.EventID = first.TypeID()
+	ev.Message = first.Type
+	if cmd := e.Command(); cmd != "" {
+		ev.Message += ": " + cmd
+	} else if exe, ok := first.Field("exe"); ok {
+		ev.Message += ": " + exe
+	}
+	if pid, err := strconv.ParseUint(fieldOf(first, "pid"), 10, 32); err == nil {
+		ev.ProcessID = uint32(pid)
+	}
+	ev.UserID = fieldOf(first, "auid")
+	if ev.UserID == "" || ev.UserID == "4294967295" || ev.UserID == "-1" || ev.UserID == "unset" {
+		ev.UserID = fieldOf(first, "uid")
+	}
+	switch auditResult(first) {
+	case "success":
+		ev.Keywords = keywordAuditSuccess
+		ev.Level = LevelFromEventType(EVENTLOG_AUDIT_SUCCESS)
+	case "failure":
+		ev.Keywords = keywordAuditFailure
+		ev.Level = LevelFromEventType(EVENTLOG_AUDIT_FAILURE)
+	}
+
+	count := map[string]int{}
+	for _, r := range e.Records[1:] {
+		count[r.Type]++
+	}
+	pos := map[string]int{}
+	for i, r := range e.Records {
+		prefix := ""
+		if i > 0 {
+			prefix = r.Type
+			if item, ok := r.Field("item"); ok {
+				prefix += "[" + item + "]"
+			} else if count[r.Type] > 1 {
+				prefix += "[" + strconv.Itoa(pos[r.Type]) + "]"
+			}
+			pos[r.Type]++
+			prefix += "."
+		}
+		for _, f := range r.Fields {
+			ev.Data = append(ev.Data, EventData{Name: prefix + f.Name, Value: f.Value})
+		}
+	}
+	return ev
+}
+
+func fieldOf(r *AuditRecord, name string) string {
+	v, _ := r.Field(name)
+	return v
+}
+
+// auditResult returns "success", "failure" or "" for records without a
+// result.
+func auditResult(r *AuditRecord) string {
+	v, ok := r.Field("success")
+	if !ok {
+		v, ok = r.Field("res")
+	}
+	if !ok {
+		return ""
+	}
+	switch v {
+	case "yes", "success", "1":
+		return "success"
+	case "no", "failed", "0":
+		return "failure"
+	}
+	return ""
+}
+
+type auditKey struct {
+	node   string
+	serial uint64
+	time   int64
+}
+
+type pendingAudit struct {
+	event *AuditEvent
+	since time.Time
+}
+
+// AuditAssembler groups audit records into events. Kernel events end with
+// an EOE record and user space messages are single records; events missing
+// their EOE, because it was lost or never written, are completed once
+// Timeout has passed since their first record.
+type AuditAssembler struct {
+	Timeout time.Duration
+
+	pending map[auditKey]*pendingAudit
+}
+
+// NewAuditAssembler creates an AuditAssembler; a zero timeout selects
+// DefaultAuditTimeout.
+func NewAuditAssembler(timeout time.Duration) *AuditAssembler {
+	if timeout <= 0 {
+		timeout = DefaultAuditTimeout
+	}
+	return &AuditAssembler{Timeout: timeout, pending: map[auditKey]*pendingAudit{}}
+}
+
+// Add adds a record received at now and returns the event it completes, if
+// any.
+func (a *AuditAssembler) Add(r *AuditRecord, now time.Time) *AuditEvent {
+	key := auditKey{node: r.Node, serial: r.Serial, time: r.Timestamp.UnixNano()}
+	p := a.pending[key]
+	if r.Type == "EOE" {
+		if p == nil {
+			return nil
+		}
+		delete(a.pending, key)
+		return p.event
+	}
+	if p == nil {
+		ev := &AuditEvent{Node: r.Node, Timestamp: r.Timestamp, Serial: r.Serial}
+		if r.standalone() {
+			ev.Records = []*AuditRecord{r}
+			return ev
+		}
+		p = &pendingAudit{event: ev, since: now}
+		a.pending[key] = p
+	}
+	p.event.Records = append(p.event.Records, r)
+	return nil
+}
+
+// Expire returns the events whose timeout has passed at now, ordered by
+// timestamp and serial.
+func (a *AuditAssembler) Expire(now time.Time) []*AuditEvent {
+	var events []*AuditEvent
+	for key, p := range a.pending {
+		if now.Sub(p.since) >= a.Timeout {
+			events = append(events, p.event)
+			delete(a.pending, key)
+		}
+	}
+	sortAuditEvents(events)
+	return events
+}
+
+// Flush returns all incomplete events.
+func (a *AuditAssembler) Flush() []*AuditEvent {
+	events := make([]*AuditEvent, 0, len(a.pending))
+	for key, p := range a.pending {
+		events = append(events, p.event)
+		delete(a.pending, key)
+	}
+	sortAuditEvents(events)
+	return events
+}
+
+func sortAuditEvents(events []*AuditEvent) {
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Timestamp.Equal(events[j].Timestamp) {
+			return events[i].Timestamp.Before(events[j].Timestamp)
+		}
+		return events[i].Serial < events[j].Serial
+	})
+}
+
+// AuditReader reads the events of an audit log, e.g. a rotated
+// audit.log.1. Timeouts are measured with the record timestamps.
+type AuditReader struct {
+	r     *bufio.Reader
+	asm   *AuditAssembler
+	ready []*AuditEvent
+	err   error
+}
+
+// NewAuditReader creates an AuditReader reading r.
+func NewAuditReader(r io.Reader) *AuditReader {
+	return &AuditReader{r: bufio.NewReader(r), asm: NewAuditAssembler(0)}
+}
+
+// Next returns the next event, or io.EOF after the last one. Malformed
+// lines are skipped.
+func (ar *AuditReader) Next() (*AuditEvent, error) {
+	for len(ar.ready) == 0 {
+		if ar.err != nil {
+			return nil, ar.err
+		}
+		line, err := ar.r.ReadBytes('\n')
+		if err != nil {
+			ar.err = err
+		}
+		if len(line) > 0 {
+			if rec, perr := ParseAuditRecord(line); perr == nil {
+				ar.ready = append(ar.ready, ar.asm.Expire(rec.Timestamp)...)
+				if ev := ar.asm.Add(rec, rec.Timestamp); ev != nil {
+					ar.ready = append(ar.ready, ev)
+				}
+			}
+		}
+		if err != nil {
+			// The last line may lack its LF; it was added above, so it
+			// is flushed with the rest of its event.
+			ar.ready = append(ar.ready, ar.asm.Flush()...)
+		}
+	}
+	ev := ar.ready[0]
+	ar.ready = ar.ready[1:]
+	return ev, nil
+}
+
+// AuditdConfig configures an AuditdSource.
+type AuditdConfig struct {
+	// Path defaults to DefaultAuditLogPath. The file is followed across
+	// rotation.
+	Path string
+	// FromStart makes the source emit the events already in the file; by
+	// default only new ones are.
+	FromStart bool
+	// Timeout defaults to DefaultAuditTimeout.
+	Timeout time.Duration
+	// PollInterval defaults to DefaultTailPollInterval.
+	PollInterval time.Duration
+}
+
+// AuditdSource is a Source following an auditd log. It emits one entry per
+// audit event, whose Buffer holds the log lines of all its records.
+type AuditdSource struct {
+	cfg  AuditdConfig
+	tail *fileTailer
+	asm  *AuditAssembler
+
+	mu        sync.Mutex
+	listening bool
+	closed    bool
+	done      chan struct{}
+}
+
+// NewAuditdSource creates an AuditdSource.
+func NewAuditdSource(cfg AuditdConfig) *AuditdSource {
+	if cfg.Path == "" {
+		cfg.Path = DefaultAuditLogPath
+	}
+	return &AuditdSource{cfg: cfg, asm: NewAuditAssembler(cfg.Timeout), done: make(chan struct{})}
+}
+
+// Init opens the log.
+func (s *AuditdSource) Init() error {
+	t, err := openFileTailer(s.cfg.Path, s.cfg.FromStart, s.cfg.PollInterval)
+	if err != nil {
+		return err
+	}
+	s.tail = t
+	return nil
+}
+
+// Listen emits events until ctx is done or Close is called. Events still
+// being assembled at that point are dropped.
+func (s *AuditdSource) Listen(ctx context.Context, emit EmitFunc) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.listening = true
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	lines := make(chan []byte)
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		for {
+			line, err := s.tail.next(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	defer func() {
+		cancel()
+		<-readerDone
+		s.tail.close()
+		s.Close()
+	}()
+
+	ticker := time.NewTicker(s.asm.Timeout / 4)
+	defer ticker.Stop()
+	deliver := func(ev *AuditEvent) bool {
+		return emit(ctx, &EventEntry{Buffer: ev.Raw(), Event: ev.Event()}) == nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		case line := <-lines:
+			rec, err := ParseAuditRecord(line)
+			if err != nil {
+				continue
+			}
+			if ev := s.asm.Add(rec, time.Now()); ev != nil && !deliver(ev) {
+				return
+			}
+		case now := <-ticker.C:
+			for _, ev := range s.asm.Expire(now) {
+				if !deliver(ev) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close stops the source.
+func (s *AuditdSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	if !s.listening && s.tail != nil {
+		s.tail.close()
+	}
+}
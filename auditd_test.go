@@ -0,0 +1,225 @@
+package eventwatcher
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAuditLog holds a syscall event split over five records plus EOE, a
+// user space login and a syscall event without EOE.
+var testAuditLog = strings.Join([]string{
+	`type=SYSCALL msg=audit(1710072000.123:100): arch=c000003e syscall=257 success=no exit=-13 a0=ffffff9c a1=7ffd items=1 ppid=900 pid=901 auid=1000 uid=1000 gid=1000 comm="cat" exe="/usr/bin/cat" key="shadow"` + "\x1d" + `ARCH=x86_64 SYSCALL=openat AUID="alice" UID="alice"`,
+	`type=EXECVE msg=audit(1710072000.123:100): argc=3 a0="cat" a1_len=12 a1[0]="/etc/" a1[1]="shadow" a2=2D2D68656C70206D65`,
+	`type=CWD msg=audit(1710072000.123:100): cwd=2F686F6D652F616C69636520646F6373`,
+	`type=PATH msg=audit(1710072000.123:100): item=0 name="/etc/shadow" inode=1234 nametype=NORMAL`,
+	`node=web01 type=USER_LOGIN msg=audit(1710072001.000:101): pid=812 uid=0 auid=4294967295 ses=4294967295 msg='op=login acct="root" exe="/usr/sbin/sshd" hostname=10.0.0.5 addr=10.0.0.5 terminal=ssh res=failed'`,
+	`type=PROCTITLE msg=audit(1710072000.123:100): proctitle=636174002F6574632F736861646F77`,
+	`type=EOE msg=audit(1710072000.123:100): `,
+	`not an audit record`,
+	`type=SYSCALL msg=audit(1710072002.500:102): arch=c000003e syscall=59 success=yes exit=0 pid=950 auid=1000 uid=0 comm="id" exe="/usr/bin/id" key=(null)`,
+	`type=PATH msg=audit(1710072002.500:102): item=0 name="/usr/bin/id"`,
+	`type=PATH msg=audit(1710072002.500:102): item=1 name="/lib64/ld-linux-x86-64.so.2"`,
+	`type=DAEMON_ROTATE msg=audit(1710072009.000:103): op=rotate-logs auid=0 pid=1 res=success`,
+}, "\n") + "\n"
+
+func TestParseAuditRecord(t *testing.T) {
+	lines := strings.Split(testAuditLog, "\n")
+	r, err := ParseAuditRecord([]byte(lines[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Type != "SYSCALL" || r.TypeID() != 1300 || r.Serial != 100 {
+		t.Fatalf("header: %+v", r)
+	}
+	if !r.Timestamp.Equal(time.Unix(1710072000, 123000000)) {
+		t.Fatalf("timestamp: %v", r.Timestamp)
+	}
+	for name, want := range map[string]string{"comm": "cat", "key": "shadow", "a0": "ffffff9c", "AUID": "alice"} {
+		if v, _ := r.Field(name); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+
+	r, _ = ParseAuditRecord([]byte(lines[1]))
+	for name, want := range map[string]string{"a1": "/etc/shadow", "a2": "--help me", "argc": "3", "a1_len": "12"} {
+		if v, _ := r.Field(name); v != want {
+			t.Errorf("EXECVE %s = %q, want %q", name, v, want)
+		}
+	}
+	r, _ = ParseAuditRecord([]byte(lines[2]))
+	if v, _ := r.Field("cwd"); v != "/home/alice docs" {
+		t.Errorf("cwd = %q", v)
+	}
+	r, _ = ParseAuditRecord([]byte(lines[4]))
+	if r.Node != "web01" || !r.standalone() {
+		t.Fatalf("user record: %+v", r)
+	}
+	for name, want := range map[string]string{"op": "login", "acct": "root", "res": "failed", "uid": "0"} {
+		if v, _ := r.Field(name); v != want {
+			t.Errorf("USER_LOGIN %s = %q, want %q", name, v, want)
+		}
+	}
+	r, _ = ParseAuditRecord([]byte(lines[5]))
+	if v, _ := r.Field("proctitle"); v != "cat /etc/shadow" {
+		t.Errorf("proctitle = %q", v)
+	}
+	r, _ = ParseAuditRecord([]byte(`type=UNKNOWN[1336] msg=audit(1.5:7): x=1`))
+	if r == nil || r.TypeID() != 1336 || !r.Timestamp.Equal(time.Unix(1, 5e8)) {
+		t.Fatalf("unknown type: %+v", r)
+	}
+
+	for _, bad := range []string{"", lines[7], `type=SYSCALL msg=audit(x:1): a=1`, `type=SYSCALL arch=1`, `msg=audit(1.0:1): a=1`} {
+		if _, err := ParseAuditRecord([]byte(bad)); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestAuditReader(t *testing.T) {
+	ar := NewAuditReader(strings.NewReader(testAuditLog))
+	var events []*AuditEvent
+	for {
+		ev, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) != 4 {
+		t.Fatalf("got %d events", len(events))
+	}
+	// The login completes first, the interleaved syscall event on its EOE.
+	if events[0].Serial != 101 || events[1].Serial != 100 || events[2].Serial != 102 || events[3].Serial != 103 {
+		t.Fatalf("order: %d %d %d %d", events[0].Serial, events[1].Serial, events[2].Serial, events[3].Serial)
+	}
+	if len(events[1].Records) != 5 || len(events[2].Records) != 3 {
+		t.Fatalf("records: %d %d", len(events[1].Records), len(events[2].Records))
+	}
+
+	ev := events[1].Event()
+	if ev.Provider != "auditd" || ev.EventID != 1300 || ev.RecordID != 100 || ev.ProcessID != 901 || ev.UserID != "1000" {
+		t.Fatalf("syscall event: %+v", ev)
+	}
+	if ev.Keywords != keywordAuditFailure || ev.Level != LevelWarning {
+		t.Fatalf("result: %x %v", ev.Keywords, ev.Level)
+	}
+	if ev.Message != "SYSCALL: cat /etc/shadow --help me" {
+		t.Fatalf("message: %q", ev.Message)
+	}
+	for name, want := range map[string]string{
+		"exe":                 "/usr/bin/cat",
+		"EXECVE.a1":           "/etc/shadow",
+		"CWD.cwd":             "/home/alice docs",
+		"PATH[0].name":        "/etc/shadow",
+		"PROCTITLE.proctitle": "cat /etc/shadow",
+	} {
+		if v, _ := ev.Field(name); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+	if !strings.Contains(string(events[1].Raw()), "\ntype=CWD ") {
+		t.Errorf("raw: %q", events[1].Raw())
+	}
+
+	ev = events[0].Event()
+	if ev.Computer != "web01" || ev.EventID != 1112 || ev.UserID != "0" || ev.Keywords != keywordAuditFailure {
+		t.Fatalf("login event: %+v", ev)
+	}
+	ev = events[2].Event()
+	if v, _ := ev.Field("PATH[1].name"); v != "/lib64/ld-linux-x86-64.so.2" || ev.Keywords != keywordAuditSuccess {
+		t.Fatalf("exec event: %+v", ev)
+	}
+}
+
+func TestAuditReaderUnterminated(t *testing.T) {
+	// The last record of the event has no trailing LF.
+	log := "type=SYSCALL msg=audit(1710072002.500:102): pid=950 comm=\"id\"\n" +
+		"type=PATH msg=audit(1710072002.500:102): item=0 name=\"/usr/bin/id\""
+	ar := NewAuditReader(strings.NewReader(log))
+	ev, err := ar.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Serial != 102 || len(ev.Records) != 2 {
+		t.Fatalf("event %d with %d records", ev.Serial, len(ev.Records))
+	}
+	if ev, err := ar.Next(); err != io.EOF {
+		t.Fatalf("got %v, %v after the last event", ev, err)
+	}
+}
+
+func TestAuditAssemblerTimeout(t *testing.T) {
+	a := NewAuditAssembler(time.Second)
+	now := time.Now()
+	rec, _ := ParseAuditRecord([]byte(`type=SYSCALL msg=audit(1.0:1): pid=1`))
+	if a.Add(rec, now) != nil {
+		t.Fatal("incomplete event returned")
+	}
+	if evs := a.Expire(now.Add(500 * time.Millisecond)); len(evs) != 0 {
+		t.Fatalf("expired early: %d", len(evs))
+	}
+	if evs := a.Expire(now.Add(time.Second)); len(evs) != 1 || evs[0].Serial != 1 {
+		t.Fatalf("not expired: %v", evs)
+	}
+	eoe, _ := ParseAuditRecord([]byte(`type=EOE msg=audit(1.0:1): `))
+	if a.Add(eoe, now) != nil {
+		t.Fatal("EOE of an expired event returned an event")
+	}
+}
+
+func TestAuditdSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(path, []byte("type=DAEMON_START msg=audit(1.0:1): op=start res=success\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	src := NewAuditdSource(AuditdConfig{Path: path, Timeout: 200 * time.Millisecond, PollInterval: 10 * time.Millisecond})
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("audit", src); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(testAuditLog)
+	f.Close()
+	// 102 has no EOE and waits for the timeout.
+	for _, want := range []uint64{101, 100, 103, 102} {
+		e := waitSyslogEntry(t, n)
+		if e.Name != "audit" || e.Event.RecordID != want {
+			t.Fatalf("got %d, want %d", e.Event.RecordID, want)
+		}
+	}
+
+	// An event without EOE is emitted after the timeout, also across rotation.
+	f, _ = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`type=SYSCALL msg=audit(1710072010.000:104): pid=1 success=yes` + "\n")
+	f.Close()
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(path, []byte(`type=USER_CMD msg=audit(1710072011.000:105): pid=2 msg='cmd=6C73202D6C res=success'`+"\n"), 0o600)
+	e := waitSyslogEntry(t, n)
+	if e.Event.RecordID != 105 {
+		t.Fatalf("got %d, want the user command first", e.Event.RecordID)
+	}
+	if v, _ := e.Event.Field("cmd"); v != "ls -l" {
+		t.Fatalf("cmd = %q", v)
+	}
+	if e = waitSyslogEntry(t, n); e.Event.RecordID != 104 {
+		t.Fatalf("got %d, want the timed out event", e.Event.RecordID)
+	}
+	if err := n.RemoveWatcher("audit"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -23,7 +23,7 @@ func EvtOpenChannelEnum(session syscall.Handle) (syscall.Handle, error) {
 		0,
 	)
 	if handle == 0 {
-		return InvalidHandle, errors.New("failed to open event channel enumeration handle: " + err.Error())
+		return syscall.Handle(InvalidHandle), errors.New("failed to open event channel enumeration handle: " + err.Error())
 	}
 	return syscall.Handle(handle), nil
 }
@@ -0,0 +1,309 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCommandLineSize limits the size of a line of command output;
+	// longer lines are truncated.
+	DefaultCommandLineSize = 64 * 1024
+	// DefaultCommandRestartDelay is the delay before the first restart.
+	DefaultCommandRestartDelay = time.Second
+	// DefaultCommandMaxRestartDelay caps the restart backoff.
+	DefaultCommandMaxRestartDelay = time.Minute
+	// DefaultCommandStopTimeout is how long a command may take to exit after
+	// it was asked to terminate before it is killed.
+	DefaultCommandStopTimeout = 5 * time.Second
+
+	// commandOutputGrace is how long the output of a command is still read
+	// after it exited.
+	commandOutputGrace = 250 * time.Millisecond
+)
+
+// CommandConfig configures a CommandSource.
+type CommandConfig struct {
+	// Command is the program and its arguments, e.g.
+	// []string{"dmesg", "--follow"}.
+	Command []string
+	// Dir and Env are passed to exec.Cmd.
+	Dir string
+	Env []string
+	// Provider of the events; defaults to the base name of the program.
+	Provider string
+	// MaxLineSize defaults to DefaultCommandLineSize.
+	MaxLineSize int
+	// NoRestart stops the source when the command exits instead of running
+	// it again.
+	NoRestart bool
+	// RestartDelay is the delay before the first restart, doubled on every
+	// further restart up to MaxRestartDelay. A command that ran for at least
+	// MaxRestartDelay starts over with RestartDelay.
+	RestartDelay    time.Duration
+	MaxRestartDelay time.Duration
+	// StopTimeout defaults to DefaultCommandStopTimeout.
+	StopTimeout time.Duration
+}
+
+// CommandSource is a Source running a command and emitting every line it
+// writes as an event. The "stream" data item is "stdout" or "stderr" for
+// output lines and "exit" for the event reporting how the command ended,
+// which carries "exit_code" and, when it is restarted, "restart_delay".
+//
+// On Unix the command runs in its own process group. Stopping the source
+// sends SIGTERM to the group and SIGKILL after StopTimeout; on Windows the
+// process is killed right away.
+type CommandSource struct {
+	cfg CommandConfig
+
+	mu     sync.Mutex
+	pid    int
+	closed bool
+	done   chan struct{}
+}
+
+// NewCommandSource creates a CommandSource.
+func NewCommandSource(cfg CommandConfig) *CommandSource {
+	if cfg.MaxLineSize <= 0 {
+		cfg.MaxLineSize = DefaultCommandLineSize
+	}
+	if cfg.RestartDelay <= 0 {
+		cfg.RestartDelay = DefaultCommandRestartDelay
+	}
+	if cfg.MaxRestartDelay < cfg.RestartDelay {
+		cfg.MaxRestartDelay = DefaultCommandMaxRestartDelay
+		if cfg.MaxRestartDelay < cfg.RestartDelay {
+			cfg.MaxRestartDelay = cfg.RestartDelay
+		}
+	}
+	if cfg.StopTimeout <= 0 {
+		cfg.StopTimeout = DefaultCommandStopTimeout
+	}
+	if cfg.Provider == "" && len(cfg.Command) > 0 {
+		cfg.Provider = filepath.Base(cfg.Command[0])
+	}
+	return &CommandSource{cfg: cfg, done: make(chan struct{})}
+}
+
+// Init checks that the program can be found.
+func (s *CommandSource) Init() error {
+	if len(s.cfg.Command) == 0 {
+		return errors.New("command: no command given")
+	}
+	_, err := exec.LookPath(s.cfg.Command[0])
+	return err
+}
+
+// PID returns the process ID of the running command, or 0.
+func (s *CommandSource) PID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pid
+}
+
+// Listen runs the command until ctx is done or Close is called, restarting
+// it whenever it exits unless NoRestart is set. It returns once the command
+// has exited.
+func (s *CommandSource) Listen(ctx context.Context, emit EmitFunc) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.done:
+			cancel()
+		}
+	}()
+
+	delay := s.cfg.RestartDelay
+	for {
+		started := time.Now()
+		state, err := s.run(ctx, emit)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) >= s.cfg.MaxRestartDelay {
+			delay = s.cfg.RestartDelay
+		}
+		restart := time.Duration(0)
+		if !s.cfg.NoRestart {
+			restart = delay
+		}
+		ev := s.exitEvent(state, err, time.Since(started), restart)
+		if emit(ctx, &EventEntry{Buffer: []byte(ev.Message), Event: ev}) != nil || s.cfg.NoRestart {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > s.cfg.MaxRestartDelay {
+			delay = s.cfg.MaxRestartDelay
+		}
+	}
+}
+
+// run runs the command once, terminating it when ctx is done. It returns
+// when the command has exited and its output was read, or, when a process
+// it started keeps the output pipes open, commandOutputGrace after that.
+func (s *CommandSource) run(ctx context.Context, emit EmitFunc) (*os.ProcessState, error) {
+	cmd := exec.Command(s.cfg.Command[0], s.cfg.Command[1:]...)
+	cmd.Dir = s.cfg.Dir
+	cmd.Env = s.cfg.Env
+	setCommandProcessGroup(cmd)
+	// The pipes are created here rather than with StdoutPipe so that Wait
+	// neither waits for nor closes them.
+	stdout, stdoutW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, stderrW, err := os.Pipe()
+	if err != nil {
+		stdout.Close()
+		stdoutW.Close()
+		return nil, err
+	}
+	cmd.Stdout, cmd.Stderr = stdoutW, stderrW
+	err = cmd.Start()
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		stdout.Close()
+		stderr.Close()
+		return nil, err
+	}
+	pid := cmd.Process.Pid
+	s.mu.Lock()
+	s.pid = pid
+	s.mu.Unlock()
+
+	exited := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			stopCommand(cmd.Process, s.cfg.StopTimeout, exited)
+		case <-exited:
+		}
+	}()
+
+	readCtx, stopReading := context.WithCancel(ctx)
+	defer stopReading()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go s.readStream(readCtx, emit, stdout, "stdout", pid, &wg)
+	go s.readStream(readCtx, emit, stderr, "stderr", pid, &wg)
+	read := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(read)
+	}()
+
+	err = cmd.Wait()
+	close(exited)
+	select {
+	case <-read:
+	case <-time.After(commandOutputGrace):
+		// A background child inherited the pipes. Its output is dropped
+		// from now on; on Windows the readers only return once it exits.
+		stopReading()
+		stdout.Close()
+		stderr.Close()
+	}
+	<-stopped
+
+	s.mu.Lock()
+	s.pid = 0
+	s.mu.Unlock()
+	return cmd.ProcessState, err
+}
+
+// readStream emits the lines of one output stream and closes it. It keeps
+// reading after a failed emit so that the command never blocks on a full
+// pipe.
+func (s *CommandSource) readStream(ctx context.Context, emit EmitFunc, r io.ReadCloser, stream string, pid int, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer r.Close()
+	br := bufio.NewReader(r)
+	failed := false
+	for {
+		line, err := readLimitedLine(br, s.cfg.MaxLineSize)
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 && !failed {
+			ev := s.lineEvent(string(line), stream, pid)
+			failed = emit(ctx, &EventEntry{Buffer: line, Event: ev}) != nil
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// lineEvent converts a line of output to an Event. Its level is detected
+// from the line, defaulting to info for stdout and warning for stderr.
+func (s *CommandSource) lineEvent(line, stream string, pid int) *Event {
+	def := LevelInfo
+	if stream == "stderr" {
+		def = LevelWarning
+	}
+	ev := lineEvent(s.cfg.Provider, line, def)
+	ev.ProcessID = uint32(pid)
+	ev.SetField("stream", stream)
+	return ev
+}
+
+// exitEvent reports how a run ended: a zero exit status is logged at info
+// level, anything else, including a failure to start, as an error.
+func (s *CommandSource) exitEvent(state *os.ProcessState, err error, ran, restart time.Duration) *Event {
+	ev := &Event{
+		Provider:    s.cfg.Provider,
+		Level:       LevelError,
+		TimeCreated: time.Now(),
+	}
+	ev.SetField("stream", "exit")
+	if state == nil {
+		ev.Message = fmt.Sprintf("%s failed to start: %v", s.cfg.Provider, err)
+		ev.SetField("error", err.Error())
+	} else {
+		ev.ProcessID = uint32(state.Pid())
+		ev.Message = fmt.Sprintf("%s %s after %s", s.cfg.Provider, state, ran.Round(time.Millisecond))
+		ev.SetField("exit_code", strconv.Itoa(state.ExitCode()))
+		if state.Success() {
+			ev.Level = LevelInfo
+		}
+	}
+	ev.SetField("duration", ran.Round(time.Millisecond).String())
+	if restart > 0 {
+		ev.SetField("restart_delay", restart.String())
+	}
+	return ev
+}
+
+// Close stops the source. It does not wait for the command to exit, which
+// may take up to StopTimeout; Listen does.
+func (s *CommandSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
@@ -0,0 +1,154 @@
+//go:build !windows
+// +build !windows
+
+package eventwatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCommandSourceRestart(t *testing.T) {
+	src := NewCommandSource(CommandConfig{
+		Command:      []string{"sh", "-c", `echo hello; echo "ERROR: disk full" >&2; exit 3`},
+		RestartDelay: 50 * time.Millisecond,
+	})
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("cmd", src); err != nil {
+		t.Fatal(err)
+	}
+
+	for run := 0; run < 2; run++ {
+		lines := map[string]*Event{}
+		for len(lines) < 2 {
+			e := waitSyslogEntry(t, n)
+			stream, _ := e.Event.Field("stream")
+			if stream == "exit" {
+				t.Fatalf("run %d: exit before output", run)
+			}
+			lines[stream] = e.Event
+		}
+		if ev := lines["stdout"]; ev.Message != "hello" || ev.Level != LevelInfo || ev.Provider != "sh" || ev.ProcessID == 0 {
+			t.Fatalf("stdout: %+v", ev)
+		}
+		if ev := lines["stderr"]; ev.Message != "ERROR: disk full" || ev.Level != LevelError {
+			t.Fatalf("stderr: %+v", ev)
+		}
+
+		e := waitSyslogEntry(t, n)
+		if stream, _ := e.Event.Field("stream"); stream != "exit" || e.Event.Level != LevelError {
+			t.Fatalf("exit: %+v", e.Event)
+		}
+		if code, _ := e.Event.Field("exit_code"); code != "3" {
+			t.Fatalf("exit_code = %q", code)
+		}
+		want := []string{"50ms", "100ms"}[run]
+		if d, _ := e.Event.Field("restart_delay"); d != want {
+			t.Fatalf("restart_delay = %q, want %q", d, want)
+		}
+	}
+}
+
+func TestCommandSourceStop(t *testing.T) {
+	// The shell ignores SIGTERM and has to be killed; the background child
+	// appending to ticks must go with it.
+	ticks := filepath.Join(t.TempDir(), "ticks")
+	src := NewCommandSource(CommandConfig{
+		Command: []string{"sh", "-c", `(trap "" TERM; while :; do echo x >> "$0"; sleep 0.02; done) &
+trap "" TERM; echo ready; while :; do sleep 1; done`, ticks},
+		StopTimeout: 300 * time.Millisecond,
+	})
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("cmd", src); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitSyslogEntry(t, n); e.Event.Message != "ready" {
+		t.Fatalf("got %q", e.Event.Message)
+	}
+	pid := src.PID()
+	if pid == 0 {
+		t.Fatal("no pid")
+	}
+	if err := n.RemoveWatcher("cmd"); err != nil {
+		t.Fatal(err)
+	}
+	waitCommandStopped(t, src)
+	if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+		t.Fatalf("command still alive: %v", err)
+	}
+	before, _ := os.ReadFile(ticks)
+	time.Sleep(100 * time.Millisecond)
+	if after, _ := os.ReadFile(ticks); len(after) != len(before) {
+		t.Fatal("child process still running")
+	}
+	select {
+	case e := <-n.EventLogChannel:
+		t.Fatalf("unexpected event after stop: %+v", e.Event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestCommandSourceNoRestart(t *testing.T) {
+	src := NewCommandSource(CommandConfig{Command: []string{"true"}, NoRestart: true})
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("cmd", src); err != nil {
+		t.Fatal(err)
+	}
+	e := waitSyslogEntry(t, n)
+	if code, _ := e.Event.Field("exit_code"); code != "0" || e.Event.Level != LevelInfo {
+		t.Fatalf("exit: %+v", e.Event)
+	}
+	if _, ok := e.Event.Field("restart_delay"); ok {
+		t.Fatal("restart_delay set without restart")
+	}
+
+	if err := NewCommandSource(CommandConfig{Command: []string{"no-such-command-here"}}).Init(); err == nil {
+		t.Fatal("expected an error for a missing program")
+	}
+}
+
+// waitCommandStopped waits for the command of src to have exited.
+func waitCommandStopped(t *testing.T, src *CommandSource) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for src.PID() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("command not stopped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCommandSourceBackgroundChild(t *testing.T) {
+	// The child keeps stdout and stderr open after the shell exits; the
+	// exit is reported and the command restarted all the same.
+	src := NewCommandSource(CommandConfig{
+		Command:      []string{"sh", "-c", `sleep 5 & echo started; exit 2`},
+		RestartDelay: 10 * time.Millisecond,
+	})
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("cmd", src); err != nil {
+		t.Fatal(err)
+	}
+	for run := 0; run < 2; run++ {
+		if e := waitSyslogEntry(t, n); e.Event.Message != "started" {
+			t.Fatalf("run %d: got %q", run, e.Event.Message)
+		}
+		e := waitSyslogEntry(t, n)
+		if code, _ := e.Event.Field("exit_code"); code != "2" {
+			t.Fatalf("run %d: exit: %+v", run, e.Event)
+		}
+	}
+	if err := n.RemoveWatcher("cmd"); err != nil {
+		t.Fatal(err)
+	}
+	waitCommandStopped(t, src)
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package eventwatcher
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// setCommandProcessGroup starts the command in a process group of its own,
+// so that stopping it also stops the processes it spawned.
+func setCommandProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// stopCommand sends SIGTERM to the process group of p and SIGKILL when it
+// has not exited within timeout.
+func stopCommand(p *os.Process, timeout time.Duration, exited <-chan struct{}) {
+	syscall.Kill(-p.Pid, syscall.SIGTERM)
+	select {
+	case <-exited:
+		// Children that outlived the leader are killed as well.
+		syscall.Kill(-p.Pid, syscall.SIGKILL)
+	case <-time.After(timeout):
+		syscall.Kill(-p.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package eventwatcher
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+func setCommandProcessGroup(cmd *exec.Cmd) {}
+
+// stopCommand kills p; Windows has no signal asking a console process
+// without a window to exit.
+func stopCommand(p *os.Process, timeout time.Duration, exited <-chan struct{}) {
+	p.Kill()
+}
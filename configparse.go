@@ -0,0 +1,881 @@
+package eventwatcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigFormat is the format of a configuration file.
+type ConfigFormat uint8
+
+const (
+	// ConfigAuto detects the format from the file extension.
+	ConfigAuto ConfigFormat = iota
+	// ConfigText is compared line by line only.
+	ConfigText
+	ConfigJSON
+	ConfigYAML
+	ConfigINI
+	ConfigTOML
+)
+
+// DetectConfigFormat returns the format of a file from its extension, and
+// ConfigText for extensions it does not know.
+func DetectConfigFormat(path string) ConfigFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ConfigJSON
+	case ".yaml", ".yml":
+		return ConfigYAML
+	case ".ini", ".cfg", ".conf":
+		return ConfigINI
+	case ".toml":
+		return ConfigTOML
+	}
+	return ConfigText
+}
+
+// ParseConfig parses a configuration file into its values keyed by path:
+// the keys of nested tables are joined with dots and list items are
+// indexed, e.g. "server.listen[0].port". Scalars keep their text, strings
+// without quotes, and empty tables and lists are kept as "{}" and "[]".
+//
+// YAML and TOML are supported in the subset configuration files use: YAML
+// block and flow collections, quoted, plain and block scalars, without
+// anchors, tags or multiple documents; TOML tables, arrays of tables,
+// dotted keys, inline tables and arrays and all string forms.
+func ParseConfig(format ConfigFormat, data []byte) (map[string]string, error) {
+	if format == ConfigAuto {
+		return nil, errors.New("config: format not detected")
+	}
+	values := make(map[string]string)
+	if len(bytes.TrimSpace(data)) == 0 && format != ConfigText {
+		// An empty or deleted file has no values.
+		return values, nil
+	}
+	var tree interface{}
+	var err error
+	switch format {
+	case ConfigJSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&tree)
+	case ConfigYAML:
+		tree, err = parseYAML(string(data))
+	case ConfigINI:
+		tree, err = parseINI(string(data))
+	case ConfigTOML:
+		tree, err = parseTOML(string(data))
+	default:
+		return nil, errors.New("config: no key structure in text files")
+	}
+	if err != nil {
+		return nil, err
+	}
+	flattenConfig(values, "", tree)
+	return values, nil
+}
+
+func flattenConfig(values map[string]string, path string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 && path != "" {
+			values[path] = "{}"
+		}
+		for k, item := range v {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			flattenConfig(values, p, item)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			values[path] = "[]"
+		}
+		for i, item := range v {
+			flattenConfig(values, path+"["+strconv.Itoa(i)+"]", item)
+		}
+	case nil:
+		values[path] = "null"
+	case string:
+		values[path] = v
+	default:
+		values[path] = fmt.Sprint(v)
+	}
+}
+
+// ConfigChange is a value added, removed or modified between two versions
+// of a configuration file.
+type ConfigChange struct {
+	// Op is "added", "removed" or "modified".
+	Op   string
+	Path string
+	Old  string
+	New  string
+}
+
+// DiffConfig compares the values of two versions of a configuration file,
+// as returned by ParseConfig. The changes are sorted by path.
+func DiffConfig(old, cur map[string]string) []ConfigChange {
+	var changes []ConfigChange
+	for path, n := range cur {
+		if o, ok := old[path]; !ok {
+			changes = append(changes, ConfigChange{Op: "added", Path: path, New: n})
+		} else if o != n {
+			changes = append(changes, ConfigChange{Op: "modified", Path: path, Old: o, New: n})
+		}
+	}
+	for path, o := range old {
+		if _, ok := cur[path]; !ok {
+			changes = append(changes, ConfigChange{Op: "removed", Path: path, Old: o})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// configError reports a syntax error at a line.
+func configError(format string, line int, msg string) error {
+	return fmt.Errorf("%s: line %d: %s", format, line, msg)
+}
+
+// parseINI parses sections of key = value or key: value lines. Keys before
+// the first section are at the top level; comments start with ; or #.
+func parseINI(s string) (interface{}, error) {
+	root := make(map[string]interface{})
+	section := root
+	for i, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			if !strings.HasSuffix(line, "]") {
+				return nil, configError("ini", i+1, "unterminated section")
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			sec, ok := root[name].(map[string]interface{})
+			if !ok {
+				sec = make(map[string]interface{})
+				root[name] = sec
+			}
+			section = sec
+			continue
+		}
+		k, v := line, ""
+		if j := strings.IndexAny(line, "=:"); j >= 0 {
+			k, v = strings.TrimSpace(line[:j]), strings.TrimSpace(line[j+1:])
+		}
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		section[k] = v
+	}
+	return root, nil
+}
+
+// yamlLine is a significant line of a YAML document.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+	// raw holds all lines, for block scalars that keep comments and blank
+	// lines.
+	raw []string
+}
+
+func parseYAML(s string) (interface{}, error) {
+	p := &yamlParser{raw: strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")}
+	for i, l := range p.raw {
+		text := strings.TrimRight(stripYAMLComment(l), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" || strings.HasPrefix(trimmed, "%") {
+			continue
+		}
+		if trimmed == "..." {
+			break
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(p.lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	v, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, configError("yaml", p.lines[p.pos].num, "unexpected indentation")
+	}
+	return v, nil
+}
+
+// stripYAMLComment removes a comment, which starts with # at the start of
+// the line or after a space, outside quotes.
+func stripYAMLComment(l string) string {
+	var quote byte
+	for i := 0; i < len(l); i++ {
+		c := l[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" \t:[{,-", rune(l[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || l[i-1] == ' ' || l[i-1] == '\t'):
+			return l[:i]
+		}
+	}
+	return l
+}
+
+// block parses the mapping or sequence whose lines are indented by indent.
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	l := p.lines[p.pos]
+	if l.text == "-" || strings.HasPrefix(l.text, "- ") {
+		return p.sequence(indent)
+	}
+	if _, _, ok := splitYAMLKey(l.text); ok {
+		return p.mapping(indent)
+	}
+	// A lone scalar, possibly spanning lines.
+	var parts []string
+	for p.pos < len(p.lines) && p.lines[p.pos].indent >= indent {
+		parts = append(parts, p.lines[p.pos].text)
+		p.pos++
+	}
+	return yamlScalar(strings.Join(parts, " "), l.num)
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	list := []interface{}{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent != indent || !(l.text == "-" || strings.HasPrefix(l.text, "- ")) {
+			if l.indent > indent {
+				return nil, configError("yaml", l.num, "unexpected indentation")
+			}
+			break
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		if rest == "" {
+			p.pos++
+			v, err := p.nested(indent, l.num)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		// The item continues on the same line: treat its text as a line
+		// indented past the dash, e.g. "- name: x" followed by "  port: 1".
+		p.lines[p.pos] = yamlLine{num: l.num, indent: indent + len(l.text) - len(rest), text: rest}
+		v, err := p.value(p.lines[p.pos].indent)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// value parses the entry at the current line, which is a nested block when
+// it is a mapping or sequence and a scalar otherwise.
+func (p *yamlParser) value(indent int) (interface{}, error) {
+	l := p.lines[p.pos]
+	if _, _, ok := splitYAMLKey(l.text); ok || l.text == "-" || strings.HasPrefix(l.text, "- ") {
+		return p.block(indent)
+	}
+	p.pos++
+	return p.scalarValue(l.text, indent, l.num)
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent != indent {
+			if l.indent > indent {
+				return nil, configError("yaml", l.num, "unexpected indentation")
+			}
+			break
+		}
+		k, rest, ok := splitYAMLKey(l.text)
+		if !ok {
+			break
+		}
+		p.pos++
+		var v interface{}
+		var err error
+		if rest == "" {
+			v, err = p.nested(indent, l.num)
+		} else {
+			v, err = p.scalarValue(rest, indent, l.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+// nested parses the block following a key or dash with nothing after it.
+// Sequences may sit at the indentation of their parent key.
+func (p *yamlParser) nested(indent, num int) (interface{}, error) {
+	if p.pos < len(p.lines) {
+		next := p.lines[p.pos]
+		isSeq := next.text == "-" || strings.HasPrefix(next.text, "- ")
+		if next.indent > indent || (next.indent == indent && isSeq && p.parentIsKey(num)) {
+			return p.block(next.indent)
+		}
+	}
+	return nil, nil
+}
+
+// parentIsKey reports whether the line num holds a mapping key, under which
+// a sequence may be indented like the key.
+func (p *yamlParser) parentIsKey(num int) bool {
+	_, _, ok := splitYAMLKey(strings.TrimLeft(strings.TrimLeft(p.raw[num-1], " "), "- "))
+	return ok
+}
+
+// scalarValue parses the value after a key, which may be a block scalar or
+// continue on more indented lines.
+func (p *yamlParser) scalarValue(s string, indent, num int) (interface{}, error) {
+	if s[0] == '|' || s[0] == '>' {
+		return p.blockScalar(s, indent, num), nil
+	}
+	if s[0] == '[' || s[0] == '{' {
+		// Flow collections may span lines.
+		for !flowComplete(s) && p.pos < len(p.lines) {
+			s += " " + p.lines[p.pos].text
+			p.pos++
+		}
+		f := &flowParser{s: s, num: num}
+		v, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		s += " " + p.lines[p.pos].text
+		p.pos++
+	}
+	return yamlScalar(s, num)
+}
+
+func flowComplete(s string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+// blockScalar reads a literal (|) or folded (>) scalar from the raw lines
+// after line num.
+func (p *yamlParser) blockScalar(header string, indent, num int) string {
+	var body []string
+	i := num
+	blockIndent := -1
+	for ; i < len(p.raw); i++ {
+		l := p.raw[i]
+		t := strings.TrimLeft(l, " ")
+		if t == "" {
+			body = append(body, "")
+			continue
+		}
+		ind := len(l) - len(t)
+		if ind <= indent {
+			break
+		}
+		if blockIndent < 0 {
+			blockIndent = ind
+		}
+		if ind < blockIndent {
+			break
+		}
+		body = append(body, l[blockIndent:])
+	}
+	// Skip the significant lines consumed.
+	for p.pos < len(p.lines) && p.lines[p.pos].num <= i {
+		p.pos++
+	}
+	for len(body) > 0 && body[len(body)-1] == "" {
+		body = body[:len(body)-1]
+	}
+	sep := "\n"
+	if header[0] == '>' {
+		sep = " "
+	}
+	s := strings.Join(body, sep)
+	if !strings.Contains(header, "-") {
+		s += "\n"
+	}
+	return s
+}
+
+// splitYAMLKey splits "key: value" and reports whether the text is a
+// mapping entry.
+func splitYAMLKey(s string) (string, string, bool) {
+	var key string
+	rest := s
+	if s != "" && (s[0] == '"' || s[0] == '\'') {
+		end := strings.IndexByte(s[1:], s[0])
+		if end < 0 {
+			return "", "", false
+		}
+		key, rest = s[1:end+1], s[end+2:]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		rest = rest[1:]
+	} else {
+		if s == "" || s[0] == '[' || s[0] == '{' {
+			return "", "", false
+		}
+		i := strings.Index(s, ": ")
+		if i < 0 {
+			if !strings.HasSuffix(s, ":") {
+				return "", "", false
+			}
+			i = len(s) - 1
+		}
+		key, rest = strings.TrimSpace(s[:i]), s[i+1:]
+	}
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(rest), true
+}
+
+// yamlScalar unquotes a scalar; plain scalars are kept as written, null
+// forms becoming nil.
+func yamlScalar(s string, num int) (interface{}, error) {
+	switch {
+	case s == "~" || s == "null" || s == "Null" || s == "NULL":
+		return nil, nil
+	case strings.HasPrefix(s, "\""):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, configError("yaml", num, "invalid double-quoted string")
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, configError("yaml", num, "invalid single-quoted string")
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	return s, nil
+}
+
+// flowParser parses YAML flow collections and TOML inline values.
+type flowParser struct {
+	s    string
+	i    int
+	num  int
+	toml bool
+}
+
+func (f *flowParser) err(msg string) error {
+	format := "yaml"
+	if f.toml {
+		format = "toml"
+	}
+	return configError(format, f.num, msg)
+}
+
+func (f *flowParser) skipSpace() {
+	for f.i < len(f.s) && (f.s[f.i] == ' ' || f.s[f.i] == '\t' || f.s[f.i] == '\n' || f.s[f.i] == '\r') {
+		f.i++
+	}
+	if f.toml && f.i < len(f.s) && f.s[f.i] == '#' {
+		for f.i < len(f.s) && f.s[f.i] != '\n' {
+			f.i++
+		}
+		f.skipSpace()
+	}
+}
+
+func (f *flowParser) value() (interface{}, error) {
+	f.skipSpace()
+	if f.i >= len(f.s) {
+		return nil, f.err("missing value")
+	}
+	switch f.s[f.i] {
+	case '[':
+		f.i++
+		list := []interface{}{}
+		for {
+			f.skipSpace()
+			if f.i < len(f.s) && f.s[f.i] == ']' {
+				f.i++
+				return list, nil
+			}
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			f.skipSpace()
+			if f.i < len(f.s) && f.s[f.i] == ',' {
+				f.i++
+			} else if f.i >= len(f.s) || f.s[f.i] != ']' {
+				return nil, f.err("unterminated list")
+			}
+		}
+	case '{':
+		f.i++
+		m := make(map[string]interface{})
+		for {
+			f.skipSpace()
+			if f.i < len(f.s) && f.s[f.i] == '}' {
+				f.i++
+				return m, nil
+			}
+			if err := f.entry(m); err != nil {
+				return nil, err
+			}
+			f.skipSpace()
+			if f.i < len(f.s) && f.s[f.i] == ',' {
+				f.i++
+			} else if f.i >= len(f.s) || f.s[f.i] != '}' {
+				return nil, f.err("unterminated table")
+			}
+		}
+	case '"', '\'':
+		return f.quoted()
+	}
+	start := f.i
+	for f.i < len(f.s) && !strings.ContainsRune(",]}\n", rune(f.s[f.i])) && !(f.toml && f.s[f.i] == '#') {
+		if !f.toml && f.s[f.i] == ':' && (f.i+1 == len(f.s) || f.s[f.i+1] == ' ') {
+			break
+		}
+		f.i++
+	}
+	v := strings.TrimSpace(f.s[start:f.i])
+	if f.toml {
+		if v == "" {
+			return nil, f.err("missing value")
+		}
+		return v, nil
+	}
+	return yamlScalar(v, f.num)
+}
+
+// entry parses "key: value" (YAML) or "key = value" (TOML) into m.
+func (f *flowParser) entry(m map[string]interface{}) error {
+	if f.toml {
+		keys, err := f.tomlKey()
+		if err != nil {
+			return err
+		}
+		f.skipSpace()
+		if f.i >= len(f.s) || f.s[f.i] != '=' {
+			return f.err("expected =")
+		}
+		f.i++
+		v, err := f.value()
+		if err != nil {
+			return err
+		}
+		return tomlSet(m, keys, v, f.num)
+	}
+	var k interface{}
+	var err error
+	if f.s[f.i] == '"' || f.s[f.i] == '\'' {
+		k, err = f.quoted()
+	} else {
+		start := f.i
+		for f.i < len(f.s) && f.s[f.i] != ':' && f.s[f.i] != ',' && f.s[f.i] != '}' {
+			f.i++
+		}
+		k = strings.TrimSpace(f.s[start:f.i])
+	}
+	if err != nil {
+		return err
+	}
+	f.skipSpace()
+	var v interface{}
+	if f.i < len(f.s) && f.s[f.i] == ':' {
+		f.i++
+		if v, err = f.value(); err != nil {
+			return err
+		}
+	}
+	m[fmt.Sprint(k)] = v
+	return nil
+}
+
+// quoted parses a quoted string: YAML double and single quotes, TOML basic
+// and literal strings, single or multi-line.
+func (f *flowParser) quoted() (interface{}, error) {
+	q := f.s[f.i]
+	if f.toml && strings.HasPrefix(f.s[f.i:], strings.Repeat(string(q), 3)) {
+		f.i += 3
+		end := strings.Index(f.s[f.i:], strings.Repeat(string(q), 3))
+		if end < 0 {
+			return nil, f.err("unterminated string")
+		}
+		body := f.s[f.i : f.i+end]
+		f.i += end + 3
+		// Up to two more quotes may close the string.
+		for n := 0; n < 2 && f.i < len(f.s) && f.s[f.i] == q; n++ {
+			body += string(q)
+			f.i++
+		}
+		body = strings.TrimPrefix(strings.TrimPrefix(body, "\r"), "\n")
+		if q == '\'' {
+			return body, nil
+		}
+		// A backslash at the end of a line trims the following
+		// whitespace.
+		var sb strings.Builder
+		for i := 0; i < len(body); i++ {
+			if body[i] == '\\' {
+				j := i + 1
+				for j < len(body) && (body[j] == ' ' || body[j] == '\t') {
+					j++
+				}
+				if j < len(body) && (body[j] == '\n' || body[j] == '\r') {
+					for j < len(body) && strings.ContainsRune(" \t\r\n", rune(body[j])) {
+						j++
+					}
+					i = j - 1
+					continue
+				}
+			}
+			sb.WriteByte(body[i])
+		}
+		return unquoteBasic(sb.String(), f)
+	}
+	start := f.i
+	f.i++
+	for f.i < len(f.s) {
+		c := f.s[f.i]
+		if c == '\\' && q == '"' {
+			f.i += 2
+			continue
+		}
+		if c == q {
+			if q == '\'' && !f.toml && f.i+1 < len(f.s) && f.s[f.i+1] == '\'' {
+				f.i += 2
+				continue
+			}
+			f.i++
+			raw := f.s[start:f.i]
+			if q == '\'' {
+				if f.toml {
+					return raw[1 : len(raw)-1], nil
+				}
+				return strings.ReplaceAll(raw[1:len(raw)-1], "''", "'"), nil
+			}
+			return unquoteBasic(raw[1:len(raw)-1], f)
+		}
+		f.i++
+	}
+	return nil, f.err("unterminated string")
+}
+
+// unquoteBasic decodes the escapes of a double-quoted string body.
+func unquoteBasic(body string, f *flowParser) (string, error) {
+	// TOML's \UXXXXXXXX and \e are not all known to strconv; map the
+	// common forms and leave raw newlines alone.
+	body = strings.ReplaceAll(body, "\\e", "\\x1b")
+	var sb strings.Builder
+	for _, part := range strings.SplitAfter(body, "\n") {
+		nl := strings.HasSuffix(part, "\n")
+		part = strings.TrimSuffix(part, "\n")
+		v, err := strconv.Unquote("\"" + strings.ReplaceAll(part, "\t", "\\t") + "\"")
+		if err != nil {
+			return "", f.err("invalid escape")
+		}
+		sb.WriteString(v)
+		if nl {
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String(), nil
+}
+
+// tomlKey parses a possibly dotted key with bare and quoted parts.
+func (f *flowParser) tomlKey() ([]string, error) {
+	var keys []string
+	for {
+		f.skipSpace()
+		if f.i >= len(f.s) {
+			return nil, f.err("missing key")
+		}
+		if c := f.s[f.i]; c == '"' || c == '\'' {
+			k, err := f.quoted()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k.(string))
+		} else {
+			start := f.i
+			for f.i < len(f.s) && isTOMLBareKey(f.s[f.i]) {
+				f.i++
+			}
+			if f.i == start {
+				return nil, f.err("invalid key")
+			}
+			keys = append(keys, f.s[start:f.i])
+		}
+		f.skipSpace()
+		if f.i < len(f.s) && f.s[f.i] == '.' {
+			f.i++
+			continue
+		}
+		return keys, nil
+	}
+}
+
+func isTOMLBareKey(c byte) bool {
+	return c == '_' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// tomlSet stores v under the dotted key in m, creating intermediate tables.
+func tomlSet(m map[string]interface{}, keys []string, v interface{}, num int) error {
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := m[k]
+		if !ok {
+			t := make(map[string]interface{})
+			m[k] = t
+			m = t
+			continue
+		}
+		t, ok := next.(map[string]interface{})
+		if !ok {
+			return configError("toml", num, "key "+k+" is not a table")
+		}
+		m = t
+	}
+	k := keys[len(keys)-1]
+	if _, ok := m[k]; ok {
+		return configError("toml", num, "duplicate key "+k)
+	}
+	m[k] = v
+	return nil
+}
+
+// parseTOML parses a TOML document. Values spanning lines, such as arrays
+// and multi-line strings, are read by the same parser as inline values.
+func parseTOML(s string) (interface{}, error) {
+	root := make(map[string]interface{})
+	table := root
+	f := &flowParser{s: strings.ReplaceAll(s, "\r\n", "\n"), toml: true}
+	line := func() int { return strings.Count(f.s[:f.i], "\n") + 1 }
+	for {
+		f.skipSpace()
+		if f.i >= len(f.s) {
+			return root, nil
+		}
+		f.num = line()
+		if f.s[f.i] == '[' {
+			array := strings.HasPrefix(f.s[f.i:], "[[")
+			if array {
+				f.i += 2
+			} else {
+				f.i++
+			}
+			keys, err := f.tomlKey()
+			if err != nil {
+				return nil, err
+			}
+			closing := "]"
+			if array {
+				closing = "]]"
+			}
+			if !strings.HasPrefix(f.s[f.i:], closing) {
+				return nil, f.err("unterminated table header")
+			}
+			f.i += len(closing)
+			if table, err = tomlTable(root, keys, array, f.num); err != nil {
+				return nil, err
+			}
+		} else if err := f.entry(table); err != nil {
+			return nil, err
+		}
+		// Only a comment may follow on the line.
+		for f.i < len(f.s) && (f.s[f.i] == ' ' || f.s[f.i] == '\t') {
+			f.i++
+		}
+		if f.i < len(f.s) && f.s[f.i] != '\n' && f.s[f.i] != '#' {
+			return nil, f.err("unexpected text after value")
+		}
+	}
+}
+
+// tomlTable returns the table of a [header] or a new element of an
+// [[array]] header.
+func tomlTable(root map[string]interface{}, keys []string, array bool, num int) (map[string]interface{}, error) {
+	m := root
+	for i, k := range keys {
+		last := i == len(keys)-1
+		switch next := m[k].(type) {
+		case nil:
+			if last && array {
+				t := make(map[string]interface{})
+				m[k] = []interface{}{t}
+				return t, nil
+			}
+			t := make(map[string]interface{})
+			m[k] = t
+			m = t
+		case map[string]interface{}:
+			if last && array {
+				return nil, configError("toml", num, k+" is not an array of tables")
+			}
+			m = next
+		case []interface{}:
+			// The last table of an array of tables.
+			if last && array {
+				t := make(map[string]interface{})
+				m[k] = append(next, t)
+				return t, nil
+			}
+			t, ok := next[len(next)-1].(map[string]interface{})
+			if !ok || last {
+				return nil, configError("toml", num, k+" is not a table")
+			}
+			m = t
+		default:
+			return nil, configError("toml", num, k+" is not a table")
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,269 @@
+package eventwatcher
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// DefaultConfigDiffContext is the number of unchanged lines shown
+	// around each change of a diff.
+	DefaultConfigDiffContext = 3
+	// DefaultConfigDiffMaxSize is the size above which files are not
+	// diffed.
+	DefaultConfigDiffMaxSize = 1 << 20
+)
+
+// ConfigDiff is a change of a configuration file.
+type ConfigDiff struct {
+	Path   string
+	Format ConfigFormat
+	// Diff is the unified diff of the old and new contents.
+	Diff string
+	// Changes are the values added, removed and modified, for formats
+	// with keys.
+	Changes []ConfigChange
+	// ParseError is the error parsing either version, when the values
+	// could not be compared.
+	ParseError error
+}
+
+// DiffConfigFile compares two versions of a configuration file, a missing
+// file having no content. It returns nil when they are equal. The values
+// are compared when format, or the format detected from the path for
+// ConfigAuto, has keys.
+func DiffConfigFile(path string, format ConfigFormat, old, cur []byte, context int) *ConfigDiff {
+	if string(old) == string(cur) {
+		return nil
+	}
+	if format == ConfigAuto {
+		format = DetectConfigFormat(path)
+	}
+	d := &ConfigDiff{Path: path, Format: format}
+	d.Diff = UnifiedDiff(path, path, string(old), string(cur), context)
+	if format == ConfigText {
+		return d
+	}
+	o, err := ParseConfig(format, old)
+	if err == nil {
+		var n map[string]string
+		if n, err = ParseConfig(format, cur); err == nil {
+			d.Changes = DiffConfig(o, n)
+		}
+	}
+	d.ParseError = err
+	return d
+}
+
+// Event converts the change to an Event from the "config" provider. The
+// message summarizes the change and is followed by the diff. The path, the
+// key paths changed and their old and new values are stored as data items
+// such as "changed", "old.server.port" and "new.server.port".
+func (d *ConfigDiff) Event() *Event {
+	ev := &Event{Provider: "config", Level: LevelNotice, TimeCreated: time.Now()}
+	ev.Data = append(ev.Data, EventData{Name: "path", Value: d.Path})
+	counts := make(map[string]int)
+	var paths []string
+	for _, c := range d.Changes {
+		counts[c.Op]++
+		paths = append(paths, c.Path)
+	}
+	if len(paths) > 0 {
+		ev.Data = append(ev.Data, EventData{Name: "changed", Value: strings.Join(paths, ",")})
+	}
+	for _, c := range d.Changes {
+		if c.Op != "added" {
+			ev.Data = append(ev.Data, EventData{Name: "old." + c.Path, Value: c.Old})
+		}
+		if c.Op != "removed" {
+			ev.Data = append(ev.Data, EventData{Name: "new." + c.Path, Value: c.New})
+		}
+	}
+	if d.ParseError != nil {
+		ev.Data = append(ev.Data, EventData{Name: "parse_error", Value: d.ParseError.Error()})
+	}
+
+	ev.Message = "changed " + d.Path
+	var summary []string
+	for _, op := range []string{"added", "removed", "modified"} {
+		if counts[op] > 0 {
+			summary = append(summary, strconv.Itoa(counts[op])+" "+op)
+		}
+	}
+	if len(summary) > 0 {
+		ev.Message += " (" + strings.Join(summary, ", ") + ")"
+	}
+	if d.Diff != "" {
+		ev.Message += "\n" + d.Diff
+	}
+	return ev
+}
+
+// ConfigDiffConfig configures a ConfigDiffSource.
+type ConfigDiffConfig struct {
+	// Paths are the files to watch.
+	Paths []string
+	// Format is the format of all the files; by default it is detected
+	// from the extension of each.
+	Format ConfigFormat
+	// Context defaults to DefaultConfigDiffContext; set it negative for
+	// none.
+	Context int
+	// MaxSize defaults to DefaultConfigDiffMaxSize.
+	MaxSize int64
+}
+
+// ConfigDiffSource is a Source reporting what changed in configuration
+// files rather than their whole content. It keeps the last version of each
+// file and emits a unified diff of every change, together with the values
+// added, removed and modified for JSON, YAML, INI and TOML files. Files
+// may be created, deleted and replaced by rename.
+type ConfigDiffSource struct {
+	cfg      ConfigDiffConfig
+	watcher  *fsnotify.Watcher
+	contents map[string][]byte
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewConfigDiffSource creates a ConfigDiffSource.
+func NewConfigDiffSource(cfg ConfigDiffConfig) *ConfigDiffSource {
+	if cfg.Context == 0 {
+		cfg.Context = DefaultConfigDiffContext
+	} else if cfg.Context < 0 {
+		cfg.Context = 0
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = DefaultConfigDiffMaxSize
+	}
+	paths := make([]string, len(cfg.Paths))
+	for i, path := range cfg.Paths {
+		paths[i] = filepath.Clean(path)
+	}
+	cfg.Paths = paths
+	return &ConfigDiffSource{cfg: cfg, done: make(chan struct{})}
+}
+
+// Init reads the current version of the files and watches their
+// directories, which must exist.
+func (s *ConfigDiffSource) Init() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	s.contents = make(map[string][]byte)
+	for _, path := range s.cfg.Paths {
+		if err := w.Add(filepath.Dir(path)); err != nil {
+			w.Close()
+			return err
+		}
+		s.contents[path], _ = s.read(path)
+	}
+	s.watcher = w
+	return nil
+}
+
+// read returns the content of a file, which is empty when the file does
+// not exist, and reports whether it fits MaxSize.
+func (s *ConfigDiffSource) read(path string) ([]byte, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, true
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, s.cfg.MaxSize+1))
+	if err != nil {
+		return nil, true
+	}
+	if int64(len(b)) > s.cfg.MaxSize {
+		return nil, false
+	}
+	return b, true
+}
+
+// Listen reports changes until ctx is done or Close is called.
+func (s *ConfigDiffSource) Listen(ctx context.Context, emit EmitFunc) {
+	defer s.Close()
+
+	watched := make(map[string]bool)
+	for _, path := range s.cfg.Paths {
+		watched[path] = true
+	}
+	var (
+		debounce <-chan time.Time
+		dirty    = make(map[string]bool)
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		case ev, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			if path := filepath.Clean(ev.Name); watched[path] {
+				dirty[path] = true
+				if debounce == nil {
+					debounce = time.After(fimDebounce)
+				}
+			}
+			continue
+		case <-s.watcher.Errors:
+			continue
+		case <-debounce:
+		}
+		for _, path := range s.cfg.Paths {
+			if dirty[path] && !s.check(ctx, emit, path) {
+				return
+			}
+		}
+		debounce, dirty = nil, make(map[string]bool)
+	}
+}
+
+// check compares a file with its last version and reports whether the
+// change, if any, was delivered.
+func (s *ConfigDiffSource) check(ctx context.Context, emit EmitFunc, path string) bool {
+	cur, ok := s.read(path)
+	var ev *Event
+	if !ok {
+		// The next version is compared with the last one read.
+		ev = &Event{Provider: "config", Level: LevelNotice, TimeCreated: time.Now(),
+			Message: "changed " + path + " (larger than " + strconv.FormatInt(s.cfg.MaxSize, 10) + " bytes, not compared)"}
+		ev.SetField("path", path)
+	} else {
+		d := DiffConfigFile(path, s.cfg.Format, s.contents[path], cur, s.cfg.Context)
+		if d == nil {
+			return true
+		}
+		ev = d.Event()
+		s.contents[path] = cur
+	}
+	buf := []byte(ev.Message)
+	if i := strings.IndexByte(ev.Message, '\n'); i >= 0 {
+		// The raw entry is the diff alone.
+		buf = buf[i+1:]
+	}
+	return emit(ctx, &EventEntry{Buffer: buf, Event: ev}) == nil
+}
+
+// Close stops the source.
+func (s *ConfigDiffSource) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.watcher != nil {
+			s.watcher.Close()
+		}
+	})
+}
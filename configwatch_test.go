@@ -0,0 +1,219 @@
+package eventwatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+	b := "one\n2\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven"
+	want := `--- a
++++ b
+@@ -1,5 +1,5 @@
+ one
+-two
++2
+ three
+ four
+ five
+@@ -8,3 +8,4 @@
+ eight
+ nine
+ ten
++eleven
+\ No newline at end of file
+`
+	if got := UnifiedDiff("a", "b", a, b, 3); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+	// Changes less than two contexts apart share a hunk.
+	want = `--- a
++++ b
+@@ -1,10 +1,11 @@
+ one
+-two
++2
+ three
+`
+	if got := UnifiedDiff("a", "b", a, b, 5); got[:len(want)] != want {
+		t.Fatalf("got\n%s", got)
+	}
+	if got := UnifiedDiff("a", "b", "", "x\n", 3); got != "--- a\n+++ b\n@@ -0,0 +1 @@\n+x\n" {
+		t.Fatalf("got %q", got)
+	}
+	if got := UnifiedDiff("a", "b", a, a, 3); got != "" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		format ConfigFormat
+		data   string
+		want   map[string]string
+	}{
+		{ConfigJSON, `{"server": {"port": 8080, "hosts": ["a", "b"], "tls": null}, "debug": true, "tags": {}}`, map[string]string{
+			"server.port": "8080", "server.hosts[0]": "a", "server.hosts[1]": "b", "server.tls": "null",
+			"debug": "true", "tags": "{}",
+		}},
+		{ConfigYAML, `# service
+server:
+  port: 8080   # http
+  hosts:
+  - a
+  - "b # not a comment"
+  listen:
+    - addr: 0.0.0.0
+      tls: yes
+    - addr: '::1'
+  motd: |
+    hello
+    world
+  empty: []
+limits: {cpu: 2, mem: "1Gi"}
+name: plain text
+  continued
+`, map[string]string{
+			"server.port": "8080", "server.hosts[0]": "a", "server.hosts[1]": "b # not a comment",
+			"server.listen[0].addr": "0.0.0.0", "server.listen[0].tls": "yes", "server.listen[1].addr": "::1",
+			"server.motd": "hello\nworld\n", "server.empty": "[]", "limits.cpu": "2", "limits.mem": "1Gi",
+			"name": "plain text continued",
+		}},
+		{ConfigINI, `; global
+user = root
+[server]
+port: 8080
+name = "web"
+`, map[string]string{"user": "root", "server.port": "8080", "server.name": "web"}},
+		{ConfigTOML, `title = "app" # comment
+[server]
+port = 8080
+hosts = [
+  "a", # first
+  'b',
+]
+tls.cert = '''
+C:\cert.pem'''
+[[plugin]]
+name = "x"
+opts = { level = 1, "quoted key" = "v" }
+[[plugin]]
+name = "y"
+[plugin.extra]
+on = true
+`, map[string]string{
+			"title": "app", "server.port": "8080", "server.hosts[0]": "a", "server.hosts[1]": "b",
+			"server.tls.cert": `C:\cert.pem`, "plugin[0].name": "x", "plugin[0].opts.level": "1",
+			"plugin[0].opts.quoted key": "v", "plugin[1].name": "y", "plugin[1].extra.on": "true",
+		}},
+		{ConfigYAML, "", map[string]string{}},
+	}
+	for _, tt := range tests {
+		got, err := ParseConfig(tt.format, []byte(tt.data))
+		if err != nil {
+			t.Fatalf("%d: %v", tt.format, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: got %q\nwant %q", tt.format, got, tt.want)
+		}
+	}
+	for _, bad := range []struct {
+		format ConfigFormat
+		data   string
+	}{
+		{ConfigJSON, `{"a": `},
+		{ConfigYAML, "a:\n  b: 1\n c: 2\n"},
+		{ConfigYAML, "a: \"unterminated\n"},
+		{ConfigTOML, "a = 1\na = 2\n"},
+		{ConfigTOML, "[a\nb = 1\n"},
+		{ConfigINI, "[a\n"},
+		{ConfigText, "x"},
+	} {
+		if _, err := ParseConfig(bad.format, []byte(bad.data)); err == nil {
+			t.Errorf("%d: parsed %q", bad.format, bad.data)
+		}
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	old := map[string]string{"a": "1", "b": "2", "c": "3"}
+	cur := map[string]string{"a": "1", "b": "20", "d": "4"}
+	want := []ConfigChange{
+		{Op: "modified", Path: "b", Old: "2", New: "20"},
+		{Op: "removed", Path: "c", Old: "3"},
+		{Op: "added", Path: "d", New: "4"},
+	}
+	if got := DiffConfig(old, cur); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v", got)
+	}
+
+	d := DiffConfigFile("app.json", ConfigAuto, []byte(`{"port": 80}`), []byte(`{"port": 8080, "debug": true}`), 3)
+	ev := d.Event()
+	if ev.Message != "changed app.json (1 added, 1 modified)\n"+d.Diff {
+		t.Fatalf("message %q", ev.Message)
+	}
+	for name, want := range map[string]string{"changed": "debug,port", "new.debug": "true", "old.port": "80", "new.port": "8080"} {
+		if v, _ := ev.Field(name); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+	if _, ok := ev.Field("old.debug"); ok {
+		t.Error("old value of added key")
+	}
+	d = DiffConfigFile("app.json", ConfigAuto, []byte(`{}`), []byte(`{`), 3)
+	if d.ParseError == nil || d.Diff == "" {
+		t.Fatalf("got %+v", d)
+	}
+	if DiffConfigFile("app.json", ConfigAuto, []byte(`{}`), []byte(`{}`), 3) != nil {
+		t.Fatal("diff of equal files")
+	}
+}
+
+func TestConfigDiffSource(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.yaml")
+	os.WriteFile(path, []byte("port: 80\nname: web\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "other.yaml"), nil, 0o644)
+
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("config", NewConfigDiffSource(ConfigDiffConfig{Paths: []string{path}})); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	os.WriteFile(path, []byte("port: 8080\nname: web\n"), 0o644)
+	e := waitSyslogEntry(t, n)
+	want := "--- " + path + "\n+++ " + path + "\n@@ -1,2 +1,2 @@\n-port: 80\n+port: 8080\n name: web\n"
+	if string(e.Buffer) != want {
+		t.Fatalf("got %q, want %q", e.Buffer, want)
+	}
+	if v, _ := e.Event.Field("new.port"); v != "8080" {
+		t.Fatalf("new.port %q", v)
+	}
+
+	// Replacing the file by rename, as editors do, is one change.
+	tmp := filepath.Join(dir, "app.yaml.tmp")
+	os.WriteFile(tmp, []byte("port: 8080\nname: api\n"), 0o644)
+	os.Rename(tmp, path)
+	e = waitSyslogEntry(t, n)
+	if v, _ := e.Event.Field("changed"); v != "name" {
+		t.Fatalf("changed %q", v)
+	}
+
+	os.Remove(path)
+	e = waitSyslogEntry(t, n)
+	if v, _ := e.Event.Field("changed"); v != "name,port" {
+		t.Fatalf("changed %q", v)
+	}
+	select {
+	case e := <-n.EventLogChannel:
+		t.Fatalf("unexpected event %s", e.Event.Message)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
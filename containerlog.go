@@ -0,0 +1,455 @@
+package eventwatcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultContainerLogSize limits the size of a log line reassembled
+	// from partial lines; the rest of a longer line is emitted separately.
+	DefaultContainerLogSize = 1 << 20
+	// DefaultContainerRescanInterval is how often the log paths are
+	// globbed again for new and removed containers.
+	DefaultContainerRescanInterval = 10 * time.Second
+)
+
+// DefaultContainerLogPaths are the patterns of the logs the kubelet writes
+// for the CRI runtimes and of Docker's json-file logs.
+var DefaultContainerLogPaths = []string{
+	"/var/log/pods/*/*/*.log",
+	"/var/lib/docker/containers/*/*-json.log",
+}
+
+var errContainerLog = errors.New("container log: malformed line")
+
+// ContainerLogFormat is the format of a container log file.
+type ContainerLogFormat uint8
+
+const (
+	// ContainerLogAuto detects the format of each line.
+	ContainerLogAuto ContainerLogFormat = iota
+	// ContainerLogDocker is Docker's json-file format.
+	ContainerLogDocker
+	// ContainerLogCRI is the format of the CRI runtimes, such as
+	// containerd and CRI-O.
+	ContainerLogCRI
+)
+
+// ContainerLogLine is a line of a container log.
+type ContainerLogLine struct {
+	Time time.Time
+	// Stream is "stdout" or "stderr".
+	Stream string
+	// Partial is set when the runtime split a long line and more of it
+	// follows.
+	Partial bool
+	// Log is the content, without its line ending.
+	Log string
+	// Attrs are the attributes Docker adds with the labels and env log
+	// options.
+	Attrs map[string]string
+}
+
+// ParseDockerLogLine parses a line of a Docker json-file log, such as
+//
+//	{"log":"GET /healthz 200\n","stream":"stdout","time":"2024-03-10T12:00:00.123456789Z"}
+//
+// Docker splits lines longer than 16 KiB; all parts but the last lack the
+// trailing newline.
+func ParseDockerLogLine(line []byte) (ContainerLogLine, error) {
+	var doc struct {
+		Log    *string           `json:"log"`
+		Stream string            `json:"stream"`
+		Time   time.Time         `json:"time"`
+		Attrs  map[string]string `json:"attrs"`
+	}
+	if err := json.Unmarshal(line, &doc); err != nil || doc.Log == nil {
+		return ContainerLogLine{}, errContainerLog
+	}
+	l := ContainerLogLine{Time: doc.Time, Stream: doc.Stream, Attrs: doc.Attrs}
+	if strings.HasSuffix(*doc.Log, "\n") {
+		l.Log = strings.TrimSuffix(strings.TrimSuffix(*doc.Log, "\n"), "\r")
+	} else {
+		l.Log, l.Partial = *doc.Log, true
+	}
+	return l, nil
+}
+
+// ParseCRILogLine parses a line of a CRI log, such as
+//
+//	2024-03-10T12:00:00.123456789Z stderr F connection refused
+//
+// The third field holds the tags separated by colons, of which "P" marks a
+// partial line and "F" a full one or the last part of a split one.
+func ParseCRILogLine(line []byte) (ContainerLogLine, error) {
+	fields := strings.SplitN(string(line), " ", 4)
+	if len(fields) < 3 {
+		return ContainerLogLine{}, errContainerLog
+	}
+	t, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return ContainerLogLine{}, errContainerLog
+	}
+	l := ContainerLogLine{Time: t, Stream: fields[1]}
+	for _, tag := range strings.Split(fields[2], ":") {
+		if tag == "P" {
+			l.Partial = true
+		}
+	}
+	if len(fields) == 4 {
+		l.Log = fields[3]
+	}
+	return l, nil
+}
+
+// ParseContainerLogLine parses a line in the given format.
+func ParseContainerLogLine(format ContainerLogFormat, line []byte) (ContainerLogLine, error) {
+	switch format {
+	case ContainerLogDocker:
+		return ParseDockerLogLine(line)
+	case ContainerLogCRI:
+		return ParseCRILogLine(line)
+	}
+	if bytes.HasPrefix(line, []byte("{")) {
+		return ParseDockerLogLine(line)
+	}
+	return ParseCRILogLine(line)
+}
+
+// ContainerLogAssembler joins the parts of split lines. The parts of each
+// stream are collected separately, as stdout and stderr are interleaved in
+// the same file.
+type ContainerLogAssembler struct {
+	// MaxSize limits the size of a reassembled line; it defaults to
+	// DefaultContainerLogSize.
+	MaxSize int
+
+	pending map[string]*ContainerLogLine
+}
+
+// Add adds a line and returns the complete line it finishes, if any. The
+// time of a reassembled line is that of its first part.
+func (a *ContainerLogAssembler) Add(l ContainerLogLine) *ContainerLogLine {
+	max := a.MaxSize
+	if max <= 0 {
+		max = DefaultContainerLogSize
+	}
+	if a.pending == nil {
+		a.pending = make(map[string]*ContainerLogLine)
+	}
+	p := a.pending[l.Stream]
+	if p == nil {
+		p = &l
+	} else {
+		p.Log += l.Log
+	}
+	if !l.Partial || len(p.Log) >= max {
+		delete(a.pending, l.Stream)
+		p.Partial = false
+		return p
+	}
+	a.pending[l.Stream] = p
+	return nil
+}
+
+// Flush returns the lines still being assembled, as they are.
+func (a *ContainerLogAssembler) Flush() []*ContainerLogLine {
+	streams := make([]string, 0, len(a.pending))
+	for s := range a.pending {
+		streams = append(streams, s)
+	}
+	sort.Strings(streams)
+	lines := make([]*ContainerLogLine, 0, len(streams))
+	for _, s := range streams {
+		lines = append(lines, a.pending[s])
+	}
+	a.pending = nil
+	return lines
+}
+
+// ContainerMeta describes the container a log file belongs to.
+type ContainerMeta struct {
+	Namespace    string
+	Pod          string
+	PodUID       string
+	Container    string
+	ContainerID  string
+	RestartCount int
+}
+
+// ParseContainerLogPath derives the container of a log from its path, as
+// laid out by the kubelet and Docker:
+//
+//	/var/log/pods/<namespace>_<pod>_<pod uid>/<container>/<restart count>.log
+//	/var/log/containers/<pod>_<namespace>_<container>-<container id>.log
+//	/var/lib/docker/containers/<container id>/<container id>-json.log
+//
+// Unknown layouts leave the fields empty.
+func ParseContainerLogPath(path string) ContainerMeta {
+	var m ContainerMeta
+	path = filepath.ToSlash(path)
+	parts := strings.Split(path, "/")
+	base := parts[len(parts)-1]
+	switch {
+	case strings.HasSuffix(base, "-json.log"):
+		if len(parts) >= 2 {
+			m.ContainerID = parts[len(parts)-2]
+		}
+	case len(parts) >= 3 && strings.Count(parts[len(parts)-3], "_") >= 2 && isDigits(strings.TrimSuffix(base, ".log")):
+		// <namespace>_<pod>_<uid>: namespace and pod names cannot contain
+		// underscores.
+		f := strings.SplitN(parts[len(parts)-3], "_", 3)
+		m.Namespace, m.Pod, m.PodUID = f[0], f[1], f[2]
+		m.Container = parts[len(parts)-2]
+		m.RestartCount, _ = strconv.Atoi(strings.TrimSuffix(base, ".log"))
+	case strings.HasSuffix(base, ".log"):
+		name := strings.TrimSuffix(base, ".log")
+		if i := strings.LastIndexByte(name, '-'); i >= 0 {
+			name, m.ContainerID = name[:i], name[i+1:]
+		}
+		if f := strings.SplitN(name, "_", 3); len(f) == 3 {
+			m.Pod, m.Namespace, m.Container = f[0], f[1], f[2]
+		} else {
+			m.ContainerID = ""
+		}
+	}
+	return m
+}
+
+// ContainerLogEvent converts a container log line to an Event. The provider
+// is the container name, or "docker" when it is not known; the stream and
+// the known container metadata are stored as data items. Lines on stderr
+// default to the warning level.
+func ContainerLogEvent(meta ContainerMeta, l *ContainerLogLine) *Event {
+	def := LevelInfo
+	if l.Stream == "stderr" {
+		def = LevelWarning
+	}
+	provider := meta.Container
+	if provider == "" {
+		provider = "docker"
+	}
+	ev := lineEvent(provider, l.Log, def)
+	if !l.Time.IsZero() {
+		ev.TimeCreated = l.Time
+	}
+	set := func(name, value string) {
+		if value != "" {
+			ev.SetField(name, value)
+		}
+	}
+	set("stream", l.Stream)
+	set("namespace", meta.Namespace)
+	set("pod", meta.Pod)
+	set("pod_uid", meta.PodUID)
+	set("container", meta.Container)
+	set("container_id", meta.ContainerID)
+	if meta.Pod != "" {
+		set("restart_count", strconv.Itoa(meta.RestartCount))
+	}
+	keys := make([]string, 0, len(l.Attrs))
+	for k := range l.Attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		set("attrs."+k, l.Attrs[k])
+	}
+	return ev
+}
+
+// ContainerLogConfig configures a ContainerLogSource.
+type ContainerLogConfig struct {
+	// Paths are glob patterns of the log files; they default to
+	// DefaultContainerLogPaths.
+	Paths []string
+	// Format defaults to ContainerLogAuto.
+	Format ContainerLogFormat
+	// FromStart makes the source emit the lines already in the files found
+	// by Init; by default only new ones are. Files appearing later are
+	// always read from their start.
+	FromStart bool
+	// MaxSize defaults to DefaultContainerLogSize.
+	MaxSize int
+	// PollInterval defaults to DefaultTailPollInterval.
+	PollInterval time.Duration
+	// RescanInterval defaults to DefaultContainerRescanInterval.
+	RescanInterval time.Duration
+}
+
+// ContainerLogSource is a Source following the logs of containers. Split
+// lines are reassembled, each line keeps the time and stream the runtime
+// recorded, and the namespace, pod and container are derived from the
+// path with ParseContainerLogPath. Files are followed across rotation and
+// the paths are globbed again every RescanInterval, starting to follow the
+// logs of new containers and stopping for those whose logs were removed.
+type ContainerLogSource struct {
+	cfg ContainerLogConfig
+
+	mu      sync.Mutex
+	initial map[string]*fileTailer
+	closed  bool
+	done    chan struct{}
+}
+
+// NewContainerLogSource creates a ContainerLogSource.
+func NewContainerLogSource(cfg ContainerLogConfig) *ContainerLogSource {
+	if len(cfg.Paths) == 0 {
+		cfg.Paths = DefaultContainerLogPaths
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = DefaultContainerLogSize
+	}
+	if cfg.RescanInterval <= 0 {
+		cfg.RescanInterval = DefaultContainerRescanInterval
+	}
+	return &ContainerLogSource{cfg: cfg, done: make(chan struct{})}
+}
+
+// Init opens the log files present now.
+func (s *ContainerLogSource) Init() error {
+	paths, err := s.glob()
+	if err != nil {
+		return err
+	}
+	s.initial = make(map[string]*fileTailer, len(paths))
+	for _, path := range paths {
+		t, err := openFileTailer(path, s.cfg.FromStart, s.cfg.PollInterval)
+		if err != nil {
+			// The container may have been removed meanwhile.
+			continue
+		}
+		s.initial[path] = t
+	}
+	return nil
+}
+
+func (s *ContainerLogSource) glob() ([]string, error) {
+	var paths []string
+	for _, pattern := range s.cfg.Paths {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// Listen emits lines until ctx is done or Close is called.
+func (s *ContainerLogSource) Listen(ctx context.Context, emit EmitFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer s.Close()
+
+	var wg sync.WaitGroup
+	defer func() {
+		// The followers stop once ctx is cancelled, which Close alone
+		// does not do.
+		cancel()
+		wg.Wait()
+	}()
+	follow := make(map[string]context.CancelFunc)
+	start := func(path string, t *fileTailer) {
+		fctx, fcancel := context.WithCancel(ctx)
+		follow[path] = fcancel
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer t.close()
+			s.follow(ctx, fctx, path, t, emit)
+		}()
+	}
+
+	s.mu.Lock()
+	initial := s.initial
+	s.initial = nil
+	s.mu.Unlock()
+	for path, t := range initial {
+		start(path, t)
+	}
+
+	ticker := time.NewTicker(s.cfg.RescanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		paths, err := s.glob()
+		if err != nil {
+			continue
+		}
+		found := make(map[string]bool, len(paths))
+		for _, path := range paths {
+			found[path] = true
+			if follow[path] != nil {
+				continue
+			}
+			if t, err := openFileTailer(path, true, s.cfg.PollInterval); err == nil {
+				start(path, t)
+			}
+		}
+		for path, stop := range follow {
+			if !found[path] {
+				stop()
+				delete(follow, path)
+			}
+		}
+	}
+}
+
+// follow emits the lines of one file until stop is done. Split lines still
+// being assembled then are emitted as they are.
+func (s *ContainerLogSource) follow(ctx, stop context.Context, path string, t *fileTailer, emit EmitFunc) {
+	meta := ParseContainerLogPath(path)
+	asm := &ContainerLogAssembler{MaxSize: s.cfg.MaxSize}
+	deliver := func(l *ContainerLogLine) bool {
+		return emit(ctx, &EventEntry{Buffer: []byte(l.Log), Event: ContainerLogEvent(meta, l)}) == nil
+	}
+	for {
+		line, err := t.next(stop)
+		if err != nil {
+			break
+		}
+		l, err := ParseContainerLogLine(s.cfg.Format, line)
+		if err != nil {
+			continue
+		}
+		if full := asm.Add(l); full != nil && !deliver(full) {
+			return
+		}
+	}
+	for _, l := range asm.Flush() {
+		if !deliver(l) {
+			return
+		}
+	}
+}
+
+// Close stops the source.
+func (s *ContainerLogSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	// Files opened by Init are only closed here when Listen never ran.
+	for _, t := range s.initial {
+		t.close()
+	}
+	s.initial = nil
+}
@@ -0,0 +1,188 @@
+package eventwatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseContainerLogLine(t *testing.T) {
+	ts := time.Date(2024, 3, 10, 12, 0, 0, 123456789, time.UTC)
+	tests := []struct {
+		line string
+		want ContainerLogLine
+	}{
+		{`{"log":"GET /healthz 200\n","stream":"stdout","time":"2024-03-10T12:00:00.123456789Z"}`,
+			ContainerLogLine{Time: ts, Stream: "stdout", Log: "GET /healthz 200"}},
+		{`{"log":"part","stream":"stderr","time":"2024-03-10T12:00:00.123456789Z","attrs":{"app":"web"}}`,
+			ContainerLogLine{Time: ts, Stream: "stderr", Partial: true, Log: "part", Attrs: map[string]string{"app": "web"}}},
+		{`2024-03-10T12:00:00.123456789Z stderr F connection refused`,
+			ContainerLogLine{Time: ts, Stream: "stderr", Log: "connection refused"}},
+		{`2024-03-10T12:00:00.123456789Z stdout P first half `,
+			ContainerLogLine{Time: ts, Stream: "stdout", Partial: true, Log: "first half "}},
+		{`2024-03-10T12:00:00.123456789Z stdout F`,
+			ContainerLogLine{Time: ts, Stream: "stdout"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseContainerLogLine(ContainerLogAuto, []byte(tt.line))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.line, err)
+		}
+		if !got.Time.Equal(tt.want.Time) {
+			t.Fatalf("%s: time %v", tt.line, got.Time)
+		}
+		got.Time = tt.want.Time
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: got %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+	for _, bad := range []string{`{"stream":"stdout"}`, `not a timestamp stdout F x`, `2024-03-10T12:00:00Z stdout`} {
+		if _, err := ParseContainerLogLine(ContainerLogAuto, []byte(bad)); err == nil {
+			t.Fatalf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestParseContainerLogPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want ContainerMeta
+	}{
+		{"/var/log/pods/prod_web-0_5f3c-11ee/nginx/2.log",
+			ContainerMeta{Namespace: "prod", Pod: "web-0", PodUID: "5f3c-11ee", Container: "nginx", RestartCount: 2}},
+		{"/var/log/containers/web-0_prod_nginx-0123abcd.log",
+			ContainerMeta{Namespace: "prod", Pod: "web-0", Container: "nginx", ContainerID: "0123abcd"}},
+		{"/var/lib/docker/containers/0123abcd/0123abcd-json.log",
+			ContainerMeta{ContainerID: "0123abcd"}},
+		{"/var/log/app.log", ContainerMeta{}},
+	}
+	for _, tt := range tests {
+		if got := ParseContainerLogPath(tt.path); got != tt.want {
+			t.Fatalf("%s: got %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestContainerLogAssembler(t *testing.T) {
+	a := &ContainerLogAssembler{MaxSize: 10}
+	t0 := time.Unix(100, 0)
+	if a.Add(ContainerLogLine{Time: t0, Stream: "stdout", Partial: true, Log: "ab"}) != nil {
+		t.Fatal("partial line emitted")
+	}
+	// Other streams are assembled independently.
+	if l := a.Add(ContainerLogLine{Stream: "stderr", Log: "err"}); l == nil || l.Log != "err" {
+		t.Fatalf("got %+v", l)
+	}
+	l := a.Add(ContainerLogLine{Time: t0.Add(time.Second), Stream: "stdout", Log: "cd"})
+	if l == nil || l.Log != "abcd" || !l.Time.Equal(t0) || l.Partial {
+		t.Fatalf("got %+v", l)
+	}
+	// Lines reaching MaxSize are cut.
+	a.Add(ContainerLogLine{Stream: "stdout", Partial: true, Log: "0123456"})
+	if l := a.Add(ContainerLogLine{Stream: "stdout", Partial: true, Log: "789"}); l == nil || l.Log != "0123456789" {
+		t.Fatalf("got %+v", l)
+	}
+	a.Add(ContainerLogLine{Stream: "stdout", Partial: true, Log: "rest"})
+	if lines := a.Flush(); len(lines) != 1 || lines[0].Log != "rest" {
+		t.Fatalf("flushed %+v", lines)
+	}
+}
+
+func TestContainerLogSource(t *testing.T) {
+	dir := t.TempDir()
+	podDir := filepath.Join(dir, "pods", "prod_web-0_uid1", "nginx")
+	if err := os.MkdirAll(podDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	podLog := filepath.Join(podDir, "0.log")
+	if err := os.WriteFile(podLog, []byte("2024-03-10T12:00:00Z stdout F old line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	src := NewContainerLogSource(ContainerLogConfig{
+		Paths: []string{
+			filepath.Join(dir, "pods", "*", "*", "*.log"),
+			filepath.Join(dir, "docker", "*", "*-json.log"),
+		},
+		PollInterval:   10 * time.Millisecond,
+		RescanInterval: 20 * time.Millisecond,
+	})
+	if err := n.AddSource("containers", src); err != nil {
+		t.Fatal(err)
+	}
+
+	appendFile := func(path, s string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(s)
+		f.Close()
+	}
+	appendFile(podLog, "2024-03-10T12:00:01.5Z stderr P error: connection \n"+
+		"2024-03-10T12:00:01.7Z stdout F interleaved\n"+
+		"2024-03-10T12:00:02Z stderr F refused\n")
+
+	e := waitSyslogEntry(t, n)
+	if e.Event.Message != "interleaved" {
+		t.Fatalf("got %+v", e.Event)
+	}
+	ev := waitSyslogEntry(t, n).Event
+	if ev.Message != "error: connection refused" || ev.Provider != "nginx" || ev.Level != LevelError {
+		t.Fatalf("got %+v", ev)
+	}
+	if !ev.TimeCreated.Equal(time.Date(2024, 3, 10, 12, 0, 1, 5e8, time.UTC)) {
+		t.Fatalf("time %v", ev.TimeCreated)
+	}
+	for name, want := range map[string]string{"stream": "stderr", "namespace": "prod", "pod": "web-0", "pod_uid": "uid1", "container": "nginx", "restart_count": "0"} {
+		if v, _ := ev.Field(name); v != want {
+			t.Fatalf("%s = %q, want %q", name, v, want)
+		}
+	}
+
+	// A container appearing later is read from its start.
+	dockerDir := filepath.Join(dir, "docker", "abc123")
+	if err := os.MkdirAll(dockerDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(filepath.Join(dockerDir, "abc123-json.log"),
+		`{"log":"long ","stream":"stdout","time":"2024-03-10T12:00:03Z"}`+"\n"+
+			`{"log":"line\n","stream":"stdout","time":"2024-03-10T12:00:03Z"}`+"\n")
+	ev = waitSyslogEntry(t, n).Event
+	if ev.Message != "long line" || ev.Provider != "docker" {
+		t.Fatalf("got %+v", ev)
+	}
+	if v, _ := ev.Field("container_id"); v != "abc123" {
+		t.Fatalf("container_id %q", v)
+	}
+}
+
+func TestContainerLogSourceClose(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "0.log")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src := NewContainerLogSource(ContainerLogConfig{Paths: []string{path}, PollInterval: 10 * time.Millisecond})
+	if err := src.Init(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		src.Listen(context.Background(), func(context.Context, *EventEntry) error { return nil })
+	}()
+	// Close alone, with a live follower and ctx not cancelled, stops Listen.
+	time.Sleep(50 * time.Millisecond)
+	src.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Listen did not return after Close")
+	}
+}
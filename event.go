@@ -43,6 +43,14 @@ func EventLogRecordNumber(handle uintptr) (uint32, error) {
 	return 0, errors.New("EventLogRecordNumber not implemented on this OS")
 }
 
+func oldestRecordNumber(handle uintptr) (uint32, error) {
+	return 0, errors.New("oldestRecordNumber not implemented on this OS")
+}
+
+func OldestEventLogRecord(handle uintptr) (uint32, error) {
+	return 0, errors.New("OldestEventLogRecord not implemented on this OS")
+}
+
 func readEventLog(handle uintptr, flags, offset uint32) ([]byte, error) {
 	return nil, errors.New("readEventLog not implemented on this OS")
 }
@@ -19,6 +19,7 @@ var (
 	procCloseEventLog              = modadvapi32.MustFindProc("CloseEventLog")
 	procNotifyChangeEventLog       = modadvapi32.MustFindProc("NotifyChangeEventLog")
 	procGetNumberOfEventLogRecords = modadvapi32.MustFindProc("GetNumberOfEventLogRecords")
+	procGetOldestEventLogRecord    = modadvapi32.MustFindProc("GetOldestEventLogRecord")
 	procRegisterEventSourceW       = modadvapi32.MustFindProc("RegisterEventSourceW")
 	procReportEventW               = modadvapi32.MustFindProc("ReportEventW")
 	procDeregisterEventSource      = modadvapi32.MustFindProc("DeregisterEventSource")
@@ -38,14 +39,14 @@ func openEventLog(name string) (syscall.Handle, error) {
 func OpenEventLog(name string) (syscall.Handle, error) {
 	namePtr, err := syscall.UTF16PtrFromString(name)
 	if err != nil {
-		return InvalidHandle, err
+		return syscall.Handle(InvalidHandle), err
 	}
 	handle, _, err := procOpenEventLog.Call(
 		0,
 		uintptr(unsafe.Pointer(namePtr)),
 	)
 	if handle == 0 {
-		return InvalidHandle, errors.New("failed to open event: " + err.Error())
+		return syscall.Handle(InvalidHandle), errors.New("failed to open event: " + err.Error())
 	}
 	return syscall.Handle(handle), nil
 }
@@ -92,6 +93,22 @@ func EventLogRecordNumber(handle syscall.Handle) (uint32, error) {
 	return 0, errors.New("failed to get number of handle: " + err.Error())
 }
 
+func oldestRecordNumber(handle syscall.Handle) (uint32, error) {
+	return OldestEventLogRecord(handle)
+}
+
+func OldestEventLogRecord(handle syscall.Handle) (uint32, error) {
+	var retVal uint32
+	ret, _, err := procGetOldestEventLogRecord.Call(
+		uintptr(handle),
+		uintptr(unsafe.Pointer(&retVal)),
+	)
+	if ret != 0 {
+		return retVal, nil
+	}
+	return 0, errors.New("failed to get oldest record of handle: " + err.Error())
+}
+
 func readEventLog(handle syscall.Handle, flags, offset uint32) ([]byte, error) {
 	return ReadEventLog(handle, flags, offset)
 }
@@ -112,7 +129,7 @@ func ReadEventLog(handle syscall.Handle, flags, offset uint32) ([]byte, error) {
 		)
 		if ret == 0 {
 			if err == ERROR_HANDLE_EOF {
-				break
+				return nil, ERROR_HANDLE_EOF
 			} else if err == ERROR_INSUFFICIENT_BUFFER {
 				buffer = make([]byte, minByteNeeded)
 				BUFFER_SIZE = int(minByteNeeded)
@@ -123,7 +140,6 @@ func ReadEventLog(handle syscall.Handle, flags, offset uint32) ([]byte, error) {
 		}
 		return buffer[:bytesRead], nil
 	}
-	return nil, errors.New("unable to read event buffer")
 }
 
 func createEvent(
@@ -146,7 +162,7 @@ func CreateEvent(
 		uintptr(unsafe.Pointer(name)),
 	)
 	if ret == 0 {
-		return InvalidHandle, errors.New("unable to create event: " + err.Error())
+		return syscall.Handle(InvalidHandle), errors.New("unable to create event: " + err.Error())
 	}
 	return syscall.Handle(ret), nil
 }
@@ -0,0 +1,19 @@
+package eventwatcher
+
+// eventLogAPI is the subset of the advapi32 and kernel32 APIs used by the
+// event log watcher. Windows builds use the system calls in event_windows.go;
+// tests use an in-memory implementation so the watcher logic in
+// eventlogwatcher.go can be exercised on any OS.
+type eventLogAPI interface {
+	OpenEventLog(name string) (uintptr, error)
+	CloseEventLog(handle uintptr) error
+	NotifyChangeEventLog(handle, event uintptr) error
+	NumberOfEventLogRecords(handle uintptr) (uint32, error)
+	OldestEventLogRecord(handle uintptr) (uint32, error)
+	ReadEventLog(handle uintptr, flags, offset uint32) ([]byte, error)
+	CreateEvent(manualReset, initialState bool) (uintptr, error)
+	ResetEvent(handle uintptr) error
+	SetEvent(handle uintptr) error
+	WaitForMultipleObjects(handles []uintptr, waitAll bool, waitMilliseconds uint32) (uint32, error)
+	CloseHandle(handle uintptr) error
+}
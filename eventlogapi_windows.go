@@ -0,0 +1,69 @@
+//go:build windows
+// +build windows
+
+package eventwatcher
+
+import (
+	"syscall"
+)
+
+// windowsEventLogAPI implements eventLogAPI with the advapi32 and kernel32
+// calls from event_windows.go.
+type windowsEventLogAPI struct{}
+
+func (windowsEventLogAPI) OpenEventLog(name string) (uintptr, error) {
+	h, err := openEventLog(name)
+	return uintptr(h), err
+}
+
+func (windowsEventLogAPI) CloseEventLog(handle uintptr) error {
+	return closeEventLog(syscall.Handle(handle))
+}
+
+func (windowsEventLogAPI) NotifyChangeEventLog(handle, event uintptr) error {
+	return notifyChange(syscall.Handle(handle), syscall.Handle(event))
+}
+
+func (windowsEventLogAPI) NumberOfEventLogRecords(handle uintptr) (uint32, error) {
+	return eventRecordNumber(syscall.Handle(handle))
+}
+
+func (windowsEventLogAPI) OldestEventLogRecord(handle uintptr) (uint32, error) {
+	return oldestRecordNumber(syscall.Handle(handle))
+}
+
+func (windowsEventLogAPI) ReadEventLog(handle uintptr, flags, offset uint32) ([]byte, error) {
+	return readEventLog(syscall.Handle(handle), flags, offset)
+}
+
+func (windowsEventLogAPI) CreateEvent(manualReset, initialState bool) (uintptr, error) {
+	var manual, initial uint32
+	if manualReset {
+		manual = 1
+	}
+	if initialState {
+		initial = 1
+	}
+	h, err := createEvent(nil, manual, initial, nil)
+	return uintptr(h), err
+}
+
+func (windowsEventLogAPI) ResetEvent(handle uintptr) error {
+	return resetEvent(syscall.Handle(handle))
+}
+
+func (windowsEventLogAPI) SetEvent(handle uintptr) error {
+	return setEvent(syscall.Handle(handle))
+}
+
+func (windowsEventLogAPI) WaitForMultipleObjects(handles []uintptr, waitAll bool, waitMilliseconds uint32) (uint32, error) {
+	hs := make([]syscall.Handle, len(handles))
+	for i, h := range handles {
+		hs[i] = syscall.Handle(h)
+	}
+	return waitForMultipleObjects(hs, waitAll, waitMilliseconds)
+}
+
+func (windowsEventLogAPI) CloseHandle(handle uintptr) error {
+	return closeHandle(syscall.Handle(handle))
+}
@@ -0,0 +1,251 @@
+package eventwatcher
+
+import (
+	"context"
+	"errors"
+)
+
+// Event log watcher state machine shared by the Windows EventWatcher and the
+// tests. All system calls go through ew.api.
+
+var errWatcherStopped = errors.New("event watcher stopped")
+
+// newEventLogWatcher creates an EventWatcher that reads the event log called
+// name through api.
+func newEventLogWatcher(ctx context.Context, name string, eventChan chan *EventEntry, api eventLogAPI) *EventWatcher {
+	ctx, cancel := context.WithCancel(ctx)
+	return &EventWatcher{
+		Name:      name,
+		api:       api,
+		ctx:       ctx,
+		cancel:    cancel,
+		eventChan: eventChan,
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// openLog opens the event log, starts tracking after its newest record and
+// creates the change notification and cancel events. On failure the
+// handles opened so far are closed.
+func (ew *EventWatcher) openLog() (err error) {
+	handle, err := ew.api.OpenEventLog(ew.Name)
+	if err != nil {
+		return err
+	}
+	ew.handle = handle
+	defer func() {
+		if err != nil {
+			ew.closeLogHandles()
+		}
+	}()
+
+	oldest, count, err := ew.logPosition()
+	if err != nil {
+		return err
+	}
+	ew.records = newRecordTracker(oldest, count)
+	if count > 0 {
+		// Remember the newest record to notice a clear and refill.
+		rn := oldest + count - 1
+		record, err := ew.readRecord(rn)
+		if err == nil {
+			ew.records.done(rn, record)
+		} else if err != ERROR_INVALID_PARAMETER {
+			return err
+		}
+	}
+
+	if ew.eventHandle, err = ew.api.CreateEvent(false, true); err != nil {
+		return err
+	}
+
+	if ew.cancelHandle, err = ew.api.CreateEvent(true, false); err != nil {
+		return err
+	}
+	return nil
+}
+
+// stopLog cancels the context and triggers the cancel event.
+func (ew *EventWatcher) stopLog() {
+	ew.cancel()
+	if ew.cancelHandle != 0 {
+		ew.api.SetEvent(ew.cancelHandle)
+	}
+	select {
+	case <-ew.stopCh:
+	default:
+		close(ew.stopCh)
+	}
+}
+
+// closeLogHandles closes all handles associated with the EventWatcher.
+func (ew *EventWatcher) closeLogHandles() error {
+	var err error
+	if ew.handle != 0 {
+		if e := ew.api.CloseEventLog(ew.handle); e != nil {
+			err = e
+		}
+		ew.handle = 0
+	}
+	if ew.cancelHandle != 0 {
+		if e := ew.api.CloseHandle(ew.cancelHandle); e != nil {
+			err = e
+		}
+		ew.cancelHandle = 0
+	}
+	if ew.eventHandle != 0 {
+		if e := ew.api.CloseHandle(ew.eventHandle); e != nil {
+			err = e
+		}
+		ew.eventHandle = 0
+	}
+	return err
+}
+
+// watchLog waits for change notifications and emits new records until the
+// watcher is closed or a call fails.
+func (ew *EventWatcher) watchLog() {
+	defer ew.closeLogHandles()
+
+	if err := ew.api.NotifyChangeEventLog(ew.handle, ew.eventHandle); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case <-ew.stopCh:
+			return
+		case <-ew.ctx.Done():
+			return
+		default:
+			handles := []uintptr{ew.eventHandle, ew.cancelHandle}
+			event, err := ew.api.WaitForMultipleObjects(handles, false, INFINITE)
+			if err != nil {
+				return
+			}
+			switch event {
+			case WAIT_OBJECT_0:
+				// The change event is auto-reset by the wait; resetting it
+				// here would drop notifications that arrive while reading.
+				if err := ew.readNewRecords(); err != nil {
+					return
+				}
+			case WAIT_OBJECT_0 + 1:
+				return
+			default:
+				return
+			}
+		}
+	}
+}
+
+// logPosition returns the oldest record number and the record count.
+func (ew *EventWatcher) logPosition() (oldest, count uint32, err error) {
+	if oldest, err = ew.api.OldestEventLogRecord(ew.handle); err != nil {
+		return 0, 0, err
+	}
+	if count, err = ew.api.NumberOfEventLogRecords(ew.handle); err != nil {
+		return 0, 0, err
+	}
+	return oldest, count, nil
+}
+
+// readNewRecords emits, in order, every record added since the last call.
+// When the log is cleared or wraps between the position check and the
+// read, the position is checked again and reading resumes at the oldest
+// record.
+func (ew *EventWatcher) readNewRecords() error {
+	for attempt := 0; attempt < 3; attempt++ {
+		oldest, count, err := ew.logPosition()
+		if err != nil {
+			return err
+		}
+		last, err := ew.lastRecord(oldest, count)
+		if err != nil {
+			return err
+		}
+		first, end, _ := ew.records.check(oldest, count, last)
+		if first >= end {
+			return nil
+		}
+		err = ew.readRecords(first, end)
+		if err != ERROR_INVALID_PARAMETER {
+			return err
+		}
+	}
+	return nil
+}
+
+// lastRecord reads the last emitted record again, or returns nil when it
+// is no longer in the log.
+func (ew *EventWatcher) lastRecord(oldest, count uint32) ([]byte, error) {
+	rn, ok := ew.records.last()
+	if !ok || rn < oldest || rn >= oldest+count {
+		return nil, nil
+	}
+	record, err := ew.readRecord(rn)
+	if err == ERROR_INVALID_PARAMETER {
+		// The log changed since the position was read; check notices.
+		return nil, nil
+	}
+	return record, err
+}
+
+// readRecord reads record rn.
+func (ew *EventWatcher) readRecord(rn uint32) ([]byte, error) {
+	buf, err := ew.api.ReadEventLog(ew.handle, EVENTLOG_SEEK_READ|EVENTLOG_FORWARDS_READ, rn)
+	if err != nil {
+		return nil, err
+	}
+	r, err := decodeEventLogRecord(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:r.Length], nil
+}
+
+// readRecords seeks to record first and reads forward until record end has
+// been emitted or the end of the log is reached.
+func (ew *EventWatcher) readRecords(first, end uint32) error {
+	flags, offset := uint32(EVENTLOG_SEEK_READ|EVENTLOG_FORWARDS_READ), first
+	for ew.records.next < end {
+		buf, err := ew.api.ReadEventLog(ew.handle, flags, offset)
+		if err == ERROR_HANDLE_EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		flags, offset = EVENTLOG_SEQUENTIAL_READ|EVENTLOG_FORWARDS_READ, 0
+
+		for len(buf) > 0 {
+			r, err := decodeEventLogRecord(buf)
+			if err != nil {
+				return err
+			}
+			record := buf[:r.Length]
+			buf = buf[r.Length:]
+			if r.RecordNumber < ew.records.next {
+				continue
+			}
+			if !ew.emit(&EventEntry{Name: ew.Name, Handle: ew.handle, Buffer: record}) {
+				return errWatcherStopped
+			}
+			ew.records.done(r.RecordNumber, record)
+		}
+	}
+	return nil
+}
+
+// emit sends entry on the event channel and reports false when the watcher
+// was stopped before the entry could be delivered.
+func (ew *EventWatcher) emit(entry *EventEntry) bool {
+	select {
+	case ew.eventChan <- entry:
+		return true
+	case <-ew.stopCh:
+		return false
+	case <-ew.ctx.Done():
+		return false
+	}
+}
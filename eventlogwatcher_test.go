@@ -0,0 +1,262 @@
+package eventwatcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// startFakeWatcher opens name on api and runs watchLog in the background.
+// The returned channel is closed once watchLog returns.
+func startFakeWatcher(t *testing.T, api *fakeEventLog, name string) (*EventWatcher, chan *EventEntry, chan struct{}) {
+	t.Helper()
+	events := make(chan *EventEntry)
+	ew := newEventLogWatcher(context.Background(), name, events, api)
+	if err := ew.openLog(); err != nil {
+		t.Fatalf("openLog: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ew.watchLog()
+	}()
+	return ew, events, done
+}
+
+func waitEntry(t *testing.T, events chan *EventEntry) *EventEntry {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return nil
+}
+
+func waitDone(t *testing.T, done chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("watcher did not stop")
+	}
+}
+
+func TestEventLogWatcherEmitsNewRecord(t *testing.T) {
+	api := newFakeEventLog()
+	api.addLog("Application", 0)
+	api.appendRecord("Application", testRecord{EventID: 1, Source: "old"})
+	api.appendRecord("Application", testRecord{EventID: 2, Source: "old"})
+
+	ew, events, done := startFakeWatcher(t, api, "Application")
+	if ew.records.next != 3 {
+		t.Fatalf("next record = %d, want 3", ew.records.next)
+	}
+
+	rn := api.appendRecord("Application", testRecord{EventID: 1000, Source: "TestSource", Strings: []string{"hello"}})
+	e := waitEntry(t, events)
+	if e.Name != "Application" || e.Handle != ew.handle {
+		t.Errorf("unexpected entry %q handle %d", e.Name, e.Handle)
+	}
+	if nums := recordNumbers(e.Buffer); len(nums) != 1 || nums[0] != rn {
+		t.Errorf("emitted records %v, want [%d]", nums, rn)
+	}
+	ev, err := EventFromRecord(e.Buffer)
+	if err != nil || ev.EventID != 1000 || ev.Provider != "TestSource" {
+		t.Errorf("unexpected event %+v, %v", ev, err)
+	}
+
+	ew.stopLog()
+	waitDone(t, done)
+	if n := api.openHandles(); n != 0 {
+		t.Errorf("%d handles left open", n)
+	}
+}
+
+func TestEventLogWatcherIgnoresSpuriousNotification(t *testing.T) {
+	api := newFakeEventLog()
+	api.addLog("System", 0)
+	ew, events, done := startFakeWatcher(t, api, "System")
+
+	api.notify("System")
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected entry %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	ew.stopLog()
+	waitDone(t, done)
+}
+
+func TestEventLogWatcherStopsOnReadError(t *testing.T) {
+	api := newFakeEventLog()
+	api.addLog("System", 0)
+	_, _, done := startFakeWatcher(t, api, "System")
+
+	api.mu.Lock()
+	api.failRead = errors.New("read failed")
+	api.mu.Unlock()
+	api.appendRecord("System", testRecord{EventID: 7})
+
+	waitDone(t, done)
+	if n := api.openHandles(); n != 0 {
+		t.Errorf("%d handles left open", n)
+	}
+}
+
+func TestEventLogWatcherStopWhileDelivering(t *testing.T) {
+	api := newFakeEventLog()
+	api.addLog("System", 0)
+	ew, _, done := startFakeWatcher(t, api, "System")
+
+	// Nobody reads the event channel; stopping must still unblock Listen.
+	api.appendRecord("System", testRecord{EventID: 7})
+	time.Sleep(50 * time.Millisecond)
+	ew.stopLog()
+	waitDone(t, done)
+}
+
+func TestEventLogWatcherOpenMissingLog(t *testing.T) {
+	api := newFakeEventLog()
+	ew := newEventLogWatcher(context.Background(), "Missing", make(chan *EventEntry), api)
+	if err := ew.openLog(); err == nil {
+		t.Fatal("expected error opening a missing log")
+	}
+}
+
+func TestEventLogWatcherOpenFailureClosesLog(t *testing.T) {
+	for _, fail := range []string{"oldest", "read"} {
+		api := newFakeEventLog()
+		api.addLog("System", 0)
+		api.appendRecord("System", testRecord{EventID: 1})
+		if fail == "oldest" {
+			api.failOldest = errors.New("oldest failed")
+		} else {
+			api.failRead = errors.New("read failed")
+		}
+		ew := newEventLogWatcher(context.Background(), "System", make(chan *EventEntry), api)
+		if err := ew.openLog(); err == nil {
+			t.Fatalf("%s: expected an error", fail)
+		}
+		if n := api.openHandles(); n != 0 {
+			t.Errorf("%s: %d handles left open", fail, n)
+		}
+	}
+}
+
+// collectRecords reads n entries and returns their record numbers. Every
+// entry must hold exactly one record.
+func collectRecords(t *testing.T, events chan *EventEntry, n int) []uint32 {
+	t.Helper()
+	var nums []uint32
+	for i := 0; i < n; i++ {
+		got := recordNumbers(waitEntry(t, events).Buffer)
+		if len(got) != 1 {
+			t.Fatalf("entry holds %d records, want 1", len(got))
+		}
+		nums = append(nums, got[0])
+	}
+	return nums
+}
+
+func equalRecords(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEventLogWatcherCatchUp(t *testing.T) {
+	api := newFakeEventLog()
+	api.addLog("Application", 0)
+	api.appendRecord("Application", testRecord{EventID: 1})
+	ew, events, done := startFakeWatcher(t, api, "Application")
+	defer waitDone(t, done)
+	defer ew.stopLog()
+
+	// Enough records to need several ReadEventLog buffers.
+	var batch []testRecord
+	for i := 0; i < 40; i++ {
+		batch = append(batch, testRecord{EventID: uint32(100 + i), Strings: []string{"a fairly long insertion string to fill the read buffer"}})
+	}
+	want := api.appendRecords("Application", batch...)
+	if got := collectRecords(t, events, len(want)); !equalRecords(got, want) {
+		t.Fatalf("emitted %v, want %v", got, want)
+	}
+
+	want = api.appendRecords("Application", testRecord{EventID: 1}, testRecord{EventID: 2})
+	if got := collectRecords(t, events, 2); !equalRecords(got, want) {
+		t.Fatalf("emitted %v, want %v", got, want)
+	}
+}
+
+func TestEventLogWatcherWrap(t *testing.T) {
+	api := newFakeEventLog()
+	api.addLog("System", 5)
+	for i := 0; i < 5; i++ {
+		api.appendRecord("System", testRecord{EventID: 1})
+	}
+	ew, events, done := startFakeWatcher(t, api, "System")
+	defer waitDone(t, done)
+	defer ew.stopLog()
+
+	// Eight new records in a log holding five: 6, 7 and 8 are lost.
+	api.appendRecords("System", make([]testRecord, 8)...)
+	if got, want := collectRecords(t, events, 5), []uint32{9, 10, 11, 12, 13}; !equalRecords(got, want) {
+		t.Fatalf("emitted %v, want %v", got, want)
+	}
+
+	want := api.appendRecords("System", testRecord{EventID: 2})
+	if got := collectRecords(t, events, 1); !equalRecords(got, want) {
+		t.Fatalf("emitted %v, want %v", got, want)
+	}
+}
+
+func TestEventLogWatcherClear(t *testing.T) {
+	api := newFakeEventLog()
+	api.addLog("Application", 0)
+	api.appendRecords("Application", make([]testRecord, 3)...)
+	ew, events, done := startFakeWatcher(t, api, "Application")
+	defer waitDone(t, done)
+	defer ew.stopLog()
+
+	api.clear("Application")
+	want := api.appendRecords("Application", testRecord{EventID: 1}, testRecord{EventID: 2})
+	if !equalRecords(want, []uint32{1, 2}) {
+		t.Fatalf("fake numbered records %v after clear", want)
+	}
+	if got := collectRecords(t, events, 2); !equalRecords(got, want) {
+		t.Fatalf("emitted %v, want %v", got, want)
+	}
+}
+
+func TestEventLogWatcherClearRefill(t *testing.T) {
+	api := newFakeEventLog()
+	api.addLog("Application", 0)
+	api.appendRecords("Application", testRecord{EventID: 1}, testRecord{EventID: 2})
+	ew, events, done := startFakeWatcher(t, api, "Application")
+	defer waitDone(t, done)
+	defer ew.stopLog()
+
+	want := api.appendRecords("Application", testRecord{EventID: 3})
+	if got := collectRecords(t, events, 1); !equalRecords(got, want) {
+		t.Fatalf("emitted %v, want %v", got, want)
+	}
+
+	// Cleared and refilled past record 3 before the watcher looks: the
+	// record numbers went forward, but record 3 is a different record.
+	want = api.refill("Application",
+		testRecord{EventID: 10}, testRecord{EventID: 11}, testRecord{EventID: 12},
+		testRecord{EventID: 13}, testRecord{EventID: 14})
+	if got := collectRecords(t, events, len(want)); !equalRecords(got, want) {
+		t.Fatalf("emitted %v, want %v", got, want)
+	}
+}
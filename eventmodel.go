@@ -0,0 +1,59 @@
+package eventwatcher
+
+import "time"
+
+// Event is a decoded, source-independent representation of a single log
+// event. Records read from the Windows event log as well as events coming
+// from other sources are converted to Event so they can be rendered and
+// processed the same way. The fields follow the System section of the
+// Windows Event XML schema.
+type Event struct {
+	Provider        string      `json:"provider,omitempty"`
+	ProviderGUID    string      `json:"provider_guid,omitempty"`
+	EventSourceName string      `json:"event_source_name,omitempty"`
+	EventID         uint32      `json:"event_id"`
+	Qualifiers      uint16      `json:"qualifiers,omitempty"`
+	Version         uint8       `json:"version,omitempty"`
+	Level           Level       `json:"level,omitempty"`
+	Task            uint16      `json:"task,omitempty"`
+	Opcode          uint8       `json:"opcode,omitempty"`
+	Keywords        uint64      `json:"keywords,omitempty"`
+	TimeCreated     time.Time   `json:"time_created"`
+	RecordID        uint64      `json:"record_id,omitempty"`
+	ActivityID      string      `json:"activity_id,omitempty"`
+	ProcessID       uint32      `json:"process_id,omitempty"`
+	ThreadID        uint32      `json:"thread_id,omitempty"`
+	Channel         string      `json:"channel,omitempty"`
+	Computer        string      `json:"computer,omitempty"`
+	UserID          string      `json:"user_id,omitempty"`
+	Message         string      `json:"message,omitempty"`
+	Data            []EventData `json:"data,omitempty"`
+	Binary          []byte      `json:"binary,omitempty"`
+}
+
+// EventData is a single, optionally named, value of an event's payload.
+type EventData struct {
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value"`
+}
+
+// Field returns the value of the first data item called name.
+func (e *Event) Field(name string) (string, bool) {
+	for _, d := range e.Data {
+		if d.Name == name {
+			return d.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetField sets the data item called name, appending it when missing.
+func (e *Event) SetField(name, value string) {
+	for i := range e.Data {
+		if e.Data[i].Name == name {
+			e.Data[i].Value = value
+			return
+		}
+	}
+	e.Data = append(e.Data, EventData{Name: name, Value: value})
+}
@@ -11,22 +11,37 @@ type EventEntry struct {
 	Name   string  `json:"name"`
 	Handle uintptr `json:"handle"`
 	Buffer []byte  `json:"buffer"`
+	// Event is the decoded event for sources that parse what they
+	// receive; it is nil for raw watcher output.
+	Event *Event `json:"event,omitempty"`
 }
 
-// EventNotifier manages a collection of EventWatchers.
+// ErrNotifierClosed is returned by an EmitFunc once the notifier is closed.
+var ErrNotifierClosed = errors.New("event notifier closed")
+
+// EventNotifier manages a collection of EventWatchers and Sources.
 type EventNotifier struct {
 	EventLogChannel chan *EventEntry
 	watchers        map[string]*EventWatcher
+	sources         map[string]*hostedSource
 	ctx             context.Context
+	done            chan struct{}
 	wg              sync.WaitGroup
 	mu              sync.Mutex
 }
 
+type hostedSource struct {
+	source Source
+	cancel context.CancelFunc
+}
+
 // NewEventNotifier creates a new EventNotifier instance.
 func NewEventNotifier(ctx context.Context) *EventNotifier {
 	return &EventNotifier{
 		ctx:             ctx,
 		watchers:        make(map[string]*EventWatcher),
+		sources:         make(map[string]*hostedSource),
+		done:            make(chan struct{}),
 		EventLogChannel: make(chan *EventEntry),
 	}
 }
@@ -36,7 +51,7 @@ func (en *EventNotifier) AddWatcher(name string) error {
 	en.mu.Lock()
 	defer en.mu.Unlock()
 
-	if _, exists := en.watchers[name]; exists {
+	if en.exists(name) {
 		return errors.New(name + " event watcher already exists")
 	}
 
@@ -54,31 +69,101 @@ func (en *EventNotifier) AddWatcher(name string) error {
 	return nil
 }
 
-// RemoveWatcher removes an EventWatcher from the EventNotifier.
-func (en *EventNotifier) RemoveWatcher(name string) error {
+// AddSource initializes src and starts it under name. Events it emits are
+// delivered on EventLogChannel with Name set to name. Sources share their
+// names with watchers and are removed with RemoveWatcher.
+func (en *EventNotifier) AddSource(name string, src Source) error {
 	en.mu.Lock()
 	defer en.mu.Unlock()
 
-	watcher, exists := en.watchers[name]
-	if !exists {
-		return errors.New(name + " event watcher does not exist")
+	if en.exists(name) {
+		return errors.New(name + " event source already exists")
+	}
+	if err := src.Init(); err != nil {
+		return err
 	}
 
-	watcher.Close()
-	delete(en.watchers, name)
+	ctx, cancel := context.WithCancel(en.ctx)
+	en.sources[name] = &hostedSource{source: src, cancel: cancel}
+	emit := func(ctx context.Context, entry *EventEntry) error {
+		if entry.Name == "" {
+			entry.Name = name
+		}
+		return en.emit(ctx, entry)
+	}
+	en.wg.Add(1)
+	go func() {
+		defer en.wg.Done()
+		src.Listen(ctx, emit)
+	}()
 	return nil
 }
 
-// Close shuts down all EventWatchers and waits for them to exit.
+// exists reports whether a watcher or source is registered as name. The
+// caller must hold en.mu.
+func (en *EventNotifier) exists(name string) bool {
+	_, watcher := en.watchers[name]
+	_, source := en.sources[name]
+	return watcher || source
+}
+
+// emit delivers entry on EventLogChannel.
+func (en *EventNotifier) emit(ctx context.Context, entry *EventEntry) error {
+	select {
+	case <-en.done:
+		return ErrNotifierClosed
+	default:
+	}
+	select {
+	case en.EventLogChannel <- entry:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-en.done:
+		return ErrNotifierClosed
+	}
+}
+
+// RemoveWatcher removes an EventWatcher or Source from the EventNotifier.
+func (en *EventNotifier) RemoveWatcher(name string) error {
+	en.mu.Lock()
+	defer en.mu.Unlock()
+
+	if watcher, exists := en.watchers[name]; exists {
+		watcher.Close()
+		delete(en.watchers, name)
+		return nil
+	}
+	if hs, exists := en.sources[name]; exists {
+		hs.cancel()
+		hs.source.Close()
+		delete(en.sources, name)
+		return nil
+	}
+	return errors.New(name + " event watcher does not exist")
+}
+
+// Close shuts down all EventWatchers and Sources, waits for them to exit
+// and then closes EventLogChannel.
 func (en *EventNotifier) Close() {
 	en.mu.Lock()
 	for _, watcher := range en.watchers {
 		watcher.Close()
 	}
-	en.mu.Unlock()
+	for _, hs := range en.sources {
+		hs.cancel()
+		hs.source.Close()
+	}
 	en.watchers = make(map[string]*EventWatcher)
-	close(en.EventLogChannel)
+	en.sources = make(map[string]*hostedSource)
+	select {
+	case <-en.done:
+	default:
+		close(en.done)
+	}
+	en.mu.Unlock()
 	en.wg.Wait()
+	close(en.EventLogChannel)
 }
 
 // GetWatcher retrieves an EventWatcher by name.
@@ -92,3 +177,15 @@ func (en *EventNotifier) GetWatcher(name string) (*EventWatcher, error) {
 	}
 	return watcher, nil
 }
+
+// GetSource retrieves a Source by name.
+func (en *EventNotifier) GetSource(name string) (Source, error) {
+	en.mu.Lock()
+	defer en.mu.Unlock()
+
+	hs, exists := en.sources[name]
+	if !exists {
+		return nil, fmt.Errorf("%s event source not found", name)
+	}
+	return hs.source, nil
+}
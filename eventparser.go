@@ -3,19 +3,25 @@
 
 package eventwatcher
 
-// Non-Windows minimal parser stubs.
-// On non-Windows platforms we don't have EventLog structures; these
-// functions provide safe fallbacks for compilation and will be
-// implemented properly later when adding Unix event support.
-
-type EventLogRecord struct{}
+// Non-Windows parser fallbacks. EventLogRecord headers are decoded with
+// decodeEventLogRecord so records copied from Windows hosts can still be
+// inspected; the functions that need Windows APIs remain stubs.
 
 func ParseEventLogData(buf []byte) *EventLogRecord {
-	return &EventLogRecord{}
+	record := &EventLogRecord{}
+	for len(buf) > 0 {
+		r, err := decodeEventLogRecord(buf)
+		if err != nil {
+			break
+		}
+		record = r
+		buf = buf[r.Length:]
+	}
+	return record
 }
 
 func ParserEventLogData(buf []byte) (*EventLogRecord, error) {
-	return &EventLogRecord{}, nil
+	return decodeEventLogRecord(buf)
 }
 
 func FormatContent(buf []byte) string {
@@ -28,4 +34,4 @@ func FormatMessage(errorCode uint32) string {
 
 func LookupAccountSid(buf []byte, sidlen, sidoffset uint32) (string, string, error) {
 	return "", "", nil
-}
\ No newline at end of file
+}
@@ -11,25 +11,6 @@ import (
 	"golang.org/x/sys/windows"
 )
 
-type EventLogRecord struct {
-	Length              uint32
-	Reserved            uint32
-	RecordNumber        uint32
-	TimeGenerated       uint32
-	TimeWritten         uint32
-	EventID             uint32
-	EventType           uint16
-	NumStrings          uint16
-	EventCategory       uint16
-	ReservedFlags       uint16
-	ClosingRecordNumber uint32
-	StringOffset        uint32
-	UserSidLength       uint32
-	UserSidOffset       uint32
-	DataLength          uint32
-	DataOffset          uint32
-}
-
 // ParseEventLogData parses the event log data.
 func ParseEventLogData(buf []byte) *EventLogRecord {
 	var record EventLogRecord
@@ -0,0 +1,193 @@
+package eventwatcher
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// EventLogRecord mirrors the fixed-size header of the Win32 EVENTLOGRECORD
+// structure.
+// https://learn.microsoft.com/zh-cn/windows/win32/api/winnt/ns-winnt-eventlogrecord
+type EventLogRecord struct {
+	Length              uint32
+	Reserved            uint32
+	RecordNumber        uint32
+	TimeGenerated       uint32
+	TimeWritten         uint32
+	EventID             uint32
+	EventType           uint16
+	NumStrings          uint16
+	EventCategory       uint16
+	ReservedFlags       uint16
+	ClosingRecordNumber uint32
+	StringOffset        uint32
+	UserSidLength       uint32
+	UserSidOffset       uint32
+	DataLength          uint32
+	DataOffset          uint32
+}
+
+const (
+	// eventLogRecordSize is the size of the fixed EVENTLOGRECORD header.
+	eventLogRecordSize = 56
+	// eventLogSignature is the value of EVENTLOGRECORD.Reserved ("LfLe").
+	eventLogSignature = 0x654c664c
+)
+
+var errShortEventLogRecord = errors.New("event log record is truncated")
+
+// decodeEventLogRecord decodes the EVENTLOGRECORD header at the start of buf
+// without relying on the in-memory layout of the host, so records copied from
+// Windows machines can be decoded on any platform.
+func decodeEventLogRecord(buf []byte) (*EventLogRecord, error) {
+	if len(buf) < eventLogRecordSize {
+		return nil, errShortEventLogRecord
+	}
+	le := binary.LittleEndian
+	r := &EventLogRecord{
+		Length:              le.Uint32(buf[0:]),
+		Reserved:            le.Uint32(buf[4:]),
+		RecordNumber:        le.Uint32(buf[8:]),
+		TimeGenerated:       le.Uint32(buf[12:]),
+		TimeWritten:         le.Uint32(buf[16:]),
+		EventID:             le.Uint32(buf[20:]),
+		EventType:           le.Uint16(buf[24:]),
+		NumStrings:          le.Uint16(buf[26:]),
+		EventCategory:       le.Uint16(buf[28:]),
+		ReservedFlags:       le.Uint16(buf[30:]),
+		ClosingRecordNumber: le.Uint32(buf[32:]),
+		StringOffset:        le.Uint32(buf[36:]),
+		UserSidLength:       le.Uint32(buf[40:]),
+		UserSidOffset:       le.Uint32(buf[44:]),
+		DataLength:          le.Uint32(buf[48:]),
+		DataOffset:          le.Uint32(buf[52:]),
+	}
+	if r.Reserved != eventLogSignature {
+		return nil, fmt.Errorf("invalid event log record signature 0x%08x", r.Reserved)
+	}
+	if r.Length < eventLogRecordSize || int(r.Length) > len(buf) {
+		return nil, errShortEventLogRecord
+	}
+	return r, nil
+}
+
+// EventFromRecord decodes the EVENTLOGRECORD at the start of buf into an
+// Event. The source name, computer name, user SID, insertion strings and
+// binary data are all taken from the variable part of the record.
+func EventFromRecord(buf []byte) (*Event, error) {
+	r, err := decodeEventLogRecord(buf)
+	if err != nil {
+		return nil, err
+	}
+	rec := buf[:r.Length]
+
+	source, next := utf16z(rec, eventLogRecordSize)
+	computer, _ := utf16z(rec, next)
+
+	ev := &Event{
+		Provider:    source,
+		EventID:     r.EventID & 0xffff,
+		Qualifiers:  uint16(r.EventID >> 16),
+		Level:       LevelFromEventType(r.EventType),
+		Task:        r.EventCategory,
+		Keywords:    eventTypeKeywords(r.EventType),
+		TimeCreated: time.Unix(int64(r.TimeGenerated), 0).UTC(),
+		RecordID:    uint64(r.RecordNumber),
+		Computer:    computer,
+	}
+
+	if r.UserSidLength > 0 {
+		end := uint64(r.UserSidOffset) + uint64(r.UserSidLength)
+		if end > uint64(len(rec)) {
+			return nil, errShortEventLogRecord
+		}
+		sid, err := sidString(rec[r.UserSidOffset:end])
+		if err != nil {
+			return nil, err
+		}
+		ev.UserID = sid
+	}
+
+	off := int(r.StringOffset)
+	for i := 0; i < int(r.NumStrings); i++ {
+		if off >= len(rec) {
+			return nil, errShortEventLogRecord
+		}
+		var s string
+		s, off = utf16z(rec, off)
+		ev.Data = append(ev.Data, EventData{Value: s})
+	}
+
+	if r.DataLength > 0 {
+		end := uint64(r.DataOffset) + uint64(r.DataLength)
+		if end > uint64(len(rec)) {
+			return nil, errShortEventLogRecord
+		}
+		ev.Binary = append([]byte(nil), rec[r.DataOffset:end]...)
+	}
+	return ev, nil
+}
+
+// utf16z reads a NUL-terminated little-endian UTF-16 string starting at off
+// and returns it together with the offset following the terminator.
+func utf16z(buf []byte, off int) (string, int) {
+	var u []uint16
+	for off+1 < len(buf) {
+		c := binary.LittleEndian.Uint16(buf[off:])
+		off += 2
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u)), off
+}
+
+// sidString converts a binary security identifier to its "S-1-5-..." form.
+func sidString(b []byte) (string, error) {
+	if len(b) < 8 {
+		return "", errors.New("security identifier is truncated")
+	}
+	count := int(b[1])
+	if len(b) < 8+4*count {
+		return "", errors.New("security identifier is truncated")
+	}
+	var authority uint64
+	for _, c := range b[2:8] {
+		authority = authority<<8 | uint64(c)
+	}
+	var sb strings.Builder
+	sb.WriteString("S-")
+	sb.WriteString(strconv.Itoa(int(b[0])))
+	sb.WriteString("-")
+	sb.WriteString(strconv.FormatUint(authority, 10))
+	for i := 0; i < count; i++ {
+		sb.WriteString("-")
+		sb.WriteString(strconv.FormatUint(uint64(binary.LittleEndian.Uint32(b[8+4*i:])), 10))
+	}
+	return sb.String(), nil
+}
+
+const (
+	keywordAuditFailure    = 0x8010000000000000
+	keywordAuditSuccess    = 0x8020000000000000
+	keywordEventLogClassic = 0x0080000000000000
+)
+
+// eventTypeKeywords returns the keywords Windows reports for classic event
+// log records of the given type.
+func eventTypeKeywords(t uint16) uint64 {
+	switch t {
+	case EVENTLOG_AUDIT_SUCCESS:
+		return keywordAuditSuccess
+	case EVENTLOG_AUDIT_FAILURE:
+		return keywordAuditFailure
+	default:
+		return keywordEventLogClassic
+	}
+}
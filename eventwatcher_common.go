@@ -7,8 +7,9 @@ import "context"
 
 type EventWatcher struct {
 	Name         string
+	api          eventLogAPI
 	handle       uintptr
-	offset       uint32
+	records      recordTracker
 	eventHandle  uintptr
 	cancelHandle uintptr
 	ctx          context.Context
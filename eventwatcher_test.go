@@ -27,14 +27,14 @@ func TestEventWatcher(t *testing.T) {
 
 	err := notify.AddWatcher(channel)
 	if err != nil {
-		return
+		t.Skipf("AddWatcher failed (needs Windows Event Log): %v", err)
 	}
 
 	var wg sync.WaitGroup
 	// Register the event source
 	handle, err := eventwatcher.RegisterEventSource(nil, windows.StringToUTF16Ptr(channel))
 	if err != nil {
-		return
+		t.Fatalf("RegisterEventSource failed: %v", err)
 	}
 	defer eventwatcher.DeregisterEventSource(handle)
 
@@ -54,7 +54,7 @@ func TestEventWatcher(t *testing.T) {
 	message = "This is an event log message!"
 	err = eventwatcher.ReportEvent(handle, eventwatcher.EVENTLOG_INFORMATION_TYPE, 0, eventId, nil, []string{message}, nil)
 	if err != nil {
-		return
+		t.Fatalf("ReportEvent failed: %v", err)
 	}
 
 	wg.Wait()
@@ -67,14 +67,14 @@ func TestEmptyEventWatcher(t *testing.T) {
 
 	err := notify.AddWatcher(channel)
 	if err != nil {
-		return
+		t.Skipf("AddWatcher failed (needs Windows Event Log): %v", err)
 	}
 
 	var wg sync.WaitGroup
 	// Register the event source
 	handle, err := eventwatcher.RegisterEventSource(nil, windows.StringToUTF16Ptr(channel))
 	if err != nil {
-		return
+		t.Fatalf("RegisterEventSource failed: %v", err)
 	}
 	defer eventwatcher.DeregisterEventSource(handle)
 
@@ -94,7 +94,7 @@ func TestEmptyEventWatcher(t *testing.T) {
 	message = ""
 	err = eventwatcher.ReportEvent(handle, eventwatcher.EVENTLOG_INFORMATION_TYPE, 0, 0, nil, []string{message}, nil)
 	if err != nil {
-		return
+		t.Fatalf("ReportEvent failed: %v", err)
 	}
 	wg.Wait()
 }
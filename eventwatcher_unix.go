@@ -109,7 +109,13 @@ func (ew *EventWatcher) Listen() {
 				if err != nil {
 					continue
 				}
-				ew.eventChan <- &EventEntry{Name: ew.Name, Handle: 0, Buffer: b}
+				select {
+				case ew.eventChan <- &EventEntry{Name: ew.Name, Handle: 0, Buffer: b}:
+				case <-ew.stopCh:
+					return
+				case <-ew.ctx.Done():
+					return
+				}
 			}
 		case <-time.After(5 * time.Second):
 			// keep loop alive and responsive to stop signals
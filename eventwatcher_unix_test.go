@@ -50,3 +50,40 @@ func TestEventWatcherUnixFile(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestEventNotifierCloseWhileSending(t *testing.T) {
+	f, err := os.CreateTemp("", "ew_test_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	n := NewEventNotifier(context.Background())
+	if err := n.AddWatcher(f.Name()); err != nil {
+		t.Fatalf("AddWatcher failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Nobody reads EventLogChannel, so the watcher blocks sending the
+	// write. Closing the notifier must stop it rather than close the
+	// channel under it, which panics.
+	if err := os.WriteFile(f.Name(), []byte("hello world"), 0644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		n.Close()
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+	if _, ok := <-n.EventLogChannel; ok {
+		t.Fatal("EventLogChannel not closed")
+	}
+}
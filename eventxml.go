@@ -0,0 +1,319 @@
+package eventwatcher
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventXMLNamespace is the namespace of the Windows Event XML schema.
+const EventXMLNamespace = "http://schemas.microsoft.com/win/2004/08/events/event"
+
+// eventXMLTimeFormat is the SystemTime layout written by Windows.
+const eventXMLTimeFormat = "2006-01-02T15:04:05.0000000Z07:00"
+
+type xmlEvent struct {
+	XMLName       xml.Name
+	System        xmlSystem         `xml:"System"`
+	EventData     *xmlEventData     `xml:"EventData"`
+	UserData      *xmlUserData      `xml:"UserData"`
+	RenderingInfo *xmlRenderingInfo `xml:"RenderingInfo"`
+}
+
+type xmlSystem struct {
+	Provider      xmlProvider    `xml:"Provider"`
+	EventID       xmlEventID     `xml:"EventID"`
+	Version       uint8          `xml:"Version"`
+	Level         uint8          `xml:"Level"`
+	Task          uint16         `xml:"Task"`
+	Opcode        uint8          `xml:"Opcode"`
+	Keywords      string         `xml:"Keywords"`
+	TimeCreated   xmlTimeCreated `xml:"TimeCreated"`
+	EventRecordID uint64         `xml:"EventRecordID"`
+	Correlation   xmlCorrelation `xml:"Correlation"`
+	Execution     *xmlExecution  `xml:"Execution"`
+	Channel       string         `xml:"Channel"`
+	Computer      string         `xml:"Computer"`
+	Security      xmlSecurity    `xml:"Security"`
+}
+
+type xmlProvider struct {
+	Name            string `xml:"Name,attr,omitempty"`
+	GUID            string `xml:"Guid,attr,omitempty"`
+	EventSourceName string `xml:"EventSourceName,attr,omitempty"`
+}
+
+type xmlEventID struct {
+	Qualifiers string `xml:"Qualifiers,attr,omitempty"`
+	Value      uint32 `xml:",chardata"`
+}
+
+type xmlTimeCreated struct {
+	SystemTime string `xml:"SystemTime,attr"`
+}
+
+type xmlCorrelation struct {
+	ActivityID string `xml:"ActivityID,attr,omitempty"`
+}
+
+type xmlExecution struct {
+	ProcessID uint32 `xml:"ProcessID,attr"`
+	ThreadID  uint32 `xml:"ThreadID,attr"`
+}
+
+type xmlSecurity struct {
+	UserID string `xml:"UserID,attr,omitempty"`
+}
+
+type xmlEventData struct {
+	Data   []xmlData `xml:"Data"`
+	Binary string    `xml:"Binary,omitempty"`
+}
+
+type xmlData struct {
+	Name  string `xml:"Name,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type xmlUserData struct {
+	Inner []byte `xml:",innerxml"`
+}
+
+type xmlRenderingInfo struct {
+	Culture string `xml:"Culture,attr,omitempty"`
+	Message string `xml:"Message,omitempty"`
+}
+
+// MarshalEventXML renders ev as a Windows Event XML <Event> element. The
+// payload is written as EventData and a non-empty Message is written to
+// RenderingInfo, matching the output of `wevtutil qe /f:RenderedXml`.
+func MarshalEventXML(ev *Event) ([]byte, error) {
+	if ev == nil {
+		return nil, errors.New("nil event")
+	}
+	x := xmlEvent{
+		XMLName: xml.Name{Space: EventXMLNamespace, Local: "Event"},
+		System: xmlSystem{
+			Provider: xmlProvider{
+				Name:            ev.Provider,
+				GUID:            ev.ProviderGUID,
+				EventSourceName: ev.EventSourceName,
+			},
+			EventID:       xmlEventID{Value: ev.EventID},
+			Version:       ev.Version,
+			Level:         xmlLevel(ev),
+			Task:          ev.Task,
+			Opcode:        ev.Opcode,
+			Keywords:      fmt.Sprintf("0x%x", ev.Keywords),
+			EventRecordID: ev.RecordID,
+			Correlation:   xmlCorrelation{ActivityID: ev.ActivityID},
+			Channel:       ev.Channel,
+			Computer:      ev.Computer,
+			Security:      xmlSecurity{UserID: ev.UserID},
+		},
+	}
+	if ev.Qualifiers != 0 {
+		x.System.EventID.Qualifiers = strconv.FormatUint(uint64(ev.Qualifiers), 10)
+	}
+	if !ev.TimeCreated.IsZero() {
+		x.System.TimeCreated.SystemTime = ev.TimeCreated.UTC().Format(eventXMLTimeFormat)
+	}
+	if ev.ProcessID != 0 || ev.ThreadID != 0 {
+		x.System.Execution = &xmlExecution{ProcessID: ev.ProcessID, ThreadID: ev.ThreadID}
+	}
+	if len(ev.Data) > 0 || len(ev.Binary) > 0 {
+		x.EventData = &xmlEventData{Binary: strings.ToUpper(hex.EncodeToString(ev.Binary))}
+		for _, d := range ev.Data {
+			x.EventData.Data = append(x.EventData.Data, xmlData{Name: d.Name, Value: d.Value})
+		}
+	}
+	if ev.Message != "" {
+		x.RenderingInfo = &xmlRenderingInfo{Message: ev.Message}
+	}
+	return xml.Marshal(&x)
+}
+
+// xmlLevel returns the Event XML level of ev. Audit records are written at
+// LogAlways (0) like Windows does.
+func xmlLevel(ev *Event) uint8 {
+	switch ev.Keywords & (keywordAuditSuccess | keywordAuditFailure) {
+	case keywordAuditSuccess, keywordAuditFailure:
+		return 0
+	}
+	return ev.Level.WindowsLevel()
+}
+
+// WriteEventXML writes each event as an <Event> element followed by a
+// newline, the same layout `wevtutil qe /f:xml` produces.
+func WriteEventXML(w io.Writer, events ...*Event) error {
+	for _, ev := range events {
+		b, err := MarshalEventXML(ev)
+		if err != nil {
+			return err
+		}
+		b = append(b, '\n')
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnmarshalEventXML parses a single <Event> element.
+func UnmarshalEventXML(data []byte) (*Event, error) {
+	events, err := ParseEventXML(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, errors.New("no Event element found")
+	}
+	return events[0], nil
+}
+
+// ParseEventXML reads every <Event> element from r. It accepts the bare
+// sequence of elements written by `wevtutil qe /f:xml` as well as documents
+// that wrap them in an <Events> root element.
+func ParseEventXML(r io.Reader) ([]*Event, error) {
+	d := xml.NewDecoder(r)
+	var events []*Event
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "Event" {
+			continue
+		}
+		var x xmlEvent
+		if err := d.DecodeElement(&x, &start); err != nil {
+			return events, err
+		}
+		ev, err := x.event()
+		if err != nil {
+			return events, err
+		}
+		events = append(events, ev)
+	}
+}
+
+func (x *xmlEvent) event() (*Event, error) {
+	s := &x.System
+	ev := &Event{
+		Provider:        s.Provider.Name,
+		ProviderGUID:    s.Provider.GUID,
+		EventSourceName: s.Provider.EventSourceName,
+		EventID:         s.EventID.Value,
+		Version:         s.Version,
+		Level:           LevelFromWindows(s.Level),
+		Task:            s.Task,
+		Opcode:          s.Opcode,
+		RecordID:        s.EventRecordID,
+		ActivityID:      s.Correlation.ActivityID,
+		Channel:         s.Channel,
+		Computer:        s.Computer,
+		UserID:          s.Security.UserID,
+	}
+	if s.EventID.Qualifiers != "" {
+		q, err := strconv.ParseUint(s.EventID.Qualifiers, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EventID qualifiers %q: %w", s.EventID.Qualifiers, err)
+		}
+		ev.Qualifiers = uint16(q)
+	}
+	if k := strings.TrimSpace(s.Keywords); k != "" {
+		kw, err := strconv.ParseUint(strings.TrimPrefix(k, "0x"), 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keywords %q: %w", k, err)
+		}
+		ev.Keywords = kw
+	}
+	if s.Level == 0 {
+		// Audit records are logged at LogAlways; derive their level from the
+		// audit keywords instead.
+		switch ev.Keywords & (keywordAuditSuccess | keywordAuditFailure) {
+		case keywordAuditSuccess:
+			ev.Level = LevelFromEventType(EVENTLOG_AUDIT_SUCCESS)
+		case keywordAuditFailure:
+			ev.Level = LevelFromEventType(EVENTLOG_AUDIT_FAILURE)
+		}
+	}
+	if s.TimeCreated.SystemTime != "" {
+		t, err := time.Parse(time.RFC3339Nano, s.TimeCreated.SystemTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SystemTime %q: %w", s.TimeCreated.SystemTime, err)
+		}
+		ev.TimeCreated = t.UTC()
+	}
+	if s.Execution != nil {
+		ev.ProcessID = s.Execution.ProcessID
+		ev.ThreadID = s.Execution.ThreadID
+	}
+	if x.EventData != nil {
+		for _, d := range x.EventData.Data {
+			ev.Data = append(ev.Data, EventData{Name: d.Name, Value: d.Value})
+		}
+		if b := strings.TrimSpace(x.EventData.Binary); b != "" {
+			bin, err := hex.DecodeString(b)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EventData binary: %w", err)
+			}
+			ev.Binary = bin
+		}
+	}
+	if x.UserData != nil {
+		data, err := userDataFields(x.UserData.Inner)
+		if err != nil {
+			return nil, err
+		}
+		ev.Data = append(ev.Data, data...)
+	}
+	if x.RenderingInfo != nil {
+		ev.Message = x.RenderingInfo.Message
+	}
+	return ev, nil
+}
+
+// userDataFields flattens the provider-defined UserData payload into named
+// values, one per leaf element.
+func userDataFields(inner []byte) ([]EventData, error) {
+	d := xml.NewDecoder(bytes.NewReader(inner))
+	var (
+		fields []EventData
+		name   string
+		text   strings.Builder
+		leaf   bool
+	)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return fields, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid UserData: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name = t.Name.Local
+			text.Reset()
+			leaf = true
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if leaf {
+				fields = append(fields, EventData{Name: name, Value: text.String()})
+			}
+			leaf = false
+		}
+	}
+}
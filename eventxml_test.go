@@ -0,0 +1,227 @@
+package eventwatcher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// testRecord describes an EVENTLOGRECORD to be encoded by buildRecord.
+type testRecord struct {
+	RecordNumber uint32
+	Time         uint32
+	EventID      uint32
+	EventType    uint16
+	Category     uint16
+	Source       string
+	Computer     string
+	Sid          []byte
+	Strings      []string
+	Data         []byte
+}
+
+func putUTF16z(b *bytes.Buffer, s string) {
+	for _, c := range utf16.Encode([]rune(s)) {
+		binary.Write(b, binary.LittleEndian, c)
+	}
+	binary.Write(b, binary.LittleEndian, uint16(0))
+}
+
+func pad4(b *bytes.Buffer) {
+	for b.Len()%4 != 0 {
+		b.WriteByte(0)
+	}
+}
+
+// buildRecord encodes r the way ReadEventLog returns it.
+func buildRecord(r testRecord) []byte {
+	var body bytes.Buffer
+	body.Write(make([]byte, eventLogRecordSize))
+	putUTF16z(&body, r.Source)
+	putUTF16z(&body, r.Computer)
+	pad4(&body)
+	sidOffset := body.Len()
+	body.Write(r.Sid)
+	stringOffset := body.Len()
+	for _, s := range r.Strings {
+		putUTF16z(&body, s)
+	}
+	dataOffset := body.Len()
+	body.Write(r.Data)
+	pad4(&body)
+	length := body.Len() + 4
+	binary.Write(&body, binary.LittleEndian, uint32(length))
+
+	b := body.Bytes()
+	le := binary.LittleEndian
+	le.PutUint32(b[0:], uint32(length))
+	le.PutUint32(b[4:], eventLogSignature)
+	le.PutUint32(b[8:], r.RecordNumber)
+	le.PutUint32(b[12:], r.Time)
+	le.PutUint32(b[16:], r.Time)
+	le.PutUint32(b[20:], r.EventID)
+	le.PutUint16(b[24:], r.EventType)
+	le.PutUint16(b[26:], uint16(len(r.Strings)))
+	le.PutUint16(b[28:], r.Category)
+	le.PutUint32(b[36:], uint32(stringOffset))
+	le.PutUint32(b[40:], uint32(len(r.Sid)))
+	le.PutUint32(b[44:], uint32(sidOffset))
+	le.PutUint32(b[48:], uint32(len(r.Data)))
+	le.PutUint32(b[52:], uint32(dataOffset))
+	return b
+}
+
+// localSystemSid is the binary form of S-1-5-18.
+var localSystemSid = []byte{1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0}
+
+func TestEventFromRecord(t *testing.T) {
+	buf := buildRecord(testRecord{
+		RecordNumber: 42,
+		Time:         1700000000,
+		EventID:      0x40000000 | 1000,
+		EventType:    EVENTLOG_ERROR_TYPE,
+		Category:     3,
+		Source:       "TestSource",
+		Computer:     "HOST01",
+		Sid:          localSystemSid,
+		Strings:      []string{"first", "second"},
+		Data:         []byte{0xde, 0xad},
+	})
+
+	ev, err := EventFromRecord(buf)
+	if err != nil {
+		t.Fatalf("EventFromRecord: %v", err)
+	}
+	want := &Event{
+		Provider:    "TestSource",
+		EventID:     1000,
+		Qualifiers:  0x4000,
+		Level:       LevelError,
+		Task:        3,
+		Keywords:    keywordEventLogClassic,
+		TimeCreated: time.Unix(1700000000, 0).UTC(),
+		RecordID:    42,
+		Computer:    "HOST01",
+		UserID:      "S-1-5-18",
+		Data:        []EventData{{Value: "first"}, {Value: "second"}},
+		Binary:      []byte{0xde, 0xad},
+	}
+	if !reflect.DeepEqual(ev, want) {
+		t.Fatalf("unexpected event:\n got %+v\nwant %+v", ev, want)
+	}
+
+	if _, err := EventFromRecord(buf[:len(buf)-8]); err == nil {
+		t.Fatal("expected error for truncated record")
+	}
+}
+
+func TestEventXMLRoundTrip(t *testing.T) {
+	ev := &Event{
+		Provider:     "Microsoft-Windows-Security-Auditing",
+		ProviderGUID: "{54849625-5478-4994-A5BA-3E3B0328C30D}",
+		EventID:      4625,
+		Version:      0,
+		Level:        LevelWarning,
+		Task:         12544,
+		Keywords:     keywordAuditFailure,
+		TimeCreated:  time.Date(2024, 5, 1, 10, 0, 0, 123456700, time.UTC),
+		RecordID:     987,
+		ProcessID:    668,
+		ThreadID:     720,
+		Channel:      "Security",
+		Computer:     "dc01.example.com",
+		Message:      "An account failed to log on.",
+		Data: []EventData{
+			{Name: "TargetUserName", Value: "alice & <bob>"},
+			{Name: "IpAddress", Value: "10.0.0.5"},
+		},
+		Binary: []byte{1, 2, 0xab},
+	}
+	b, err := MarshalEventXML(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), `<Event xmlns="`+EventXMLNamespace+`"><System>`) {
+		t.Fatalf("unexpected XML prefix: %s", b)
+	}
+	got, err := UnmarshalEventXML(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, ev) {
+		t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", got, ev)
+	}
+}
+
+const wevtutilSample = `<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='Application Error'/><EventID Qualifiers='0'>1000</EventID><Version>0</Version><Level>2</Level><Task>100</Task><Opcode>0</Opcode><Keywords>0x80000000000000</Keywords><TimeCreated SystemTime='2024-03-04T05:06:07.8910111Z'/><EventRecordID>5501</EventRecordID><Correlation/><Execution ProcessID='0' ThreadID='0'/><Channel>Application</Channel><Computer>WS01</Computer><Security/></System><EventData><Data>app.exe</Data><Data>1.0.0.0</Data><Binary>00FF</Binary></EventData></Event>
+<Event xmlns='http://schemas.microsoft.com/win/2004/08/events/event'><System><Provider Name='Microsoft-Windows-Eventlog' Guid='{fc65ddd8-d6ef-4962-83d5-6e5cfe9ce148}'/><EventID>104</EventID><Version>0</Version><Level>4</Level><Task>104</Task><Opcode>0</Opcode><Keywords>0x8000000000000000</Keywords><TimeCreated SystemTime='2024-03-04T05:07:00.0000000Z'/><EventRecordID>5502</EventRecordID><Correlation/><Execution ProcessID='1234' ThreadID='5678'/><Channel>System</Channel><Computer>WS01</Computer><Security UserID='S-1-5-21-1-2-3-500'/></System><UserData><LogFileCleared xmlns='http://manifests.microsoft.com/win/2004/08/windows/eventlog'><SubjectUserName>admin</SubjectUserName><SubjectDomainName>CORP</SubjectDomainName><Channel>Application</Channel></LogFileCleared></UserData></Event>
+`
+
+func TestParseEventXMLWevtutil(t *testing.T) {
+	for _, doc := range []string{wevtutilSample, "<Events>\n" + wevtutilSample + "</Events>"} {
+		events, err := ParseEventXML(strings.NewReader(doc))
+		if err != nil {
+			t.Fatalf("ParseEventXML: %v", err)
+		}
+		if len(events) != 2 {
+			t.Fatalf("got %d events, want 2", len(events))
+		}
+
+		app := events[0]
+		if app.Provider != "Application Error" || app.EventID != 1000 || app.Level != LevelError || app.RecordID != 5501 {
+			t.Errorf("unexpected application event: %+v", app)
+		}
+		if app.Keywords != keywordEventLogClassic {
+			t.Errorf("keywords = 0x%x", app.Keywords)
+		}
+		if !app.TimeCreated.Equal(time.Date(2024, 3, 4, 5, 6, 7, 891011100, time.UTC)) {
+			t.Errorf("time = %v", app.TimeCreated)
+		}
+		if len(app.Data) != 2 || app.Data[0].Value != "app.exe" || !bytes.Equal(app.Binary, []byte{0, 0xff}) {
+			t.Errorf("unexpected event data: %+v %x", app.Data, app.Binary)
+		}
+
+		cleared := events[1]
+		if cleared.UserID != "S-1-5-21-1-2-3-500" || cleared.ProcessID != 1234 || cleared.ThreadID != 5678 {
+			t.Errorf("unexpected system values: %+v", cleared)
+		}
+		if v, ok := cleared.Field("SubjectUserName"); !ok || v != "admin" {
+			t.Errorf("SubjectUserName = %q, %v", v, ok)
+		}
+		if v, _ := cleared.Field("Channel"); v != "Application" {
+			t.Errorf("UserData Channel = %q", v)
+		}
+	}
+}
+
+func TestRecordToXML(t *testing.T) {
+	ev, err := EventFromRecord(buildRecord(testRecord{
+		RecordNumber: 7,
+		Time:         1700000000,
+		EventID:      1,
+		EventType:    EVENTLOG_INFORMATION_TYPE,
+		Source:       "TestSource",
+		Computer:     "HOST01",
+		Strings:      []string{"hello"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev.Channel = "Application"
+
+	var buf bytes.Buffer
+	if err := WriteEventXML(&buf, ev, ev); err != nil {
+		t.Fatal(err)
+	}
+	events, err := ParseEventXML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || !reflect.DeepEqual(events[0], ev) {
+		t.Fatalf("unexpected round trip result: %+v", events)
+	}
+}
@@ -0,0 +1,338 @@
+package eventwatcher
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// fakeEventLog is an in-memory implementation of eventLogAPI. Logs are
+// created with addLog and records appended with appendRecord; registered
+// change notifications are signalled on every append, like the real
+// NotifyChangeEventLog.
+type fakeEventLog struct {
+	mu      sync.Mutex
+	logs    map[string]*fakeLog
+	handles map[uintptr]*fakeLogHandle
+	events  map[uintptr]*fakeEvent
+	next    uintptr
+	changed chan struct{}
+
+	// failRead, when set, is returned by the next ReadEventLog call.
+	failRead error
+	// failOldest, when set, is returned by the next OldestEventLogRecord
+	// call.
+	failOldest error
+}
+
+type fakeLog struct {
+	// records holds the encoded records, oldest first.
+	records [][]byte
+	// oldest is the record number of records[0].
+	oldest uint32
+	// capacity is the maximum number of records kept; older records are
+	// overwritten once it is reached. Zero means unlimited.
+	capacity int
+	notify   []uintptr
+}
+
+type fakeLogHandle struct {
+	log *fakeLog
+	// pos is the record number of the next sequential read.
+	pos uint32
+}
+
+type fakeEvent struct {
+	manualReset bool
+	signaled    bool
+}
+
+func newFakeEventLog() *fakeEventLog {
+	return &fakeEventLog{
+		logs:    make(map[string]*fakeLog),
+		handles: make(map[uintptr]*fakeLogHandle),
+		events:  make(map[uintptr]*fakeEvent),
+		next:    0x100,
+		changed: make(chan struct{}),
+	}
+}
+
+// addLog creates an empty log whose first record will be numbered 1.
+func (f *fakeEventLog) addLog(name string, capacity int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logs[name] = &fakeLog{oldest: 1, capacity: capacity}
+}
+
+// appendRecord appends r, numbering it after the newest record, and
+// signals the change notifications of the log. It returns the record
+// number assigned to r.
+func (f *fakeEventLog) appendRecord(name string, r testRecord) uint32 {
+	return f.appendRecords(name, r)[0]
+}
+
+// appendRecords appends all records before signalling a single change
+// notification, as happens when several events arrive between two
+// notifications. Once the capacity of the log is reached the oldest
+// records are overwritten.
+func (f *fakeEventLog) appendRecords(name string, rs ...testRecord) []uint32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.appendLocked(f.logs[name], rs)
+}
+
+func (f *fakeEventLog) appendLocked(l *fakeLog, rs []testRecord) []uint32 {
+	nums := make([]uint32, len(rs))
+	for i, r := range rs {
+		r.RecordNumber = l.oldest + uint32(len(l.records))
+		l.records = append(l.records, buildRecord(r))
+		if l.capacity > 0 && len(l.records) > l.capacity {
+			l.records = l.records[1:]
+			l.oldest++
+		}
+		nums[i] = r.RecordNumber
+	}
+	f.signalLocked(l.notify...)
+	return nums
+}
+
+// clear empties the log the way ClearEventLog does: numbering restarts at 1.
+func (f *fakeEventLog) clear(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l := f.logs[name]
+	l.records = nil
+	l.oldest = 1
+	f.signalLocked(l.notify...)
+}
+
+// refill clears the log and appends rs before signalling a single change
+// notification, as happens when a log is cleared and refilled between two
+// notifications.
+func (f *fakeEventLog) refill(name string, rs ...testRecord) []uint32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l := f.logs[name]
+	l.records = nil
+	l.oldest = 1
+	return f.appendLocked(l, rs)
+}
+
+// notify signals the change notifications of the log without adding records.
+func (f *fakeEventLog) notify(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.signalLocked(f.logs[name].notify...)
+}
+
+// openHandles returns the number of log handles and events not yet closed.
+func (f *fakeEventLog) openHandles() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.handles) + len(f.events)
+}
+
+func (f *fakeEventLog) signalLocked(handles ...uintptr) {
+	for _, h := range handles {
+		if ev, ok := f.events[h]; ok {
+			ev.signaled = true
+		}
+	}
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeEventLog) newHandleLocked() uintptr {
+	f.next++
+	return f.next
+}
+
+var errFakeHandle = errors.New("invalid handle")
+
+func (f *fakeEventLog) OpenEventLog(name string) (uintptr, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l, ok := f.logs[name]
+	if !ok {
+		return 0, errors.New("failed to open event: log " + name + " does not exist")
+	}
+	h := f.newHandleLocked()
+	f.handles[h] = &fakeLogHandle{log: l, pos: l.oldest}
+	return h, nil
+}
+
+func (f *fakeEventLog) CloseEventLog(handle uintptr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.handles[handle]; !ok {
+		return errFakeHandle
+	}
+	delete(f.handles, handle)
+	return nil
+}
+
+func (f *fakeEventLog) NotifyChangeEventLog(handle, event uintptr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lh, ok := f.handles[handle]
+	if !ok {
+		return errFakeHandle
+	}
+	if _, ok := f.events[event]; !ok {
+		return errFakeHandle
+	}
+	lh.log.notify = append(lh.log.notify, event)
+	return nil
+}
+
+func (f *fakeEventLog) NumberOfEventLogRecords(handle uintptr) (uint32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lh, ok := f.handles[handle]
+	if !ok {
+		return 0, errFakeHandle
+	}
+	return uint32(len(lh.log.records)), nil
+}
+
+// OldestEventLogRecord reports 0 for an empty log.
+func (f *fakeEventLog) OldestEventLogRecord(handle uintptr) (uint32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failOldest; err != nil {
+		f.failOldest = nil
+		return 0, err
+	}
+	lh, ok := f.handles[handle]
+	if !ok {
+		return 0, errFakeHandle
+	}
+	if len(lh.log.records) == 0 {
+		return 0, nil
+	}
+	return lh.log.oldest, nil
+}
+
+// ReadEventLog returns as many consecutive records as fit into 4096 bytes,
+// but always at least one, mirroring the buffer handling of ReadEventLog in
+// event_windows.go.
+func (f *fakeEventLog) ReadEventLog(handle uintptr, flags, offset uint32) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failRead; err != nil {
+		f.failRead = nil
+		return nil, err
+	}
+	lh, ok := f.handles[handle]
+	if !ok {
+		return nil, errFakeHandle
+	}
+	l := lh.log
+	pos := lh.pos
+	if flags&EVENTLOG_SEEK_READ != 0 {
+		pos = offset
+		if pos < l.oldest || pos >= l.oldest+uint32(len(l.records)) {
+			return nil, ERROR_INVALID_PARAMETER
+		}
+	}
+	if pos < l.oldest || pos >= l.oldest+uint32(len(l.records)) {
+		return nil, ERROR_HANDLE_EOF
+	}
+	var buf []byte
+	for i := int(pos - l.oldest); i < len(l.records); i++ {
+		if len(buf) > 0 && len(buf)+len(l.records[i]) > 4096 {
+			break
+		}
+		buf = append(buf, l.records[i]...)
+		pos++
+	}
+	lh.pos = pos
+	return buf, nil
+}
+
+func (f *fakeEventLog) CreateEvent(manualReset, initialState bool) (uintptr, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h := f.newHandleLocked()
+	f.events[h] = &fakeEvent{manualReset: manualReset, signaled: initialState}
+	return h, nil
+}
+
+func (f *fakeEventLog) ResetEvent(handle uintptr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ev, ok := f.events[handle]
+	if !ok {
+		return errFakeHandle
+	}
+	ev.signaled = false
+	return nil
+}
+
+func (f *fakeEventLog) SetEvent(handle uintptr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.events[handle]; !ok {
+		return errFakeHandle
+	}
+	f.signalLocked(handle)
+	return nil
+}
+
+// WaitForMultipleObjects waits for any of the handles; waitAll is not
+// supported.
+func (f *fakeEventLog) WaitForMultipleObjects(handles []uintptr, waitAll bool, waitMilliseconds uint32) (uint32, error) {
+	var timeout <-chan time.Time
+	if waitMilliseconds != INFINITE {
+		timer := time.NewTimer(time.Duration(waitMilliseconds) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		f.mu.Lock()
+		for i, h := range handles {
+			ev, ok := f.events[h]
+			if !ok {
+				f.mu.Unlock()
+				return WAIT_FAILED, errors.New("unable to wait for multiple objects: invalid handle")
+			}
+			if ev.signaled {
+				if !ev.manualReset {
+					ev.signaled = false
+				}
+				f.mu.Unlock()
+				return WAIT_OBJECT_0 + uint32(i), nil
+			}
+		}
+		changed := f.changed
+		f.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout:
+			return WAIT_TIMEOUT, nil
+		}
+	}
+}
+
+func (f *fakeEventLog) CloseHandle(handle uintptr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.events[handle]; !ok {
+		return errFakeHandle
+	}
+	delete(f.events, handle)
+	return nil
+}
+
+// recordNumbers returns the record numbers of the records in buf.
+func recordNumbers(buf []byte) []uint32 {
+	var nums []uint32
+	for len(buf) >= eventLogRecordSize {
+		length := binary.LittleEndian.Uint32(buf)
+		nums = append(nums, binary.LittleEndian.Uint32(buf[8:]))
+		buf = buf[length:]
+	}
+	return nums
+}
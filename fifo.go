@@ -0,0 +1,268 @@
+//go:build !windows
+// +build !windows
+
+package eventwatcher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// FIFOConfig configures a FIFOSource.
+type FIFOConfig struct {
+	// Path of the named pipe.
+	Path string
+	// Create makes the named pipe with Mode (default 0o600) when it does
+	// not exist.
+	Create bool
+	Mode   os.FileMode
+	// Provider of the events; defaults to the base name of Path.
+	Provider string
+	// MaxLineSize defaults to DefaultStreamLineSize.
+	MaxLineSize int
+}
+
+const (
+	// fifoReopenDelay is how long the source waits before opening the
+	// pipe again when it cannot be opened or a writer left without
+	// writing.
+	fifoReopenDelay = 100 * time.Millisecond
+	// fifoCheckInterval is how often an idle source checks whether Path
+	// was replaced by another pipe.
+	fifoCheckInterval = 500 * time.Millisecond
+)
+
+var errFIFOClosed = errors.New("fifo: source closed")
+
+// FIFOSource is a Source reading lines from a named pipe. The pipe is opened
+// read-only; when the last writer goes away, the source emits what remains
+// of an unterminated line and opens Path again. An idle source also opens
+// Path again when it was deleted and re-created. The new read end is opened
+// before the old one is closed, so a writer arriving in between finds a
+// reader.
+type FIFOSource struct {
+	cfg FIFOConfig
+	// fd is the read end of the pipe; wake is a pipe whose read end
+	// becomes readable when the source is closed.
+	fd   int
+	wake [2]int
+
+	mu        sync.Mutex
+	listening bool
+	closed    bool
+}
+
+// NewFIFOSource creates a FIFOSource.
+func NewFIFOSource(cfg FIFOConfig) *FIFOSource {
+	if cfg.Mode == 0 {
+		cfg.Mode = 0o600
+	}
+	if cfg.MaxLineSize <= 0 {
+		cfg.MaxLineSize = DefaultStreamLineSize
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = filepath.Base(cfg.Path)
+	}
+	return &FIFOSource{cfg: cfg, fd: -1, wake: [2]int{-1, -1}}
+}
+
+// Init opens the named pipe, creating it if configured.
+func (s *FIFOSource) Init() error {
+	_, err := os.Stat(s.cfg.Path)
+	if os.IsNotExist(err) && s.cfg.Create {
+		if err := syscall.Mkfifo(s.cfg.Path, uint32(s.cfg.Mode.Perm())); err != nil {
+			return &os.PathError{Op: "mkfifo", Path: s.cfg.Path, Err: err}
+		}
+	}
+	fd, err := s.open()
+	if err != nil {
+		return err
+	}
+	if err := unix.Pipe(s.wake[:]); err != nil {
+		unix.Close(fd)
+		return err
+	}
+	s.fd = fd
+	return nil
+}
+
+// open opens the read end of the pipe without waiting for a writer.
+func (s *FIFOSource) open() (int, error) {
+	fd, err := unix.Open(s.cfg.Path, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, &os.PathError{Op: "open", Path: s.cfg.Path, Err: err}
+	}
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil || st.Mode&unix.S_IFMT != unix.S_IFIFO {
+		unix.Close(fd)
+		return -1, fmt.Errorf("%s is not a named pipe", s.cfg.Path)
+	}
+	return fd, nil
+}
+
+// Listen emits lines until ctx is done or Close is called.
+func (s *FIFOSource) Listen(ctx context.Context, emit EmitFunc) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.listening = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.listening = false
+		s.closeFDs()
+	}()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-stop:
+		}
+	}()
+
+	r := bufio.NewReader(fifoReader{s})
+	read := false
+	for {
+		line, err := readLimitedLine(r, s.cfg.MaxLineSize)
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 {
+			read = true
+			ev := lineEvent(s.cfg.Provider, string(line), LevelInfo)
+			if emit(ctx, &EventEntry{Buffer: line, Event: ev}) != nil {
+				return
+			}
+		}
+		if err != io.EOF {
+			if err != nil {
+				return
+			}
+			continue
+		}
+		// The last writer went away; a writer that left without writing
+		// waits out the delay so that the loop does not spin.
+		if !read && !s.sleep() {
+			return
+		}
+		for !s.reopen() {
+			if !s.sleep() {
+				return
+			}
+		}
+		read = false
+	}
+}
+
+// reopen replaces the read end with a new one opened on Path.
+func (s *FIFOSource) reopen() bool {
+	fd, err := s.open()
+	if err != nil {
+		return false
+	}
+	unix.Close(s.fd)
+	s.fd = fd
+	return true
+}
+
+// sleep waits for fifoReopenDelay and reports false when the source was
+// closed first.
+func (s *FIFOSource) sleep() bool {
+	fds := []unix.PollFd{{Fd: int32(s.wake[0]), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, int(fifoReopenDelay/time.Millisecond))
+	return n == 0 || err == unix.EINTR
+}
+
+// replaced reports whether Path no longer refers to the open pipe.
+func (s *FIFOSource) replaced() bool {
+	var open, cur unix.Stat_t
+	if unix.Fstat(s.fd, &open) != nil || unix.Stat(s.cfg.Path, &cur) != nil {
+		return false
+	}
+	return open.Dev != cur.Dev || open.Ino != cur.Ino
+}
+
+// Close stops the source and closes the pipe.
+func (s *FIFOSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if !s.listening {
+		s.closeFDs()
+		return
+	}
+	// Listen closes the descriptors once it returned from poll.
+	unix.Write(s.wake[1], []byte{0})
+}
+
+// closeFDs closes the pipe and the wake pipe. The caller must hold s.mu.
+func (s *FIFOSource) closeFDs() {
+	for _, fd := range []int{s.fd, s.wake[0], s.wake[1]} {
+		if fd >= 0 {
+			unix.Close(fd)
+		}
+	}
+	s.fd, s.wake = -1, [2]int{-1, -1}
+}
+
+// fifoReader reads the current read end of a FIFOSource, waiting for data.
+// It returns io.EOF when no writer is left or Path was replaced, and
+// errFIFOClosed once the source was closed.
+type fifoReader struct {
+	s *FIFOSource
+}
+
+func (r fifoReader) Read(p []byte) (int, error) {
+	for {
+		fds := []unix.PollFd{
+			{Fd: int32(r.s.fd), Events: unix.POLLIN},
+			{Fd: int32(r.s.wake[0]), Events: unix.POLLIN},
+		}
+		n, err := unix.Poll(fds, int(fifoCheckInterval/time.Millisecond))
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			if r.s.replaced() {
+				return 0, io.EOF
+			}
+			continue
+		}
+		if fds[1].Revents != 0 {
+			return 0, errFIFOClosed
+		}
+		if fds[0].Revents == 0 {
+			continue
+		}
+		n, err = unix.Read(r.s.fd, p)
+		switch {
+		case err == unix.EAGAIN || err == unix.EINTR:
+			continue
+		case err != nil:
+			return 0, err
+		case n == 0:
+			return 0, io.EOF
+		}
+		return n, nil
+	}
+}
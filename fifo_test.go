@@ -0,0 +1,140 @@
+//go:build !windows
+// +build !windows
+
+package eventwatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFIFOSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.fifo")
+	n := NewEventNotifier(context.Background())
+	if err := n.AddSource("fifo", NewFIFOSource(FIFOConfig{Path: path, Create: true})); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Fatalf("fifo not created: %v", err)
+	}
+
+	// Reading goes on after a writer went away, and nothing is lost when
+	// writers come and go before the lines are read.
+	batches := [][]string{{"one", "two"}, {"warning: three"}}
+	for _, lines := range batches {
+		w, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range lines {
+			if _, err := w.WriteString(line + "\n"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.Close()
+	}
+	for _, lines := range batches {
+		for _, want := range lines {
+			if e := waitSyslogEntry(t, n); e.Event.Message != want || e.Event.Provider != "app.fifo" {
+				t.Fatalf("got %+v, want %q", e.Event, want)
+			}
+		}
+	}
+
+	w, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("four\n")
+	w.Close()
+	if e := waitSyslogEntry(t, n); e.Event.Message != "four" {
+		t.Fatalf("got %q after the writers left", e.Event.Message)
+	}
+
+	// Close must not hang while no writer is connected.
+	closed := make(chan struct{})
+	go func() {
+		n.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close blocked")
+	}
+
+	if err := NewFIFOSource(FIFOConfig{Path: filepath.Join(t.TempDir(), "missing")}).Init(); err == nil {
+		t.Fatal("expected an error for a missing pipe")
+	}
+}
+
+// writeFIFO opens the pipe at path for writing, writes s and closes it.
+func writeFIFO(path, s string) error {
+	w, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = w.WriteString(s)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func TestFIFOSourcePartialLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.fifo")
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("fifo", NewFIFOSource(FIFOConfig{Path: path, Create: true})); err != nil {
+		t.Fatal(err)
+	}
+	// A writer leaving mid-line ends the line; it is not joined to what
+	// the next writer sends.
+	if err := writeFIFO(path, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitSyslogEntry(t, n); e.Event.Message != "abc" {
+		t.Fatalf("got %q", e.Event.Message)
+	}
+	if err := writeFIFO(path, "def\n"); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitSyslogEntry(t, n); e.Event.Message != "def" {
+		t.Fatalf("got %q", e.Event.Message)
+	}
+}
+
+func TestFIFOSourceRecreated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.fifo")
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("fifo", NewFIFOSource(FIFOConfig{Path: path, Create: true})); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFIFO(path, "before\n"); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitSyslogEntry(t, n); e.Event.Message != "before" {
+		t.Fatalf("got %q", e.Event.Message)
+	}
+
+	// The application removes the pipe and makes a new one on restart.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan error, 1)
+	go func() { written <- writeFIFO(path, "after\n") }()
+	if e := waitSyslogEntry(t, n); e.Event.Message != "after" {
+		t.Fatalf("got %q", e.Event.Message)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+}
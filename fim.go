@@ -0,0 +1,398 @@
+package eventwatcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// DefaultFIMMaxHashSize is the size above which file contents are not
+	// hashed.
+	DefaultFIMMaxHashSize = 64 << 20
+	// DefaultFIMRescanInterval is how often all files are scanned and
+	// hashed again, catching changes that were not notified or that kept
+	// the size and modification time.
+	DefaultFIMRescanInterval = 10 * time.Minute
+	// fimDebounce groups the notifications of one change, such as an
+	// editor writing and renaming a file, into one scan.
+	fimDebounce = 100 * time.Millisecond
+)
+
+// The changes reported by a FIMSource.
+const (
+	FIMCreated  = "created"
+	FIMModified = "modified"
+	FIMChmod    = "chmod"
+	FIMChown    = "chown"
+	FIMTouched  = "touched"
+	FIMRenamed  = "renamed"
+	FIMDeleted  = "deleted"
+)
+
+// FileState is what file integrity monitoring records of a file. UID and
+// GID are -1 where ownership is not available.
+type FileState struct {
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	UID     int         `json:"uid"`
+	GID     int         `json:"gid"`
+	ModTime time.Time   `json:"mtime"`
+	Inode   uint64      `json:"inode,omitempty"`
+	// SHA256 is the hex encoded hash of the content; it is empty for files
+	// larger than the hashing limit.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// FIMChange is a difference between two scans.
+type FIMChange struct {
+	// Type is one of FIMCreated, FIMModified, FIMChmod, FIMChown,
+	// FIMTouched, FIMRenamed and FIMDeleted.
+	Type string
+	Path string
+	// OldPath is the former path of a renamed file.
+	OldPath string
+	// Old and New are nil for created and deleted files respectively.
+	Old, New *FileState
+	// Changes lists the attributes that differ: "content", "mode",
+	// "owner" and "mtime".
+	Changes []string
+}
+
+// DiffFileStates compares two scans. Files that were deleted while a file
+// with the same content and inode appeared are reported as renamed. The
+// changes are sorted by path.
+func DiffFileStates(old, cur map[string]FileState) []FIMChange {
+	var changes []FIMChange
+	var created, deleted []string
+	for path, n := range cur {
+		o, ok := old[path]
+		if !ok {
+			created = append(created, path)
+			continue
+		}
+		if c := compareFileStates(o, n); c != nil {
+			o, n := o, n
+			changes = append(changes, FIMChange{Type: fimChangeType(c), Path: path, Old: &o, New: &n, Changes: c})
+		}
+	}
+	for path := range old {
+		if _, ok := cur[path]; !ok {
+			deleted = append(deleted, path)
+		}
+	}
+	sort.Strings(created)
+	sort.Strings(deleted)
+	renamed := make(map[string]bool)
+	for _, path := range created {
+		n := cur[path]
+		c := FIMChange{Type: FIMCreated, Path: path, New: &n}
+		for _, from := range deleted {
+			o := old[from]
+			if !renamed[from] && o.SHA256 != "" && o.SHA256 == n.SHA256 && o.Inode == n.Inode {
+				renamed[from] = true
+				c = FIMChange{Type: FIMRenamed, Path: path, OldPath: from, Old: &o, New: &n, Changes: compareFileStates(o, n)}
+				break
+			}
+		}
+		changes = append(changes, c)
+	}
+	for _, path := range deleted {
+		if !renamed[path] {
+			o := old[path]
+			changes = append(changes, FIMChange{Type: FIMDeleted, Path: path, Old: &o})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func compareFileStates(o, n FileState) []string {
+	var c []string
+	if o.Size != n.Size || o.SHA256 != n.SHA256 {
+		c = append(c, "content")
+	}
+	if o.Mode != n.Mode {
+		c = append(c, "mode")
+	}
+	if o.UID != n.UID || o.GID != n.GID {
+		c = append(c, "owner")
+	}
+	if !o.ModTime.Equal(n.ModTime) {
+		c = append(c, "mtime")
+	}
+	return c
+}
+
+func fimChangeType(changes []string) string {
+	switch changes[0] {
+	case "content":
+		return FIMModified
+	case "mode":
+		return FIMChmod
+	case "owner":
+		return FIMChown
+	}
+	return FIMTouched
+}
+
+// Event converts the change to an Event from the "fim" provider. The path,
+// the change type and the old and new attributes are stored as data items
+// such as "old.sha256" and "new.mode".
+func (c *FIMChange) Event() *Event {
+	ev := &Event{Provider: "fim", Level: LevelWarning, TimeCreated: time.Now()}
+	ev.SetField("path", c.Path)
+	ev.SetField("change", c.Type)
+	if c.OldPath != "" {
+		ev.SetField("old_path", c.OldPath)
+	}
+	if len(c.Changes) > 0 {
+		ev.SetField("changes", strings.Join(c.Changes, ","))
+	}
+	for _, s := range []struct {
+		prefix string
+		state  *FileState
+	}{{"old.", c.Old}, {"new.", c.New}} {
+		if s.state == nil {
+			continue
+		}
+		ev.SetField(s.prefix+"size", strconv.FormatInt(s.state.Size, 10))
+		ev.SetField(s.prefix+"mode", s.state.Mode.String())
+		if s.state.UID >= 0 {
+			ev.SetField(s.prefix+"uid", strconv.Itoa(s.state.UID))
+			ev.SetField(s.prefix+"gid", strconv.Itoa(s.state.GID))
+		}
+		ev.SetField(s.prefix+"mtime", s.state.ModTime.UTC().Format(time.RFC3339Nano))
+		if s.state.SHA256 != "" {
+			ev.SetField(s.prefix+"sha256", s.state.SHA256)
+		}
+	}
+	switch {
+	case c.Type == FIMRenamed:
+		ev.Message = "renamed " + c.OldPath + " to " + c.Path
+	case len(c.Changes) > 0:
+		ev.Message = c.Type + " " + c.Path + " (" + strings.Join(c.Changes, ", ") + ")"
+	default:
+		ev.Message = c.Type + " " + c.Path
+	}
+	return ev
+}
+
+// FIMConfig configures a FIMSource.
+type FIMConfig struct {
+	// Paths are the files to monitor, as paths or glob patterns such as
+	// "/etc/sudoers.d/*". Directories matched are skipped.
+	Paths []string
+	// BaselinePath is where the baseline is kept between runs. When it is
+	// set, the changes made while the source was not running are reported
+	// at startup with the "startup" data item set.
+	BaselinePath string
+	// MaxHashSize defaults to DefaultFIMMaxHashSize.
+	MaxHashSize int64
+	// RescanInterval defaults to DefaultFIMRescanInterval.
+	RescanInterval time.Duration
+}
+
+// FIMSource is a Source reporting changes to sensitive files: creation,
+// modification, change of mode, owner or modification time, rename and
+// deletion. It keeps a baseline of the size, mode, owner, modification
+// time and SHA-256 of every file, watches their directories for changes
+// and scans them all again every RescanInterval.
+type FIMSource struct {
+	cfg      FIMConfig
+	watcher  *fsnotify.Watcher
+	baseline map[string]FileState
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewFIMSource creates a FIMSource.
+func NewFIMSource(cfg FIMConfig) *FIMSource {
+	if cfg.MaxHashSize <= 0 {
+		cfg.MaxHashSize = DefaultFIMMaxHashSize
+	}
+	if cfg.RescanInterval <= 0 {
+		cfg.RescanInterval = DefaultFIMRescanInterval
+	}
+	return &FIMSource{cfg: cfg, done: make(chan struct{})}
+}
+
+// Init loads the baseline and watches the directories of the files.
+// Directories that do not exist are only covered by the periodic scans.
+func (s *FIMSource) Init() error {
+	for _, pattern := range s.cfg.Paths {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+	if s.cfg.BaselinePath != "" {
+		b, err := os.ReadFile(s.cfg.BaselinePath)
+		if err == nil {
+			if err := json.Unmarshal(b, &s.baseline); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	dirs := make(map[string]bool)
+	for _, pattern := range s.cfg.Paths {
+		dirs[filepath.Dir(pattern)] = true
+	}
+	for dir := range dirs {
+		matches, _ := filepath.Glob(dir)
+		for _, d := range matches {
+			w.Add(d)
+		}
+	}
+	s.watcher = w
+	return nil
+}
+
+// Listen reports changes until ctx is done or Close is called.
+func (s *FIMSource) Listen(ctx context.Context, emit EmitFunc) {
+	defer s.Close()
+
+	startup := s.baseline != nil
+	cur := s.scan(nil, true)
+	if startup && !s.report(ctx, emit, cur, true) {
+		return
+	}
+	s.baseline = cur
+	s.save()
+
+	rescan := time.NewTicker(s.cfg.RescanInterval)
+	defer rescan.Stop()
+	var (
+		debounce <-chan time.Time
+		dirty    = make(map[string]bool)
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		case ev, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			dirty[ev.Name] = true
+			if debounce == nil {
+				debounce = time.After(fimDebounce)
+			}
+			continue
+		case <-s.watcher.Errors:
+			continue
+		case <-debounce:
+			cur = s.scan(dirty, false)
+		case <-rescan.C:
+			cur = s.scan(nil, true)
+		}
+		debounce, dirty = nil, make(map[string]bool)
+		if !s.report(ctx, emit, cur, false) {
+			return
+		}
+		s.baseline = cur
+		s.save()
+	}
+}
+
+// report emits the differences of cur from the baseline and reports
+// whether all of them were delivered.
+func (s *FIMSource) report(ctx context.Context, emit EmitFunc, cur map[string]FileState, startup bool) bool {
+	for _, c := range DiffFileStates(s.baseline, cur) {
+		ev := c.Event()
+		if startup {
+			ev.SetField("startup", "true")
+		}
+		if emit(ctx, &EventEntry{Buffer: []byte(ev.Message), Event: ev}) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// scan records the state of every file. Contents are hashed when hashAll
+// is set, for files named in dirty and for those whose size, modification
+// time or inode differ from the baseline; other hashes are carried over.
+func (s *FIMSource) scan(dirty map[string]bool, hashAll bool) map[string]FileState {
+	states := make(map[string]FileState)
+	for _, pattern := range s.cfg.Paths {
+		matches, _ := filepath.Glob(pattern)
+		for _, path := range matches {
+			if _, done := states[path]; done {
+				continue
+			}
+			fi, err := os.Lstat(path)
+			if err != nil || fi.IsDir() {
+				continue
+			}
+			st := FileState{Size: fi.Size(), Mode: fi.Mode(), ModTime: fi.ModTime()}
+			st.UID, st.GID, st.Inode = fileOwner(fi)
+			prev, known := s.baseline[path]
+			if !hashAll && !dirty[path] && known && prev.Size == st.Size && prev.ModTime.Equal(st.ModTime) && prev.Inode == st.Inode {
+				st.SHA256 = prev.SHA256
+			} else if fi.Mode().IsRegular() && fi.Size() <= s.cfg.MaxHashSize {
+				st.SHA256 = hashFile(path)
+			}
+			states[path] = st
+		}
+	}
+	return states
+}
+
+func hashFile(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// save writes the baseline, replacing the previous one atomically.
+func (s *FIMSource) save() {
+	if s.cfg.BaselinePath == "" {
+		return
+	}
+	b, err := json.Marshal(s.baseline)
+	if err != nil {
+		return
+	}
+	tmp := s.cfg.BaselinePath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return
+	}
+	os.Rename(tmp, s.cfg.BaselinePath)
+}
+
+// Close stops the source.
+func (s *FIMSource) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.watcher != nil {
+			s.watcher.Close()
+		}
+	})
+}
@@ -0,0 +1,126 @@
+//go:build !windows
+// +build !windows
+
+package eventwatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffFileStates(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	st := func(size int64, mode os.FileMode, uid int, mtime time.Time, inode uint64, hash string) FileState {
+		return FileState{Size: size, Mode: mode, UID: uid, GID: 0, ModTime: mtime, Inode: inode, SHA256: hash}
+	}
+	old := map[string]FileState{
+		"/etc/passwd":  st(10, 0o644, 0, t0, 1, "aa"),
+		"/etc/shadow":  st(10, 0o640, 0, t0, 2, "bb"),
+		"/etc/group":   st(10, 0o644, 0, t0, 3, "cc"),
+		"/etc/hosts":   st(10, 0o644, 0, t0, 4, "dd"),
+		"/etc/old.cfg": st(10, 0o644, 0, t0, 5, "ee"),
+		"/etc/gone":    st(10, 0o644, 0, t0, 6, "ff"),
+	}
+	cur := map[string]FileState{
+		"/etc/passwd":  st(12, 0o644, 0, t0.Add(time.Second), 1, "a2"),
+		"/etc/shadow":  st(10, 0o666, 0, t0, 2, "bb"),
+		"/etc/group":   st(10, 0o644, 1000, t0, 3, "cc"),
+		"/etc/hosts":   st(10, 0o644, 0, t0.Add(time.Hour), 4, "dd"),
+		"/etc/new.cfg": st(10, 0o644, 0, t0, 5, "ee"),
+		"/etc/added":   st(1, 0o600, 0, t0, 7, "11"),
+	}
+	var got []string
+	for _, c := range DiffFileStates(old, cur) {
+		got = append(got, c.Type+" "+c.OldPath+" "+c.Path)
+	}
+	want := []string{
+		"created  /etc/added",
+		"deleted  /etc/gone",
+		"chown  /etc/group",
+		"touched  /etc/hosts",
+		"renamed /etc/old.cfg /etc/new.cfg",
+		"modified  /etc/passwd",
+		"chmod  /etc/shadow",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+	if c := DiffFileStates(old, cur)[5]; !reflect.DeepEqual(c.Changes, []string{"content", "mtime"}) {
+		t.Fatalf("changes %v", c.Changes)
+	}
+}
+
+// waitFIM returns the next event, which must report change of path.
+func waitFIM(t *testing.T, n *EventNotifier, change, path string) *Event {
+	t.Helper()
+	ev := waitSyslogEntry(t, n).Event
+	if v, _ := ev.Field("change"); v != change {
+		t.Fatalf("got %q (%s), want %s of %s", v, ev.Message, change, path)
+	}
+	if v, _ := ev.Field("path"); v != path {
+		t.Fatalf("got %s, want %s", v, path)
+	}
+	return ev
+}
+
+func TestFIMSource(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.conf"), filepath.Join(dir, "b.conf")
+	os.WriteFile(a, []byte("one"), 0o644)
+	os.WriteFile(b, []byte("two"), 0o644)
+	cfg := FIMConfig{
+		Paths:        []string{filepath.Join(dir, "*.conf")},
+		BaselinePath: filepath.Join(t.TempDir(), "baseline.json"),
+	}
+
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("fim", NewFIMSource(cfg)); err != nil {
+		t.Fatal(err)
+	}
+	// The first run only records the baseline.
+	time.Sleep(100 * time.Millisecond)
+
+	os.WriteFile(a, []byte("one, changed"), 0o644)
+	ev := waitFIM(t, n, FIMModified, a)
+	if v, _ := ev.Field("old.size"); v != "3" {
+		t.Fatalf("old.size %q", v)
+	}
+	if v, _ := ev.Field("new.sha256"); v != hashFile(a) {
+		t.Fatalf("new.sha256 %q", v)
+	}
+
+	os.Chmod(b, 0o600)
+	ev = waitFIM(t, n, FIMChmod, b)
+	if v, _ := ev.Field("new.mode"); v != "-rw-------" {
+		t.Fatalf("new.mode %q", v)
+	}
+
+	c := filepath.Join(dir, "c.conf")
+	os.Rename(b, c)
+	ev = waitFIM(t, n, FIMRenamed, c)
+	if v, _ := ev.Field("old_path"); v != b {
+		t.Fatalf("old_path %q", v)
+	}
+
+	os.Remove(a)
+	waitFIM(t, n, FIMDeleted, a)
+	n.RemoveWatcher("fim")
+
+	// Changes made while not running are reported at startup.
+	os.WriteFile(c, []byte("tampered"), 0o600)
+	d := filepath.Join(dir, "d.conf")
+	os.WriteFile(d, nil, 0o644)
+	if err := n.AddSource("fim2", NewFIMSource(cfg)); err != nil {
+		t.Fatal(err)
+	}
+	ev = waitFIM(t, n, FIMModified, c)
+	if v, _ := ev.Field("startup"); v != "true" {
+		t.Fatalf("startup %q", v)
+	}
+	waitFIM(t, n, FIMCreated, d)
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package eventwatcher
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the owner and inode of a file.
+func fileOwner(fi os.FileInfo) (uid, gid int, inode uint64) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1, 0
+	}
+	return int(st.Uid), int(st.Gid), uint64(st.Ino)
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package eventwatcher
+
+import "os"
+
+// fileOwner reports no owner or inode; file ownership on Windows is an ACL.
+func fileOwner(fi os.FileInfo) (uid, gid int, inode uint64) {
+	return -1, -1, 0
+}
@@ -0,0 +1,421 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultForwardMessageSize limits the size of a single value of a
+	// Forward message, such as the packed entries of a chunk, and of the
+	// decompressed entries of a compressed chunk.
+	DefaultForwardMessageSize = 32 << 20
+	// DefaultForwardTimeout is how long a connection may stay silent before
+	// it is closed, and how long a ForwardSink waits for the server.
+	DefaultForwardTimeout = time.Minute
+)
+
+var errForwardMessage = errors.New("forward: malformed message")
+
+// ForwardConfig configures a ForwardSource.
+type ForwardConfig struct {
+	// Addr is the TCP address to listen on, e.g. ":24224".
+	Addr string
+	// TLS, when set, requires clients to connect with TLS.
+	TLS *tls.Config
+	// MaxMessageSize defaults to DefaultForwardMessageSize.
+	MaxMessageSize int
+	// Timeout defaults to DefaultForwardTimeout.
+	Timeout time.Duration
+}
+
+// ForwardSource is a Source receiving events from Fluent Bit and Fluentd
+// over the Forward protocol, in all of its modes: Message, Forward,
+// PackedForward and CompressedPackedForward. A message carrying a "chunk"
+// option is acknowledged once all of its events were received from
+// EventLogChannel, so clients with acknowledgements enabled resend it when
+// the collector stops before. Each record is converted with ForwardEvent,
+// and the client address is stored in the "peer" data item.
+type ForwardSource struct {
+	cfg ForwardConfig
+	ln  net.Listener
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewForwardSource creates a ForwardSource; its socket is bound by Init.
+func NewForwardSource(cfg ForwardConfig) *ForwardSource {
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = DefaultForwardMessageSize
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultForwardTimeout
+	}
+	return &ForwardSource{
+		cfg:   cfg,
+		conns: make(map[net.Conn]struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Init binds the listener.
+func (s *ForwardSource) Init() error {
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	if s.cfg.TLS != nil {
+		ln = tls.NewListener(ln, s.cfg.TLS)
+	}
+	s.ln = ln
+	return nil
+}
+
+// Addr returns the bound address.
+func (s *ForwardSource) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Listen serves connections until ctx is done or Close is called.
+func (s *ForwardSource) Listen(ctx context.Context, emit EmitFunc) {
+	s.wg.Add(1)
+	go s.accept(ctx, emit)
+	select {
+	case <-ctx.Done():
+	case <-s.done:
+	}
+	s.Close()
+	s.wg.Wait()
+}
+
+// Close closes the listener and all connections; unacknowledged chunks are
+// resent by the clients.
+func (s *ForwardSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *ForwardSource) accept(ctx context.Context, emit EmitFunc) {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return
+		}
+		s.wg.Add(1)
+		go s.serveConn(ctx, conn, emit)
+	}
+}
+
+func (s *ForwardSource) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *ForwardSource) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+// serveConn reads messages until the connection fails or sends an invalid
+// message.
+func (s *ForwardSource) serveConn(ctx context.Context, conn net.Conn, emit EmitFunc) {
+	defer s.wg.Done()
+	defer s.untrack(conn)
+	dec := newMsgpackDecoder(bufio.NewReader(conn), s.cfg.MaxMessageSize)
+	peer := conn.RemoteAddr().String()
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.cfg.Timeout))
+		v, err := dec.Decode()
+		if err != nil {
+			return
+		}
+		msg, ok := v.([]interface{})
+		if !ok {
+			return
+		}
+		chunk, err := s.handleMessage(msg, func(ev *Event, raw []byte) error {
+			ev.SetField("peer", peer)
+			return emit(ctx, &EventEntry{Buffer: raw, Event: ev})
+		})
+		if err != nil {
+			return
+		}
+		if chunk != "" {
+			ack := appendMsgpack(nil, map[string]interface{}{"ack": chunk})
+			conn.SetWriteDeadline(time.Now().Add(s.cfg.Timeout))
+			if _, err := conn.Write(ack); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleMessage delivers the entries of a message and returns the chunk ID
+// to acknowledge, if any. Entries that are not a time and a record are
+// skipped rather than failing the whole chunk, which the client would
+// otherwise resend forever.
+func (s *ForwardSource) handleMessage(msg []interface{}, deliver func(*Event, []byte) error) (string, error) {
+	if len(msg) < 2 {
+		return "", errForwardMessage
+	}
+	tag, ok := msg[0].(string)
+	if !ok {
+		return "", errForwardMessage
+	}
+	var option map[string]interface{}
+	optionAt := func(i int) {
+		if len(msg) > i {
+			option, _ = msg[i].(map[string]interface{})
+		}
+	}
+	entry := func(e interface{}) error {
+		pair, ok := e.([]interface{})
+		if !ok || len(pair) < 2 {
+			return nil
+		}
+		return s.deliverRecord(tag, pair[0], pair[1], deliver)
+	}
+
+	switch entries := msg[1].(type) {
+	case []interface{}:
+		// Forward mode: [tag, [[time, record], ...], option]
+		optionAt(2)
+		for _, e := range entries {
+			if err := entry(e); err != nil {
+				return "", err
+			}
+		}
+	case []byte, string:
+		// PackedForward mode: [tag, packed entries, option], the entries
+		// gzipped in CompressedPackedForward mode.
+		optionAt(2)
+		var packed []byte
+		if b, ok := entries.([]byte); ok {
+			packed = b
+		} else {
+			packed = []byte(entries.(string))
+		}
+		var r io.Reader = bytes.NewReader(packed)
+		if c, _ := option["compressed"].(string); c == "gzip" {
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				return "", errForwardMessage
+			}
+			defer zr.Close()
+			r = io.LimitReader(zr, int64(s.cfg.MaxMessageSize)+1)
+		} else if c != "" && c != "text" {
+			return "", errForwardMessage
+		}
+		dec := newMsgpackDecoder(bufio.NewReader(r), s.cfg.MaxMessageSize)
+		for {
+			e, err := dec.Decode()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", errForwardMessage
+			}
+			if err := entry(e); err != nil {
+				return "", err
+			}
+		}
+	default:
+		// Message mode: [tag, time, record, option]
+		if len(msg) < 3 {
+			return "", errForwardMessage
+		}
+		optionAt(3)
+		if err := s.deliverRecord(tag, msg[1], msg[2], deliver); err != nil {
+			return "", err
+		}
+	}
+	chunk, _ := option["chunk"].(string)
+	return chunk, nil
+}
+
+func (s *ForwardSource) deliverRecord(tag string, t, record interface{}, deliver func(*Event, []byte) error) error {
+	rec, ok := record.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	// Fluent Bit 2 may send [time, metadata] in place of the time.
+	if pair, ok := t.([]interface{}); ok && len(pair) > 0 {
+		t = pair[0]
+	}
+	ts, ok := forwardTime(t)
+	if !ok {
+		return nil
+	}
+	ev := ForwardEvent(tag, ts, rec)
+	raw, _ := json.Marshal(jsonValue(rec))
+	return deliver(ev, raw)
+}
+
+// forwardTime accepts the EventTime extension and Unix times in seconds,
+// as integers or floats.
+func forwardTime(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case int64:
+		return time.Unix(v, 0), true
+	case uint64:
+		return time.Unix(int64(v), 0), true
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return time.Time{}, false
+		}
+		sec := math.Floor(v)
+		return time.Unix(int64(sec), int64((v-sec)*1e9)).Round(time.Microsecond), true
+	}
+	return time.Time{}, false
+}
+
+// jsonValue converts a decoded MessagePack value to what encoding/json
+// decodes with UseNumber: numbers become json.Number, binaries strings,
+// EventTimes RFC 3339 strings.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10))
+	case uint64:
+		return json.Number(strconv.FormatUint(v, 10))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64))
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case msgpackExt:
+		return base64.StdEncoding.EncodeToString(v.Data)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = jsonValue(item)
+		}
+		return list
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			obj[k] = jsonValue(item)
+		}
+		return obj
+	}
+	return v
+}
+
+// ForwardEvent converts a Fluent record to an Event. The keys are mapped
+// like the JSON objects of HTTPSource, with "log", the key used by Fluent
+// Bit's tail and Docker inputs, taken as the message when there is no
+// "message". The time of the entry sets TimeCreated. The provider defaults
+// to the tag, which is otherwise kept as the "tag" data item.
+func ForwardEvent(tag string, t time.Time, record map[string]interface{}) *Event {
+	obj := jsonValue(record).(map[string]interface{})
+	if _, ok := obj["message"]; !ok {
+		if log, ok := obj["log"].(string); ok {
+			obj["message"] = log
+			delete(obj, "log")
+		}
+	}
+	ev := eventFromJSON(obj)
+	if !t.IsZero() {
+		ev.TimeCreated = t
+	} else if ev.TimeCreated.IsZero() {
+		ev.TimeCreated = time.Now()
+	}
+	if ev.Provider == "" {
+		ev.Provider = tag
+	} else if ev.Provider != tag {
+		ev.SetField("tag", tag)
+	}
+	return ev
+}
+
+// ForwardRecord converts an Event to a Fluent record, the reverse of
+// ForwardEvent: the Event fields are stored under their JSON names, with
+// "host" for the computer, and named data items as keys of their own.
+// Unnamed data items, and those whose name is taken, are kept in the "data"
+// list.
+func ForwardRecord(ev *Event) map[string]interface{} {
+	rec := make(map[string]interface{})
+	set := func(k, v string) {
+		if v != "" {
+			rec[k] = v
+		}
+	}
+	set("message", ev.Message)
+	set("provider", ev.Provider)
+	set("host", ev.Computer)
+	set("channel", ev.Channel)
+	set("user_id", ev.UserID)
+	if ev.Level != LevelUnknown {
+		rec["level"] = ev.Level.String()
+	}
+	if ev.EventID != 0 {
+		rec["event_id"] = int64(ev.EventID)
+	}
+	if ev.RecordID != 0 {
+		rec["record_id"] = ev.RecordID
+	}
+	if ev.ProcessID != 0 {
+		rec["process_id"] = int64(ev.ProcessID)
+	}
+	var unnamed []interface{}
+	for _, d := range ev.Data {
+		if _, taken := rec[d.Name]; d.Name == "" || d.Name == "data" || taken {
+			item := map[string]interface{}{"value": d.Value}
+			if d.Name != "" {
+				item["name"] = d.Name
+			}
+			unnamed = append(unnamed, item)
+			continue
+		}
+		rec[d.Name] = d.Value
+	}
+	if unnamed != nil {
+		rec["data"] = unnamed
+	}
+	return rec
+}
@@ -0,0 +1,230 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	values := []interface{}{
+		nil, true, false,
+		int64(0), int64(127), int64(-32), int64(-33), int64(200), int64(-200),
+		int64(70000), int64(-70000), int64(1 << 40), int64(-1 << 40), uint64(1 << 63),
+		1.5, "", "short", string(bytes.Repeat([]byte("x"), 300)), string(bytes.Repeat([]byte("y"), 70000)),
+		[]byte{1, 2, 3},
+		[]interface{}{int64(1), "two", []interface{}{}},
+		map[string]interface{}{"a": int64(1), "b": map[string]interface{}{"c": "d"}},
+		time.Unix(1700000000, 123456789),
+	}
+	big := make([]interface{}, 20)
+	for i := range big {
+		big[i] = int64(i)
+	}
+	values = append(values, big)
+
+	var b []byte
+	for _, v := range values {
+		b = appendMsgpack(b, v)
+	}
+	dec := newMsgpackDecoder(bufio.NewReader(bytes.NewReader(b)), DefaultForwardMessageSize)
+	for _, want := range values {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wt, ok := want.(time.Time); ok {
+			if gt, ok := got.(time.Time); !ok || !gt.Equal(wt) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %#v, want %#v", got, want)
+		}
+	}
+	if _, err := dec.Decode(); err == nil {
+		t.Fatal("expected the end of the stream")
+	}
+
+	// Lengths beyond the limit are rejected before allocating.
+	huge := []byte{0xdb, 0x7f, 0xff, 0xff, 0xff}
+	if _, err := newMsgpackDecoder(bufio.NewReader(bytes.NewReader(huge)), 1024).Decode(); err != errMsgpackTooLarge {
+		t.Fatalf("got %v for a huge string", err)
+	}
+}
+
+func TestMsgpackDeclaredLength(t *testing.T) {
+	// A bin 32 claiming 32 MiB followed by a few bytes allocates what
+	// arrived, not what was declared.
+	stream := append([]byte{0xc6, 0x01, 0xff, 0xff, 0xff}, make([]byte, 100)...)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	_, err := newMsgpackDecoder(bufio.NewReader(bytes.NewReader(stream)), DefaultForwardMessageSize).Decode()
+	runtime.ReadMemStats(&after)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %v for a short value", err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Fatalf("allocated %d bytes for a truncated value", n)
+	}
+
+	// Values longer than a chunk still decode whole.
+	long := bytes.Repeat([]byte("x"), 10000)
+	stream = append([]byte{0xc5, 0x27, 0x10}, long...)
+	v, err := newMsgpackDecoder(bufio.NewReader(bytes.NewReader(stream)), DefaultForwardMessageSize).Decode()
+	if b, ok := v.([]byte); err != nil || !ok || !bytes.Equal(b, long) {
+		t.Fatalf("got %d bytes, %v", len(b), err)
+	}
+}
+
+func startForwardSource(t *testing.T) (*EventNotifier, *ForwardSource) {
+	t.Helper()
+	n := NewEventNotifier(context.Background())
+	t.Cleanup(n.Close)
+	src := NewForwardSource(ForwardConfig{Addr: "127.0.0.1:0", Timeout: 5 * time.Second})
+	if err := n.AddSource("forward", src); err != nil {
+		t.Fatal(err)
+	}
+	return n, src
+}
+
+func TestForwardSourceModes(t *testing.T) {
+	n, src := startForwardSource(t)
+	conn, err := net.Dial("tcp", src.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ts := time.Unix(1700000000, 5000)
+	rec := func(msg string) map[string]interface{} {
+		return map[string]interface{}{"log": msg, "stream": "stdout", "kubernetes": map[string]interface{}{"pod_name": "web-0"}}
+	}
+	entry := func(msg string) []interface{} { return []interface{}{ts, rec(msg)} }
+	var packed, compressed []byte
+	packed = appendMsgpack(appendMsgpack(packed, entry("packed 1")), entry("packed 2"))
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(appendMsgpack(nil, entry("error: compressed")))
+	zw.Close()
+	compressed = buf.Bytes()
+
+	var out []byte
+	out = appendMsgpack(out, []interface{}{"kube.web", int64(1700000000), rec("message mode")})
+	out = appendMsgpack(out, []interface{}{"kube.web", []interface{}{entry("forward 1"), "junk", entry("forward 2")}})
+	out = appendMsgpack(out, []interface{}{"kube.web", packed, map[string]interface{}{"size": 2}})
+	out = appendMsgpack(out, []interface{}{"kube.web", compressed, map[string]interface{}{"compressed": "gzip", "chunk": "c1"}})
+	if _, err := conn.Write(out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"message mode", "forward 1", "forward 2", "packed 1", "packed 2", "error: compressed"} {
+		e := waitSyslogEntry(t, n)
+		ev := e.Event
+		if ev.Message != want || ev.Provider != "kube.web" {
+			t.Fatalf("got %+v, want %q", ev, want)
+		}
+		if v, _ := ev.Field("kubernetes.pod_name"); v != "web-0" {
+			t.Fatalf("pod name %q", v)
+		}
+		if v, _ := ev.Field("peer"); v != conn.LocalAddr().String() {
+			t.Fatalf("peer %q", v)
+		}
+		if want != "message mode" && !ev.TimeCreated.Equal(ts) {
+			t.Fatalf("time %v", ev.TimeCreated)
+		}
+		if want == "error: compressed" && ev.Level != LevelError {
+			t.Fatalf("level %v", ev.Level)
+		}
+	}
+
+	// The chunk is acknowledged once its events were delivered.
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	ack, err := newMsgpackDecoder(bufio.NewReader(conn), 1024).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := ack.(map[string]interface{}); m["ack"] != "c1" {
+		t.Fatalf("ack %v", ack)
+	}
+}
+
+func TestForwardSink(t *testing.T) {
+	n, src := startForwardSource(t)
+	sink := NewForwardSink(ForwardSinkConfig{
+		Addr:          src.Addr().String(),
+		Compress:      true,
+		RequireAck:    true,
+		Timeout:       2 * time.Second,
+		FlushInterval: 20 * time.Millisecond,
+		RetryDelay:    20 * time.Millisecond,
+	})
+	defer sink.Close()
+
+	ev := &Event{
+		Provider:    "sshd",
+		EventID:     4625,
+		RecordID:    42,
+		ProcessID:   1234,
+		Level:       LevelWarning,
+		Channel:     "Security",
+		Computer:    "host1",
+		UserID:      "S-1-5-18",
+		TimeCreated: time.Unix(1700000000, 250000000),
+		Message:     "failed login",
+		Data:        []EventData{{Name: "user", Value: "root"}, {Value: "unnamed"}, {Name: "message", Value: "clash"}},
+	}
+	// Send blocks until the chunk is acknowledged, which needs the event
+	// to be received.
+	sent := make(chan error, 1)
+	go func() { sent <- sink.Send(context.Background(), "auth", []*Event{ev}) }()
+	got := waitSyslogEntry(t, n).Event
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	got.Data = got.Data[:len(got.Data)-1] // peer
+	want := *ev
+	want.Data = []EventData{{Value: "unnamed"}, {Name: "message", Value: "clash"}, {Name: "user", Value: "root"}, {Name: "tag", Value: "auth"}}
+	if !reflect.DeepEqual(got, &want) {
+		t.Fatalf("got %+v\nwant %+v", got, &want)
+	}
+
+	// Run sends the entries of a channel, grouped by tag, until the channel
+	// is closed.
+	entries := make(chan *EventEntry)
+	done := make(chan error, 1)
+	go func() { done <- sink.Run(context.Background(), entries) }()
+	entries <- &EventEntry{Name: "app", Buffer: []byte("line one\n")}
+	entries <- &EventEntry{Name: "app", Event: &Event{Provider: "app", Message: "line two"}}
+	entries <- &EventEntry{Name: "other", Buffer: []byte("line three")}
+	close(entries)
+	for _, want := range []struct{ msg, provider string }{{"line one", "app"}, {"line two", "app"}, {"line three", "other"}} {
+		ev := waitSyslogEntry(t, n).Event
+		if ev.Message != want.msg || ev.Provider != want.provider {
+			t.Fatalf("got %+v, want %v", ev, want)
+		}
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return")
+	}
+
+	// A cancelled Send waiting for its acknowledgement returns.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := sink.Send(ctx, "auth", []*Event{{Message: "never read"}}); err != context.DeadlineExceeded {
+		t.Fatalf("got %v", err)
+	}
+}
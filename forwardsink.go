@@ -0,0 +1,307 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultForwardBatchSize is the number of events ForwardSink.Run sends
+	// in one chunk at most.
+	DefaultForwardBatchSize = 100
+	// DefaultForwardFlushInterval is how long ForwardSink.Run holds events
+	// back to fill a chunk.
+	DefaultForwardFlushInterval = time.Second
+	// DefaultForwardRetryDelay is how long ForwardSink.Run waits before
+	// sending a chunk again after a failure.
+	DefaultForwardRetryDelay = time.Second
+	// DefaultForwardTag is the tag of entries without a name.
+	DefaultForwardTag = "eventwatcher"
+)
+
+var errForwardAck = errors.New("forward: unexpected acknowledgement")
+
+// ForwardSinkConfig configures a ForwardSink.
+type ForwardSinkConfig struct {
+	// Addr is the TCP address of the Fluent Bit or Fluentd forward input.
+	Addr string
+	// TLS, when set, connects with TLS.
+	TLS *tls.Config
+	// Tag of the events sent by Run. It defaults to the name of the source
+	// or watcher of each entry, or DefaultForwardTag.
+	Tag string
+	// Compress sends chunks in CompressedPackedForward mode.
+	Compress bool
+	// RequireAck asks the server to acknowledge every chunk and waits for
+	// it, so that a chunk is only reported sent once it was accepted.
+	RequireAck bool
+	// Timeout bounds connecting, writing and waiting for an
+	// acknowledgement; it defaults to DefaultForwardTimeout.
+	Timeout time.Duration
+	// BatchSize defaults to DefaultForwardBatchSize.
+	BatchSize int
+	// FlushInterval defaults to DefaultForwardFlushInterval.
+	FlushInterval time.Duration
+	// RetryDelay defaults to DefaultForwardRetryDelay.
+	RetryDelay time.Duration
+}
+
+// ForwardSink sends events to Fluent Bit or Fluentd over the Forward
+// protocol, in PackedForward mode. Each event is converted with
+// ForwardRecord. The connection is made on first use and made again after
+// a failure.
+type ForwardSink struct {
+	cfg ForwardSinkConfig
+
+	mu   sync.Mutex
+	conn net.Conn
+	dec  *msgpackDecoder
+}
+
+// NewForwardSink creates a ForwardSink.
+func NewForwardSink(cfg ForwardSinkConfig) *ForwardSink {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultForwardTimeout
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultForwardBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultForwardFlushInterval
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = DefaultForwardRetryDelay
+	}
+	return &ForwardSink{cfg: cfg}
+}
+
+// Send sends events as one chunk tagged tag. With RequireAck it returns
+// once the server acknowledged the chunk. On error the connection is
+// closed; the chunk may or may not have been received.
+func (s *ForwardSink) Send(ctx context.Context, tag string, events []*Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	msg, chunk, err := s.pack(tag, events)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.connect(ctx); err != nil {
+		return err
+	}
+	conn := s.conn
+	conn.SetDeadline(time.Now().Add(s.cfg.Timeout))
+	// ctx being done interrupts a write or a wait for the acknowledgement.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	if _, err := conn.Write(msg); err != nil {
+		s.disconnect()
+		return ctxErr(ctx, err)
+	}
+	if chunk == "" {
+		return nil
+	}
+	v, err := s.dec.Decode()
+	if err != nil {
+		s.disconnect()
+		return ctxErr(ctx, err)
+	}
+	if reply, ok := v.(map[string]interface{}); !ok || reply["ack"] != chunk {
+		s.disconnect()
+		return errForwardAck
+	}
+	return nil
+}
+
+// pack encodes a PackedForward message and returns its chunk ID when an
+// acknowledgement is required.
+func (s *ForwardSink) pack(tag string, events []*Event) ([]byte, string, error) {
+	var entries []byte
+	for _, ev := range events {
+		t := ev.TimeCreated
+		if t.IsZero() {
+			t = time.Now()
+		}
+		entries = appendMsgpack(entries, []interface{}{t, ForwardRecord(ev)})
+	}
+	option := map[string]interface{}{"size": len(events)}
+	if s.cfg.Compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(entries)
+		if err := zw.Close(); err != nil {
+			return nil, "", err
+		}
+		entries = buf.Bytes()
+		option["compressed"] = "gzip"
+	}
+	var chunk string
+	if s.cfg.RequireAck {
+		var id [16]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			return nil, "", err
+		}
+		chunk = base64.StdEncoding.EncodeToString(id[:])
+		option["chunk"] = chunk
+	}
+	return appendMsgpack(nil, []interface{}{tag, entries, option}), chunk, nil
+}
+
+func (s *ForwardSink) connect(ctx context.Context) error {
+	if s.conn != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.cfg.TLS != nil {
+		d := &tls.Dialer{Config: s.cfg.TLS}
+		conn, err = d.DialContext(ctx, "tcp", s.cfg.Addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", s.cfg.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.dec = newMsgpackDecoder(bufio.NewReader(conn), DefaultForwardMessageSize)
+	return nil
+}
+
+func (s *ForwardSink) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn, s.dec = nil, nil
+	}
+}
+
+// ctxErr prefers the error of a done ctx over the network error it caused.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Run sends the entries received from entries, typically EventLogChannel,
+// until it is closed or ctx is done. Entries are sent in chunks of up to
+// BatchSize, after at most FlushInterval, and a chunk that failed is sent
+// again every RetryDelay, so that no entry is dropped while the server is
+// unavailable. Entries without an Event are sent as a line event of their
+// Buffer. Run returns nil once entries is closed and everything was sent,
+// or the error of ctx.
+func (s *ForwardSink) Run(ctx context.Context, entries <-chan *EventEntry) error {
+	var (
+		batch []*EventEntry
+		timer *time.Timer
+		flush <-chan time.Time
+	)
+	send := func() error {
+		if timer != nil {
+			timer.Stop()
+			timer, flush = nil, nil
+		}
+		for len(batch) > 0 {
+			// Send runs of entries sharing a tag in order.
+			tag := s.tag(batch[0])
+			n := 1
+			for n < len(batch) && s.tag(batch[n]) == tag {
+				n++
+			}
+			events := make([]*Event, n)
+			for i, e := range batch[:n] {
+				events[i] = entryEvent(e)
+			}
+			for {
+				err := s.Send(ctx, tag, events)
+				if err == nil {
+					break
+				}
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(s.cfg.RetryDelay):
+				}
+			}
+			batch = batch[n:]
+		}
+		batch = nil
+		return nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-entries:
+			if !ok {
+				return send()
+			}
+			batch = append(batch, e)
+			if len(batch) >= s.cfg.BatchSize {
+				if err := send(); err != nil {
+					return err
+				}
+			} else if timer == nil {
+				timer = time.NewTimer(s.cfg.FlushInterval)
+				flush = timer.C
+			}
+		case <-flush:
+			timer, flush = nil, nil
+			if err := send(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *ForwardSink) tag(e *EventEntry) string {
+	switch {
+	case s.cfg.Tag != "":
+		return s.cfg.Tag
+	case e.Name != "":
+		return e.Name
+	}
+	return DefaultForwardTag
+}
+
+func entryEvent(e *EventEntry) *Event {
+	if e.Event != nil {
+		return e.Event
+	}
+	return lineEvent(e.Name, string(bytes.TrimRight(e.Buffer, "\r\n")), LevelInfo)
+}
+
+// Close closes the connection; the next Send connects again.
+func (s *ForwardSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disconnect()
+	return nil
+}
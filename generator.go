@@ -0,0 +1,185 @@
+package eventwatcher
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// GeneratorConfig configures a GeneratorSource. The zero value emits small
+// info events as fast as possible.
+type GeneratorConfig struct {
+	// Rate is the number of events per second; 0 emits them as fast as
+	// they are received.
+	Rate float64
+	// Count stops the source after that many events; 0 is unlimited.
+	Count int
+	// BurstSize events are emitted at once every BurstInterval, on top of
+	// those paced by Rate.
+	BurstSize     int
+	BurstInterval time.Duration
+	// MinSize and MaxSize bound the size of the messages; they default to
+	// 64 and MinSize.
+	MinSize, MaxSize int
+	// Levels weighs the levels of the events, e.g. {LevelInfo: 90,
+	// LevelError: 10}; it defaults to info only.
+	Levels map[Level]int
+	// Fields is the number of data items of each event and Cardinality
+	// the number of distinct values each of them takes, default 100.
+	Fields      int
+	Cardinality int
+	// Providers is the number of distinct providers, default 1.
+	Providers int
+	// Seed makes the generated events reproducible; 0 uses the time.
+	Seed int64
+}
+
+// GeneratorSource is a Source emitting synthetic events, for load and soak
+// testing. Events are numbered by RecordID from 1 and TimeCreated is set
+// when they are emitted, so that the time to receive them can be measured.
+type GeneratorSource struct {
+	cfg    GeneratorConfig
+	rnd    *rand.Rand
+	levels []Level
+	// weights holds the cumulated weights of levels.
+	weights []int
+	seq     uint64
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewGeneratorSource creates a GeneratorSource.
+func NewGeneratorSource(cfg GeneratorConfig) *GeneratorSource {
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = 64
+	}
+	if cfg.MaxSize < cfg.MinSize {
+		cfg.MaxSize = cfg.MinSize
+	}
+	if cfg.Cardinality <= 0 {
+		cfg.Cardinality = 100
+	}
+	if cfg.Providers <= 0 {
+		cfg.Providers = 1
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	s := &GeneratorSource{cfg: cfg, rnd: rand.New(rand.NewSource(cfg.Seed)), done: make(chan struct{})}
+	for l, w := range cfg.Levels {
+		if w > 0 {
+			s.levels = append(s.levels, l)
+		}
+	}
+	sort.Slice(s.levels, func(i, j int) bool { return s.levels[i] < s.levels[j] })
+	total := 0
+	for _, l := range s.levels {
+		total += cfg.Levels[l]
+		s.weights = append(s.weights, total)
+	}
+	if len(s.levels) == 0 {
+		s.levels, s.weights = []Level{LevelInfo}, []int{1}
+	}
+	return s
+}
+
+// Init does nothing; the generator needs no resources.
+func (s *GeneratorSource) Init() error {
+	return nil
+}
+
+// Listen emits events until Count is reached, ctx is done or Close is
+// called.
+func (s *GeneratorSource) Listen(ctx context.Context, emit EmitFunc) {
+	defer s.Close()
+	start := time.Now()
+	nextBurst := start.Add(s.cfg.BurstInterval)
+	bursts := s.cfg.BurstSize > 0 && s.cfg.BurstInterval > 0
+	var paced int
+	for !s.finished() {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		n := 1
+		if s.cfg.Rate > 0 {
+			// Pace against the start rather than the previous event so
+			// that delays do not add up.
+			due := start.Add(time.Duration(float64(paced) / s.cfg.Rate * float64(time.Second)))
+			burst := bursts && nextBurst.Before(due)
+			if burst {
+				due = nextBurst
+			}
+			if wait := time.Until(due); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-s.done:
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+			}
+			if burst {
+				n = s.cfg.BurstSize
+				nextBurst = nextBurst.Add(s.cfg.BurstInterval)
+			} else {
+				paced++
+			}
+		}
+		for i := 0; i < n && !s.finished(); i++ {
+			ev := s.Next()
+			if emit(ctx, &EventEntry{Buffer: []byte(ev.Message), Event: ev}) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *GeneratorSource) finished() bool {
+	return s.cfg.Count > 0 && s.seq >= uint64(s.cfg.Count)
+}
+
+// Next generates the next event. It is not safe to call while the source
+// is listening.
+func (s *GeneratorSource) Next() *Event {
+	s.seq++
+	r := s.rnd
+	level := s.levels[sort.SearchInts(s.weights, r.Intn(s.weights[len(s.weights)-1])+1)]
+	size := s.cfg.MinSize
+	if s.cfg.MaxSize > s.cfg.MinSize {
+		size += r.Intn(s.cfg.MaxSize - s.cfg.MinSize + 1)
+	}
+	msg := make([]byte, size)
+	const letters = "abcdefghijklmnopqrstuvwxyz     "
+	for i := range msg {
+		msg[i] = letters[r.Intn(len(letters))]
+	}
+	ev := &Event{
+		Provider: "generator-" + strconv.Itoa(r.Intn(s.cfg.Providers)),
+		EventID:  uint32(r.Intn(1000)),
+		Level:    level,
+		RecordID: s.seq,
+		Message:  string(msg),
+	}
+	for i := 0; i < s.cfg.Fields; i++ {
+		ev.Data = append(ev.Data, EventData{
+			Name:  "field" + strconv.Itoa(i),
+			Value: "value" + strconv.Itoa(r.Intn(s.cfg.Cardinality)),
+		})
+	}
+	ev.TimeCreated = time.Now()
+	return ev
+}
+
+// Close stops the generator.
+func (s *GeneratorSource) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
@@ -0,0 +1,109 @@
+package eventwatcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGeneratorSourceMix(t *testing.T) {
+	cfg := GeneratorConfig{
+		MinSize:     10,
+		MaxSize:     20,
+		Levels:      map[Level]int{LevelInfo: 3, LevelError: 1},
+		Fields:      2,
+		Cardinality: 3,
+		Providers:   2,
+		Seed:        42,
+	}
+	g := NewGeneratorSource(cfg)
+	levels := map[Level]int{}
+	values := map[string]bool{}
+	providers := map[string]bool{}
+	for i := 1; i <= 1000; i++ {
+		ev := g.Next()
+		if ev.RecordID != uint64(i) {
+			t.Fatalf("record %d: id %d", i, ev.RecordID)
+		}
+		if len(ev.Message) < 10 || len(ev.Message) > 20 {
+			t.Fatalf("message size %d", len(ev.Message))
+		}
+		if len(ev.Data) != 2 {
+			t.Fatalf("data %+v", ev.Data)
+		}
+		levels[ev.Level]++
+		providers[ev.Provider] = true
+		values[ev.Data[0].Value] = true
+	}
+	if len(levels) != 2 || levels[LevelInfo] < 600 || levels[LevelInfo] > 900 {
+		t.Fatalf("levels %v", levels)
+	}
+	if len(values) != 3 || len(providers) != 2 {
+		t.Fatalf("values %v providers %v", values, providers)
+	}
+
+	// The same seed generates the same events.
+	a, b := NewGeneratorSource(cfg).Next(), NewGeneratorSource(cfg).Next()
+	if a.Message != b.Message || a.Level != b.Level || a.EventID != b.EventID {
+		t.Fatalf("%+v and %+v differ", a, b)
+	}
+}
+
+func TestGeneratorSourceRate(t *testing.T) {
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	start := time.Now()
+	// 10 paced events take 90ms at 100/s; the bursts come on top.
+	if err := n.AddSource("gen", NewGeneratorSource(GeneratorConfig{
+		Rate:          100,
+		Count:         30,
+		BurstSize:     10,
+		BurstInterval: 40 * time.Millisecond,
+	})); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		waitSyslogEntry(t, n)
+	}
+	if d := time.Since(start); d < 60*time.Millisecond || d > time.Second {
+		t.Fatalf("30 events took %v", d)
+	}
+	select {
+	case e := <-n.EventLogChannel:
+		t.Fatalf("event beyond Count: %+v", e.Event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRunLoadTest(t *testing.T) {
+	r, err := RunLoadTest(context.Background(), LoadTestConfig{
+		Generator: GeneratorConfig{Count: 2000, Fields: 4},
+		Sources:   2,
+		Duration:  10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(r)
+	if r.Events != 4000 || r.Throughput <= 0 || r.Bytes != 4000*64 {
+		t.Fatalf("report %v", r)
+	}
+	if r.LatencyP50 > r.LatencyP90 || r.LatencyP90 > r.LatencyP99 || r.LatencyP99 > r.LatencyMax {
+		t.Fatalf("latencies out of order: %v", r)
+	}
+	if r.Allocs == 0 || r.HeapPeak == 0 {
+		t.Fatalf("memory not measured: %v", r)
+	}
+}
+
+func BenchmarkEventNotifierThroughput(b *testing.B) {
+	b.ReportAllocs()
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("gen", NewGeneratorSource(GeneratorConfig{Count: b.N, Fields: 4})); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		<-n.EventLogChannel
+	}
+}
@@ -2,6 +2,7 @@ module github.com/auuunya/eventwatcher
 
 go 1.19
 
-require golang.org/x/sys v0.21.0
-
-require github.com/fsnotify/fsnotify v1.5.4 // indirect
+require (
+	github.com/fsnotify/fsnotify v1.5.4
+	golang.org/x/sys v0.21.0
+)
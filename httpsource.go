@@ -0,0 +1,522 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHTTPBodySize limits the size of a request body after
+	// decompression.
+	DefaultHTTPBodySize = 1 << 20
+	// DefaultHTTPDeliveryTimeout is how long a request waits for its events
+	// to be received from EventLogChannel before it is answered with 429.
+	DefaultHTTPDeliveryTimeout = time.Second
+)
+
+var (
+	errHTTPTooLarge = errors.New("http: request body too large")
+	errHTTPBody     = errors.New("http: malformed request body")
+	errHTTPEncoding = errors.New("http: unsupported content encoding")
+)
+
+// HTTPConfig configures an HTTPSource.
+type HTTPConfig struct {
+	// Addr is the TCP address to listen on, e.g. ":8080".
+	Addr string
+	// Paths accepting events; defaults to "/events".
+	Paths []string
+	// TLS, when set, serves HTTPS.
+	TLS *tls.Config
+	// Tokens, when set, are the bearer tokens accepted in the Authorization
+	// header. Requests without one of them are answered with 401.
+	Tokens []string
+	// MaxBodySize defaults to DefaultHTTPBodySize. Larger bodies are
+	// answered with 413.
+	MaxBodySize int64
+	// DeliveryTimeout defaults to DefaultHTTPDeliveryTimeout.
+	DeliveryTimeout time.Duration
+	// Headers are request headers copied to every event as
+	// "header.<Canonical-Name>" data items, e.g. "X-Request-Id".
+	Headers []string
+	// Provider of events that do not name one; defaults to "http".
+	Provider string
+}
+
+// HTTPSource is a Source receiving events pushed with POST requests. The
+// body is one of
+//
+//   - application/json: an object or an array of objects,
+//   - application/x-ndjson: one object per line,
+//   - application/x-www-form-urlencoded: one event from the form values,
+//   - text/plain: one event per line,
+//
+// optionally with Content-Encoding gzip. Object keys such as "message",
+// "level", "time", "provider" and "host" set the Event fields and all other
+// keys become data items, nested objects as "outer.inner"; the JSON encoding
+// of Event itself is understood as well. The remote address is stored as
+// the "peer" data item.
+//
+// A request is answered once all of its events were received from
+// EventLogChannel: 200 with {"accepted": n}, or 429 when delivery did not
+// finish within DeliveryTimeout, in which case the first n events were
+// delivered.
+type HTTPSource struct {
+	cfg HTTPConfig
+	ln  net.Listener
+	srv *http.Server
+
+	mu     sync.Mutex
+	ctx    context.Context
+	emit   EmitFunc
+	closed bool
+	done   chan struct{}
+}
+
+// NewHTTPSource creates an HTTPSource; its socket is bound by Init.
+func NewHTTPSource(cfg HTTPConfig) *HTTPSource {
+	if len(cfg.Paths) == 0 {
+		cfg.Paths = []string{"/events"}
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = DefaultHTTPBodySize
+	}
+	if cfg.DeliveryTimeout <= 0 {
+		cfg.DeliveryTimeout = DefaultHTTPDeliveryTimeout
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = "http"
+	}
+	return &HTTPSource{cfg: cfg, done: make(chan struct{})}
+}
+
+// Init binds the listener.
+func (s *HTTPSource) Init() error {
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	if s.cfg.TLS != nil {
+		ln = tls.NewListener(ln, s.cfg.TLS)
+	}
+	mux := http.NewServeMux()
+	for _, p := range s.cfg.Paths {
+		mux.HandleFunc(p, s.handle)
+	}
+	s.ln = ln
+	s.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return nil
+}
+
+// Addr returns the bound address.
+func (s *HTTPSource) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Listen serves requests until ctx is done or Close is called.
+func (s *HTTPSource) Listen(ctx context.Context, emit EmitFunc) {
+	s.mu.Lock()
+	s.ctx, s.emit = ctx, emit
+	s.mu.Unlock()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.srv.Serve(s.ln)
+	}()
+	select {
+	case <-ctx.Done():
+	case <-s.done:
+	}
+	s.Close()
+	<-served
+}
+
+// Close stops the server, interrupting requests in progress.
+func (s *HTTPSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	if s.srv != nil {
+		s.srv.Close()
+	}
+}
+
+func (s *HTTPSource) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		httpError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	body, err := s.readBody(w, r)
+	if errors.Is(err, errHTTPTooLarge) {
+		httpError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if errors.Is(err, errHTTPEncoding) {
+		httpError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	events, err := parseHTTPEvents(r.Header.Get("Content-Type"), body)
+	if errors.Is(err, errHTTPContentType) {
+		httpError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	meta := []EventData{{Name: "peer", Value: r.RemoteAddr}}
+	for _, h := range s.cfg.Headers {
+		if v := r.Header.Get(h); v != "" {
+			meta = append(meta, EventData{Name: "header." + http.CanonicalHeaderKey(h), Value: v})
+		}
+	}
+
+	s.mu.Lock()
+	ctx, emit := s.ctx, s.emit
+	s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.DeliveryTimeout)
+	defer cancel()
+	accepted := 0
+	for _, ev := range events {
+		if ev.Provider == "" {
+			ev.Provider = s.cfg.Provider
+		}
+		if ev.TimeCreated.IsZero() {
+			ev.TimeCreated = time.Now()
+		}
+		for _, d := range meta {
+			ev.SetField(d.Name, d.Value)
+		}
+		err := emit(ctx, &EventEntry{Buffer: []byte(ev.Message), Event: ev})
+		if errors.Is(err, context.DeadlineExceeded) {
+			w.Header().Set("Retry-After", "1")
+			httpReply(w, http.StatusTooManyRequests, map[string]interface{}{"error": "delivery saturated", "accepted": accepted})
+			return
+		}
+		if err != nil {
+			httpReply(w, http.StatusServiceUnavailable, map[string]interface{}{"error": "shutting down", "accepted": accepted})
+			return
+		}
+		accepted++
+	}
+	httpReply(w, http.StatusOK, map[string]interface{}{"accepted": accepted})
+}
+
+func (s *HTTPSource) authorized(r *http.Request) bool {
+	if len(s.cfg.Tokens) == 0 {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return false
+	}
+	token := []byte(strings.TrimSpace(auth[7:]))
+	ok := false
+	for _, t := range s.cfg.Tokens {
+		if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// readBody reads the body, decompressing gzip, up to MaxBodySize bytes.
+func (s *HTTPSource) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	limit := s.cfg.MaxBodySize
+	if r.ContentLength > limit {
+		return nil, errHTTPTooLarge
+	}
+	var body io.Reader = http.MaxBytesReader(w, r.Body, limit)
+	switch strings.ToLower(r.Header.Get("Content-Encoding")) {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, errHTTPBody
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		return nil, errHTTPEncoding
+	}
+	b, err := io.ReadAll(io.LimitReader(body, limit+1))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || int64(len(b)) > limit {
+		return nil, errHTTPTooLarge
+	}
+	if err != nil {
+		return nil, errHTTPBody
+	}
+	return b, nil
+}
+
+var errHTTPContentType = errors.New("http: unsupported content type")
+
+// parseHTTPEvents decodes the events of a request body.
+func parseHTTPEvents(contentType string, body []byte) ([]*Event, error) {
+	mt := "application/json"
+	if contentType != "" {
+		var err error
+		if mt, _, err = mime.ParseMediaType(contentType); err != nil {
+			return nil, errHTTPContentType
+		}
+	}
+	var events []*Event
+	switch mt {
+	case "application/json":
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, errHTTPBody
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			events = append(events, eventFromJSON(v))
+		case []interface{}:
+			for _, item := range v {
+				obj, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, errHTTPBody
+				}
+				events = append(events, eventFromJSON(obj))
+			}
+		default:
+			return nil, errHTTPBody
+		}
+	case "application/x-ndjson", "application/jsonlines", "application/x-jsonlines":
+		sc := bufio.NewScanner(bytes.NewReader(body))
+		sc.Buffer(nil, len(body)+1)
+		for sc.Scan() {
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			dec := json.NewDecoder(bytes.NewReader(line))
+			dec.UseNumber()
+			var obj map[string]interface{}
+			if err := dec.Decode(&obj); err != nil || obj == nil {
+				return nil, errHTTPBody
+			}
+			events = append(events, eventFromJSON(obj))
+		}
+	case "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, errHTTPBody
+		}
+		obj := make(map[string]interface{}, len(values))
+		for k, vs := range values {
+			if len(vs) == 1 {
+				obj[k] = vs[0]
+				continue
+			}
+			list := make([]interface{}, len(vs))
+			for i, v := range vs {
+				list[i] = v
+			}
+			obj[k] = list
+		}
+		events = append(events, eventFromJSON(obj))
+	case "text/plain":
+		for _, line := range strings.Split(string(body), "\n") {
+			if line = strings.TrimRight(line, "\r"); line != "" {
+				events = append(events, lineEvent("", line, LevelInfo))
+			}
+		}
+	default:
+		return nil, errHTTPContentType
+	}
+	return events, nil
+}
+
+// eventFromJSON maps a decoded JSON object to an Event; see HTTPSource for
+// the recognized keys.
+func eventFromJSON(obj map[string]interface{}) *Event {
+	ev := &Event{}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := obj[k]
+		s, isString := v.(string)
+		switch strings.ToLower(k) {
+		case "message", "msg":
+			if isString {
+				ev.Message = s
+				continue
+			}
+		case "level", "severity":
+			if l, err := ParseLevel(s); isString && err == nil {
+				ev.Level = l
+				continue
+			}
+		case "time", "timestamp", "@timestamp", "time_created":
+			if t, ok := jsonTime(v); ok {
+				ev.TimeCreated = t
+				continue
+			}
+		case "provider", "source", "app":
+			if isString {
+				ev.Provider = s
+				continue
+			}
+		case "host", "hostname", "computer":
+			if isString {
+				ev.Computer = s
+				continue
+			}
+		case "event_id":
+			if n, ok := v.(json.Number); ok {
+				if id, err := strconv.ParseUint(n.String(), 10, 32); err == nil {
+					ev.EventID = uint32(id)
+					continue
+				}
+			}
+		case "record_id":
+			if n, ok := v.(json.Number); ok {
+				if id, err := strconv.ParseUint(n.String(), 10, 64); err == nil {
+					ev.RecordID = id
+					continue
+				}
+			}
+		case "process_id", "pid":
+			if n, ok := v.(json.Number); ok {
+				if pid, err := strconv.ParseUint(n.String(), 10, 32); err == nil {
+					ev.ProcessID = uint32(pid)
+					continue
+				}
+			}
+		case "channel":
+			if isString {
+				ev.Channel = s
+				continue
+			}
+		case "user_id":
+			if isString {
+				ev.UserID = s
+				continue
+			}
+		case "data":
+			// The Data list of a JSON encoded Event.
+			if list, ok := v.([]interface{}); ok && appendJSONEventData(ev, list) {
+				continue
+			}
+		}
+		flattenJSON(ev, k, v)
+	}
+	if ev.Level == LevelUnknown && ev.Message != "" {
+		ev.Level = DetectLevel(ev.Message)
+	}
+	if ev.Level == LevelUnknown {
+		ev.Level = LevelInfo
+	}
+	return ev
+}
+
+// jsonTime accepts RFC 3339 strings and Unix times in seconds or, for
+// values too large to be seconds, milliseconds.
+func jsonTime(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		if f > 1e11 {
+			f /= 1000
+		}
+		sec := int64(f)
+		return time.Unix(sec, int64((f-float64(sec))*1e9)).Round(time.Microsecond), true
+	}
+	return time.Time{}, false
+}
+
+func appendJSONEventData(ev *Event, list []interface{}) bool {
+	data := make([]EventData, 0, len(list))
+	for _, item := range list {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		name, _ := obj["name"].(string)
+		value, ok := obj["value"].(string)
+		if !ok {
+			return false
+		}
+		data = append(data, EventData{Name: name, Value: value})
+	}
+	ev.Data = append(ev.Data, data...)
+	return true
+}
+
+// flattenJSON stores v as data items, nested objects under "name.key".
+func flattenJSON(ev *Event, name string, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+	case string:
+		ev.Data = append(ev.Data, EventData{Name: name, Value: v})
+	case json.Number:
+		ev.Data = append(ev.Data, EventData{Name: name, Value: v.String()})
+	case bool:
+		ev.Data = append(ev.Data, EventData{Name: name, Value: strconv.FormatBool(v)})
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			flattenJSON(ev, name+"."+k, v[k])
+		}
+	default:
+		b, _ := json.Marshal(v)
+		ev.Data = append(ev.Data, EventData{Name: name, Value: string(b)})
+	}
+}
+
+func httpError(w http.ResponseWriter, code int, msg string) {
+	httpReply(w, code, map[string]interface{}{"error": msg})
+}
+
+func httpReply(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
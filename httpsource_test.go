@@ -0,0 +1,177 @@
+package eventwatcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type httpResult struct {
+	code int
+	body map[string]interface{}
+}
+
+// postEvents sends a request in the background; its result arrives once
+// the events were consumed.
+func postEvents(t *testing.T, url, contentType string, body []byte, header map[string]string) <-chan httpResult {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res := make(chan httpResult, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			res <- httpResult{}
+			return
+		}
+		defer resp.Body.Close()
+		var r httpResult
+		r.code = resp.StatusCode
+		json.NewDecoder(resp.Body).Decode(&r.body)
+		res <- r
+	}()
+	return res
+}
+
+func TestHTTPSource(t *testing.T) {
+	src := NewHTTPSource(HTTPConfig{
+		Addr:            "127.0.0.1:0",
+		Paths:           []string{"/events", "/v1/logs"},
+		Tokens:          []string{"s3cret"},
+		MaxBodySize:     512,
+		DeliveryTimeout: 100 * time.Millisecond,
+		Headers:         []string{"x-request-id"},
+	})
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("http", src); err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + src.Addr().String()
+	auth := map[string]string{"Authorization": "Bearer s3cret", "X-Request-Id": "req-1"}
+
+	res := postEvents(t, url+"/events", "application/json", []byte(`{
+		"message": "disk almost full", "level": "warn", "time": "2024-03-10T12:00:00.5Z",
+		"host": "web01", "app": "df", "free": 12, "mount": {"path": "/var", "ro": false}}`), auth)
+	e := waitSyslogEntry(t, n)
+	ev := e.Event
+	if e.Name != "http" || ev.Message != "disk almost full" || ev.Level != LevelWarning || ev.Computer != "web01" || ev.Provider != "df" {
+		t.Fatalf("json: %+v", ev)
+	}
+	if !ev.TimeCreated.Equal(time.Date(2024, 3, 10, 12, 0, 0, 5e8, time.UTC)) {
+		t.Fatalf("time: %v", ev.TimeCreated)
+	}
+	for name, want := range map[string]string{"free": "12", "mount.path": "/var", "mount.ro": "false", "header.X-Request-Id": "req-1"} {
+		if v, _ := ev.Field(name); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+	if peer, _ := ev.Field("peer"); !strings.HasPrefix(peer, "127.0.0.1:") {
+		t.Errorf("peer = %q", peer)
+	}
+	if r := <-res; r.code != http.StatusOK || r.body["accepted"] != 1.0 {
+		t.Fatalf("response: %+v", r)
+	}
+
+	// Gzip compressed NDJSON on the second path.
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	io.WriteString(zw, "{\"msg\":\"one\",\"time\":1710072000}\n\n{\"msg\":\"[ERROR] two\",\"event_id\":7}\n")
+	zw.Close()
+	res = postEvents(t, url+"/v1/logs", "application/x-ndjson", gz.Bytes(), map[string]string{"Authorization": "bearer s3cret", "Content-Encoding": "gzip"})
+	e = waitSyslogEntry(t, n)
+	if e.Event.Message != "one" || e.Event.Provider != "http" || !e.Event.TimeCreated.Equal(time.Unix(1710072000, 0)) {
+		t.Fatalf("ndjson 1: %+v", e.Event)
+	}
+	e = waitSyslogEntry(t, n)
+	if e.Event.Message != "[ERROR] two" || e.Event.Level != LevelError || e.Event.EventID != 7 {
+		t.Fatalf("ndjson 2: %+v", e.Event)
+	}
+	if r := <-res; r.code != http.StatusOK || r.body["accepted"] != 2.0 {
+		t.Fatalf("response: %+v", r)
+	}
+
+	res = postEvents(t, url+"/events", "application/x-www-form-urlencoded", []byte("message=form+event&tag=a&tag=b"), auth)
+	e = waitSyslogEntry(t, n)
+	if v, _ := e.Event.Field("tag"); e.Event.Message != "form event" || v != `["a","b"]` {
+		t.Fatalf("form: %+v", e.Event)
+	}
+	<-res
+
+	res = postEvents(t, url+"/events", "text/plain; charset=utf-8", []byte("line one\r\nline two\n"), auth)
+	for _, want := range []string{"line one", "line two"} {
+		if e = waitSyslogEntry(t, n); e.Event.Message != want {
+			t.Fatalf("text: %q", e.Event.Message)
+		}
+	}
+	<-res
+
+	for _, tc := range []struct {
+		name, contentType, body string
+		header                  map[string]string
+		code                    int
+	}{
+		{"no token", "application/json", `{}`, nil, http.StatusUnauthorized},
+		{"wrong token", "application/json", `{}`, map[string]string{"Authorization": "Bearer nope"}, http.StatusUnauthorized},
+		{"too large", "text/plain", strings.Repeat("x", 513), auth, http.StatusRequestEntityTooLarge},
+		{"bad json", "application/json", `{"message":`, auth, http.StatusBadRequest},
+		{"bad array", "application/json", `[1,2]`, auth, http.StatusBadRequest},
+		{"content type", "application/xml", `<e/>`, auth, http.StatusUnsupportedMediaType},
+		{"encoding", "application/json", `{}`, map[string]string{"Authorization": "Bearer s3cret", "Content-Encoding": "br"}, http.StatusUnsupportedMediaType},
+	} {
+		if r := <-postEvents(t, url+"/events", tc.contentType, []byte(tc.body), tc.header); r.code != tc.code {
+			t.Errorf("%s: got %d, want %d", tc.name, r.code, tc.code)
+		}
+	}
+
+	// Nobody reads EventLogChannel: the first event waits, then 429.
+	r := <-postEvents(t, url+"/events", "application/json", []byte(`[{"message":"a"},{"message":"b"}]`), auth)
+	if r.code != http.StatusTooManyRequests || r.body["accepted"] != 0.0 {
+		t.Fatalf("saturated: %+v", r)
+	}
+
+	resp, err := http.Get(url + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("GET: %d", resp.StatusCode)
+	}
+}
+
+func TestEventFromJSONEvent(t *testing.T) {
+	// The JSON encoding of an Event converts back to the same fields.
+	in := &Event{
+		Provider:    "app",
+		EventID:     42,
+		Level:       LevelError,
+		TimeCreated: time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC),
+		Message:     "boom",
+		Data:        []EventData{{Name: "k", Value: "v"}},
+	}
+	b, _ := json.Marshal(in)
+	events, err := parseHTTPEvents("application/json", b)
+	if err != nil || len(events) != 1 {
+		t.Fatal(events, err)
+	}
+	out := events[0]
+	if out.Provider != in.Provider || out.EventID != 42 || out.Level != LevelError || !out.TimeCreated.Equal(in.TimeCreated) || out.Message != "boom" {
+		t.Fatalf("got %+v", out)
+	}
+	if v, _ := out.Field("k"); v != "v" {
+		t.Fatalf("data: %+v", out.Data)
+	}
+}
@@ -0,0 +1,156 @@
+package eventwatcher
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// JournalCompression identifies the compression of a journal data object,
+// using the values of the object flags.
+type JournalCompression uint8
+
+const (
+	JournalCompressionXZ   JournalCompression = 1 << 0
+	JournalCompressionLZ4  JournalCompression = 1 << 1
+	JournalCompressionZSTD JournalCompression = 1 << 2
+)
+
+func (c JournalCompression) String() string {
+	switch c {
+	case JournalCompressionXZ:
+		return "xz"
+	case JournalCompressionLZ4:
+		return "lz4"
+	case JournalCompressionZSTD:
+		return "zstd"
+	}
+	return fmt.Sprintf("compression(%d)", uint8(c))
+}
+
+// JournalDecompressor decompresses a journal data payload. The result may
+// not exceed limit bytes.
+type JournalDecompressor func(payload []byte, limit int) ([]byte, error)
+
+var (
+	journalDecompressorsMu sync.RWMutex
+	journalDecompressors   = map[JournalCompression]JournalDecompressor{
+		JournalCompressionLZ4: decompressJournalLZ4,
+	}
+)
+
+// RegisterJournalDecompressor installs fn for payloads compressed with c.
+// Only LZ4 is built in. zstd, the default of current systemd versions, and
+// XZ, used by older ones, can be added with any package implementing them,
+// e.g.
+//
+//	dec, _ := zstd.NewReader(nil)
+//	eventwatcher.RegisterJournalDecompressor(eventwatcher.JournalCompressionZSTD,
+//		func(p []byte, limit int) ([]byte, error) { return dec.DecodeAll(p, nil) })
+func RegisterJournalDecompressor(c JournalCompression, fn JournalDecompressor) {
+	journalDecompressorsMu.Lock()
+	defer journalDecompressorsMu.Unlock()
+	journalDecompressors[c] = fn
+}
+
+// ErrJournalCompression is returned for data objects whose compression has
+// no registered decompressor.
+var ErrJournalCompression = errors.New("journal: unsupported compression")
+
+func decompressJournal(c JournalCompression, payload []byte, limit int) ([]byte, error) {
+	journalDecompressorsMu.RLock()
+	fn := journalDecompressors[c]
+	journalDecompressorsMu.RUnlock()
+	if fn == nil {
+		return nil, fmt.Errorf("%w: %s", ErrJournalCompression, c)
+	}
+	data, err := fn(payload, limit)
+	if err == nil && len(data) > limit {
+		err = errJournalObjectSize
+	}
+	return data, err
+}
+
+var errLZ4Corrupt = errors.New("journal: corrupt lz4 block")
+
+// decompressJournalLZ4 decodes systemd's LZ4 payloads: the little-endian
+// 64 bit uncompressed size followed by one LZ4 block.
+func decompressJournalLZ4(payload []byte, limit int) ([]byte, error) {
+	if len(payload) < 8 {
+		return nil, errLZ4Corrupt
+	}
+	size := binary.LittleEndian.Uint64(payload)
+	if size > uint64(limit) {
+		return nil, errJournalObjectSize
+	}
+	dst, err := decodeLZ4Block(payload[8:], make([]byte, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(dst)) != size {
+		return nil, errLZ4Corrupt
+	}
+	return dst, nil
+}
+
+// decodeLZ4Block appends the decoded LZ4 block src to dst, never growing
+// dst beyond its capacity.
+func decodeLZ4Block(src, dst []byte) ([]byte, error) {
+	length := func(n int, i *int) (int, error) {
+		if n != 15 {
+			return n, nil
+		}
+		for {
+			if *i >= len(src) {
+				return 0, errLZ4Corrupt
+			}
+			b := src[*i]
+			*i++
+			n += int(b)
+			if b != 255 {
+				return n, nil
+			}
+		}
+	}
+
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+		lit, err := length(int(token>>4), &i)
+		if err != nil {
+			return nil, err
+		}
+		if lit > len(src)-i || lit > cap(dst)-len(dst) {
+			return nil, errLZ4Corrupt
+		}
+		dst = append(dst, src[i:i+lit]...)
+		i += lit
+		if i == len(src) {
+			break
+		}
+
+		if i+2 > len(src) {
+			return nil, errLZ4Corrupt
+		}
+		offset := int(binary.LittleEndian.Uint16(src[i:]))
+		i += 2
+		if offset == 0 || offset > len(dst) {
+			return nil, errLZ4Corrupt
+		}
+		match, err := length(int(token&15), &i)
+		if err != nil {
+			return nil, err
+		}
+		match += 4
+		if match > cap(dst)-len(dst) {
+			return nil, errLZ4Corrupt
+		}
+		// Matches may overlap the bytes they produce, so copy bytewise.
+		start := len(dst) - offset
+		for j := 0; j < match; j++ {
+			dst = append(dst, dst[start+j])
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,477 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultJournalFieldSize limits the size of a single journal field.
+	DefaultJournalFieldSize = 16 << 20
+	// DefaultJournalRestartDelay is the delay before the journal reader is
+	// first restarted after its stream ended.
+	DefaultJournalRestartDelay = time.Second
+	// DefaultJournalMaxRestartDelay caps the restart backoff.
+	DefaultJournalMaxRestartDelay = time.Minute
+)
+
+// journalCursorSaveInterval is how often the cursor file is rewritten while
+// entries are flowing.
+const journalCursorSaveInterval = time.Second
+
+var errJournalField = errors.New("journal: malformed export field")
+
+// JournalEntry is a journal entry as a map from field name to value. Binary
+// fields hold their raw bytes; when a field repeats, the last value wins.
+type JournalEntry map[string]string
+
+// Cursor returns the __CURSOR field of the entry.
+func (e JournalEntry) Cursor() string {
+	return e["__CURSOR"]
+}
+
+// journalEventFields are mapped onto Event fields and not copied to Data.
+var journalEventFields = map[string]bool{
+	"MESSAGE": true,
+}
+
+// Event converts e to an Event. MESSAGE, PRIORITY, _PID, TID, _HOSTNAME,
+// _UID and the realtime timestamps are mapped onto the Event fields, the
+// provider is SYSLOG_IDENTIFIER or else _COMM, and every other field,
+// including _SYSTEMD_UNIT, is kept in Data under its journal name.
+func (e JournalEntry) Event() *Event {
+	ev := &Event{
+		Message:  e["MESSAGE"],
+		Computer: e["_HOSTNAME"],
+		UserID:   e["_UID"],
+		Provider: e["SYSLOG_IDENTIFIER"],
+	}
+	if ev.Provider == "" {
+		ev.Provider = e["_COMM"]
+	}
+	if p, err := strconv.Atoi(e["PRIORITY"]); err == nil {
+		ev.Level = LevelFromSyslog(p)
+	}
+	if pid, err := strconv.ParseUint(e["_PID"], 10, 32); err == nil {
+		ev.ProcessID = uint32(pid)
+	}
+	if tid, err := strconv.ParseUint(e["TID"], 10, 32); err == nil {
+		ev.ThreadID = uint32(tid)
+	}
+	for _, name := range []string{"_SOURCE_REALTIME_TIMESTAMP", "__REALTIME_TIMESTAMP"} {
+		if usec, err := strconv.ParseInt(e[name], 10, 64); err == nil {
+			ev.TimeCreated = time.UnixMicro(usec)
+			break
+		}
+	}
+	names := make([]string, 0, len(e))
+	for name := range e {
+		if !journalEventFields[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ev.Data = append(ev.Data, EventData{Name: name, Value: e[name]})
+	}
+	return ev
+}
+
+// JournalExportReader reads entries in the journal export format written
+// by "journalctl -o export" and systemd-journal-remote: "NAME=value" lines
+// for text fields, "NAME", a little-endian 64 bit length and the raw bytes
+// for binary ones, and an empty line after every entry.
+type JournalExportReader struct {
+	r *bufio.Reader
+	// MaxFieldSize defaults to DefaultJournalFieldSize.
+	MaxFieldSize int
+}
+
+// NewJournalExportReader returns a reader of the export stream r.
+func NewJournalExportReader(r io.Reader) *JournalExportReader {
+	return &JournalExportReader{r: bufio.NewReader(r), MaxFieldSize: DefaultJournalFieldSize}
+}
+
+// Next returns the next entry. It returns io.EOF at the end of the stream
+// and io.ErrUnexpectedEOF when the stream ends within a field.
+func (jr *JournalExportReader) Next() (JournalEntry, error) {
+	entry := JournalEntry{}
+	for {
+		line, err := jr.readLine()
+		if err == io.EOF && len(line) == 0 {
+			if len(entry) > 0 {
+				return entry, nil
+			}
+			return nil, io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(line) == 0 {
+			if len(entry) > 0 {
+				return entry, nil
+			}
+			continue
+		}
+		if i := bytes.IndexByte(line, '='); i >= 0 {
+			entry[string(line[:i])] = string(line[i+1:])
+			continue
+		}
+		value, err := jr.readBinary()
+		if err != nil {
+			return nil, err
+		}
+		entry[string(line)] = value
+	}
+}
+
+// readLine reads a line without its LF, bounded by MaxFieldSize.
+func (jr *JournalExportReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := jr.r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > jr.MaxFieldSize {
+			return nil, errJournalField
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == nil {
+			line = line[:len(line)-1]
+		} else if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return line, err
+	}
+}
+
+// readBinary reads the length, data and LF of a binary field.
+func (jr *JournalExportReader) readBinary() (string, error) {
+	var size [8]byte
+	if _, err := io.ReadFull(jr.r, size[:]); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	n := binary.LittleEndian.Uint64(size[:])
+	if n > uint64(jr.MaxFieldSize) {
+		return "", errJournalField
+	}
+	data := make([]byte, n+1)
+	if _, err := io.ReadFull(jr.r, data); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if data[n] != '\n' {
+		return "", errJournalField
+	}
+	return string(data[:n]), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// JournaldConfig configures a JournaldSource.
+type JournaldConfig struct {
+	// Command is the journal reader to run; it must write the export format
+	// to stdout and accept the arguments of journalctl. It defaults to
+	// "journalctl -o export --follow". "--after-cursor=CURSOR" or, without
+	// a stored cursor, "--lines=0" is appended, followed by Matches.
+	Command []string
+	// Matches are journalctl match expressions such as
+	// "_SYSTEMD_UNIT=sshd.service".
+	Matches []string
+	// Open, when set, is used instead of running Command. It returns the
+	// export stream following the entry with the given cursor, or new
+	// entries when cursor is empty.
+	Open func(ctx context.Context, cursor string) (io.ReadCloser, error)
+	// CursorFile stores the cursor of the last delivered entry, so that a
+	// restarted source resumes right after it.
+	CursorFile string
+	// NoRestart stops the source when the stream ends instead of opening
+	// it again after the last delivered entry.
+	NoRestart bool
+	// RestartDelay is the delay before the first restart, doubled on every
+	// further restart up to MaxRestartDelay. A stream that lasted at least
+	// MaxRestartDelay starts over with RestartDelay.
+	RestartDelay    time.Duration
+	MaxRestartDelay time.Duration
+}
+
+// JournaldSource is a Source reading the systemd journal in the export
+// format. When the stream ends, e.g. because journalctl exited, it emits an
+// error event and opens the stream again after the last delivered entry.
+type JournaldSource struct {
+	cfg JournaldConfig
+
+	mu     sync.Mutex
+	cursor string
+	saved  time.Time
+	stream io.ReadCloser
+	closed bool
+	done   chan struct{}
+}
+
+// NewJournaldSource creates a JournaldSource.
+func NewJournaldSource(cfg JournaldConfig) *JournaldSource {
+	if cfg.RestartDelay <= 0 {
+		cfg.RestartDelay = DefaultJournalRestartDelay
+	}
+	if cfg.MaxRestartDelay < cfg.RestartDelay {
+		cfg.MaxRestartDelay = DefaultJournalMaxRestartDelay
+		if cfg.MaxRestartDelay < cfg.RestartDelay {
+			cfg.MaxRestartDelay = cfg.RestartDelay
+		}
+	}
+	return &JournaldSource{cfg: cfg, done: make(chan struct{})}
+}
+
+// Init loads the stored cursor.
+func (s *JournaldSource) Init() error {
+	if s.cfg.CursorFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(s.cfg.CursorFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.cursor = string(bytes.TrimSpace(b))
+	return nil
+}
+
+// Cursor returns the cursor of the last delivered entry.
+func (s *JournaldSource) Cursor() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cursor
+}
+
+// Listen emits journal entries until ctx is done or Close is called, then
+// saves the cursor. Unless NoRestart is set, a stream that ended is opened
+// again after the last delivered entry.
+func (s *JournaldSource) Listen(ctx context.Context, emit EmitFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer s.saveCursor()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.done:
+			cancel()
+		}
+	}()
+
+	delay := s.cfg.RestartDelay
+	for {
+		started := time.Now()
+		stop, err := s.follow(ctx, emit)
+		if stop || ctx.Err() != nil {
+			return
+		}
+		s.saveCursor()
+		if time.Since(started) >= s.cfg.MaxRestartDelay {
+			delay = s.cfg.RestartDelay
+		}
+		restart := time.Duration(0)
+		if !s.cfg.NoRestart {
+			restart = delay
+		}
+		ev := s.endEvent(err, restart)
+		if emit(ctx, &EventEntry{Buffer: []byte(ev.Message), Event: ev}) != nil || s.cfg.NoRestart {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > s.cfg.MaxRestartDelay {
+			delay = s.cfg.MaxRestartDelay
+		}
+	}
+}
+
+// follow opens the stream after the last delivered entry and emits its
+// entries until it ends. It returns why the stream ended, and stop when
+// an entry could not be delivered.
+func (s *JournaldSource) follow(ctx context.Context, emit EmitFunc) (stop bool, err error) {
+	stream, err := s.open(ctx, s.Cursor())
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		stream.Close()
+		return true, nil
+	}
+	s.stream = stream
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.stream = nil
+		s.mu.Unlock()
+		stream.Close()
+	}()
+
+	ended := make(chan struct{})
+	defer close(ended)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.Close()
+		case <-ended:
+		}
+	}()
+
+	jr := NewJournalExportReader(stream)
+	for {
+		entry, err := jr.Next()
+		if err != nil {
+			return false, err
+		}
+		ev := entry.Event()
+		if err := emit(ctx, &EventEntry{Buffer: []byte(ev.Message), Event: ev}); err != nil {
+			return true, err
+		}
+		if cursor := entry.Cursor(); cursor != "" {
+			s.mu.Lock()
+			s.cursor = cursor
+			s.mu.Unlock()
+			if time.Since(s.saved) >= journalCursorSaveInterval {
+				s.saveCursor()
+			}
+		}
+	}
+}
+
+// endEvent reports why a journal stream ended, with the delay before it
+// is opened again.
+func (s *JournaldSource) endEvent(err error, restart time.Duration) *Event {
+	ev := &Event{
+		Provider:    "journald",
+		Level:       LevelError,
+		TimeCreated: time.Now(),
+		Message:     "journal stream ended",
+	}
+	if err != io.EOF {
+		ev.Message += ": " + err.Error()
+		ev.SetField("error", err.Error())
+	}
+	if cursor := s.Cursor(); cursor != "" {
+		ev.SetField("cursor", cursor)
+	}
+	if restart > 0 {
+		ev.SetField("restart_delay", restart.String())
+	}
+	return ev
+}
+
+// Close stops the source and the journal reader.
+func (s *JournaldSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	if s.stream != nil {
+		s.stream.Close()
+	}
+}
+
+func (s *JournaldSource) open(ctx context.Context, cursor string) (io.ReadCloser, error) {
+	if s.cfg.Open != nil {
+		return s.cfg.Open(ctx, cursor)
+	}
+	args := append([]string(nil), s.cfg.Command...)
+	if len(args) == 0 {
+		args = []string{"journalctl", "-o", "export", "--follow"}
+	}
+	if cursor != "" {
+		args = append(args, "--after-cursor="+cursor)
+	} else {
+		args = append(args, "--lines=0")
+	}
+	args = append(args, s.cfg.Matches...)
+	return startCommandStream(ctx, args)
+}
+
+// saveCursor writes the cursor file, replacing it atomically.
+func (s *JournaldSource) saveCursor() {
+	cursor := s.Cursor()
+	if s.cfg.CursorFile == "" || cursor == "" {
+		return
+	}
+	s.saved = time.Now()
+	writeFileAtomic(s.cfg.CursorFile, []byte(cursor+"\n"))
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// commandStream is the stdout of a running command. Closing it kills the
+// command and waits for it.
+type commandStream struct {
+	io.ReadCloser
+	cmd  *exec.Cmd
+	once sync.Once
+}
+
+func startCommandStream(ctx context.Context, args []string) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandStream{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+func (c *commandStream) Close() error {
+	c.once.Do(func() {
+		c.cmd.Process.Kill()
+		c.ReadCloser.Close()
+		c.cmd.Wait()
+	})
+	return nil
+}
@@ -0,0 +1,227 @@
+package eventwatcher
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// journalExport builds an export stream; fields whose value contains a
+// newline are written in the binary form.
+func journalExport(entries ...[][2]string) string {
+	var b strings.Builder
+	for _, entry := range entries {
+		for _, f := range entry {
+			if strings.ContainsRune(f[1], '\n') {
+				var size [8]byte
+				binary.LittleEndian.PutUint64(size[:], uint64(len(f[1])))
+				b.WriteString(f[0] + "\n")
+				b.Write(size[:])
+				b.WriteString(f[1] + "\n")
+				continue
+			}
+			b.WriteString(f[0] + "=" + f[1] + "\n")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func testJournalEntry(n int, msg string) [][2]string {
+	return [][2]string{
+		{"__CURSOR", "s=abc;i=" + string(rune('0'+n))},
+		{"__REALTIME_TIMESTAMP", "1710072000000000"},
+		{"__MONOTONIC_TIMESTAMP", "123456"},
+		{"_HOSTNAME", "web01"},
+		{"_SYSTEMD_UNIT", "sshd.service"},
+		{"SYSLOG_IDENTIFIER", "sshd"},
+		{"_COMM", "sshd"},
+		{"_PID", "812"},
+		{"_UID", "0"},
+		{"PRIORITY", "3"},
+		{"MESSAGE", msg},
+	}
+}
+
+func TestJournalExportReader(t *testing.T) {
+	stream := journalExport(
+		testJournalEntry(1, "Accepted publickey for root"),
+		testJournalEntry(2, "line one\nline two"),
+	)
+	jr := NewJournalExportReader(strings.NewReader(stream))
+
+	e, err := jr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Cursor() != "s=abc;i=1" {
+		t.Errorf("cursor = %q", e.Cursor())
+	}
+	ev := e.Event()
+	if ev.Message != "Accepted publickey for root" || ev.Level != LevelError || ev.ProcessID != 812 ||
+		ev.Computer != "web01" || ev.Provider != "sshd" || ev.UserID != "0" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	if want := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC); !ev.TimeCreated.Equal(want) {
+		t.Errorf("time = %v, want %v", ev.TimeCreated, want)
+	}
+	if unit, _ := ev.Field("_SYSTEMD_UNIT"); unit != "sshd.service" {
+		t.Errorf("unit = %q", unit)
+	}
+	if _, ok := ev.Field("MESSAGE"); ok {
+		t.Error("MESSAGE copied to data")
+	}
+
+	e, err = jr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e["MESSAGE"] != "line one\nline two" {
+		t.Errorf("binary field = %q", e["MESSAGE"])
+	}
+	if _, err := jr.Next(); err != io.EOF {
+		t.Errorf("end of stream error = %v", err)
+	}
+
+	truncated := stream[:len(stream)-10]
+	jr = NewJournalExportReader(strings.NewReader(truncated))
+	jr.Next()
+	if _, err := jr.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated stream error = %v", err)
+	}
+}
+
+func TestJournaldSourceResume(t *testing.T) {
+	cursorFile := filepath.Join(t.TempDir(), "cursor")
+	var cursors []string
+	journal := [][][2]string{
+		testJournalEntry(1, "first"),
+		testJournalEntry(2, "second"),
+		testJournalEntry(3, "third"),
+	}
+	open := func(ctx context.Context, cursor string) (io.ReadCloser, error) {
+		cursors = append(cursors, cursor)
+		var rest [][][2]string
+		for i, entry := range journal {
+			if cursor == "" || journal[i][0][1] > cursor {
+				rest = append(rest, entry)
+			}
+		}
+		return io.NopCloser(strings.NewReader(journalExport(rest...))), nil
+	}
+
+	run := func(want ...string) {
+		t.Helper()
+		n := NewEventNotifier(context.Background())
+		src := NewJournaldSource(JournaldConfig{Open: open, CursorFile: cursorFile})
+		if err := n.AddSource("journal", src); err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range want {
+			if e := waitSyslogEntry(t, n); e.Event.Message != msg || e.Name != "journal" {
+				t.Errorf("entry %q %q, want %q", e.Name, e.Event.Message, msg)
+			}
+		}
+		n.Close()
+	}
+
+	run("first", "second", "third")
+	if b, _ := os.ReadFile(cursorFile); strings.TrimSpace(string(b)) != "s=abc;i=3" {
+		t.Fatalf("cursor file = %q", b)
+	}
+	journal = append(journal, testJournalEntry(4, "fourth"))
+	run("fourth")
+	if len(cursors) != 2 || cursors[0] != "" || cursors[1] != "s=abc;i=3" {
+		t.Errorf("opened at cursors %q", cursors)
+	}
+}
+
+func TestJournaldSourceCommand(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	dir := t.TempDir()
+	stream := journalExport(testJournalEntry(1, "from a command"))
+	script, args := filepath.Join(dir, "export"), filepath.Join(dir, "args")
+	os.WriteFile(script, []byte(stream), 0600)
+	cursorFile := filepath.Join(dir, "cursor")
+	os.WriteFile(cursorFile, []byte("s=abc;i=0\n"), 0600)
+
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	src := NewJournaldSource(JournaldConfig{
+		Command:    []string{"/bin/sh", "-c", `echo "$@" > ` + args + "; cat " + script + "; sleep 10", "journalctl"},
+		Matches:    []string{"_SYSTEMD_UNIT=sshd.service"},
+		CursorFile: cursorFile,
+	})
+	if err := n.AddSource("journal", src); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitSyslogEntry(t, n); e.Event.Message != "from a command" {
+		t.Errorf("message = %q", e.Event.Message)
+	}
+	// The cursor and matches are passed to custom commands as well.
+	if b, _ := os.ReadFile(args); string(b) != "--after-cursor=s=abc;i=0 _SYSTEMD_UNIT=sshd.service\n" {
+		t.Errorf("args = %q", b)
+	}
+	if err := n.RemoveWatcher("journal"); err != nil {
+		t.Fatal(err)
+	}
+	if src.Cursor() != "s=abc;i=1" {
+		t.Errorf("cursor = %q", src.Cursor())
+	}
+}
+
+func TestJournaldSourceRestart(t *testing.T) {
+	// The first reader dies after one entry and the second cannot start;
+	// the third resumes after the delivered entry.
+	var cursors []string
+	open := func(ctx context.Context, cursor string) (io.ReadCloser, error) {
+		cursors = append(cursors, cursor)
+		switch len(cursors) {
+		case 1:
+			return io.NopCloser(strings.NewReader(journalExport(testJournalEntry(1, "first")))), nil
+		case 2:
+			return nil, errors.New("journalctl not found")
+		}
+		return io.NopCloser(strings.NewReader(journalExport(testJournalEntry(2, "second")))), nil
+	}
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	src := NewJournaldSource(JournaldConfig{Open: open, RestartDelay: 10 * time.Millisecond})
+	if err := n.AddSource("journal", src); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitSyslogEntry(t, n); e.Event.Message != "first" {
+		t.Fatalf("got %q", e.Event.Message)
+	}
+	e := waitSyslogEntry(t, n)
+	if e.Event.Level != LevelError || e.Event.Message != "journal stream ended" {
+		t.Fatalf("end event %+v", e.Event)
+	}
+	if d, _ := e.Event.Field("restart_delay"); d != "10ms" {
+		t.Fatalf("restart_delay = %q", d)
+	}
+	e = waitSyslogEntry(t, n)
+	if v, _ := e.Event.Field("error"); v != "journalctl not found" {
+		t.Fatalf("open failure event %+v", e.Event)
+	}
+	if d, _ := e.Event.Field("restart_delay"); d != "20ms" {
+		t.Fatalf("restart_delay = %q", d)
+	}
+	if e := waitSyslogEntry(t, n); e.Event.Message != "second" {
+		t.Fatalf("got %q", e.Event.Message)
+	}
+	if err := n.RemoveWatcher("journal"); err != nil {
+		t.Fatal(err)
+	}
+	if len(cursors) < 3 || cursors[1] != "s=abc;i=1" || cursors[2] != "s=abc;i=1" {
+		t.Fatalf("opened at cursors %q", cursors)
+	}
+}
@@ -0,0 +1,427 @@
+package eventwatcher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Reader for systemd journal files (/var/log/journal/*/*.journal), following
+// systemd's "Journal File Format" specification. Files are read with ReadAt
+// and never modified, so copies from other hosts can be analyzed without
+// systemd.
+
+const (
+	journalSignature = "LPKSHHRH"
+	// journalHeaderMinSize is the size of the original header;
+	// journalHeaderCountsSize adds the data and field counts of systemd 187.
+	journalHeaderMinSize    = 208
+	journalHeaderCountsSize = 224
+	journalObjectHeaderSize = 16
+	// journalMaxObjectSize bounds the objects read into memory.
+	journalMaxObjectSize = DefaultJournalFieldSize + 1024
+)
+
+// Incompatible header flags.
+const (
+	journalIncompatibleXZ        = 1 << 0
+	journalIncompatibleLZ4       = 1 << 1
+	journalIncompatibleKeyedHash = 1 << 2
+	journalIncompatibleZSTD      = 1 << 3
+	journalIncompatibleCompact   = 1 << 4
+	journalIncompatibleSupported = journalIncompatibleXZ | journalIncompatibleLZ4 |
+		journalIncompatibleKeyedHash | journalIncompatibleZSTD | journalIncompatibleCompact
+)
+
+// Object types.
+const (
+	journalObjectData           = 1
+	journalObjectField          = 2
+	journalObjectEntry          = 3
+	journalObjectDataHashTable  = 4
+	journalObjectFieldHashTable = 5
+	journalObjectEntryArray     = 6
+	journalObjectTag            = 7
+)
+
+const journalObjectCompressionMask = JournalCompressionXZ | JournalCompressionLZ4 | JournalCompressionZSTD
+
+var (
+	errJournalSignature  = errors.New("journal: not a journal file")
+	errJournalObject     = errors.New("journal: corrupt object")
+	errJournalObjectSize = errors.New("journal: object too large")
+)
+
+// JournalHeader holds the header fields of a journal file.
+type JournalHeader struct {
+	CompatibleFlags   uint32
+	IncompatibleFlags uint32
+	// State is 0 for offline, 1 for online (being written) and 2 for
+	// archived files.
+	State     uint8
+	FileID    [16]byte
+	MachineID [16]byte
+	// BootID is the boot of the last entry.
+	BootID               [16]byte
+	SeqnumID             [16]byte
+	HeaderSize           uint64
+	ArenaSize            uint64
+	DataHashTableOffset  uint64
+	DataHashTableSize    uint64
+	FieldHashTableOffset uint64
+	FieldHashTableSize   uint64
+	TailObjectOffset     uint64
+	Objects              uint64
+	Entries              uint64
+	TailEntrySeqnum      uint64
+	HeadEntrySeqnum      uint64
+	EntryArrayOffset     uint64
+	HeadEntryRealtime    uint64
+	TailEntryRealtime    uint64
+	TailEntryMonotonic   uint64
+	Data                 uint64
+	Fields               uint64
+}
+
+// Compact reports whether the file uses the compact layout of systemd 252
+// and later, with 32 bit offsets in entries and entry arrays.
+func (h *JournalHeader) Compact() bool {
+	return h.IncompatibleFlags&journalIncompatibleCompact != 0
+}
+
+// KeyedHash reports whether the hash tables use SipHash keyed with FileID.
+func (h *JournalHeader) KeyedHash() bool {
+	return h.IncompatibleFlags&journalIncompatibleKeyedHash != 0
+}
+
+// JournalFile is an open journal file.
+type JournalFile struct {
+	Header JournalHeader
+	r      io.ReaderAt
+	size   int64
+	closer io.Closer
+}
+
+// OpenJournalFile opens the journal file at path.
+func OpenJournalFile(path string) (*JournalFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	j, err := NewJournalFile(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	j.closer = f
+	return j, nil
+}
+
+// NewJournalFile reads the journal file of the given size from r.
+func NewJournalFile(r io.ReaderAt, size int64) (*JournalFile, error) {
+	j := &JournalFile{r: r, size: size}
+	if err := j.readHeader(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Close closes the file opened by OpenJournalFile.
+func (j *JournalFile) Close() error {
+	if j.closer == nil {
+		return nil
+	}
+	return j.closer.Close()
+}
+
+func (j *JournalFile) readHeader() error {
+	buf := make([]byte, journalHeaderCountsSize)
+	if n, _ := j.r.ReadAt(buf, 0); n < journalHeaderMinSize {
+		return errJournalSignature
+	}
+	if string(buf[:8]) != journalSignature {
+		return errJournalSignature
+	}
+	h := &j.Header
+	le := binary.LittleEndian
+	h.CompatibleFlags = le.Uint32(buf[8:])
+	h.IncompatibleFlags = le.Uint32(buf[12:])
+	h.State = buf[16]
+	copy(h.FileID[:], buf[24:40])
+	copy(h.MachineID[:], buf[40:56])
+	copy(h.BootID[:], buf[56:72])
+	copy(h.SeqnumID[:], buf[72:88])
+	fields := []*uint64{
+		&h.HeaderSize, &h.ArenaSize, &h.DataHashTableOffset, &h.DataHashTableSize,
+		&h.FieldHashTableOffset, &h.FieldHashTableSize, &h.TailObjectOffset, &h.Objects,
+		&h.Entries, &h.TailEntrySeqnum, &h.HeadEntrySeqnum, &h.EntryArrayOffset,
+		&h.HeadEntryRealtime, &h.TailEntryRealtime, &h.TailEntryMonotonic, &h.Data, &h.Fields,
+	}
+	for i, f := range fields {
+		*f = le.Uint64(buf[88+8*i:])
+	}
+	if h.HeaderSize < journalHeaderCountsSize {
+		h.Data, h.Fields = 0, 0
+	}
+	if unknown := h.IncompatibleFlags &^ journalIncompatibleSupported; unknown != 0 {
+		return fmt.Errorf("journal: unsupported incompatible flags %#x", unknown)
+	}
+	if h.HeaderSize < journalHeaderMinSize || h.HeaderSize > uint64(j.size) {
+		return errJournalSignature
+	}
+	return nil
+}
+
+// object reads the object at off, which must have type typ.
+func (j *JournalFile) object(off uint64, typ uint8) ([]byte, error) {
+	if off%8 != 0 || off < j.Header.HeaderSize || off+journalObjectHeaderSize > uint64(j.size) {
+		return nil, fmt.Errorf("%w: bad offset %d", errJournalObject, off)
+	}
+	var hdr [journalObjectHeaderSize]byte
+	if _, err := j.r.ReadAt(hdr[:], int64(off)); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint64(hdr[8:])
+	if hdr[0] != typ {
+		return nil, fmt.Errorf("%w: object at %d has type %d, want %d", errJournalObject, off, hdr[0], typ)
+	}
+	if size < journalObjectHeaderSize || size > uint64(j.size)-off {
+		return nil, fmt.Errorf("%w: object at %d has size %d", errJournalObject, off, size)
+	}
+	if size > journalMaxObjectSize {
+		return nil, errJournalObjectSize
+	}
+	obj := make([]byte, size)
+	if _, err := j.r.ReadAt(obj, int64(off)); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// dataPayload returns the decompressed "FIELD=value" payload of a data
+// object.
+func (j *JournalFile) dataPayload(obj []byte) ([]byte, error) {
+	start := 64
+	if j.Header.Compact() {
+		start = 72
+	}
+	if len(obj) < start {
+		return nil, errJournalObject
+	}
+	payload := obj[start:]
+	if c := JournalCompression(obj[1]) & journalObjectCompressionMask; c != 0 {
+		return decompressJournal(c, payload, DefaultJournalFieldSize)
+	}
+	return payload, nil
+}
+
+// entryArrayItems returns the offsets stored in an entry array object and
+// the offset of the next array.
+func (j *JournalFile) entryArrayItems(off uint64) ([]uint64, uint64, error) {
+	obj, err := j.object(off, journalObjectEntryArray)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(obj) < 24 {
+		return nil, 0, errJournalObject
+	}
+	next := binary.LittleEndian.Uint64(obj[16:])
+	items := readJournalOffsets(obj[24:], j.Header.Compact())
+	return items, next, nil
+}
+
+// readJournalOffsets decodes 32 bit (compact) or 64 bit offsets.
+func readJournalOffsets(b []byte, compact bool) []uint64 {
+	var offs []uint64
+	if compact {
+		for ; len(b) >= 4; b = b[4:] {
+			offs = append(offs, uint64(binary.LittleEndian.Uint32(b)))
+		}
+		return offs
+	}
+	for ; len(b) >= 8; b = b[8:] {
+		offs = append(offs, binary.LittleEndian.Uint64(b))
+	}
+	return offs
+}
+
+// entry reads the entry object at off with all of its data fields, and
+// adds the fields journalctl's export format derives from the entry:
+// __CURSOR, __REALTIME_TIMESTAMP, __MONOTONIC_TIMESTAMP, __SEQNUM and
+// _BOOT_ID.
+func (j *JournalFile) entry(off uint64) (JournalEntry, error) {
+	obj, err := j.object(off, journalObjectEntry)
+	if err != nil {
+		return nil, err
+	}
+	if len(obj) < 64 {
+		return nil, errJournalObject
+	}
+	le := binary.LittleEndian
+	seqnum := le.Uint64(obj[16:])
+	realtime := le.Uint64(obj[24:])
+	monotonic := le.Uint64(obj[32:])
+	bootID := hex.EncodeToString(obj[40:56])
+	xorHash := le.Uint64(obj[56:])
+
+	var dataOffs []uint64
+	if j.Header.Compact() {
+		dataOffs = readJournalOffsets(obj[64:], true)
+	} else {
+		for b := obj[64:]; len(b) >= 16; b = b[16:] {
+			dataOffs = append(dataOffs, le.Uint64(b))
+		}
+	}
+
+	entry := JournalEntry{}
+	for _, doff := range dataOffs {
+		dobj, err := j.object(doff, journalObjectData)
+		if err != nil {
+			return nil, err
+		}
+		payload, err := j.dataPayload(dobj)
+		if err != nil {
+			return nil, err
+		}
+		i := bytes.IndexByte(payload, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("%w: data object at %d has no field name", errJournalObject, doff)
+		}
+		entry[string(payload[:i])] = string(payload[i+1:])
+	}
+	entry["__CURSOR"] = fmt.Sprintf("s=%s;i=%x;b=%s;m=%x;t=%x;x=%x",
+		hex.EncodeToString(j.Header.SeqnumID[:]), seqnum, bootID, monotonic, realtime, xorHash)
+	entry["__REALTIME_TIMESTAMP"] = strconv.FormatUint(realtime, 10)
+	entry["__MONOTONIC_TIMESTAMP"] = strconv.FormatUint(monotonic, 10)
+	entry["__SEQNUM"] = strconv.FormatUint(seqnum, 10)
+	entry["_BOOT_ID"] = bootID
+	return entry, nil
+}
+
+// Entries returns an iterator over all entries, oldest first.
+func (j *JournalFile) Entries() *JournalFileIterator {
+	return &JournalFileIterator{j: j, array: j.Header.EntryArrayOffset, left: j.Header.Entries}
+}
+
+// Match returns an iterator over the entries containing the field match
+// "FIELD=value", found through the data hash table.
+func (j *JournalFile) Match(match string) (*JournalFileIterator, error) {
+	obj, err := j.findData([]byte(match))
+	if err != nil || obj == nil {
+		return &JournalFileIterator{j: j}, err
+	}
+	le := binary.LittleEndian
+	return &JournalFileIterator{
+		j:     j,
+		first: le.Uint64(obj[40:]),
+		array: le.Uint64(obj[48:]),
+		left:  le.Uint64(obj[56:]),
+	}, nil
+}
+
+// hash returns the hash of a data payload used by the hash tables.
+func (j *JournalFile) hash(data []byte) uint64 {
+	if j.Header.KeyedHash() {
+		return siphash24(data, j.Header.FileID)
+	}
+	return jenkinsHash64(data)
+}
+
+// findData looks payload up in the data hash table and returns its data
+// object, or nil when no entry contains it.
+func (j *JournalFile) findData(payload []byte) ([]byte, error) {
+	buckets := j.Header.DataHashTableSize / 16
+	if buckets == 0 {
+		return nil, nil
+	}
+	h := j.hash(payload)
+	var item [8]byte
+	if _, err := j.r.ReadAt(item[:], int64(j.Header.DataHashTableOffset+h%buckets*16)); err != nil {
+		return nil, err
+	}
+	// Guard against cycles in corrupt files.
+	for off, n := binary.LittleEndian.Uint64(item[:]), uint64(0); off != 0; n++ {
+		if n > j.Header.Objects {
+			return nil, fmt.Errorf("%w: hash chain loop", errJournalObject)
+		}
+		obj, err := j.object(off, journalObjectData)
+		if err != nil {
+			return nil, err
+		}
+		if len(obj) < 64 {
+			return nil, errJournalObject
+		}
+		if binary.LittleEndian.Uint64(obj[16:]) == h {
+			got, err := j.dataPayload(obj)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(got, payload) {
+				return obj, nil
+			}
+		}
+		off = binary.LittleEndian.Uint64(obj[24:])
+	}
+	return nil, nil
+}
+
+// JournalFileIterator walks entries through an entry array chain.
+type JournalFileIterator struct {
+	j *JournalFile
+	// first is an entry to return before the array chain, as data objects
+	// store their first entry outside of their arrays.
+	first uint64
+	array uint64
+	items []uint64
+	left  uint64
+}
+
+// Next returns the next entry, or io.EOF when there are no more.
+func (it *JournalFileIterator) Next() (JournalEntry, error) {
+	off, err := it.nextOffset()
+	if err != nil {
+		return nil, err
+	}
+	return it.j.entry(off)
+}
+
+func (it *JournalFileIterator) nextOffset() (uint64, error) {
+	if it.left == 0 {
+		return 0, io.EOF
+	}
+	if it.first != 0 {
+		off := it.first
+		it.first = 0
+		it.left--
+		return off, nil
+	}
+	for len(it.items) == 0 {
+		if it.array == 0 {
+			return 0, io.EOF
+		}
+		items, next, err := it.j.entryArrayItems(it.array)
+		if err != nil {
+			return 0, err
+		}
+		it.items, it.array = items, next
+	}
+	off := it.items[0]
+	it.items = it.items[1:]
+	if off == 0 {
+		// Unused slots end the chain.
+		it.left = 0
+		return 0, io.EOF
+	}
+	it.left--
+	return off, nil
+}
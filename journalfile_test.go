@@ -0,0 +1,450 @@
+package eventwatcher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testJournal writes journal files the way journald lays them out, with
+// enough fidelity for the reader: hash tables, deduplicated data objects
+// with their own entry arrays, entries and a main entry array split into
+// chained arrays.
+type testJournal struct {
+	buf       []byte
+	compact   bool
+	keyed     bool
+	compress  func([]byte) ([]byte, JournalCompression)
+	fileID    [16]byte
+	seqnumID  [16]byte
+	bootID    [16]byte
+	buckets   uint64
+	hashTable uint64
+	data      map[string]*testJournalData
+	nData     uint64
+	nObjects  uint64
+	entries   []uint64
+}
+
+type testJournalData struct {
+	off     uint64
+	entries []uint64
+}
+
+const testJournalHeaderSize = 272
+
+func newTestJournal(compact, keyed bool) *testJournal {
+	tj := &testJournal{
+		compact: compact,
+		keyed:   keyed,
+		buf:     make([]byte, testJournalHeaderSize),
+		buckets: 4,
+		data:    make(map[string]*testJournalData),
+	}
+	copy(tj.fileID[:], "file-id-01234567")
+	copy(tj.seqnumID[:], "seqnum-id-abcdef")
+	copy(tj.bootID[:], "boot-id-89abcdef")
+	off := tj.object(journalObjectDataHashTable, 0, make([]byte, tj.buckets*16))
+	tj.hashTable = off + journalObjectHeaderSize
+	tj.object(journalObjectFieldHashTable, 0, make([]byte, tj.buckets*16))
+	return tj
+}
+
+// object appends an object and returns its offset.
+func (tj *testJournal) object(typ, flags uint8, body []byte) uint64 {
+	off := uint64(len(tj.buf))
+	tj.nObjects++
+	var hdr [journalObjectHeaderSize]byte
+	hdr[0], hdr[1] = typ, flags
+	binary.LittleEndian.PutUint64(hdr[8:], uint64(journalObjectHeaderSize+len(body)))
+	tj.buf = append(tj.buf, hdr[:]...)
+	tj.buf = append(tj.buf, body...)
+	for len(tj.buf)%8 != 0 {
+		tj.buf = append(tj.buf, 0)
+	}
+	return off
+}
+
+func (tj *testJournal) put64(off, v uint64) {
+	binary.LittleEndian.PutUint64(tj.buf[off:], v)
+}
+
+func (tj *testJournal) hash(payload []byte) uint64 {
+	if tj.keyed {
+		return siphash24(payload, tj.fileID)
+	}
+	return jenkinsHash64(payload)
+}
+
+// addData returns the data object of payload, creating it and linking it
+// into the hash table when needed.
+func (tj *testJournal) addData(payload string) *testJournalData {
+	if d, ok := tj.data[payload]; ok {
+		return d
+	}
+	h := tj.hash([]byte(payload))
+	body := make([]byte, 48)
+	binary.LittleEndian.PutUint64(body[0:], h)
+	if tj.compact {
+		body = append(body, make([]byte, 8)...)
+	}
+	stored, flags := []byte(payload), uint8(0)
+	if tj.compress != nil {
+		var c JournalCompression
+		stored, c = tj.compress(stored)
+		flags = uint8(c)
+	}
+	off := tj.object(journalObjectData, flags, append(body, stored...))
+
+	bucket := tj.hashTable + h%tj.buckets*16
+	if tail := binary.LittleEndian.Uint64(tj.buf[bucket+8:]); tail != 0 {
+		tj.put64(tail+24, off)
+	} else {
+		tj.put64(bucket, off)
+	}
+	tj.put64(bucket+8, off)
+
+	d := &testJournalData{off: off}
+	tj.data[payload] = d
+	tj.nData++
+	return d
+}
+
+func (tj *testJournal) addEntry(seqnum uint64, realtime time.Time, fields ...string) {
+	var datas []*testJournalData
+	for _, f := range fields {
+		datas = append(datas, tj.addData(f))
+	}
+	body := make([]byte, 48)
+	binary.LittleEndian.PutUint64(body[0:], seqnum)
+	binary.LittleEndian.PutUint64(body[8:], uint64(realtime.UnixMicro()))
+	binary.LittleEndian.PutUint64(body[16:], seqnum*1000)
+	copy(body[24:40], tj.bootID[:])
+	for _, d := range datas {
+		if tj.compact {
+			body = binary.LittleEndian.AppendUint32(body, uint32(d.off))
+		} else {
+			body = binary.LittleEndian.AppendUint64(body, d.off)
+			body = binary.LittleEndian.AppendUint64(body, 0)
+		}
+	}
+	off := tj.object(journalObjectEntry, 0, body)
+	for _, d := range datas {
+		d.entries = append(d.entries, off)
+	}
+	tj.entries = append(tj.entries, off)
+}
+
+// entryArrays writes offs as a chain of arrays holding at most perArray
+// items, the last one padded with unused slots, and returns the first.
+func (tj *testJournal) entryArrays(offs []uint64, perArray int) uint64 {
+	var first, prev uint64
+	for len(offs) > 0 {
+		n := perArray
+		if n > len(offs) {
+			n = len(offs)
+		}
+		body := make([]byte, 8)
+		for i := 0; i < perArray; i++ {
+			var v uint64
+			if i < n {
+				v = offs[i]
+			}
+			if tj.compact {
+				body = binary.LittleEndian.AppendUint32(body, uint32(v))
+			} else {
+				body = binary.LittleEndian.AppendUint64(body, v)
+			}
+		}
+		off := tj.object(journalObjectEntryArray, 0, body)
+		if prev != 0 {
+			tj.put64(prev+16, off)
+		} else {
+			first = off
+		}
+		prev = off
+		offs = offs[n:]
+	}
+	return first
+}
+
+// bytes finishes the file.
+func (tj *testJournal) bytes() []byte {
+	main := tj.entryArrays(tj.entries, 2)
+	for _, d := range tj.data {
+		tj.put64(d.off+40, d.entries[0])
+		tj.put64(d.off+48, tj.entryArrays(d.entries[1:], 2))
+		tj.put64(d.off+56, uint64(len(d.entries)))
+	}
+
+	h := tj.buf[:testJournalHeaderSize]
+	copy(h, journalSignature)
+	var flags uint32
+	if tj.keyed {
+		flags |= journalIncompatibleKeyedHash
+	}
+	if tj.compact {
+		flags |= journalIncompatibleCompact
+	}
+	if tj.compress != nil {
+		flags |= journalIncompatibleXZ | journalIncompatibleLZ4
+	}
+	binary.LittleEndian.PutUint32(h[12:], flags)
+	h[16] = 2
+	copy(h[24:], tj.fileID[:])
+	copy(h[56:], tj.bootID[:])
+	copy(h[72:], tj.seqnumID[:])
+	le := binary.LittleEndian
+	le.PutUint64(h[88:], testJournalHeaderSize)
+	le.PutUint64(h[96:], uint64(len(tj.buf)-testJournalHeaderSize))
+	le.PutUint64(h[104:], tj.hashTable)
+	le.PutUint64(h[112:], tj.buckets*16)
+	le.PutUint64(h[144:], tj.nObjects)
+	le.PutUint64(h[152:], uint64(len(tj.entries)))
+	le.PutUint64(h[176:], main)
+	le.PutUint64(h[208:], tj.nData)
+	return tj.buf
+}
+
+func TestJournalHashes(t *testing.T) {
+	c, b := hashlittle2([]byte("Four score and seven years ago"), 0, 0)
+	if c != 0x17770551 || b != 0xce7226e6 {
+		t.Errorf("hashlittle2 = %08x %08x, want 17770551 ce7226e6", c, b)
+	}
+	if c, b := hashlittle2(nil, 0, 0); c != 0xdeadbeef || b != 0xdeadbeef {
+		t.Errorf("hashlittle2 of nothing = %08x %08x", c, b)
+	}
+
+	var key [16]byte
+	msg := make([]byte, 15)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	if h := siphash24(msg, key); h != 0xa129ca6149be45e5 {
+		t.Errorf("siphash24 = %016x, want a129ca6149be45e5", h)
+	}
+}
+
+func TestDecodeLZ4Block(t *testing.T) {
+	// "abc", then a 9 byte match at offset 3, then the literals "XY".
+	block := []byte{0x35, 'a', 'b', 'c', 3, 0, 0x20, 'X', 'Y'}
+	got, err := decodeLZ4Block(block, make([]byte, 0, 64))
+	if err != nil || string(got) != "abcabcabcabcXY" {
+		t.Fatalf("decoded %q, %v", got, err)
+	}
+	if _, err := decodeLZ4Block(block, make([]byte, 0, 8)); err == nil {
+		t.Error("expected error when the output exceeds its limit")
+	}
+	if _, err := decodeLZ4Block([]byte{0x35, 'a', 'b', 'c', 9, 0}, make([]byte, 0, 64)); err == nil {
+		t.Error("expected error for an offset before the output")
+	}
+}
+
+// lz4Literals encodes data as a single literal-only LZ4 block with
+// systemd's size prefix.
+func lz4Literals(data []byte) []byte {
+	out := binary.LittleEndian.AppendUint64(nil, uint64(len(data)))
+	n := len(data)
+	if n < 15 {
+		out = append(out, byte(n<<4))
+	} else {
+		out = append(out, 0xf0)
+		for n -= 15; n >= 255; n -= 255 {
+			out = append(out, 255)
+		}
+		out = append(out, byte(n))
+	}
+	return append(out, data...)
+}
+
+func testJournalEntries(tj *testJournal) {
+	base := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	tj.addEntry(1, base, "_HOSTNAME=web01", "_SYSTEMD_UNIT=sshd.service", "PRIORITY=6", "_PID=812", "MESSAGE=Accepted publickey")
+	tj.addEntry(2, base.Add(time.Second), "_HOSTNAME=web01", "_SYSTEMD_UNIT=cron.service", "PRIORITY=6", "MESSAGE=job started")
+	tj.addEntry(3, base.Add(2*time.Second), "_HOSTNAME=web01", "_SYSTEMD_UNIT=sshd.service", "PRIORITY=3", "_PID=813", "MESSAGE=Failed password")
+	tj.addEntry(4, base.Add(3*time.Second), "_HOSTNAME=web01", "_SYSTEMD_UNIT=sshd.service", "PRIORITY=6", "MESSAGE="+strings.Repeat("long ", 40))
+}
+
+func TestJournalFile(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		compact, keyed bool
+	}{
+		{"regular", false, false},
+		{"compact keyed", true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tj := newTestJournal(tc.compact, tc.keyed)
+			testJournalEntries(tj)
+			data := tj.bytes()
+			j, err := NewJournalFile(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if j.Header.Entries != 4 || j.Header.Compact() != tc.compact || j.Header.KeyedHash() != tc.keyed {
+				t.Fatalf("header %+v", j.Header)
+			}
+
+			var msgs []string
+			it := j.Entries()
+			for {
+				e, err := it.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				msgs = append(msgs, e["MESSAGE"])
+			}
+			if len(msgs) != 4 || msgs[0] != "Accepted publickey" || msgs[3] != strings.Repeat("long ", 40) {
+				t.Fatalf("messages %q", msgs)
+			}
+
+			it, err = j.Match("_SYSTEMD_UNIT=sshd.service")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var seqnums []string
+			for {
+				e, err := it.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				seqnums = append(seqnums, e["__SEQNUM"])
+			}
+			if strings.Join(seqnums, ",") != "1,3,4" {
+				t.Errorf("sshd entries %q, want 1,3,4", seqnums)
+			}
+			if it, _ := j.Match("_SYSTEMD_UNIT=none.service"); it != nil {
+				if _, err := it.Next(); err != io.EOF {
+					t.Errorf("missing match returned %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestJournalFileEvent(t *testing.T) {
+	tj := newTestJournal(false, true)
+	testJournalEntries(tj)
+	path := filepath.Join(t.TempDir(), "system.journal")
+	if err := os.WriteFile(path, tj.bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	j, err := OpenJournalFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	it := j.Entries()
+	it.Next()
+	it.Next()
+	e, err := it.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := e.Event()
+	if ev.Message != "Failed password" || ev.Level != LevelError || ev.ProcessID != 813 || ev.Computer != "web01" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	if want := time.Date(2024, 3, 10, 12, 0, 2, 0, time.UTC); !ev.TimeCreated.Equal(want) {
+		t.Errorf("time = %v, want %v", ev.TimeCreated, want)
+	}
+	wantCursor := "s=" + hex.EncodeToString(tj.seqnumID[:]) + ";i=3;b=" + hex.EncodeToString(tj.bootID[:])
+	if !strings.HasPrefix(e.Cursor(), wantCursor) {
+		t.Errorf("cursor = %q, want prefix %q", e.Cursor(), wantCursor)
+	}
+	if boot, _ := ev.Field("_BOOT_ID"); boot != hex.EncodeToString(tj.bootID[:]) {
+		t.Errorf("boot id = %q", boot)
+	}
+}
+
+func TestJournalFileCompressed(t *testing.T) {
+	tj := newTestJournal(true, true)
+	i := 0
+	tj.compress = func(p []byte) ([]byte, JournalCompression) {
+		i++
+		if i%2 == 0 {
+			return lz4Literals(p), JournalCompressionLZ4
+		}
+		return append([]byte("xz:"), p...), JournalCompressionXZ
+	}
+	// XZ payloads go through a registered decompressor.
+	RegisterJournalDecompressor(JournalCompressionXZ, func(p []byte, limit int) ([]byte, error) {
+		return bytes.TrimPrefix(p, []byte("xz:")), nil
+	})
+	defer func() {
+		journalDecompressorsMu.Lock()
+		delete(journalDecompressors, JournalCompressionXZ)
+		journalDecompressorsMu.Unlock()
+	}()
+	testJournalEntries(tj)
+	data := tj.bytes()
+	j, err := NewJournalFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := j.Match("PRIORITY=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := it.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e["MESSAGE"] != "Failed password" || e["_PID"] != "813" {
+		t.Errorf("unexpected entry %v", e)
+	}
+}
+
+func TestJournalFileUnsupportedCompression(t *testing.T) {
+	tj := newTestJournal(false, false)
+	tj.compress = func(p []byte) ([]byte, JournalCompression) { return p, JournalCompressionZSTD }
+	testJournalEntries(tj)
+	data := tj.bytes()
+	j, err := NewJournalFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	journalDecompressorsMu.RLock()
+	_, registered := journalDecompressors[JournalCompressionZSTD]
+	journalDecompressorsMu.RUnlock()
+	if registered {
+		t.Skip("zstd decompressor registered")
+	}
+	if _, err := j.Entries().Next(); err == nil || !strings.Contains(err.Error(), "zstd") {
+		t.Errorf("error = %v, want unsupported zstd", err)
+	}
+}
+
+func TestJournalFileCorrupt(t *testing.T) {
+	if _, err := NewJournalFile(bytes.NewReader([]byte("not a journal")), 13); err == nil {
+		t.Error("expected signature error")
+	}
+	tj := newTestJournal(false, false)
+	testJournalEntries(tj)
+	data := tj.bytes()
+	// Point the entry array at the header.
+	binary.LittleEndian.PutUint64(data[176:], 8)
+	j, err := NewJournalFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Entries().Next(); err == nil {
+		t.Error("expected error for a corrupt entry array offset")
+	}
+}
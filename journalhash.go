@@ -0,0 +1,135 @@
+package eventwatcher
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Hash functions of the journal file format, needed to look up data
+// objects in the data hash table. Files with the keyed-hash flag use
+// SipHash-2-4 keyed with the file ID; older files use Bob Jenkins'
+// lookup3 hashlittle2.
+
+// jenkinsHash64 returns the 64 bit lookup3 hash systemd uses for journal
+// files without a keyed hash.
+func jenkinsHash64(data []byte) uint64 {
+	c, b := hashlittle2(data, 0, 0)
+	return uint64(c)<<32 | uint64(b)
+}
+
+// hashlittle2 is lookup3's hashlittle2 for byte input. pc and pb seed the
+// hash and it returns the primary (c) and secondary (b) results.
+func hashlittle2(k []byte, pc, pb uint32) (uint32, uint32) {
+	a := 0xdeadbeef + uint32(len(k)) + pc
+	b, c := a, a+pb
+
+	for len(k) > 12 {
+		a += binary.LittleEndian.Uint32(k[0:])
+		b += binary.LittleEndian.Uint32(k[4:])
+		c += binary.LittleEndian.Uint32(k[8:])
+		a, b, c = lookup3Mix(a, b, c)
+		k = k[12:]
+	}
+	if len(k) == 0 {
+		return c, b
+	}
+
+	var tail [12]byte
+	copy(tail[:], k)
+	a += binary.LittleEndian.Uint32(tail[0:])
+	b += binary.LittleEndian.Uint32(tail[4:])
+	c += binary.LittleEndian.Uint32(tail[8:])
+	a, b, c = lookup3Final(a, b, c)
+	return c, b
+}
+
+func lookup3Mix(a, b, c uint32) (uint32, uint32, uint32) {
+	a -= c
+	a ^= bits.RotateLeft32(c, 4)
+	c += b
+	b -= a
+	b ^= bits.RotateLeft32(a, 6)
+	a += c
+	c -= b
+	c ^= bits.RotateLeft32(b, 8)
+	b += a
+	a -= c
+	a ^= bits.RotateLeft32(c, 16)
+	c += b
+	b -= a
+	b ^= bits.RotateLeft32(a, 19)
+	a += c
+	c -= b
+	c ^= bits.RotateLeft32(b, 4)
+	b += a
+	return a, b, c
+}
+
+func lookup3Final(a, b, c uint32) (uint32, uint32, uint32) {
+	c ^= b
+	c -= bits.RotateLeft32(b, 14)
+	a ^= c
+	a -= bits.RotateLeft32(c, 11)
+	b ^= a
+	b -= bits.RotateLeft32(a, 25)
+	c ^= b
+	c -= bits.RotateLeft32(b, 16)
+	a ^= c
+	a -= bits.RotateLeft32(c, 4)
+	b ^= a
+	b -= bits.RotateLeft32(a, 14)
+	c ^= b
+	c -= bits.RotateLeft32(b, 24)
+	return a, b, c
+}
+
+// siphash24 returns the SipHash-2-4 of data under the 16 byte key.
+func siphash24(data []byte, key [16]byte) uint64 {
+	k0 := binary.LittleEndian.Uint64(key[0:])
+	k1 := binary.LittleEndian.Uint64(key[8:])
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	n := len(data)
+	for ; len(data) >= 8; data = data[8:] {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+	var tail [8]byte
+	copy(tail[:], data)
+	tail[7] = byte(n)
+	m := binary.LittleEndian.Uint64(tail[:])
+	v3 ^= m
+	round()
+	round()
+	v0 ^= m
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
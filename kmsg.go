@@ -0,0 +1,298 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultKmsgPath is the kernel log device.
+const DefaultKmsgPath = "/dev/kmsg"
+
+// kmsgRecordSize is the read buffer for /dev/kmsg, which returns one record
+// per read and fails reads into smaller buffers.
+const kmsgRecordSize = 8192
+
+var errKmsgRecord = errors.New("kmsg: malformed record")
+
+// KmsgRecord is a record of the kernel ring buffer as exported by
+// /dev/kmsg: "PRI,SEQ,TIMESTAMP,FLAGS[,...];MESSAGE" followed by
+// " KEY=VALUE" continuation lines.
+type KmsgRecord struct {
+	Facility int
+	Severity int
+	Seq      uint64
+	// Monotonic is the time since boot at which the record was logged.
+	Monotonic time.Duration
+	// Continuation is set for the "c" flag of records continuing a line.
+	Continuation bool
+	Message      string
+	// Dict holds the continuation key/value pairs, e.g. SUBSYSTEM and
+	// DEVICE.
+	Dict []EventData
+}
+
+// ParseKmsgRecord parses one record. Message bytes escaped as \xNN by the
+// kernel are unescaped.
+func ParseKmsgRecord(rec []byte) (*KmsgRecord, error) {
+	rec = bytes.TrimRight(rec, "\n")
+	lines := strings.Split(string(rec), "\n")
+	semi := strings.IndexByte(lines[0], ';')
+	if semi < 0 {
+		return nil, errKmsgRecord
+	}
+	prefix := strings.Split(lines[0][:semi], ",")
+	if len(prefix) < 3 {
+		return nil, errKmsgRecord
+	}
+	pri, err := strconv.Atoi(prefix[0])
+	if err != nil || pri < 0 {
+		return nil, errKmsgRecord
+	}
+	seq, err := strconv.ParseUint(prefix[1], 10, 64)
+	if err != nil {
+		return nil, errKmsgRecord
+	}
+	usec, err := strconv.ParseInt(prefix[2], 10, 64)
+	if err != nil {
+		return nil, errKmsgRecord
+	}
+	r := &KmsgRecord{
+		Facility:  pri >> 3,
+		Severity:  pri & 7,
+		Seq:       seq,
+		Monotonic: time.Duration(usec) * time.Microsecond,
+		Message:   unescapeKmsg(lines[0][semi+1:]),
+	}
+	if len(prefix) > 3 {
+		r.Continuation = prefix[3] == "c"
+	}
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(line, " ") {
+			return nil, errKmsgRecord
+		}
+		kv := unescapeKmsg(line[1:])
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			r.Dict = append(r.Dict, EventData{Name: kv[:i], Value: kv[i+1:]})
+		}
+	}
+	return r, nil
+}
+
+// unescapeKmsg undoes the \xNN escaping of non-printable bytes.
+func unescapeKmsg(s string) string {
+	if !strings.Contains(s, `\x`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if v, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Event converts r to an Event from the "kernel" provider, logged at
+// boot plus the record's monotonic timestamp. The facility and the
+// continuation dictionary are kept as data items.
+func (r *KmsgRecord) Event(boot time.Time) *Event {
+	ev := &Event{
+		Provider:    "kernel",
+		Level:       LevelFromSyslog(r.Severity),
+		TimeCreated: boot.Add(r.Monotonic),
+		RecordID:    r.Seq,
+		Message:     r.Message,
+	}
+	ev.SetField("facility", SyslogFacilityName(r.Facility))
+	for _, d := range r.Dict {
+		ev.SetField(d.Name, d.Value)
+	}
+	return ev
+}
+
+// KmsgConfig configures a KmsgSource.
+type KmsgConfig struct {
+	// Path defaults to DefaultKmsgPath. Any regular file holding records in
+	// the /dev/kmsg format can be read instead; the source then stops at
+	// its end.
+	Path string
+	// FromStart makes the source emit the records already in the kernel
+	// buffer; by default only new ones are. Regular files are always read
+	// from the start.
+	FromStart bool
+	// BootTime converts monotonic timestamps to wall time. It defaults to
+	// the boot time of the running system.
+	BootTime time.Time
+}
+
+// KmsgSource is a Source reading the kernel ring buffer. When sequence
+// numbers skip, because the ring buffer was overwritten before it was read,
+// it emits a warning event from the "kmsg" provider whose "lost" data item
+// holds the number of missing records.
+type KmsgSource struct {
+	cfg    KmsgConfig
+	file   *os.File
+	device bool
+	boot   time.Time
+
+	closeOnce sync.Once
+}
+
+// NewKmsgSource creates a KmsgSource.
+func NewKmsgSource(cfg KmsgConfig) *KmsgSource {
+	if cfg.Path == "" {
+		cfg.Path = DefaultKmsgPath
+	}
+	return &KmsgSource{cfg: cfg}
+}
+
+// Init opens the device or file.
+func (s *KmsgSource) Init() error {
+	f, err := os.Open(s.cfg.Path)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.device = fi.Mode()&os.ModeCharDevice != 0
+	if s.device && !s.cfg.FromStart {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	s.boot = s.cfg.BootTime
+	if s.boot.IsZero() {
+		if s.boot, err = bootTime(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	s.file = f
+	return nil
+}
+
+// Listen emits records until ctx is done, Close is called or, for regular
+// files, the end of the file is reached.
+func (s *KmsgSource) Listen(ctx context.Context, emit EmitFunc) {
+	defer s.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-stop:
+		}
+	}()
+
+	next := s.fileRecords()
+	if s.device {
+		next = s.deviceRecords()
+	}
+	var lastSeq uint64
+	var seen bool
+	for {
+		rec, err := next()
+		if err != nil {
+			return
+		}
+		r, err := ParseKmsgRecord(rec)
+		if err != nil {
+			continue
+		}
+		if seen && r.Seq > lastSeq+1 {
+			lost := s.gapEvent(lastSeq, r)
+			if emit(ctx, &EventEntry{Buffer: []byte(lost.Message), Event: lost}) != nil {
+				return
+			}
+		}
+		if !seen || r.Seq > lastSeq {
+			lastSeq, seen = r.Seq, true
+		}
+		if emit(ctx, &EventEntry{Buffer: rec, Event: r.Event(s.boot)}) != nil {
+			return
+		}
+	}
+}
+
+// gapEvent describes the records missing between last and r.
+func (s *KmsgSource) gapEvent(last uint64, r *KmsgRecord) *Event {
+	lost := r.Seq - last - 1
+	ev := &Event{
+		Provider:    "kmsg",
+		Level:       LevelWarning,
+		TimeCreated: s.boot.Add(r.Monotonic),
+		Message:     fmt.Sprintf("%d kernel messages lost before sequence number %d", lost, r.Seq),
+	}
+	ev.SetField("lost", strconv.FormatUint(lost, 10))
+	ev.SetField("first_lost", strconv.FormatUint(last+1, 10))
+	return ev
+}
+
+// deviceRecords returns one record per read. Reads fail with EPIPE when
+// unread records were overwritten; reading then continues with the oldest
+// record still available.
+func (s *KmsgSource) deviceRecords() func() ([]byte, error) {
+	buf := make([]byte, kmsgRecordSize)
+	return func() ([]byte, error) {
+		for {
+			n, err := s.file.Read(buf)
+			if errors.Is(err, syscall.EPIPE) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			rec := make([]byte, n)
+			copy(rec, buf[:n])
+			return rec, nil
+		}
+	}
+}
+
+// fileRecords groups the lines of a regular file into records.
+func (s *KmsgSource) fileRecords() func() ([]byte, error) {
+	r := bufio.NewReader(s.file)
+	return func() ([]byte, error) {
+		rec, err := r.ReadBytes('\n')
+		if len(rec) == 0 {
+			return nil, err
+		}
+		for {
+			b, err := r.Peek(1)
+			if err != nil || b[0] != ' ' {
+				return rec, nil
+			}
+			line, _ := r.ReadBytes('\n')
+			rec = append(rec, line...)
+		}
+	}
+}
+
+// Close closes the device or file.
+func (s *KmsgSource) Close() {
+	s.closeOnce.Do(func() {
+		if s.file != nil {
+			s.file.Close()
+		}
+	})
+}
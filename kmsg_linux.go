@@ -0,0 +1,20 @@
+//go:build linux
+// +build linux
+
+package eventwatcher
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// bootTime returns the wall time of boot on the clock used by /dev/kmsg
+// timestamps, CLOCK_MONOTONIC.
+func bootTime() (time.Time, error) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(-time.Duration(ts.Nano())), nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package eventwatcher
+
+import (
+	"errors"
+	"time"
+)
+
+// bootTime is only known on Linux; elsewhere KmsgConfig.BootTime must be
+// set.
+func bootTime() (time.Time, error) {
+	return time.Time{}, errors.New("kmsg: boot time unknown, set KmsgConfig.BootTime")
+}
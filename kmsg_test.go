@@ -0,0 +1,95 @@
+package eventwatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseKmsgRecord(t *testing.T) {
+	rec := "6,339,5140900,-;NET: Registered \\x22PF_INET6\\x22\n SUBSYSTEM=net\n DEVICE=+net:eth0\n"
+	r, err := ParseKmsgRecord([]byte(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Facility != 0 || r.Severity != 6 || r.Seq != 339 {
+		t.Fatalf("prefix: %+v", r)
+	}
+	if r.Monotonic != 5140900*time.Microsecond {
+		t.Fatalf("monotonic: %v", r.Monotonic)
+	}
+	if r.Message != `NET: Registered "PF_INET6"` {
+		t.Fatalf("message: %q", r.Message)
+	}
+	if len(r.Dict) != 2 || r.Dict[0] != (EventData{Name: "SUBSYSTEM", Value: "net"}) || r.Dict[1].Value != "+net:eth0" {
+		t.Fatalf("dict: %+v", r.Dict)
+	}
+
+	r, err = ParseKmsgRecord([]byte("12,340,5141000,c,caller=T1;trailing\\x5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Continuation || r.Facility != 1 || r.Severity != 4 || r.Message != `trailing\x5` {
+		t.Fatalf("continuation: %+v", r)
+	}
+
+	for _, bad := range []string{"", "no prefix", "6,1;short", "x,1,2,-;pri", "6,1,2,-;msg\nKEY=noindent"} {
+		if _, err := ParseKmsgRecord([]byte(bad)); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestKmsgSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kmsg")
+	data := "6,10,1000000,-;first\n SUBSYSTEM=usb\n" +
+		"garbage\n" +
+		"3,11,2000000,-;second\n" +
+		"4,15,2500000,-;after gap\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	boot := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("kmsg", NewKmsgSource(KmsgConfig{Path: path, BootTime: boot})); err != nil {
+		t.Fatal(err)
+	}
+
+	e := waitSyslogEntry(t, n)
+	if e.Name != "kmsg" || e.Event.Message != "first" || e.Event.RecordID != 10 {
+		t.Fatalf("first: %+v", e.Event)
+	}
+	if !e.Event.TimeCreated.Equal(boot.Add(time.Second)) {
+		t.Fatalf("time: %v", e.Event.TimeCreated)
+	}
+	if v, _ := e.Event.Field("SUBSYSTEM"); v != "usb" {
+		t.Fatalf("SUBSYSTEM: %q", v)
+	}
+	if e.Event.Level != LevelInfo {
+		t.Fatalf("level: %v", e.Event.Level)
+	}
+
+	e = waitSyslogEntry(t, n)
+	if e.Event.Message != "second" || e.Event.Level != LevelError {
+		t.Fatalf("second: %+v", e.Event)
+	}
+
+	e = waitSyslogEntry(t, n)
+	if e.Event.Provider != "kmsg" || e.Event.Level != LevelWarning {
+		t.Fatalf("gap: %+v", e.Event)
+	}
+	if v, _ := e.Event.Field("lost"); v != "3" {
+		t.Fatalf("lost: %q", v)
+	}
+	if v, _ := e.Event.Field("first_lost"); v != "12" {
+		t.Fatalf("first_lost: %q", v)
+	}
+
+	e = waitSyslogEntry(t, n)
+	if e.Event.Message != "after gap" || e.Event.RecordID != 15 {
+		t.Fatalf("after gap: %+v", e.Event)
+	}
+}
@@ -0,0 +1,281 @@
+package eventwatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Level is a normalized event severity. Levels are ordered from least to
+// most severe, so "warning and above" is simply l >= LevelWarning no matter
+// whether the event came from the Windows event log, syslog or a text log.
+type Level uint8
+
+const (
+	LevelUnknown Level = iota
+	LevelTrace
+	LevelDebug
+	LevelInfo
+	LevelNotice
+	LevelWarning
+	LevelError
+	LevelCritical
+	LevelAlert
+	LevelEmergency
+)
+
+var levelNames = [...]string{
+	LevelUnknown:   "unknown",
+	LevelTrace:     "trace",
+	LevelDebug:     "debug",
+	LevelInfo:      "info",
+	LevelNotice:    "notice",
+	LevelWarning:   "warning",
+	LevelError:     "error",
+	LevelCritical:  "critical",
+	LevelAlert:     "alert",
+	LevelEmergency: "emergency",
+}
+
+// levelKeywords maps the spellings found in configuration and text logs to
+// levels. Keys are lower case.
+var levelKeywords = map[string]Level{
+	"trace":         LevelTrace,
+	"trc":           LevelTrace,
+	"verbose":       LevelTrace,
+	"debug":         LevelDebug,
+	"dbg":           LevelDebug,
+	"info":          LevelInfo,
+	"inf":           LevelInfo,
+	"information":   LevelInfo,
+	"informational": LevelInfo,
+	"notice":        LevelNotice,
+	"warn":          LevelWarning,
+	"wrn":           LevelWarning,
+	"warning":       LevelWarning,
+	"err":           LevelError,
+	"error":         LevelError,
+	"crit":          LevelCritical,
+	"critical":      LevelCritical,
+	"fatal":         LevelCritical,
+	"alert":         LevelAlert,
+	"emerg":         LevelEmergency,
+	"emergency":     LevelEmergency,
+	"panic":         LevelEmergency,
+}
+
+// String returns the lower-case name of the level.
+func (l Level) String() string {
+	if int(l) < len(levelNames) {
+		return levelNames[l]
+	}
+	return fmt.Sprintf("Level(%d)", uint8(l))
+}
+
+// ParseLevel parses a level name such as "warn", "ERROR" or "emerg", or the
+// "Level(n)" form String uses for levels without a name.
+func ParseLevel(s string) (Level, error) {
+	key := strings.ToLower(strings.TrimSpace(s))
+	if key == levelNames[LevelUnknown] {
+		return LevelUnknown, nil
+	}
+	if l, ok := levelKeywords[key]; ok {
+		return l, nil
+	}
+	if strings.HasPrefix(key, "level(") && strings.HasSuffix(key, ")") {
+		if n, err := strconv.ParseUint(key[len("level("):len(key)-1], 10, 8); err == nil {
+			return Level(n), nil
+		}
+	}
+	return LevelUnknown, fmt.Errorf("unknown level %q", s)
+}
+
+// MarshalJSON encodes the level as its name.
+func (l Level) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.String())
+}
+
+// UnmarshalJSON accepts a level name.
+func (l *Level) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	*l = v
+	return nil
+}
+
+// LevelFromEventType maps an EVENTLOG_*_TYPE value to a Level. Failed audits
+// are treated as warnings so they pass "warning and above" filters.
+func LevelFromEventType(t uint16) Level {
+	switch t {
+	case EVENTLOG_ERROR_TYPE:
+		return LevelError
+	case EVENTLOG_WARNING_TYPE, EVENTLOG_AUDIT_FAILURE:
+		return LevelWarning
+	default:
+		return LevelInfo
+	}
+}
+
+// LevelFromSyslog maps a syslog severity (0 emergency .. 7 debug) to a Level.
+func LevelFromSyslog(severity int) Level {
+	if severity < 0 || severity > 7 {
+		return LevelUnknown
+	}
+	return LevelEmergency - Level(severity)
+}
+
+// SyslogSeverity returns the syslog severity of l. Trace and unknown levels
+// are reported as debug and informational respectively.
+func (l Level) SyslogSeverity() int {
+	switch {
+	case l == LevelUnknown:
+		return 6
+	case l <= LevelDebug:
+		return 7
+	case l > LevelEmergency:
+		return 0
+	}
+	return int(LevelEmergency - l)
+}
+
+// LevelFromWindows maps the Level of Windows Event XML (1 critical ..
+// 5 verbose) to a Level. Level 0 (LogAlways) has no severity.
+func LevelFromWindows(level uint8) Level {
+	switch level {
+	case 1:
+		return LevelCritical
+	case 2:
+		return LevelError
+	case 3:
+		return LevelWarning
+	case 4:
+		return LevelInfo
+	case 5:
+		return LevelTrace
+	}
+	return LevelUnknown
+}
+
+// WindowsLevel returns the Windows Event XML level of l.
+func (l Level) WindowsLevel() uint8 {
+	switch {
+	case l == LevelUnknown:
+		return 0
+	case l <= LevelDebug:
+		return 5
+	case l <= LevelNotice:
+		return 4
+	case l == LevelWarning:
+		return 3
+	case l == LevelError:
+		return 2
+	}
+	return 1
+}
+
+// DetectLevel looks for a level in a line of a text log, e.g. "[ERROR]",
+// "level=warn" or "W0102 ...", and returns LevelUnknown when none is found.
+// level=, lvl= and severity= pairs are recognized anywhere. A bare keyword
+// only counts where loggers write the level: as the first word, after a
+// timestamp, thread or syslog "host tag:" prefix, or in brackets, so that
+// "connection closed, no error" is not an error.
+func DetectLevel(line string) Level {
+	fields := strings.Fields(line)
+	for _, f := range fields {
+		j := strings.IndexByte(f, '=')
+		if j < 0 {
+			continue
+		}
+		switch strings.ToLower(strings.Trim(f[:j], levelPunct)) {
+		case "level", "lvl", "severity":
+			if l := levelWord(strings.Trim(f[j+1:], levelPunct)); l != LevelUnknown {
+				return l
+			}
+		}
+	}
+	// words counts the words after the prefix; positions that may hold
+	// the level are the first one and the one after a "tag:" among the
+	// first two.
+	words, next := 0, true
+	for i, f := range fields {
+		if i >= 8 {
+			break
+		}
+		word := strings.Trim(f, levelPunct)
+		t := strings.TrimRight(f, ":")
+		bracketed := word != "" && strings.IndexByte("[(<", t[0]) >= 0 && strings.IndexByte("])>", t[len(t)-1]) >= 0
+		if next || bracketed {
+			if l := levelWord(word); l != LevelUnknown {
+				return l
+			}
+		}
+		if word == "" || bracketed || (words == 0 && isTimestampWord(word)) {
+			continue
+		}
+		words++
+		next = words < 3 && strings.HasSuffix(f, ":")
+	}
+	return LevelUnknown
+}
+
+// levelPunct is trimmed from the words DetectLevel looks at.
+const levelPunct = "[]()<>|,;:-\"'"
+
+// levelWord returns the level named by a single word, a keyword or a
+// glog/klog prefix: a severity letter followed by MMDD.
+func levelWord(w string) Level {
+	if l, ok := levelKeywords[strings.ToLower(w)]; ok {
+		return l
+	}
+	if len(w) == 5 && isDigits(w[1:]) {
+		switch w[0] {
+		case 'I':
+			return LevelInfo
+		case 'W':
+			return LevelWarning
+		case 'E':
+			return LevelError
+		case 'F':
+			return LevelCritical
+		}
+	}
+	return LevelUnknown
+}
+
+// isTimestampWord reports whether w may be part of a timestamp prefix:
+// a month or weekday name, or digits with date and time separators.
+func isTimestampWord(w string) bool {
+	switch w {
+	case "Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec",
+		"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun":
+		return true
+	}
+	if w[0] < '0' || w[0] > '9' {
+		return false
+	}
+	for i := 0; i < len(w); i++ {
+		if (w[i] < '0' || w[i] > '9') && strings.IndexByte("-:./,TZ+", w[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package eventwatcher
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLevelOrdering(t *testing.T) {
+	levels := []Level{
+		LevelFromEventType(EVENTLOG_INFORMATION_TYPE),
+		LevelFromSyslog(5),
+		LevelFromEventType(EVENTLOG_WARNING_TYPE),
+		LevelFromWindows(2),
+		LevelFromSyslog(2),
+		LevelFromSyslog(0),
+	}
+	for i := 1; i < len(levels); i++ {
+		if levels[i] <= levels[i-1] {
+			t.Fatalf("levels not ordered: %v", levels)
+		}
+	}
+
+	min := LevelWarning
+	if LevelFromEventType(EVENTLOG_AUDIT_FAILURE) < min {
+		t.Error("failed audits should pass a warning filter")
+	}
+	if LevelFromSyslog(6) >= min || DetectLevel("INFO started") >= min {
+		t.Error("informational events should not pass a warning filter")
+	}
+}
+
+func TestLevelSyslogRoundTrip(t *testing.T) {
+	for sev := 0; sev <= 7; sev++ {
+		if got := LevelFromSyslog(sev).SyslogSeverity(); got != sev {
+			t.Errorf("severity %d round-tripped to %d", sev, got)
+		}
+	}
+	if LevelFromSyslog(8) != LevelUnknown {
+		t.Error("out-of-range severity should be unknown")
+	}
+}
+
+func TestLevelWindowsRoundTrip(t *testing.T) {
+	for lvl := uint8(1); lvl <= 5; lvl++ {
+		if got := LevelFromWindows(lvl).WindowsLevel(); got != lvl {
+			t.Errorf("windows level %d round-tripped to %d", lvl, got)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := map[string]Level{
+		"WARN":          LevelWarning,
+		"warning":       LevelWarning,
+		" err ":         LevelError,
+		"emerg":         LevelEmergency,
+		"Critical":      LevelCritical,
+		"verbose":       LevelTrace,
+		"unknown":       LevelUnknown,
+		"notice":        LevelNotice,
+		"Informational": LevelInfo,
+	}
+	for in, want := range tests {
+		got, err := ParseLevel(in)
+		if err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+func TestLevelJSON(t *testing.T) {
+	b, err := json.Marshal(struct {
+		Level Level `json:"level"`
+	}{LevelCritical})
+	if err != nil || string(b) != `{"level":"critical"}` {
+		t.Fatalf("Marshal = %s, %v", b, err)
+	}
+	var v struct {
+		Level Level `json:"level"`
+	}
+	if err := json.Unmarshal([]byte(`{"level":"warn"}`), &v); err != nil || v.Level != LevelWarning {
+		t.Fatalf("Unmarshal = %v, %v", v.Level, err)
+	}
+	if err := json.Unmarshal([]byte(`{"level":"bogus"}`), &v); err == nil {
+		t.Fatal("expected error for unknown level")
+	}
+	// Levels without a name survive a round trip.
+	b, err = json.Marshal(Level(42))
+	if err != nil || string(b) != `"Level(42)"` {
+		t.Fatalf("Marshal = %s, %v", b, err)
+	}
+	var l Level
+	if err := json.Unmarshal(b, &l); err != nil || l != 42 {
+		t.Fatalf("Unmarshal = %v, %v", l, err)
+	}
+	if _, err := ParseLevel("Level(256)"); err == nil {
+		t.Fatal("expected error for a level out of range")
+	}
+}
+
+func TestDetectLevel(t *testing.T) {
+	tests := map[string]Level{
+		"2024-01-02 10:00:00 [ERROR] disk full":          LevelError,
+		"time=2024-01-02T10:00:00Z level=warn msg=slow":  LevelWarning,
+		"W0102 10:00:00.000000    1 controller.go:42] x": LevelWarning,
+		"Jan  2 10:00:00 host app: DEBUG: value=3":       LevelDebug,
+		"plain message without a level":                  LevelUnknown,
+
+		"ERROR: disk full":                                           LevelError,
+		"kernel: panic: attempted to kill init":                      LevelEmergency,
+		"2024-01-02 10:00:00,123 [main] WARN com.example.App - slow": LevelWarning,
+		"[2024-01-02 10:00:00] <debug> cache miss":                   LevelDebug,
+		`ts=2024-01-02T10:00:00Z msg="request done" severity="err"`:  LevelError,
+
+		// Keywords in the message itself are not levels.
+		"connection closed, no error":                          LevelUnknown,
+		"kernel: not a panic":                                  LevelUnknown,
+		"2024-01-02 10:00:00 retrying after fatal timeout":     LevelUnknown,
+		"Jan  2 10:00:00 host app: user dismissed the warning": LevelUnknown,
+	}
+	for line, want := range tests {
+		if got := DetectLevel(line); got != want {
+			t.Errorf("DetectLevel(%q) = %v, want %v", line, got, want)
+		}
+	}
+}
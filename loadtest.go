@@ -0,0 +1,159 @@
+package eventwatcher
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// loadTestSamples bounds the number of latencies kept for percentiles.
+const loadTestSamples = 100000
+
+// LoadTestConfig configures RunLoadTest.
+type LoadTestConfig struct {
+	// Generator configures each of Sources generator sources, default 1.
+	Generator GeneratorConfig
+	Sources   int
+	// Duration bounds the test, default 10s. The test ends earlier when
+	// every generator reached its Count.
+	Duration time.Duration
+	// Consume, when set, is called with every entry received, to account
+	// for the work of a real consumer.
+	Consume func(*EventEntry)
+	// SampleInterval is how often memory is sampled, default 100ms.
+	SampleInterval time.Duration
+}
+
+// LoadReport is the outcome of RunLoadTest. Latency is the time from the
+// creation of an event to its receipt from EventLogChannel.
+type LoadReport struct {
+	Events     int
+	Bytes      int64
+	Duration   time.Duration
+	Throughput float64 // events per second
+
+	LatencyP50, LatencyP90, LatencyP99, LatencyMax time.Duration
+
+	// HeapPeak is the largest heap size sampled and HeapEnd the heap size
+	// after a final collection.
+	HeapPeak, HeapEnd uint64
+	// Allocs and AllocBytes are the allocations made during the test and
+	// GCs the number of collections.
+	Allocs, AllocBytes uint64
+	GCs                uint32
+	// Goroutines is the number of goroutines at the end of the test,
+	// before the notifier is closed.
+	Goroutines int
+}
+
+// String formats the report on one line.
+func (r *LoadReport) String() string {
+	return fmt.Sprintf("events=%d bytes=%d duration=%v throughput=%.0f/s latency p50=%v p90=%v p99=%v max=%v heap peak=%d end=%d allocs=%d alloc_bytes=%d gcs=%d goroutines=%d",
+		r.Events, r.Bytes, r.Duration.Round(time.Millisecond), r.Throughput,
+		r.LatencyP50, r.LatencyP90, r.LatencyP99, r.LatencyMax,
+		r.HeapPeak, r.HeapEnd, r.Allocs, r.AllocBytes, r.GCs, r.Goroutines)
+}
+
+// RunLoadTest runs generator sources in an EventNotifier, consumes its
+// EventLogChannel and reports throughput, latency percentiles and memory
+// use, to size deployments and catch regressions.
+func RunLoadTest(ctx context.Context, cfg LoadTestConfig) (*LoadReport, error) {
+	if cfg.Sources <= 0 {
+		cfg.Sources = 1
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = 10 * time.Second
+	}
+	if cfg.SampleInterval <= 0 {
+		cfg.SampleInterval = 100 * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	var before runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	n := NewEventNotifier(ctx)
+	defer n.Close()
+	for i := 0; i < cfg.Sources; i++ {
+		gen := cfg.Generator
+		if gen.Seed != 0 {
+			gen.Seed += int64(i)
+		}
+		if err := n.AddSource("generator-"+strconv.Itoa(i), NewGeneratorSource(gen)); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &LoadReport{}
+	want := cfg.Generator.Count * cfg.Sources
+	samples := make([]time.Duration, 0, 1024)
+	rnd := rand.New(rand.NewSource(1))
+	ticker := time.NewTicker(cfg.SampleInterval)
+	defer ticker.Stop()
+	var ms runtime.MemStats
+	start := time.Now()
+loop:
+	for want == 0 || r.Events < want {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			runtime.ReadMemStats(&ms)
+			if ms.HeapAlloc > r.HeapPeak {
+				r.HeapPeak = ms.HeapAlloc
+			}
+		case e := <-n.EventLogChannel:
+			now := time.Now()
+			r.Events++
+			r.Bytes += int64(len(e.Buffer))
+			if e.Event != nil {
+				lat := now.Sub(e.Event.TimeCreated)
+				if lat > r.LatencyMax {
+					r.LatencyMax = lat
+				}
+				// Reservoir sampling keeps a uniform sample of the
+				// latencies in bounded memory.
+				if len(samples) < loadTestSamples {
+					samples = append(samples, lat)
+				} else if j := rnd.Intn(r.Events); j < loadTestSamples {
+					samples[j] = lat
+				}
+			}
+			if cfg.Consume != nil {
+				cfg.Consume(e)
+			}
+		}
+	}
+	r.Duration = time.Since(start)
+	r.Goroutines = runtime.NumGoroutine()
+	if r.Duration > 0 {
+		r.Throughput = float64(r.Events) / r.Duration.Seconds()
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	percentile := func(p float64) time.Duration {
+		if len(samples) == 0 {
+			return 0
+		}
+		return samples[int(p*float64(len(samples)-1))]
+	}
+	r.LatencyP50, r.LatencyP90, r.LatencyP99 = percentile(0.5), percentile(0.9), percentile(0.99)
+
+	runtime.ReadMemStats(&ms)
+	if ms.HeapAlloc > r.HeapPeak {
+		r.HeapPeak = ms.HeapAlloc
+	}
+	r.Allocs = ms.Mallocs - before.Mallocs
+	r.AllocBytes = ms.TotalAlloc - before.TotalAlloc
+	r.GCs = ms.NumGC - before.NumGC
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	r.HeapEnd = ms.HeapAlloc
+	return r, nil
+}
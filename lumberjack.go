@@ -0,0 +1,494 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLumberjackPayloadSize limits the size of a data frame and of
+	// the decompressed content of a compressed frame.
+	DefaultLumberjackPayloadSize = 10 << 20
+	// DefaultLumberjackTimeout is how long a connection may stay silent
+	// before it is closed.
+	DefaultLumberjackTimeout = time.Minute
+	// lumberjackKeepAlive is how often a window whose events are still
+	// being delivered is acknowledged up to the last delivered event, so
+	// that the client does not time out. The same sequence number is
+	// acknowledged again when nothing was delivered meanwhile, such as
+	// while an event waits for a slow EventLogChannel consumer.
+	lumberjackKeepAlive = 5 * time.Second
+)
+
+var (
+	errLumberjackFrame    = errors.New("lumberjack: malformed frame")
+	errLumberjackTooLarge = errors.New("lumberjack: frame too large")
+)
+
+// LumberjackConfig configures a LumberjackSource.
+type LumberjackConfig struct {
+	// Addr is the TCP address to listen on, e.g. ":5044".
+	Addr string
+	// TLS, when set, requires clients to connect with TLS. Set ClientAuth
+	// and ClientCAs to verify client certificates.
+	TLS *tls.Config
+	// MaxPayloadSize defaults to DefaultLumberjackPayloadSize.
+	MaxPayloadSize int
+	// Timeout defaults to DefaultLumberjackTimeout.
+	Timeout time.Duration
+}
+
+// LumberjackSource is a Source receiving events from Beats, such as
+// Filebeat and Winlogbeat, over the Lumberjack protocol (version 2, and
+// the key/value data frames of version 1). The events of a window are
+// acknowledged once they were received from EventLogChannel, so Beats
+// resend them when the collector stops before. Each Beats document is
+// converted with BeatsEvent, and the client address is stored in the
+// "peer" data item.
+type LumberjackSource struct {
+	cfg LumberjackConfig
+	ln  net.Listener
+	// keepAlive is lumberjackKeepAlive, shortened by tests.
+	keepAlive time.Duration
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewLumberjackSource creates a LumberjackSource; its socket is bound by
+// Init.
+func NewLumberjackSource(cfg LumberjackConfig) *LumberjackSource {
+	if cfg.MaxPayloadSize <= 0 {
+		cfg.MaxPayloadSize = DefaultLumberjackPayloadSize
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultLumberjackTimeout
+	}
+	return &LumberjackSource{
+		cfg:       cfg,
+		keepAlive: lumberjackKeepAlive,
+		conns:     make(map[net.Conn]struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Init binds the listener.
+func (s *LumberjackSource) Init() error {
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	if s.cfg.TLS != nil {
+		ln = tls.NewListener(ln, s.cfg.TLS)
+	}
+	s.ln = ln
+	return nil
+}
+
+// Addr returns the bound address.
+func (s *LumberjackSource) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Listen serves connections until ctx is done or Close is called.
+func (s *LumberjackSource) Listen(ctx context.Context, emit EmitFunc) {
+	s.wg.Add(1)
+	go s.accept(ctx, emit)
+	select {
+	case <-ctx.Done():
+	case <-s.done:
+	}
+	s.Close()
+	s.wg.Wait()
+}
+
+// Close closes the listener and all connections; unacknowledged events
+// are resent by the clients.
+func (s *LumberjackSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *LumberjackSource) accept(ctx context.Context, emit EmitFunc) {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return
+		}
+		s.wg.Add(1)
+		go s.serveConn(ctx, conn, emit)
+	}
+}
+
+func (s *LumberjackSource) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *LumberjackSource) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+// lumberjackConn is the state of one client connection.
+type lumberjackConn struct {
+	s    *LumberjackSource
+	conn net.Conn
+	r    *bufio.Reader
+	ctx  context.Context
+	emit EmitFunc
+	peer string
+
+	// mu guards the window state and writes, shared with keepAlive.
+	mu      sync.Mutex
+	version byte
+	// window is the number of events of the current window still to come.
+	window uint32
+	// last is the sequence number of the last delivered event and acked
+	// that of the last acknowledged one.
+	last, acked uint32
+	ackedAt     time.Time
+}
+
+// serveConn reads windows until the connection fails or sends an invalid
+// frame.
+func (s *LumberjackSource) serveConn(ctx context.Context, conn net.Conn, emit EmitFunc) {
+	defer s.wg.Done()
+	defer s.untrack(conn)
+	c := &lumberjackConn{
+		s:    s,
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		ctx:  ctx,
+		emit: emit,
+		peer: conn.RemoteAddr().String(),
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.keepAlive(stop)
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.cfg.Timeout))
+		if err := c.readFrame(c.r, true); err != nil {
+			return
+		}
+	}
+}
+
+// readFrame reads and handles one frame. Window and compressed frames are
+// only allowed at the top level: Beats never nest compressed frames, and
+// nesting them would let a client make the server allocate a decompressor
+// per level.
+func (c *lumberjackConn) readFrame(r io.Reader, top bool) error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	version, typ := hdr[0], hdr[1]
+	if version != '1' && version != '2' {
+		return errLumberjackFrame
+	}
+	switch typ {
+	case 'W':
+		n, err := readUint32(r)
+		if err != nil || !top {
+			return errLumberjackFrame
+		}
+		c.mu.Lock()
+		c.version, c.window, c.last, c.acked = version, n, 0, 0
+		c.ackedAt = time.Now()
+		c.mu.Unlock()
+		return nil
+	case 'C':
+		n, err := readUint32(r)
+		if err != nil {
+			return err
+		}
+		if !top {
+			return errLumberjackFrame
+		}
+		if int(n) > c.s.cfg.MaxPayloadSize {
+			return errLumberjackTooLarge
+		}
+		compressed := io.LimitReader(r, int64(n))
+		// Leave the stream at the next frame whatever the content was.
+		defer io.Copy(io.Discard, compressed)
+		zr, err := zlib.NewReader(compressed)
+		if err != nil {
+			return errLumberjackFrame
+		}
+		defer zr.Close()
+		// The decompressed frames are read through a limit as well, one
+		// byte beyond it to tell a complete stream from a cut one.
+		lr := &io.LimitedReader{R: zr, N: int64(c.s.cfg.MaxPayloadSize) + 1}
+		br := bufio.NewReader(lr)
+		for {
+			if _, err := br.Peek(1); err == io.EOF {
+				break
+			}
+			if err := c.readFrame(br, false); err != nil {
+				if lr.N == 0 {
+					return errLumberjackTooLarge
+				}
+				return err
+			}
+		}
+		return nil
+	case 'J':
+		seq, payload, err := c.readPayload(r)
+		if err != nil {
+			return err
+		}
+		dec := json.NewDecoder(bytes.NewReader(payload))
+		dec.UseNumber()
+		var doc map[string]interface{}
+		if err := dec.Decode(&doc); err != nil {
+			return errLumberjackFrame
+		}
+		return c.deliver(seq, BeatsEvent(doc), payload)
+	case 'D':
+		seq, err := readUint32(r)
+		if err != nil {
+			return err
+		}
+		pairs, err := readUint32(r)
+		if err != nil {
+			return err
+		}
+		doc := make(map[string]interface{})
+		for i := uint32(0); i < pairs; i++ {
+			k, err := c.readString(r)
+			if err != nil {
+				return err
+			}
+			v, err := c.readString(r)
+			if err != nil {
+				return err
+			}
+			doc[k] = v
+		}
+		if line, ok := doc["line"]; ok {
+			doc["message"] = line
+			delete(doc, "line")
+		}
+		ev := BeatsEvent(doc)
+		return c.deliver(seq, ev, []byte(ev.Message))
+	}
+	return errLumberjackFrame
+}
+
+func (c *lumberjackConn) readPayload(r io.Reader) (uint32, []byte, error) {
+	seq, err := readUint32(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := readUint32(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if int(n) > c.s.cfg.MaxPayloadSize {
+		return 0, nil, errLumberjackTooLarge
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return seq, payload, nil
+}
+
+func (c *lumberjackConn) readString(r io.Reader) (string, error) {
+	n, err := readUint32(r)
+	if err != nil {
+		return "", err
+	}
+	if int(n) > c.s.cfg.MaxPayloadSize {
+		return "", errLumberjackTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// deliver emits the event with sequence number seq. The window is
+// acknowledged after its last event, and before that by keepAlive.
+func (c *lumberjackConn) deliver(seq uint32, ev *Event, raw []byte) error {
+	c.mu.Lock()
+	open := c.window > 0
+	c.mu.Unlock()
+	if !open {
+		return errLumberjackFrame
+	}
+	ev.SetField("peer", c.peer)
+	if err := c.emit(c.ctx, &EventEntry{Buffer: raw, Event: ev}); err != nil {
+		return err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(c.s.cfg.Timeout))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = seq
+	c.window--
+	if c.window == 0 {
+		return c.ack()
+	}
+	return nil
+}
+
+// keepAlive acknowledges the last delivered event of an open window every
+// keepAlive interval without acknowledgement, until stop is closed. It
+// runs while the reader is blocked delivering an event.
+func (c *lumberjackConn) keepAlive(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.s.keepAlive / 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		c.mu.Lock()
+		if c.window > 0 && time.Since(c.ackedAt) >= c.s.keepAlive {
+			if c.ack() != nil {
+				// The reader fails as well.
+				c.conn.Close()
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// ack acknowledges the last delivered event; c.mu must be held.
+func (c *lumberjackConn) ack() error {
+	frame := [6]byte{c.version, 'A'}
+	binary.BigEndian.PutUint32(frame[2:], c.last)
+	c.conn.SetWriteDeadline(time.Now().Add(c.s.cfg.Timeout))
+	if _, err := c.conn.Write(frame[:]); err != nil {
+		return err
+	}
+	c.acked, c.ackedAt = c.last, time.Now()
+	// The client may take its time before sending the next window.
+	c.conn.SetReadDeadline(time.Now().Add(c.s.cfg.Timeout))
+	return nil
+}
+
+func readUint32(r io.Reader) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+// BeatsEvent converts a document published by a Beat to an Event. The
+// whole document is kept as data items named by their dotted path, e.g.
+// "log.file.path", except for "message" and "@timestamp", which become
+// Message and TimeCreated. Well-known fields set the Event fields:
+// "host.name", "log.level", "process.pid" and, for Winlogbeat, the
+// "winlog.*" provider, channel, event and record IDs. The provider is
+// otherwise the name of the Beat.
+func BeatsEvent(doc map[string]interface{}) *Event {
+	ev := &Event{}
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := doc[k]
+		switch k {
+		case "message":
+			if s, ok := v.(string); ok {
+				ev.Message = s
+				continue
+			}
+		case "@timestamp":
+			if t, ok := jsonTime(v); ok {
+				ev.TimeCreated = t
+				continue
+			}
+		}
+		flattenJSON(ev, k, v)
+	}
+	if ev.TimeCreated.IsZero() {
+		ev.TimeCreated = time.Now()
+	}
+
+	field := func(names ...string) string {
+		for _, name := range names {
+			if v, ok := ev.Field(name); ok && v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	ev.Provider = field("winlog.provider_name", "@metadata.beat", "agent.type")
+	if ev.Provider == "" {
+		ev.Provider = "beats"
+	}
+	ev.Computer = field("host.name", "winlog.computer_name", "host.hostname", "host")
+	ev.Channel = field("winlog.channel")
+	if id, err := strconv.ParseUint(field("winlog.event_id", "event.code"), 10, 32); err == nil {
+		ev.EventID = uint32(id)
+	}
+	if id, err := strconv.ParseUint(field("winlog.record_id"), 10, 64); err == nil {
+		ev.RecordID = id
+	}
+	if pid, err := strconv.ParseUint(field("process.pid", "winlog.process.pid"), 10, 32); err == nil {
+		ev.ProcessID = uint32(pid)
+	}
+	if l, err := ParseLevel(field("log.level", "winlog.level")); err == nil {
+		ev.Level = l
+	}
+	if ev.Level == LevelUnknown {
+		ev.Level = DetectLevel(ev.Message)
+	}
+	if ev.Level == LevelUnknown {
+		ev.Level = LevelInfo
+	}
+	if ev.UserID = field("winlog.user.identifier"); ev.UserID == "" {
+		ev.UserID = field("user.id")
+	}
+	if ev.Message == "" {
+		ev.Message = strings.TrimSpace(field("event.original"))
+	}
+	return ev
+}
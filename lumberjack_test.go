@@ -0,0 +1,299 @@
+package eventwatcher
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// lumberjackClient is a minimal Lumberjack v2 client, as used by Beats.
+type lumberjackClient struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func (c *lumberjackClient) frame(typ byte, fields ...interface{}) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{'2', typ})
+	for _, f := range fields {
+		switch f := f.(type) {
+		case uint32:
+			binary.Write(&b, binary.BigEndian, f)
+		case []byte:
+			b.Write(f)
+		}
+	}
+	return b.Bytes()
+}
+
+func (c *lumberjackClient) jsonFrame(seq uint32, doc interface{}) []byte {
+	payload, _ := json.Marshal(doc)
+	return c.frame('J', seq, uint32(len(payload)), payload)
+}
+
+// send writes a window of docs, compressed or not, and returns the frames
+// as written.
+func (c *lumberjackClient) send(compress bool, docs ...interface{}) {
+	c.t.Helper()
+	var frames bytes.Buffer
+	for i, doc := range docs {
+		frames.Write(c.jsonFrame(uint32(i+1), doc))
+	}
+	out := c.frame('W', uint32(len(docs)))
+	if compress {
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(frames.Bytes())
+		zw.Close()
+		out = append(out, c.frame('C', uint32(z.Len()), z.Bytes())...)
+	} else {
+		out = append(out, frames.Bytes()...)
+	}
+	if _, err := c.conn.Write(out); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *lumberjackClient) ack() (uint32, error) {
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var b [6]byte
+	if _, err := io.ReadFull(c.conn, b[:]); err != nil {
+		return 0, err
+	}
+	if b[0] != '2' || b[1] != 'A' {
+		c.t.Fatalf("not an ack: %q", b[:2])
+	}
+	return binary.BigEndian.Uint32(b[2:]), nil
+}
+
+func filebeatDoc(msg string) map[string]interface{} {
+	return map[string]interface{}{
+		"@timestamp": "2024-03-10T12:00:00.123Z",
+		"@metadata":  map[string]interface{}{"beat": "filebeat", "type": "_doc"},
+		"message":    msg,
+		"host":       map[string]interface{}{"name": "web01"},
+		"log":        map[string]interface{}{"level": "error", "file": map[string]interface{}{"path": "/var/log/app.log"}},
+		"process":    map[string]interface{}{"pid": 812},
+	}
+}
+
+func TestLumberjackSource(t *testing.T) {
+	src := NewLumberjackSource(LumberjackConfig{Addr: "127.0.0.1:0"})
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("beats", src); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", src.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &lumberjackClient{t: t, conn: conn}
+
+	for _, compress := range []bool{false, true} {
+		c.send(compress, filebeatDoc("first"), filebeatDoc("second"), filebeatDoc("third"))
+		for _, want := range []string{"first", "second", "third"} {
+			e := waitSyslogEntry(t, n)
+			ev := e.Event
+			if e.Name != "beats" || ev.Message != want || ev.Provider != "filebeat" || ev.Computer != "web01" || ev.Level != LevelError || ev.ProcessID != 812 {
+				t.Fatalf("compress=%v: %+v", compress, ev)
+			}
+			if !ev.TimeCreated.Equal(time.Date(2024, 3, 10, 12, 0, 0, 123e6, time.UTC)) {
+				t.Fatalf("time: %v", ev.TimeCreated)
+			}
+			if v, _ := ev.Field("log.file.path"); v != "/var/log/app.log" {
+				t.Fatalf("log.file.path = %q", v)
+			}
+			if v, _ := ev.Field("peer"); v != conn.LocalAddr().String() {
+				t.Fatalf("peer = %q", v)
+			}
+		}
+		if seq, err := c.ack(); err != nil || seq != 3 {
+			t.Fatalf("compress=%v: ack %d %v", compress, seq, err)
+		}
+	}
+
+	// A Winlogbeat document, sent as the only event of a window.
+	c.send(true, map[string]interface{}{
+		"@timestamp": "2024-03-10T12:00:01Z",
+		"@metadata":  map[string]interface{}{"beat": "winlogbeat"},
+		"message":    "An account failed to log on.",
+		"log":        map[string]interface{}{"level": "information"},
+		"winlog": map[string]interface{}{
+			"provider_name": "Microsoft-Windows-Security-Auditing",
+			"channel":       "Security",
+			"event_id":      "4625",
+			"record_id":     98765,
+			"computer_name": "dc01.example.com",
+			"event_data":    map[string]interface{}{"TargetUserName": "alice"},
+		},
+	})
+	ev := waitSyslogEntry(t, n).Event
+	if ev.Provider != "Microsoft-Windows-Security-Auditing" || ev.Channel != "Security" || ev.EventID != 4625 ||
+		ev.RecordID != 98765 || ev.Computer != "dc01.example.com" || ev.Level != LevelInfo {
+		t.Fatalf("winlogbeat: %+v", ev)
+	}
+	if v, _ := ev.Field("winlog.event_data.TargetUserName"); v != "alice" {
+		t.Fatalf("event data: %q", v)
+	}
+	if seq, err := c.ack(); err != nil || seq != 1 {
+		t.Fatalf("ack %d %v", seq, err)
+	}
+
+	// Nothing is acknowledged while the window is incomplete.
+	c.conn.Write(c.frame('W', uint32(2)))
+	c.conn.Write(c.jsonFrame(1, filebeatDoc("only one")))
+	waitSyslogEntry(t, n)
+	c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := c.conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("incomplete window acknowledged")
+	}
+
+	// An invalid frame closes the connection.
+	c.conn.Write([]byte("2X"))
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("connection not closed: %v", err)
+	}
+}
+
+func TestLumberjackSourceV1DataAndTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	cert := pki.issue("server", "beats.test", x509.ExtKeyUsageServerAuth, "beats.test")
+	src := NewLumberjackSource(LumberjackConfig{
+		Addr: "127.0.0.1:0",
+		TLS:  &tls.Config{Certificates: []tls.Certificate{cert}},
+	})
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("beats", src); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", src.Addr().String(), &tls.Config{RootCAs: pki.caPool, ServerName: "beats.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &lumberjackClient{t: t, conn: conn}
+
+	// A version 1 key/value data frame.
+	var kv bytes.Buffer
+	for _, s := range []string{"line", "WARN low memory", "host", "old01", "file", "/var/log/syslog"} {
+		binary.Write(&kv, binary.BigEndian, uint32(len(s)))
+		kv.WriteString(s)
+	}
+	out := []byte{'1', 'W', 0, 0, 0, 1, '1', 'D', 0, 0, 0, 1, 0, 0, 0, 3}
+	conn.Write(append(out, kv.Bytes()...))
+	ev := waitSyslogEntry(t, n).Event
+	if ev.Message != "WARN low memory" || ev.Level != LevelWarning || ev.Provider != "beats" || ev.Computer != "old01" {
+		t.Fatalf("v1: %+v", ev)
+	}
+	if v, _ := ev.Field("file"); v != "/var/log/syslog" {
+		t.Fatalf("file = %q", v)
+	}
+	var ack [6]byte
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, ack[:]); err != nil || string(ack[:2]) != "1A" || binary.BigEndian.Uint32(ack[2:]) != 1 {
+		t.Fatalf("v1 ack %q %v", ack, err)
+	}
+
+	c.send(false, filebeatDoc("over tls"))
+	if ev := waitSyslogEntry(t, n).Event; ev.Message != "over tls" {
+		t.Fatalf("tls: %+v", ev)
+	}
+	if seq, err := c.ack(); err != nil || seq != 1 {
+		t.Fatalf("ack %d %v", seq, err)
+	}
+}
+
+func TestLumberjackSourceNestedCompression(t *testing.T) {
+	src := NewLumberjackSource(LumberjackConfig{Addr: "127.0.0.1:0"})
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("beats", src); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", src.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &lumberjackClient{t: t, conn: conn}
+
+	compress := func(b []byte) []byte {
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(b)
+		zw.Close()
+		return c.frame('C', uint32(z.Len()), z.Bytes())
+	}
+	// A compressed frame inside a compressed frame is refused.
+	inner := compress(c.jsonFrame(1, filebeatDoc("nested")))
+	conn.Write(append(c.frame('W', uint32(1)), compress(inner)...))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("connection not closed: %v", err)
+	}
+	select {
+	case e := <-n.EventLogChannel:
+		t.Fatalf("nested event delivered: %+v", e.Event)
+	default:
+	}
+}
+
+func TestLumberjackSourceKeepAlive(t *testing.T) {
+	src := NewLumberjackSource(LumberjackConfig{Addr: "127.0.0.1:0"})
+	src.keepAlive = 50 * time.Millisecond
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("beats", src); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", src.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &lumberjackClient{t: t, conn: conn}
+
+	// While the first event waits for the consumer, the window is
+	// acknowledged with no progress, and then repeatedly up to the event
+	// delivered.
+	c.send(false, filebeatDoc("first"), filebeatDoc("second"), filebeatDoc("third"))
+	if seq, err := c.ack(); err != nil || seq != 0 {
+		t.Fatalf("ack %d %v", seq, err)
+	}
+	waitSyslogEntry(t, n)
+	for i := 0; i < 2; i++ {
+		seq, err := c.ack()
+		for err == nil && seq == 0 {
+			seq, err = c.ack()
+		}
+		if err != nil || seq != 1 {
+			t.Fatalf("ack %d %v", seq, err)
+		}
+	}
+	waitSyslogEntry(t, n)
+	waitSyslogEntry(t, n)
+	for {
+		seq, err := c.ack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq == 3 {
+			break
+		}
+		if seq > 3 {
+			t.Fatalf("ack %d", seq)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package eventwatcher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FormatInserts expands a message template using FormatMessage insert
+// syntax: %1 to %99 (optionally followed by a !printf! specification) are
+// replaced with the matching insertion string, %n, %r, %t, %b, %%, %. and %!
+// produce their literal characters and %0 ends the message. Inserts without
+// a matching string are left untouched.
+func FormatInserts(template string, inserts []string) string {
+	var sb strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c != '%' || i+1 >= len(template) {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		switch next := template[i]; next {
+		case 'n':
+			sb.WriteString("\r\n")
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'b':
+			sb.WriteByte(' ')
+		case '0':
+			return strings.TrimRight(sb.String(), "\r\n")
+		case '%', '.', '!', ' ':
+			sb.WriteByte(next)
+		default:
+			if next < '1' || next > '9' {
+				sb.WriteByte('%')
+				sb.WriteByte(next)
+				continue
+			}
+			end := i + 1
+			if end < len(template) && template[end] >= '0' && template[end] <= '9' {
+				end++
+			}
+			n, _ := strconv.Atoi(template[i:end])
+			// Skip an optional !printf! format specification.
+			if end < len(template) && template[end] == '!' {
+				if j := strings.IndexByte(template[end+1:], '!'); j >= 0 {
+					end += j + 2
+				}
+			}
+			if n <= len(inserts) {
+				sb.WriteString(inserts[n-1])
+			} else {
+				sb.WriteString(template[i-1 : end])
+			}
+			i = end - 1
+		}
+	}
+	return strings.TrimRight(sb.String(), "\r\n")
+}
+
+// MessageCatalog resolves event messages from the message files registered
+// for each event source, mirroring the EventMessageFile and
+// ParameterMessageFile registry values of the Windows event log.
+type MessageCatalog struct {
+	mu      sync.RWMutex
+	sources map[string]*messageSource
+}
+
+type messageSource struct {
+	events []*MessageTable
+	params []*MessageTable
+}
+
+// NewMessageCatalog creates an empty MessageCatalog.
+func NewMessageCatalog() *MessageCatalog {
+	return &MessageCatalog{sources: make(map[string]*messageSource)}
+}
+
+// AddEventMessageFile loads the message files in paths (separated by ';'
+// as in the registry) and registers them as event messages for source.
+func (c *MessageCatalog) AddEventMessageFile(source, paths string) error {
+	tables, err := loadMessageTables(paths)
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		c.AddEventMessageTable(source, t)
+	}
+	return nil
+}
+
+// AddParameterMessageFile loads the message files in paths and registers
+// them as parameter messages (%%N inserts) for source.
+func (c *MessageCatalog) AddParameterMessageFile(source, paths string) error {
+	tables, err := loadMessageTables(paths)
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		c.AddParameterMessageTable(source, t)
+	}
+	return nil
+}
+
+// AddEventMessageTable registers an already loaded table for source.
+func (c *MessageCatalog) AddEventMessageTable(source string, t *MessageTable) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.source(source)
+	s.events = append(s.events, t)
+}
+
+// AddParameterMessageTable registers an already loaded parameter table for
+// source.
+func (c *MessageCatalog) AddParameterMessageTable(source string, t *MessageTable) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.source(source)
+	s.params = append(s.params, t)
+}
+
+func (c *MessageCatalog) source(name string) *messageSource {
+	key := strings.ToLower(name)
+	s, ok := c.sources[key]
+	if !ok {
+		s = &messageSource{}
+		c.sources[key] = s
+	}
+	return s
+}
+
+func loadMessageTables(paths string) ([]*MessageTable, error) {
+	var tables []*MessageTable
+	for _, p := range strings.Split(paths, ";") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		t, err := LoadMessageTable(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// Format looks up the template of eventID (including its qualifier bits)
+// for source in lang and substitutes the insertion strings. Inserts of the
+// form %%N are first replaced with parameter message N of the source.
+func (c *MessageCatalog) Format(source string, eventID uint32, lang uint16, inserts []string) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	s, ok := c.sources[strings.ToLower(source)]
+	if !ok {
+		return "", fmt.Errorf("no message files registered for source %q", source)
+	}
+	template, ok := lookupMessage(s.events, eventID, lang)
+	if !ok {
+		return "", fmt.Errorf("message 0x%08x not found for source %q", eventID, source)
+	}
+	if len(s.params) > 0 {
+		resolved := make([]string, len(inserts))
+		for i, in := range inserts {
+			resolved[i] = expandParameters(in, s.params, lang)
+		}
+		inserts = resolved
+	}
+	return FormatInserts(template, inserts), nil
+}
+
+// FormatEvent formats the message of ev using its provider, event ID,
+// qualifiers and data values as insertion strings.
+func (c *MessageCatalog) FormatEvent(ev *Event, lang uint16) (string, error) {
+	source := ev.EventSourceName
+	if source == "" {
+		source = ev.Provider
+	}
+	inserts := make([]string, len(ev.Data))
+	for i, d := range ev.Data {
+		inserts[i] = d.Value
+	}
+	return c.Format(source, uint32(ev.Qualifiers)<<16|ev.EventID, lang, inserts)
+}
+
+// lookupMessage searches tables for id. Identifiers without qualifier bits,
+// as found in Event XML without a Qualifiers attribute, match any message
+// whose low 16 bits are equal; of several, the one with the lowest full
+// identifier is used.
+func lookupMessage(tables []*MessageTable, id uint32, lang uint16) (string, bool) {
+	for _, t := range tables {
+		if msg, ok := t.Message(id, lang); ok {
+			return msg, true
+		}
+	}
+	if id > 0xffff {
+		return "", false
+	}
+	for _, t := range tables {
+		best, found := uint32(0), false
+		for _, l := range t.langs {
+			for full := range t.messages[l] {
+				if full&0xffff == id && (!found || full < best) {
+					best, found = full, true
+				}
+			}
+		}
+		if found {
+			return t.Message(best, lang)
+		}
+	}
+	return "", false
+}
+
+// expandParameters replaces %%N references in s with parameter messages.
+func expandParameters(s string, params []*MessageTable, lang uint16) string {
+	if !strings.Contains(s, "%%") {
+		return s
+	}
+	var sb strings.Builder
+	for {
+		i := strings.Index(s, "%%")
+		if i < 0 {
+			sb.WriteString(s)
+			return sb.String()
+		}
+		j := i + 2
+		for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			j++
+		}
+		sb.WriteString(s[:i])
+		n, err := strconv.ParseUint(s[i+2:j], 10, 32)
+		msg, ok := "", false
+		if err == nil {
+			msg, ok = lookupMessage(params, uint32(n), lang)
+		}
+		if ok {
+			sb.WriteString(strings.TrimRight(msg, "\r\n"))
+		} else {
+			sb.WriteString(s[i:j])
+		}
+		s = s[j:]
+	}
+}
@@ -0,0 +1,281 @@
+package eventwatcher
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// rtMessageTable is the RT_MESSAGETABLE resource type.
+	rtMessageTable = 11
+	// imageDirectoryEntryResource is the index of the resource directory in
+	// the PE optional header.
+	imageDirectoryEntryResource = 2
+
+	// Flags of a MESSAGE_RESOURCE_ENTRY.
+	messageResourceUnicode = 0x0001
+	messageResourceUTF8    = 0x0002
+)
+
+// Language identifiers used as fallbacks when looking up messages.
+const (
+	LangNeutral = 0x0000
+	LangEnUS    = 0x0409
+)
+
+// MessageTable holds the RT_MESSAGETABLE resources of a message file (for
+// example a DLL registered as EventMessageFile), keyed by language and
+// message identifier.
+type MessageTable struct {
+	messages map[uint16]map[uint32]string
+	// langs holds the languages of messages in ascending order and primary
+	// the same languages keyed by their primary language (the low 10 bits),
+	// so that lookups falling back to another language do not sort.
+	langs   []uint16
+	primary map[uint16][]uint16
+}
+
+// LoadMessageTable reads the message table resources of the PE file at path.
+func LoadMessageTable(path string) (*MessageTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseMessageTable(f)
+}
+
+// ParseMessageTable extracts the message table resources from a PE image.
+// It only relies on the file contents, so message DLLs copied from Windows
+// hosts can be used on any platform.
+func ParseMessageTable(r io.ReaderAt) (*MessageTable, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dir pe.DataDirectory
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if oh.NumberOfRvaAndSizes > imageDirectoryEntryResource {
+			dir = oh.DataDirectory[imageDirectoryEntryResource]
+		}
+	case *pe.OptionalHeader64:
+		if oh.NumberOfRvaAndSizes > imageDirectoryEntryResource {
+			dir = oh.DataDirectory[imageDirectoryEntryResource]
+		}
+	}
+	if dir.VirtualAddress == 0 || dir.Size == 0 {
+		return nil, errors.New("PE file has no resource directory")
+	}
+	rsrc, err := peData(f, dir.VirtualAddress, dir.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &MessageTable{messages: make(map[uint16]map[uint32]string)}
+	types, err := resourceEntries(rsrc, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, typ := range types {
+		if typ.id != rtMessageTable || !typ.dir {
+			continue
+		}
+		names, err := resourceEntries(rsrc, typ.offset)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !name.dir {
+				continue
+			}
+			langs, err := resourceEntries(rsrc, name.offset)
+			if err != nil {
+				return nil, err
+			}
+			for _, lang := range langs {
+				if lang.dir {
+					continue
+				}
+				if int(lang.offset)+16 > len(rsrc) {
+					return nil, errors.New("resource data entry out of range")
+				}
+				rva := binary.LittleEndian.Uint32(rsrc[lang.offset:])
+				size := binary.LittleEndian.Uint32(rsrc[lang.offset+4:])
+				data, err := peData(f, rva, size)
+				if err != nil {
+					return nil, err
+				}
+				if err := t.parseResource(uint16(lang.id), data); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if len(t.messages) == 0 {
+		return nil, errors.New("PE file has no message table resource")
+	}
+	t.index()
+	return t, nil
+}
+
+// resourceEntry is one entry of an IMAGE_RESOURCE_DIRECTORY.
+type resourceEntry struct {
+	id     uint32
+	dir    bool
+	offset uint32
+}
+
+// resourceEntries lists the ID entries of the resource directory at off.
+// Named entries are skipped because message tables are always identified by
+// numeric IDs.
+func resourceEntries(rsrc []byte, off uint32) ([]resourceEntry, error) {
+	if int(off)+16 > len(rsrc) {
+		return nil, errors.New("resource directory out of range")
+	}
+	named := int(binary.LittleEndian.Uint16(rsrc[off+12:]))
+	ids := int(binary.LittleEndian.Uint16(rsrc[off+14:]))
+	start := int(off) + 16
+	if start+(named+ids)*8 > len(rsrc) {
+		return nil, errors.New("resource directory entries out of range")
+	}
+	entries := make([]resourceEntry, 0, ids)
+	for i := named; i < named+ids; i++ {
+		e := rsrc[start+i*8:]
+		target := binary.LittleEndian.Uint32(e[4:])
+		entries = append(entries, resourceEntry{
+			id:     binary.LittleEndian.Uint32(e),
+			dir:    target&0x80000000 != 0,
+			offset: target &^ 0x80000000,
+		})
+	}
+	return entries, nil
+}
+
+// peData returns size bytes of the image starting at the relative virtual
+// address rva.
+func peData(f *pe.File, rva, size uint32) ([]byte, error) {
+	for _, s := range f.Sections {
+		if rva < s.VirtualAddress || rva >= s.VirtualAddress+s.Size {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			return nil, err
+		}
+		start := rva - s.VirtualAddress
+		if uint64(start)+uint64(size) > uint64(len(data)) {
+			return nil, fmt.Errorf("data at RVA 0x%x exceeds section %s", rva, s.Name)
+		}
+		return data[start : start+size], nil
+	}
+	return nil, fmt.Errorf("no section contains RVA 0x%x", rva)
+}
+
+// parseResource decodes a MESSAGE_RESOURCE_DATA block for lang.
+func (t *MessageTable) parseResource(lang uint16, data []byte) error {
+	le := binary.LittleEndian
+	if len(data) < 4 {
+		return errors.New("message table resource is truncated")
+	}
+	blocks := int(le.Uint32(data))
+	if 4+blocks*12 > len(data) {
+		return errors.New("message table blocks out of range")
+	}
+	msgs := t.messages[lang]
+	if msgs == nil {
+		msgs = make(map[uint32]string)
+		t.messages[lang] = msgs
+	}
+	for i := 0; i < blocks; i++ {
+		b := data[4+i*12:]
+		low, high, off := le.Uint32(b), le.Uint32(b[4:]), int(le.Uint32(b[8:]))
+		for id := uint64(low); id <= uint64(high); id++ {
+			if off+4 > len(data) {
+				return errors.New("message table entry out of range")
+			}
+			length := int(le.Uint16(data[off:]))
+			flags := le.Uint16(data[off+2:])
+			if length < 4 || off+length > len(data) {
+				return errors.New("message table entry out of range")
+			}
+			msgs[uint32(id)] = decodeMessageText(data[off+4:off+length], flags)
+			off += length
+		}
+	}
+	return nil
+}
+
+func decodeMessageText(b []byte, flags uint16) string {
+	var s string
+	switch flags {
+	case messageResourceUnicode:
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			u[i] = binary.LittleEndian.Uint16(b[i*2:])
+		}
+		s = string(utf16.Decode(u))
+	case messageResourceUTF8:
+		s = string(b)
+	default:
+		// ANSI text is decoded as Latin-1, which covers the ASCII messages
+		// shipped in practically all message files.
+		r := make([]rune, len(b))
+		for i, c := range b {
+			r[i] = rune(c)
+		}
+		s = string(r)
+	}
+	return strings.TrimRight(s, "\x00")
+}
+
+// index builds langs and primary once the resources are parsed.
+func (t *MessageTable) index() {
+	t.langs = make([]uint16, 0, len(t.messages))
+	for lang := range t.messages {
+		t.langs = append(t.langs, lang)
+	}
+	sort.Slice(t.langs, func(i, j int) bool { return t.langs[i] < t.langs[j] })
+	t.primary = make(map[uint16][]uint16)
+	for _, lang := range t.langs {
+		t.primary[lang&0x3ff] = append(t.primary[lang&0x3ff], lang)
+	}
+}
+
+// Languages returns the language identifiers present in the table.
+func (t *MessageTable) Languages() []uint16 {
+	return append([]uint16(nil), t.langs...)
+}
+
+// Message returns the template for id in lang. When the language is not
+// present, the same primary language, the neutral language, en-US and
+// finally any language are tried, in that order.
+func (t *MessageTable) Message(id uint32, lang uint16) (string, bool) {
+	for _, l := range []uint16{lang, LangNeutral, LangEnUS} {
+		if msg, ok := t.messages[l][id]; ok {
+			return msg, true
+		}
+		if l != LangNeutral {
+			for _, cand := range t.primary[l&0x3ff] {
+				if msg, ok := t.messages[cand][id]; ok {
+					return msg, true
+				}
+			}
+		}
+	}
+	for _, cand := range t.langs {
+		if msg, ok := t.messages[cand][id]; ok {
+			return msg, true
+		}
+	}
+	return "", false
+}
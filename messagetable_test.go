@@ -0,0 +1,238 @@
+package eventwatcher
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"unicode/utf16"
+)
+
+// buildMessageDLL returns a minimal PE32 image whose .rsrc section holds a
+// single RT_MESSAGETABLE resource with one language entry per key of msgs.
+func buildMessageDLL(msgs map[uint16]map[uint32]string) []byte {
+	const rsrcRVA = 0x1000
+	le := binary.LittleEndian
+
+	var langs []uint16
+	for l := range msgs {
+		langs = append(langs, l)
+	}
+	sort.Slice(langs, func(i, j int) bool { return langs[i] < langs[j] })
+
+	dir := func(b *bytes.Buffer, n int) {
+		b.Write(make([]byte, 14))
+		binary.Write(b, le, uint16(n))
+	}
+	var rsrc bytes.Buffer
+	// root -> type 11 -> name 1 -> languages -> data entries
+	dir(&rsrc, 1)
+	binary.Write(&rsrc, le, []uint32{rtMessageTable, 0x80000000 | 24})
+	dir(&rsrc, 1)
+	binary.Write(&rsrc, le, []uint32{1, 0x80000000 | 48})
+	dir(&rsrc, len(langs))
+	entries := 64 + 8*len(langs)
+	for i, l := range langs {
+		binary.Write(&rsrc, le, []uint32{uint32(l), uint32(entries + 16*i)})
+	}
+	dataStart := entries + 16*len(langs)
+
+	var blobs [][]byte
+	for _, l := range langs {
+		var ids []uint32
+		for id := range msgs[l] {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		var text bytes.Buffer
+		for _, id := range ids {
+			u := utf16.Encode([]rune(msgs[l][id] + "\r\n\x00"))
+			n := 4 + 2*len(u)
+			n += (4 - n%4) % 4
+			binary.Write(&text, le, uint16(n))
+			binary.Write(&text, le, uint16(messageResourceUnicode))
+			binary.Write(&text, le, u)
+			text.Write(make([]byte, n-4-2*len(u)))
+		}
+		var blob bytes.Buffer
+		binary.Write(&blob, le, uint32(len(ids)))
+		off := 4 + 12*len(ids)
+		for _, id := range ids {
+			// one block per message keeps the builder simple
+			binary.Write(&blob, le, []uint32{id, id, uint32(off)})
+			length := le.Uint16(text.Bytes()[off-4-12*len(ids):])
+			off += int(length)
+		}
+		blob.Write(text.Bytes())
+		blobs = append(blobs, blob.Bytes())
+	}
+	off := dataStart
+	for _, b := range blobs {
+		binary.Write(&rsrc, le, []uint32{uint32(rsrcRVA + off), uint32(len(b)), 0, 0})
+		off += len(b)
+	}
+	for _, b := range blobs {
+		rsrc.Write(b)
+	}
+
+	rawSize := (rsrc.Len() + 0x1ff) &^ 0x1ff
+	var img bytes.Buffer
+	dos := make([]byte, 0x40)
+	copy(dos, "MZ")
+	le.PutUint32(dos[0x3c:], 0x40)
+	img.Write(dos)
+	img.WriteString("PE\x00\x00")
+	binary.Write(&img, le, pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_I386,
+		NumberOfSections:     1,
+		SizeOfOptionalHeader: 224,
+		Characteristics:      0x2102,
+	})
+	oh := pe.OptionalHeader32{
+		Magic:               0x10b,
+		SectionAlignment:    0x1000,
+		FileAlignment:       0x200,
+		SizeOfImage:         0x2000,
+		SizeOfHeaders:       0x200,
+		NumberOfRvaAndSizes: 16,
+	}
+	oh.DataDirectory[imageDirectoryEntryResource] = pe.DataDirectory{VirtualAddress: rsrcRVA, Size: uint32(rsrc.Len())}
+	binary.Write(&img, le, oh)
+	sh := pe.SectionHeader32{
+		VirtualSize:      uint32(rsrc.Len()),
+		VirtualAddress:   rsrcRVA,
+		SizeOfRawData:    uint32(rawSize),
+		PointerToRawData: 0x200,
+		Characteristics:  0x40000040,
+	}
+	copy(sh.Name[:], ".rsrc")
+	binary.Write(&img, le, sh)
+	img.Write(make([]byte, 0x200-img.Len()))
+	img.Write(rsrc.Bytes())
+	img.Write(make([]byte, rawSize-rsrc.Len()))
+	return img.Bytes()
+}
+
+func TestParseMessageTable(t *testing.T) {
+	dll := buildMessageDLL(map[uint16]map[uint32]string{
+		LangEnUS: {
+			0x40000001: "Service %1 entered the %2 state.",
+			0xC0000002: "Logon failure for %1: %2",
+		},
+		0x0407: {
+			0x40000001: "Dienst %1 hat den Status %2 erreicht.",
+		},
+	})
+	mt, err := ParseMessageTable(bytes.NewReader(dll))
+	if err != nil {
+		t.Fatalf("ParseMessageTable: %v", err)
+	}
+	if langs := mt.Languages(); len(langs) != 2 || langs[0] != 0x0407 || langs[1] != LangEnUS {
+		t.Fatalf("unexpected languages: %v", langs)
+	}
+	if msg, ok := mt.Message(0x40000001, 0x0407); !ok || msg != "Dienst %1 hat den Status %2 erreicht.\r\n" {
+		t.Errorf("German message = %q, %v", msg, ok)
+	}
+	// en-GB falls back to en-US via the primary language.
+	if msg, ok := mt.Message(0xC0000002, 0x0809); !ok || msg != "Logon failure for %1: %2\r\n" {
+		t.Errorf("fallback message = %q, %v", msg, ok)
+	}
+	// de-CH falls back to de-DE before en-US.
+	if msg, ok := mt.Message(0x40000001, 0x0807); !ok || msg != "Dienst %1 hat den Status %2 erreicht.\r\n" {
+		t.Errorf("primary language message = %q, %v", msg, ok)
+	}
+	if _, ok := mt.Message(0x1234, LangEnUS); ok {
+		t.Error("unexpected message for unknown id")
+	}
+	// Misses walk the prebuilt indexes without allocating.
+	if n := testing.AllocsPerRun(100, func() { mt.Message(0x1234, 0x0809) }); n != 0 {
+		t.Errorf("Message allocates %v times per miss", n)
+	}
+
+	if _, err := ParseMessageTable(bytes.NewReader([]byte("not a PE file"))); err == nil {
+		t.Error("expected error for invalid PE")
+	}
+}
+
+func TestFormatInserts(t *testing.T) {
+	tests := []struct {
+		template string
+		inserts  []string
+		want     string
+	}{
+		{"Service %1 entered the %2 state.\r\n", []string{"Spooler", "running"}, "Service Spooler entered the running state."},
+		{"%1!s! and %2!d! items", []string{"a", "3"}, "a and 3 items"},
+		{"Line one%nLine two%tTab %% done", nil, "Line one\r\nLine two\tTab % done"},
+		{"Missing %3 insert", []string{"x"}, "Missing %3 insert"},
+		{"Value %10 end", []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "ten"}, "Value ten end"},
+		{"Stop here%0 not shown", nil, "Stop here"},
+	}
+	for _, tt := range tests {
+		if got := FormatInserts(tt.template, tt.inserts); got != tt.want {
+			t.Errorf("FormatInserts(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestMessageCatalog(t *testing.T) {
+	dir := t.TempDir()
+	events := filepath.Join(dir, "events.dll")
+	params := filepath.Join(dir, "params.dll")
+	if err := os.WriteFile(events, buildMessageDLL(map[uint16]map[uint32]string{
+		LangEnUS: {0xC0000002: "Logon failure for %1: %2"},
+	}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(params, buildMessageDLL(map[uint16]map[uint32]string{
+		LangEnUS: {2313: "Unknown user name or bad password."},
+	}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewMessageCatalog()
+	if err := c.AddEventMessageFile("TestSource", events); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddParameterMessageFile("TestSource", params); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := c.Format("testsource", 0xC0000002, LangEnUS, []string{"alice", "%%2313"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "Logon failure for alice: Unknown user name or bad password." {
+		t.Errorf("unexpected message %q", msg)
+	}
+
+	// Events decoded from XML without qualifiers still resolve.
+	ev := &Event{Provider: "TestSource", EventID: 2, Data: []EventData{{Value: "bob"}, {Value: "locked"}}}
+	if msg, err := c.FormatEvent(ev, LangEnUS); err != nil || msg != "Logon failure for bob: locked" {
+		t.Errorf("FormatEvent = %q, %v", msg, err)
+	}
+
+	// Of several messages with the same low 16 bits, the lowest identifier
+	// is used every time.
+	multi := filepath.Join(dir, "multi.dll")
+	if err := os.WriteFile(multi, buildMessageDLL(map[uint16]map[uint32]string{
+		LangEnUS: {0xC0000007: "error", 0x40000007: "info", 0x80000007: "warning", 0x00010007: "success"},
+	}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddEventMessageFile("Multi", multi); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if msg, err := c.Format("Multi", 7, LangEnUS, nil); err != nil || msg != "success" {
+			t.Fatalf("Format = %q, %v", msg, err)
+		}
+	}
+
+	if _, err := c.Format("Other", 1, LangEnUS, nil); err == nil {
+		t.Error("expected error for unknown source")
+	}
+}
@@ -0,0 +1,351 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// This file implements the subset of MessagePack needed by the Fluent
+// Forward protocol. Decoded values are nil, bool, int64, uint64 (only above
+// math.MaxInt64), float64, string, []byte, []interface{},
+// map[string]interface{} (other keys are formatted with fmt), time.Time for
+// the Fluent EventTime extension and msgpackExt for other extensions.
+
+var (
+	errMsgpack         = errors.New("msgpack: invalid data")
+	errMsgpackTooLarge = errors.New("msgpack: value too large")
+)
+
+// msgpackMaxDepth bounds the nesting of arrays and maps.
+const msgpackMaxDepth = 64
+
+// msgpackExt is an extension value of a type the decoder does not know.
+type msgpackExt struct {
+	Type int8
+	Data []byte
+}
+
+// msgpackDecoder decodes values from a stream. Strings, binaries,
+// extensions, arrays and maps longer than limit are rejected before they
+// are allocated.
+type msgpackDecoder struct {
+	r     *bufio.Reader
+	limit int
+}
+
+func newMsgpackDecoder(r *bufio.Reader, limit int) *msgpackDecoder {
+	return &msgpackDecoder{r: r, limit: limit}
+}
+
+// Decode reads the next value. A stream ending between values returns
+// io.EOF, one ending within a value io.ErrUnexpectedEOF.
+func (d *msgpackDecoder) Decode() (interface{}, error) {
+	if _, err := d.r.Peek(1); err != nil {
+		return nil, err
+	}
+	v, err := d.decode(0)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+func (d *msgpackDecoder) decode(depth int) (interface{}, error) {
+	if depth > msgpackMaxDepth {
+		return nil, errMsgpackTooLarge
+	}
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c >= 0x80 && c <= 0x8f:
+		return d.decodeMap(int(c&0x0f), depth)
+	case c >= 0x90 && c <= 0x9f:
+		return d.decodeArray(int(c&0x0f), depth)
+	case c >= 0xa0 && c <= 0xbf:
+		b, err := d.bytes(int(c & 0x1f))
+		return string(b), err
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.length(c - 0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return d.bytes(n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.length(c - 0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(n)
+	case 0xca:
+		b, err := d.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 0xcb:
+		b, err := d.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		b, err := d.bytes(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		u := beUint(b)
+		if u > math.MaxInt64 {
+			return u, nil
+		}
+		return int64(u), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n := 1 << (c - 0xd0)
+		b, err := d.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+		// Sign-extend from the width of the value.
+		shift := uint(64 - 8*n)
+		return int64(beUint(b)<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.ext(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.length(c - 0xd9)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.bytes(n)
+		return string(b), err
+	case 0xdc, 0xdd:
+		n, err := d.length(c - 0xdc + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.length(c - 0xde + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n, depth)
+	}
+	return nil, errMsgpack
+}
+
+// length reads a big-endian length of 1, 2 or 4 bytes for size 0, 1 or 2.
+func (d *msgpackDecoder) length(size byte) (int, error) {
+	b, err := d.bytes(1 << size)
+	if err != nil {
+		return 0, err
+	}
+	n := beUint(b)
+	if n > uint64(d.limit) {
+		return 0, errMsgpackTooLarge
+	}
+	return int(n), nil
+}
+
+// msgpackChunkSize is how much of a value is allocated before its bytes
+// arrive; longer values grow with the data actually received.
+const msgpackChunkSize = 4096
+
+func (d *msgpackDecoder) bytes(n int) ([]byte, error) {
+	if n > d.limit {
+		return nil, errMsgpackTooLarge
+	}
+	if n <= msgpackChunkSize {
+		b := make([]byte, n)
+		_, err := io.ReadFull(d.r, b)
+		return b, err
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, d.r, int64(n)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *msgpackDecoder) ext(n int) (interface{}, error) {
+	t, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.bytes(n)
+	if err != nil {
+		return nil, err
+	}
+	if t == 0 && n == 8 {
+		// Fluent EventTime: seconds and nanoseconds as big-endian uint32.
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), int64(binary.BigEndian.Uint32(data[4:]))), nil
+	}
+	return msgpackExt{Type: int8(t), Data: data}, nil
+}
+
+func (d *msgpackDecoder) decodeArray(n, depth int) (interface{}, error) {
+	a := make([]interface{}, 0, minInt(n, 64))
+	for i := 0; i < n; i++ {
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+func (d *msgpackDecoder) decodeMap(n, depth int) (interface{}, error) {
+	m := make(map[string]interface{}, minInt(n, 64))
+	for i := 0; i < n; i++ {
+		k, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch k := k.(type) {
+		case string:
+			m[k] = v
+		case []byte:
+			m[string(k)] = v
+		default:
+			m[fmt.Sprint(k)] = v
+		}
+	}
+	return m, nil
+}
+
+func beUint(b []byte) uint64 {
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// appendMsgpack appends the encoding of v. Map keys are written in sorted
+// order; unsupported types are encoded as their fmt representation.
+func appendMsgpack(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if v {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case int:
+		return appendMsgpackInt(b, int64(v))
+	case int64:
+		return appendMsgpackInt(b, v)
+	case uint32:
+		return appendMsgpackInt(b, int64(v))
+	case uint64:
+		if v > math.MaxInt64 {
+			b = append(b, 0xcf)
+			return binary.BigEndian.AppendUint64(b, v)
+		}
+		return appendMsgpackInt(b, int64(v))
+	case float64:
+		b = append(b, 0xcb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(v))
+	case string:
+		return append(appendMsgpackHeader(b, len(v), 0xa0, 0x1f, 0xd9), v...)
+	case []byte:
+		switch n := len(v); {
+		case n <= math.MaxUint8:
+			b = append(b, 0xc4, byte(n))
+		case n <= math.MaxUint16:
+			b = binary.BigEndian.AppendUint16(append(b, 0xc5), uint16(n))
+		default:
+			b = binary.BigEndian.AppendUint32(append(b, 0xc6), uint32(n))
+		}
+		return append(b, v...)
+	case []interface{}:
+		b = appendMsgpackHeader(b, len(v), 0x90, 0x0f, 0xdc)
+		for _, item := range v {
+			b = appendMsgpack(b, item)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = appendMsgpackHeader(b, len(v), 0x80, 0x0f, 0xde)
+		for _, k := range keys {
+			b = appendMsgpack(b, k)
+			b = appendMsgpack(b, v[k])
+		}
+		return b
+	case time.Time:
+		// Fluent EventTime extension.
+		b = append(b, 0xd7, 0)
+		b = binary.BigEndian.AppendUint32(b, uint32(v.Unix()))
+		return binary.BigEndian.AppendUint32(b, uint32(v.Nanosecond()))
+	}
+	return appendMsgpack(b, fmt.Sprint(v))
+}
+
+func appendMsgpackInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v <= 0x7f, v < 0 && v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+}
+
+// appendMsgpackHeader appends the header of a string (fix 0xa0, str8 0xd9),
+// array (0x90, array16 0xdc) or map (0x80, map16 0xde) of n elements.
+// Strings have an 8 bit form; arrays and maps start at 16 bits.
+func appendMsgpackHeader(b []byte, n int, fix byte, fixMax int, base byte) []byte {
+	if n <= fixMax {
+		return append(b, fix|byte(n))
+	}
+	if base == 0xd9 {
+		if n <= math.MaxUint8 {
+			return append(b, 0xd9, byte(n))
+		}
+		base++
+	}
+	if n <= math.MaxUint16 {
+		return binary.BigEndian.AppendUint16(append(b, base), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, base+1), uint32(n))
+}
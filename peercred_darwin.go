@@ -0,0 +1,34 @@
+//go:build darwin
+// +build darwin
+
+package eventwatcher
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// unixPeerCred returns the LOCAL_PEERCRED credentials and LOCAL_PEERPID of
+// conn, or nil.
+func unixPeerCred(conn *net.UnixConn) *peerCred {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var cred *peerCred
+	raw.Control(func(fd uintptr) {
+		xu, err := unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+		if err != nil {
+			return
+		}
+		cred = &peerCred{Uid: xu.Uid}
+		if xu.Ngroups > 0 {
+			cred.Gid = xu.Groups[0]
+		}
+		if pid, err := unix.GetsockoptInt(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERPID); err == nil {
+			cred.Pid = uint32(pid)
+		}
+	})
+	return cred
+}
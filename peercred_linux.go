@@ -0,0 +1,26 @@
+//go:build linux
+// +build linux
+
+package eventwatcher
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// unixPeerCred returns the SO_PEERCRED credentials of conn, or nil.
+func unixPeerCred(conn *net.UnixConn) *peerCred {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var cred *peerCred
+	raw.Control(func(fd uintptr) {
+		uc, err := unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+		if err == nil {
+			cred = &peerCred{Pid: uint32(uc.Pid), Uid: uc.Uid, Gid: uc.Gid}
+		}
+	})
+	return cred
+}
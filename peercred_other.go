@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package eventwatcher
+
+import "net"
+
+// unixPeerCred is not supported on this platform.
+func unixPeerCred(conn *net.UnixConn) *peerCred {
+	return nil
+}
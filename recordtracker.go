@@ -0,0 +1,115 @@
+package eventwatcher
+
+import "hash/fnv"
+
+// logChange describes how an event log changed between two checks.
+type logChange int
+
+const (
+	// logUnchanged means no records were added.
+	logUnchanged logChange = iota
+	// logAppended means new records follow the last emitted one.
+	logAppended
+	// logOverwritten means the log wrapped and overwrote records that had
+	// not been read yet; reading resumes at the oldest record.
+	logOverwritten
+	// logCleared means the log was cleared and record numbers restarted.
+	logCleared
+)
+
+func (c logChange) String() string {
+	switch c {
+	case logAppended:
+		return "appended"
+	case logOverwritten:
+		return "overwritten"
+	case logCleared:
+		return "cleared"
+	}
+	return "unchanged"
+}
+
+// recordTracker follows the position of a watcher in an event log using
+// record numbers rather than record counts, so it stays correct when
+// several records arrive between notifications, when a circular log wraps
+// and when the log is cleared. It performs no system calls; the watcher
+// feeds it GetOldestEventLogRecord and GetNumberOfEventLogRecords results
+// and the last emitted record as it reads now. A log cleared and refilled
+// past the tracked position keeps its record numbers going forward; it is
+// told from an appended one by that record having changed.
+type recordTracker struct {
+	// oldest is the oldest record number seen at the last check.
+	oldest uint32
+	// next is the number of the next record to emit.
+	next uint32
+	// sum is the hash of record next-1 when summed is set.
+	sum    uint64
+	summed bool
+}
+
+// newRecordTracker positions a tracker after the newest record of a log
+// whose oldest record and record count are given.
+func newRecordTracker(oldest, count uint32) recordTracker {
+	return recordTracker{oldest: oldest, next: oldest + count}
+}
+
+// firstRecord clamps n to 1, the lowest valid record number. Empty logs
+// may report 0 as their oldest record.
+func firstRecord(n uint32) uint32 {
+	if n == 0 {
+		return 1
+	}
+	return n
+}
+
+// last returns the number of the last emitted record, which the watcher
+// reads again before each check, and whether its content is known.
+func (t *recordTracker) last() (uint32, bool) {
+	return t.next - 1, t.summed && t.next > 0
+}
+
+// check compares a new snapshot of the log with the tracked position. last
+// is the record returned by last as the log holds it now, or nil when it
+// was not read. check returns the record number to start reading from, the
+// number following the newest record and how the log changed. After a
+// clear or overwrite the tracker is repositioned at the oldest record.
+func (t *recordTracker) check(oldest, count uint32, last []byte) (first, end uint32, change logChange) {
+	end = oldest + count
+	switch {
+	case oldest < t.oldest || end < t.next:
+		// Record numbers only go backwards when the log was cleared.
+		t.next = oldest
+		change = logCleared
+	case last != nil && t.summed && recordSum(last) != t.sum:
+		// The log was cleared and refilled past the position.
+		t.next = oldest
+		change = logCleared
+	case end == t.next:
+		change = logUnchanged
+	case firstRecord(t.next) < oldest:
+		t.next = oldest
+		change = logOverwritten
+	default:
+		change = logAppended
+	}
+	if change == logCleared || change == logOverwritten {
+		t.summed = false
+	}
+	t.oldest = oldest
+	return firstRecord(t.next), end, change
+}
+
+// done marks record rn, whose content is record, as emitted. Marking the
+// record before the position again records its content.
+func (t *recordTracker) done(rn uint32, record []byte) {
+	if rn+1 >= t.next {
+		t.next = rn + 1
+		t.sum, t.summed = recordSum(record), true
+	}
+}
+
+func recordSum(record []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(record)
+	return h.Sum64()
+}
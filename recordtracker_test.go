@@ -0,0 +1,67 @@
+package eventwatcher
+
+import "testing"
+
+func TestRecordTrackerScript(t *testing.T) {
+	type step struct {
+		oldest, count uint32
+		first, end    uint32
+		change        logChange
+	}
+	tr := newRecordTracker(1, 10)
+	script := []step{
+		{1, 10, 11, 11, logUnchanged},
+		// three records arrived between notifications
+		{1, 13, 11, 14, logAppended},
+		// the circular log dropped old records we had already read
+		{5, 10, 14, 15, logAppended},
+		// the log wrapped past records we had not read yet
+		{20, 10, 20, 30, logOverwritten},
+		// the log was cleared
+		{0, 0, 1, 0, logCleared},
+		{1, 3, 1, 4, logAppended},
+		{1, 3, 4, 4, logUnchanged},
+		// cleared and refilled short of the old position between two checks
+		{1, 2, 1, 3, logCleared},
+	}
+	for i, s := range script {
+		first, end, change := tr.check(s.oldest, s.count, nil)
+		if first != s.first || end != s.end || change != s.change {
+			t.Fatalf("step %d: check(%d, %d) = %d, %d, %v; want %d, %d, %v",
+				i, s.oldest, s.count, first, end, change, s.first, s.end, s.change)
+		}
+		for rn := first; rn < end; rn++ {
+			tr.done(rn, nil)
+		}
+	}
+}
+
+func TestRecordTrackerEmptyLog(t *testing.T) {
+	tr := newRecordTracker(0, 0)
+	if first, end, change := tr.check(0, 0, nil); first < end || change != logUnchanged {
+		t.Fatalf("empty log reported %d..%d %v", first, end, change)
+	}
+	if first, end, change := tr.check(1, 2, nil); first != 1 || end != 3 || change != logAppended {
+		t.Fatalf("first records reported %d..%d %v", first, end, change)
+	}
+}
+
+func TestRecordTrackerRefill(t *testing.T) {
+	tr := newRecordTracker(1, 2)
+	tr.done(2, []byte("second"))
+	if rn, ok := tr.last(); rn != 2 || !ok {
+		t.Fatalf("last() = %d, %v", rn, ok)
+	}
+	if first, end, change := tr.check(1, 4, []byte("second")); first != 3 || end != 5 || change != logAppended {
+		t.Fatalf("append reported %d..%d %v", first, end, change)
+	}
+	tr.done(3, []byte("third"))
+	tr.done(4, []byte("fourth"))
+	// Cleared and refilled past the old position: record 4 changed.
+	if first, end, change := tr.check(1, 6, []byte("new fourth")); first != 1 || end != 7 || change != logCleared {
+		t.Fatalf("refill reported %d..%d %v", first, end, change)
+	}
+	if _, ok := tr.last(); ok {
+		t.Fatal("last record of the cleared log still known")
+	}
+}
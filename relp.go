@@ -0,0 +1,374 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RELP (Reliable Event Logging Protocol) frames are
+//
+//	TXNR SP COMMAND SP DATALEN [SP DATA] LF
+//
+// Every command of the client is answered by an "rsp" frame carrying the
+// same transaction number. Clients may send several commands before waiting
+// for their responses; this window is served in order, and a syslog command
+// is acknowledged as soon as, but not before, its event was delivered to the
+// notifier.
+
+const (
+	relpMaxTxnr     = 999999999
+	relpVersion     = "0"
+	relpSoftware    = "eventwatcher"
+	relpMaxToken    = 32
+	relpRspOK       = "200 OK"
+	relpHeaderSlack = 1024
+)
+
+var (
+	errRELPFrame    = errors.New("relp: malformed frame")
+	errRELPTooLarge = errors.New("relp: frame too large")
+)
+
+// RELPConfig configures a RELPSource.
+type RELPConfig struct {
+	// Addr is the TCP address to listen on, e.g. ":2514".
+	Addr string
+	// MaxMessageSize defaults to DefaultSyslogMessageSize. Larger syslog
+	// commands are refused and their connection closed.
+	MaxMessageSize int
+	// Parser parses the messages carried by syslog commands.
+	Parser SyslogParser
+}
+
+// RELPSource is a Source that implements the server side of RELP, as used
+// by rsyslog's omrelp. Events look like those of SyslogSource.
+type RELPSource struct {
+	cfg      RELPConfig
+	listener net.Listener
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// relpFrame is a decoded RELP frame.
+type relpFrame struct {
+	txnr    int
+	command string
+	data    []byte
+}
+
+// NewRELPSource creates a RELPSource; it starts listening in Init.
+func NewRELPSource(cfg RELPConfig) *RELPSource {
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = DefaultSyslogMessageSize
+	}
+	return &RELPSource{
+		cfg:   cfg,
+		conns: make(map[net.Conn]struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Init binds the listener.
+func (s *RELPSource) Init() error {
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = ln
+	return nil
+}
+
+// Addr returns the bound address.
+func (s *RELPSource) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Listen serves RELP sessions until ctx is done or Close is called.
+func (s *RELPSource) Listen(ctx context.Context, emit EmitFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.wg.Add(1)
+	go s.accept(ctx, emit)
+	select {
+	case <-ctx.Done():
+	case <-s.done:
+	}
+	cancel()
+	s.Close()
+	s.wg.Wait()
+}
+
+// Close stops accepting sessions. Open sessions are sent "serverclose" once
+// their current command has been answered.
+func (s *RELPSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for conn := range s.conns {
+		// Interrupt blocked reads; serveConn notices done and says goodbye.
+		conn.SetReadDeadline(time.Now())
+	}
+}
+
+func (s *RELPSource) accept(ctx context.Context, emit EmitFunc) {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return
+		}
+		s.wg.Add(1)
+		go s.serveConn(ctx, conn, emit)
+	}
+}
+
+func (s *RELPSource) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *RELPSource) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+func (s *RELPSource) stopping() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// serveConn runs one RELP session.
+func (s *RELPSource) serveConn(ctx context.Context, conn net.Conn, emit EmitFunc) {
+	defer s.wg.Done()
+	defer s.untrack(conn)
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	peer := []EventData{{Name: "peer", Value: conn.RemoteAddr().String()}}
+	session := relpSession{}
+
+	for {
+		if s.stopping() {
+			writeRELPFrame(w, 0, "serverclose", "")
+			w.Flush()
+			return
+		}
+		f, err := readRELPFrame(r, s.cfg.MaxMessageSize+relpHeaderSlack)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() && s.stopping() {
+				continue
+			}
+			if err != io.EOF {
+				writeRELPFrame(w, 0, "serverclose", "")
+				w.Flush()
+			}
+			return
+		}
+		rsp, keep := session.handle(f, func(msg []byte) error {
+			return emitSyslog(ctx, emit, &s.cfg.Parser, msg, peer)
+		})
+		if rsp != "" || f.command == "close" {
+			writeRELPFrame(w, f.txnr, "rsp", rsp)
+		}
+		if !keep {
+			if f.command != "close" {
+				writeRELPFrame(w, 0, "serverclose", "")
+			}
+			w.Flush()
+			return
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// relpSession holds the state of one RELP session.
+type relpSession struct {
+	open bool
+	last int
+}
+
+// handle processes f and returns the response data and whether the session
+// continues. Only the "syslog" command is offered; failed deliveries are
+// not acknowledged so the client sends the message again.
+func (ss *relpSession) handle(f relpFrame, deliver func([]byte) error) (string, bool) {
+	if f.txnr != ss.nextTxnr() {
+		return "500 transaction number out of sequence", false
+	}
+	ss.last = f.txnr
+	switch f.command {
+	case "open":
+		if ss.open {
+			return "500 session already open", false
+		}
+		offers := parseRELPOffers(f.data)
+		if _, ok := offers["relp_version"]; !ok {
+			return "500 relp_version offer missing", false
+		}
+		if cmds, ok := offers["commands"]; ok && !containsToken(cmds, "syslog") {
+			return "500 syslog command not offered", false
+		}
+		ss.open = true
+		return relpRspOK + "\nrelp_version=" + relpVersion +
+			"\nrelp_software=" + relpSoftware + "\ncommands=syslog", true
+	case "syslog":
+		if !ss.open {
+			return "500 session not open", false
+		}
+		if err := deliver(f.data); err != nil {
+			return "", false
+		}
+		return relpRspOK, true
+	case "close":
+		return "", false
+	default:
+		return "500 unknown command " + f.command, true
+	}
+}
+
+// nextTxnr returns the transaction number the next command must carry.
+func (ss *relpSession) nextTxnr() int {
+	if ss.last == relpMaxTxnr {
+		return 1
+	}
+	return ss.last + 1
+}
+
+// parseRELPOffers parses the "name=value" lines of an open command.
+func parseRELPOffers(data []byte) map[string]string {
+	offers := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		name, value := line, ""
+		if i := strings.IndexByte(line, '='); i >= 0 {
+			name, value = line[:i], line[i+1:]
+		}
+		offers[name] = value
+	}
+	return offers
+}
+
+func containsToken(list, token string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.TrimSpace(t) == token {
+			return true
+		}
+	}
+	return false
+}
+
+// readRELPToken reads up to the next space or LF and returns the token and
+// its delimiter.
+func readRELPToken(r *bufio.Reader) (string, byte, error) {
+	var b []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(b) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", 0, err
+		}
+		if c == ' ' || c == '\n' {
+			return string(b), c, nil
+		}
+		if len(b) == relpMaxToken {
+			return "", 0, errRELPFrame
+		}
+		b = append(b, c)
+	}
+}
+
+// readRELPFrame reads one frame whose data may not exceed limit bytes.
+func readRELPFrame(r *bufio.Reader, limit int) (relpFrame, error) {
+	var f relpFrame
+	tok, delim, err := readRELPToken(r)
+	if err != nil {
+		return f, err
+	}
+	if delim != ' ' || !isDigits(tok) {
+		return f, errRELPFrame
+	}
+	if f.txnr, err = strconv.Atoi(tok); err != nil || f.txnr > relpMaxTxnr {
+		return f, errRELPFrame
+	}
+	if f.command, delim, err = readRELPToken(r); err != nil {
+		return f, err
+	}
+	if delim != ' ' || f.command == "" {
+		return f, errRELPFrame
+	}
+	if tok, delim, err = readRELPToken(r); err != nil {
+		return f, err
+	}
+	if !isDigits(tok) {
+		return f, errRELPFrame
+	}
+	n, err := strconv.Atoi(tok)
+	if err != nil {
+		return f, errRELPFrame
+	}
+	if n > limit {
+		return f, errRELPTooLarge
+	}
+	if delim == '\n' {
+		if n != 0 {
+			return f, errRELPFrame
+		}
+		return f, nil
+	}
+	f.data = make([]byte, n)
+	if _, err := io.ReadFull(r, f.data); err != nil {
+		return f, err
+	}
+	if c, err := r.ReadByte(); err != nil || c != '\n' {
+		return f, errRELPFrame
+	}
+	return f, nil
+}
+
+func writeRELPFrame(w io.Writer, txnr int, command, data string) error {
+	if data == "" {
+		_, err := fmt.Fprintf(w, "%d %s 0\n", txnr, command)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d %s %d %s\n", txnr, command, len(data), data)
+	return err
+}
@@ -0,0 +1,181 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type relpTestClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRELP(t *testing.T, src *RELPSource) *relpTestClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", src.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &relpTestClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *relpTestClient) send(txnr int, command, data string) {
+	c.t.Helper()
+	if err := writeRELPFrame(c.conn, txnr, command, data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *relpTestClient) recv() relpFrame {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	f, err := readRELPFrame(c.r, 1<<20)
+	if err != nil {
+		c.t.Fatalf("reading response: %v", err)
+	}
+	return f
+}
+
+func (c *relpTestClient) open() {
+	c.t.Helper()
+	c.send(1, "open", "relp_version=0\nrelp_software=test\ncommands=syslog")
+	f := c.recv()
+	if f.txnr != 1 || f.command != "rsp" || !bytes.HasPrefix(f.data, []byte("200 OK\n")) {
+		c.t.Fatalf("open response %d %s %q", f.txnr, f.command, f.data)
+	}
+	if !strings.Contains(string(f.data), "commands=syslog") {
+		c.t.Errorf("open response does not offer syslog: %q", f.data)
+	}
+}
+
+func startRELP(t *testing.T) (*RELPSource, *EventNotifier) {
+	t.Helper()
+	src := NewRELPSource(RELPConfig{Addr: "127.0.0.1:0"})
+	n := NewEventNotifier(context.Background())
+	if err := n.AddSource("relp", src); err != nil {
+		t.Fatal(err)
+	}
+	return src, n
+}
+
+func TestReadRELPFrame(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("1 open 5 a=b\nc\n2 close 0\n3 rsp 0 \n"))
+	for _, want := range []relpFrame{{1, "open", []byte("a=b\nc")}, {2, "close", nil}, {3, "rsp", []byte{}}} {
+		f, err := readRELPFrame(r, 100)
+		if err != nil || f.txnr != want.txnr || f.command != want.command || string(f.data) != string(want.data) {
+			t.Fatalf("frame = %+v, %v; want %+v", f, err, want)
+		}
+	}
+	for _, in := range []string{"x open 0\n", "1 open 5 abc\n", "1000000000 open 0\n"} {
+		if _, err := readRELPFrame(bufio.NewReader(strings.NewReader(in)), 100); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+	if _, err := readRELPFrame(bufio.NewReader(strings.NewReader("1 syslog 500 x")), 100); err != errRELPTooLarge {
+		t.Errorf("oversized frame error = %v", err)
+	}
+}
+
+func TestRELPSession(t *testing.T) {
+	src, n := startRELP(t)
+	defer n.Close()
+	c := dialRELP(t, src)
+	defer c.conn.Close()
+	c.open()
+
+	// A window of pipelined messages is acknowledged in order.
+	for i := 2; i <= 6; i++ {
+		c.send(i, "syslog", "<13>Mar 10 11:00:00 host app: message "+string(rune('0'+i)))
+	}
+	for i := 2; i <= 6; i++ {
+		e := waitSyslogEntry(t, n)
+		if want := "message " + string(rune('0'+i)); e.Event.Message != want {
+			t.Errorf("message = %q, want %q", e.Event.Message, want)
+		}
+		if f := c.recv(); f.txnr != i || string(f.data) != relpRspOK {
+			t.Errorf("response %d %q, want %d %q", f.txnr, f.data, i, relpRspOK)
+		}
+	}
+
+	c.send(7, "close", "")
+	if f := c.recv(); f.txnr != 7 || f.command != "rsp" || len(f.data) != 0 {
+		t.Errorf("close response %d %s %q", f.txnr, f.command, f.data)
+	}
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Errorf("connection not closed after close: %v", err)
+	}
+}
+
+func TestRELPAckAfterDelivery(t *testing.T) {
+	src, n := startRELP(t)
+	defer n.Close()
+	c := dialRELP(t, src)
+	defer c.conn.Close()
+	c.open()
+
+	c.send(2, "syslog", "<13>held back")
+	c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Fatal("response sent before the event was delivered")
+	}
+	waitSyslogEntry(t, n)
+	if f := c.recv(); f.txnr != 2 || string(f.data) != relpRspOK {
+		t.Fatalf("response %d %q", f.txnr, f.data)
+	}
+
+	// Events that cannot be delivered are never acknowledged.
+	c.send(3, "syslog", "<13>lost")
+	time.Sleep(50 * time.Millisecond)
+	if err := n.RemoveWatcher("relp"); err != nil {
+		t.Fatal(err)
+	}
+	f := c.recv()
+	if f.command != "serverclose" {
+		t.Fatalf("got %d %s %q, want serverclose", f.txnr, f.command, f.data)
+	}
+}
+
+func TestRELPProtocolErrors(t *testing.T) {
+	src, n := startRELP(t)
+	defer n.Close()
+
+	c := dialRELP(t, src)
+	defer c.conn.Close()
+	c.send(1, "syslog", "<13>before open")
+	if f := c.recv(); f.txnr != 1 || !strings.HasPrefix(string(f.data), "500") {
+		t.Errorf("syslog before open answered %d %q", f.txnr, f.data)
+	}
+	if f := c.recv(); f.command != "serverclose" {
+		t.Errorf("got %s, want serverclose", f.command)
+	}
+
+	c = dialRELP(t, src)
+	defer c.conn.Close()
+	c.open()
+	c.send(3, "syslog", "<13>skipped txnr")
+	if f := c.recv(); f.txnr != 3 || !strings.HasPrefix(string(f.data), "500") {
+		t.Errorf("out of sequence txnr answered %d %q", f.txnr, f.data)
+	}
+
+	c = dialRELP(t, src)
+	defer c.conn.Close()
+	c.send(1, "open", "relp_version=0\ncommands=other")
+	if f := c.recv(); !strings.HasPrefix(string(f.data), "500") {
+		t.Errorf("open without syslog answered %q", f.data)
+	}
+}
+
+func TestRELPTxnrWrap(t *testing.T) {
+	ss := relpSession{open: true, last: relpMaxTxnr}
+	deliver := func([]byte) error { return nil }
+	if rsp, ok := ss.handle(relpFrame{txnr: 1, command: "syslog", data: []byte("x")}, deliver); !ok || rsp != relpRspOK {
+		t.Fatalf("wrapped txnr answered %q, %v", rsp, ok)
+	}
+}
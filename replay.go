@@ -0,0 +1,196 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ReplayRecord is a line of a recording: an entry and when it was emitted.
+type ReplayRecord struct {
+	At    time.Time   `json:"at"`
+	Entry *EventEntry `json:"entry"`
+}
+
+// EventRecorder writes the entries received from EventLogChannel to a
+// recording, one JSON encoded ReplayRecord per line, for ReplaySource to
+// play back.
+type EventRecorder struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewEventRecorder creates an EventRecorder writing to w.
+func NewEventRecorder(w io.Writer) *EventRecorder {
+	return &EventRecorder{w: w}
+}
+
+// Record writes entry, stamped with the current time.
+func (r *EventRecorder) Record(entry *EventEntry) error {
+	b, err := json.Marshal(ReplayRecord{At: time.Now(), Entry: entry})
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.w.Write(append(b, '\n')); err != nil {
+		if r.err == nil {
+			r.err = err
+		}
+		return err
+	}
+	return nil
+}
+
+// Tee records the entries received from in and passes them on to the
+// returned channel, which is closed once in is closed or ctx is done. Write
+// errors do not stop it; Err reports the first one.
+func (r *EventRecorder) Tee(ctx context.Context, in <-chan *EventEntry) <-chan *EventEntry {
+	out := make(chan *EventEntry)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case entry, ok := <-in:
+				if !ok {
+					return
+				}
+				r.Record(entry)
+				select {
+				case out <- entry:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Err returns the first error writing the recording.
+func (r *EventRecorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// ReplayConfig configures a ReplaySource.
+type ReplayConfig struct {
+	// Path of the recording written by an EventRecorder.
+	Path string
+	// Speed multiplies the pace of the recording, e.g. 10 replays it ten
+	// times faster; it defaults to 1, the original timing.
+	Speed float64
+	// AsFastAsPossible emits the entries without waiting between them.
+	AsFastAsPossible bool
+	// Loop starts over at the end of the recording.
+	Loop bool
+	// ShiftTime moves the TimeCreated of the events by the time elapsed
+	// since they were recorded, so that they appear to happen now.
+	ShiftTime bool
+}
+
+// ReplaySource is a Source playing back a recording made with an
+// EventRecorder, for reproducing incidents and testing rules. Entries keep
+// the name they were recorded with. Lines that cannot be decoded are
+// skipped. Without Loop, the source stops at the end of the recording.
+type ReplaySource struct {
+	cfg  ReplayConfig
+	file *os.File
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewReplaySource creates a ReplaySource.
+func NewReplaySource(cfg ReplayConfig) *ReplaySource {
+	if cfg.Speed <= 0 {
+		cfg.Speed = 1
+	}
+	return &ReplaySource{cfg: cfg, done: make(chan struct{})}
+}
+
+// Init opens the recording.
+func (s *ReplaySource) Init() error {
+	f, err := os.Open(s.cfg.Path)
+	if err != nil {
+		return err
+	}
+	s.file = f
+	return nil
+}
+
+// Listen emits the recorded entries until the end of the recording, ctx
+// is done or Close is called.
+func (s *ReplaySource) Listen(ctx context.Context, emit EmitFunc) {
+	defer s.Close()
+	for {
+		n, ok := s.play(ctx, emit)
+		if !ok || n == 0 || !s.cfg.Loop {
+			return
+		}
+		if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+			return
+		}
+	}
+}
+
+// play replays the recording once and returns the number of entries
+// emitted and whether to go on.
+func (s *ReplaySource) play(ctx context.Context, emit EmitFunc) (int, bool) {
+	r := bufio.NewReader(s.file)
+	start := time.Now()
+	var first time.Time
+	n := 0
+	for {
+		line, err := r.ReadBytes('\n')
+		var rec ReplayRecord
+		if len(line) > 0 && json.Unmarshal(line, &rec) == nil && rec.Entry != nil {
+			if n == 0 {
+				first = rec.At
+			}
+			if !s.cfg.AsFastAsPossible {
+				offset := time.Duration(float64(rec.At.Sub(first)) / s.cfg.Speed)
+				if wait := time.Until(start.Add(offset)); wait > 0 {
+					timer := time.NewTimer(wait)
+					select {
+					case <-ctx.Done():
+						timer.Stop()
+						return n, false
+					case <-s.done:
+						timer.Stop()
+						return n, false
+					case <-timer.C:
+					}
+				}
+			}
+			if s.cfg.ShiftTime && rec.Entry.Event != nil && !rec.At.IsZero() {
+				rec.Entry.Event.TimeCreated = rec.Entry.Event.TimeCreated.Add(time.Since(rec.At))
+			}
+			if emit(ctx, rec.Entry) != nil {
+				return n, false
+			}
+			n++
+		}
+		if err != nil {
+			return n, err == io.EOF
+		}
+	}
+}
+
+// Close stops the replay.
+func (s *ReplaySource) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.file != nil {
+			s.file.Close()
+		}
+	})
+}
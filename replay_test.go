@@ -0,0 +1,107 @@
+package eventwatcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEventRecorderTee(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewEventRecorder(&buf)
+	in := make(chan *EventEntry, 2)
+	in <- &EventEntry{Name: "app", Buffer: []byte("raw")}
+	in <- &EventEntry{Name: "syslog", Event: &Event{Provider: "sshd", Message: "accepted"}}
+	close(in)
+	var got []*EventEntry
+	for e := range rec.Tee(context.Background(), in) {
+		got = append(got, e)
+	}
+	if len(got) != 2 || rec.Err() != nil {
+		t.Fatalf("got %d entries, err %v", len(got), rec.Err())
+	}
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("recorded %q", buf.String())
+	}
+	var r ReplayRecord
+	if err := json.Unmarshal(lines[1], &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.At.IsZero() || r.Entry.Name != "syslog" || r.Entry.Event.Message != "accepted" {
+		t.Fatalf("got %+v", r)
+	}
+}
+
+// writeRecording writes entries recorded at the given offsets from base.
+func writeRecording(t *testing.T, base time.Time, offsets ...time.Duration) string {
+	t.Helper()
+	var buf bytes.Buffer
+	for i, off := range offsets {
+		b, _ := json.Marshal(ReplayRecord{At: base.Add(off), Entry: &EventEntry{
+			Name:  "recorded",
+			Event: &Event{Provider: "app", EventID: uint32(i), TimeCreated: base.Add(off), Message: "event"},
+		}})
+		buf.Write(append(b, '\n'))
+		if i == 0 {
+			buf.WriteString("not json\n")
+		}
+	}
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReplaySourceTiming(t *testing.T) {
+	base := time.Now().Add(-time.Hour)
+	path := writeRecording(t, base, 0, 2*time.Second, 4*time.Second)
+	for _, tt := range []struct {
+		cfg      ReplayConfig
+		min, max time.Duration
+	}{
+		{ReplayConfig{Speed: 20}, 180 * time.Millisecond, 1500 * time.Millisecond},
+		{ReplayConfig{AsFastAsPossible: true}, 0, 150 * time.Millisecond},
+	} {
+		tt.cfg.Path = path
+		n := NewEventNotifier(context.Background())
+		start := time.Now()
+		if err := n.AddSource("replay", NewReplaySource(tt.cfg)); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			e := waitSyslogEntry(t, n)
+			if e.Name != "recorded" || e.Event.EventID != uint32(i) {
+				t.Fatalf("entry %d: got %+v", i, e)
+			}
+		}
+		if d := time.Since(start); d < tt.min || d > tt.max {
+			t.Fatalf("%+v: replay took %v", tt.cfg, d)
+		}
+		n.Close()
+	}
+}
+
+func TestReplaySourceLoopShift(t *testing.T) {
+	base := time.Now().Add(-time.Hour)
+	path := writeRecording(t, base, 0, time.Millisecond)
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("replay", NewReplaySource(ReplayConfig{Path: path, Loop: true, ShiftTime: true})); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		e := waitSyslogEntry(t, n)
+		if e.Event.EventID != uint32(i%2) {
+			t.Fatalf("entry %d: event %d", i, e.Event.EventID)
+		}
+		if d := time.Since(e.Event.TimeCreated); d < 0 || d > time.Second {
+			t.Fatalf("time not shifted: %v", e.Event.TimeCreated)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package eventwatcher
+
+import (
+	"context"
+	"time"
+)
+
+// Source is an event producer, such as a network listener, that an
+// EventNotifier hosts next to its EventWatchers. Register one with
+// EventNotifier.AddSource.
+type Source interface {
+	// Init prepares the source, e.g. binds its sockets. AddSource calls it
+	// once before Listen and reports its error.
+	Init() error
+	// Listen produces events until ctx is done or Close is called, passing
+	// each of them to emit.
+	Listen(ctx context.Context, emit EmitFunc)
+	// Close stops the source and releases its resources.
+	Close()
+}
+
+// EmitFunc delivers an entry to the EventLogChannel of the notifier hosting
+// a Source. It blocks until the entry has been received, ctx is done or the
+// notifier is closed, and returns nil only once the entry was delivered.
+type EmitFunc func(ctx context.Context, entry *EventEntry) error
+
+// lineEvent converts a line of a plain text stream to an Event logged now.
+// The level is detected from the line and is def when none is found.
+func lineEvent(provider, line string, def Level) *Event {
+	ev := &Event{
+		Provider:    provider,
+		Level:       DetectLevel(line),
+		TimeCreated: time.Now(),
+		Message:     line,
+	}
+	if ev.Level == LevelUnknown {
+		ev.Level = def
+	}
+	return ev
+}
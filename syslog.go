@@ -0,0 +1,364 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+// DefaultSyslogMessageSize is the default maximum size of a syslog message.
+// Longer TCP messages are truncated; longer datagrams are cut off by the
+// read buffer.
+const DefaultSyslogMessageSize = 64 * 1024
+
+var errSyslogFrame = errors.New("syslog: invalid octet count")
+
+// SyslogConfig configures a SyslogSource. Listeners whose address is empty
+// are not started.
+type SyslogConfig struct {
+	// UDPAddr is the UDP address to listen on, e.g. ":514".
+	UDPAddr string
+	// TCPAddr is the TCP address to listen on, e.g. ":514". Both LF
+	// terminated and octet-counted (RFC 6587) framing are accepted.
+	TCPAddr string
+	// TLS configures an RFC 5425 syslog-over-TLS listener; nil disables it.
+	TLS *SyslogTLSConfig
+	// UnixPath is the path of a Unix datagram socket, e.g. "/dev/log". A
+	// stale socket at the path is replaced, and the socket is removed again
+	// by Close.
+	UnixPath string
+	// MaxMessageSize defaults to DefaultSyslogMessageSize.
+	MaxMessageSize int
+	// Parser parses received messages.
+	Parser SyslogParser
+}
+
+// SyslogSource is a Source that receives syslog messages over the network
+// and emits them with Event set from the parsed message. The remote address
+// of network messages is stored in the "peer" data item, and the identity
+// of TLS clients in the "tls.*" items.
+type SyslogSource struct {
+	cfg SyslogConfig
+
+	udp  net.PacketConn
+	unix net.PacketConn
+	tcp  net.Listener
+	tls  *syslogTLS
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewSyslogSource creates a SyslogSource; its sockets are bound by Init.
+func NewSyslogSource(cfg SyslogConfig) *SyslogSource {
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = DefaultSyslogMessageSize
+	}
+	return &SyslogSource{
+		cfg:   cfg,
+		conns: make(map[net.Conn]struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Init binds the configured listeners.
+func (s *SyslogSource) Init() error {
+	if s.cfg.UDPAddr == "" && s.cfg.TCPAddr == "" && s.cfg.TLS == nil && s.cfg.UnixPath == "" {
+		return errors.New("syslog: no listen address configured")
+	}
+	var err error
+	if s.cfg.UDPAddr != "" {
+		if s.udp, err = net.ListenPacket("udp", s.cfg.UDPAddr); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	if s.cfg.TCPAddr != "" {
+		if s.tcp, err = net.Listen("tcp", s.cfg.TCPAddr); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	if s.cfg.TLS != nil {
+		if s.tls, err = listenSyslogTLS(s.cfg.TLS); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	if s.cfg.UnixPath != "" {
+		if err = removeStaleSocket(s.cfg.UnixPath); err != nil {
+			s.Close()
+			return err
+		}
+		if s.unix, err = net.ListenPacket("unixgram", s.cfg.UnixPath); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStaleSocket removes a socket left at path by a previous process.
+// Any other kind of file is left alone.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
+
+// UDPAddr returns the bound UDP address, or nil.
+func (s *SyslogSource) UDPAddr() net.Addr {
+	if s.udp == nil {
+		return nil
+	}
+	return s.udp.LocalAddr()
+}
+
+// TCPAddr returns the bound TCP address, or nil.
+func (s *SyslogSource) TCPAddr() net.Addr {
+	if s.tcp == nil {
+		return nil
+	}
+	return s.tcp.Addr()
+}
+
+// Listen receives messages until ctx is done or Close is called.
+func (s *SyslogSource) Listen(ctx context.Context, emit EmitFunc) {
+	if s.udp != nil {
+		s.wg.Add(1)
+		go s.readPackets(ctx, s.udp, emit, true)
+	}
+	if s.unix != nil {
+		s.wg.Add(1)
+		go s.readPackets(ctx, s.unix, emit, false)
+	}
+	if s.tcp != nil {
+		s.wg.Add(1)
+		go s.accept(ctx, s.tcp, emit)
+	}
+	if s.tls != nil {
+		s.wg.Add(1)
+		go s.accept(ctx, s.tls.listener, emit)
+	}
+	select {
+	case <-ctx.Done():
+	case <-s.done:
+	}
+	s.Close()
+	s.wg.Wait()
+}
+
+// Close closes all listeners and connections.
+func (s *SyslogSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	if s.tcp != nil {
+		s.tcp.Close()
+	}
+	if s.tls != nil {
+		s.tls.close()
+	}
+	if s.unix != nil {
+		s.unix.Close()
+		os.Remove(s.cfg.UnixPath)
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// readPackets emits one message per datagram read from pc.
+func (s *SyslogSource) readPackets(ctx context.Context, pc net.PacketConn, emit EmitFunc, withPeer bool) {
+	defer s.wg.Done()
+	buf := make([]byte, s.cfg.MaxMessageSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		var peer []EventData
+		if withPeer && addr != nil {
+			peer = []EventData{{Name: "peer", Value: addr.String()}}
+		}
+		if err := s.emitMessage(ctx, emit, buf[:n], peer); err != nil {
+			return
+		}
+	}
+}
+
+// accept serves the connections of ln until it is closed.
+func (s *SyslogSource) accept(ctx context.Context, ln net.Listener, emit EmitFunc) {
+	defer s.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return
+		}
+		s.wg.Add(1)
+		go s.serveConn(ctx, conn, emit)
+	}
+}
+
+// track registers conn so Close can interrupt it, and reports false when
+// the source is already closed.
+func (s *SyslogSource) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *SyslogSource) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+// serveConn emits the messages framed on conn until it is closed or a
+// frame is invalid. TLS connections are closed when the handshake fails.
+func (s *SyslogSource) serveConn(ctx context.Context, conn net.Conn, emit EmitFunc) {
+	defer s.wg.Done()
+	defer s.untrack(conn)
+	peer := []EventData{{Name: "peer", Value: conn.RemoteAddr().String()}}
+	if tc, ok := conn.(*tls.Conn); ok {
+		identity, err := handshakeSyslogTLS(tc)
+		if err != nil {
+			return
+		}
+		peer = append(peer, identity...)
+	}
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := readSyslogFrame(r, s.cfg.MaxMessageSize)
+		if len(msg) > 0 {
+			if err := s.emitMessage(ctx, emit, msg, peer); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readSyslogFrame reads one RFC 6587 frame from r. Frames starting with a
+// digit are octet-counted ("LEN SP MSG"); any other frame ends at LF. LF
+// terminated messages longer than limit are truncated.
+func readSyslogFrame(r *bufio.Reader, limit int) ([]byte, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if c >= '1' && c <= '9' {
+		n := int(c - '0')
+		for {
+			if c, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+			if c == ' ' {
+				break
+			}
+			if c < '0' || c > '9' || n > limit {
+				return nil, errSyslogFrame
+			}
+			n = n*10 + int(c-'0')
+		}
+		if n > limit {
+			return nil, errSyslogFrame
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+	if err := r.UnreadByte(); err != nil {
+		return nil, err
+	}
+	return readLimitedLine(r, limit)
+}
+
+// readLimitedLine reads up to and including the next LF, keeping at most
+// limit bytes of longer lines.
+func readLimitedLine(r *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		b, err := r.ReadSlice('\n')
+		if room := limit - len(line); room > 0 {
+			if len(b) > room {
+				b = b[:room]
+			}
+			line = append(line, b...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return line, err
+	}
+}
+
+func (s *SyslogSource) emitMessage(ctx context.Context, emit EmitFunc, msg []byte, peer []EventData) error {
+	return emitSyslog(ctx, emit, &s.cfg.Parser, msg, peer)
+}
+
+// emitSyslog parses msg with p and emits it with the peer data items added.
+// A message that cannot be parsed is emitted as a plain line with a
+// "parse_error" data item; empty messages are dropped. The returned error
+// is that of emit.
+func emitSyslog(ctx context.Context, emit EmitFunc, p *SyslogParser, msg []byte, peer []EventData) error {
+	var ev *Event
+	m, err := p.Parse(msg)
+	switch {
+	case err == errSyslogEmpty:
+		return nil
+	case err != nil:
+		ev = lineEvent("syslog", string(bytes.TrimRight(msg, "\r\n\x00")), LevelInfo)
+		ev.SetField("parse_error", err.Error())
+	default:
+		ev = m.Event()
+	}
+	for _, d := range peer {
+		ev.SetField(d.Name, d.Value)
+	}
+	buf := make([]byte, len(msg))
+	copy(buf, msg)
+	return emit(ctx, &EventEntry{Buffer: buf, Event: ev})
+}
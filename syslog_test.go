@@ -0,0 +1,336 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+var syslogTestNow = time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+func testSyslogParser() *SyslogParser {
+	return &SyslogParser{Location: time.UTC, Now: func() time.Time { return syslogTestNow }}
+}
+
+func TestParseSyslog5424(t *testing.T) {
+	msg := `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog 1234 ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high \"quoted\" \]"] ` + "\ufeff" + `An application event log entry...`
+	m, err := testSyslogParser().Parse([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != 1 || m.Facility != 20 || m.Severity != 5 {
+		t.Errorf("version/facility/severity = %d/%d/%d", m.Version, m.Facility, m.Severity)
+	}
+	if want := time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC); !m.Timestamp.Equal(want) {
+		t.Errorf("timestamp = %v, want %v", m.Timestamp, want)
+	}
+	if m.Hostname != "mymachine.example.com" || m.AppName != "evntslog" || m.ProcID != "1234" || m.MsgID != "ID47" {
+		t.Errorf("header = %q %q %q %q", m.Hostname, m.AppName, m.ProcID, m.MsgID)
+	}
+	if m.Message != "An application event log entry..." {
+		t.Errorf("message = %q", m.Message)
+	}
+	if len(m.StructuredData) != 2 || len(m.StructuredData[0].Params) != 3 {
+		t.Fatalf("structured data = %+v", m.StructuredData)
+	}
+	if p := m.StructuredData[1].Params[0]; p.Name != "class" || p.Value != `high "quoted" ]` {
+		t.Errorf("escaped param = %+v", p)
+	}
+
+	ev := m.Event()
+	checks := map[string]string{
+		"facility":                  "local4",
+		"severity":                  "notice",
+		"procid":                    "1234",
+		"msgid":                     "ID47",
+		"exampleSDID@32473.eventID": "1011",
+	}
+	for name, want := range checks {
+		if got, _ := ev.Field(name); got != want {
+			t.Errorf("field %s = %q, want %q", name, got, want)
+		}
+	}
+	if ev.Provider != "evntslog" || ev.Computer != "mymachine.example.com" || ev.ProcessID != 1234 || ev.Level != LevelNotice {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
+
+func TestParseSyslog5424Nil(t *testing.T) {
+	m, err := testSyslogParser().Parse([]byte("<34>1 - - - - - -"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != 1 || m.Hostname != "" || m.AppName != "" || m.Message != "" || m.StructuredData != nil {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if !m.Timestamp.Equal(syslogTestNow) {
+		t.Errorf("timestamp = %v, want receive time", m.Timestamp)
+	}
+}
+
+func TestParseSyslog3164(t *testing.T) {
+	tests := []struct {
+		in                  string
+		ts                  time.Time
+		host, app, pid, msg string
+		facility, severity  int
+	}{
+		{
+			in: "<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8",
+			ts: time.Date(2023, 10, 11, 22, 14, 15, 0, time.UTC), host: "mymachine", app: "su",
+			msg: "'su root' failed for lonvick on /dev/pts/8", facility: 4, severity: 2,
+		},
+		{
+			in: "<13>Mar  1 08:00:01 web01 CRON[4242]: (root) CMD (run-parts)\n",
+			ts: time.Date(2024, 3, 1, 8, 0, 1, 0, time.UTC), host: "web01", app: "CRON", pid: "4242",
+			msg: "(root) CMD (run-parts)", facility: 1, severity: 5,
+		},
+		{
+			// Local /dev/log senders omit the hostname.
+			in: "<38>Mar 10 11:59:00 sshd[99]: Accepted publickey",
+			ts: time.Date(2024, 3, 10, 11, 59, 0, 0, time.UTC), app: "sshd", pid: "99",
+			msg: "Accepted publickey", facility: 4, severity: 6,
+		},
+		{
+			in: "<30>2024-03-10T11:00:00.5+01:00 host1 app: high precision",
+			ts: time.Date(2024, 3, 10, 10, 0, 0, 500000000, time.UTC), host: "host1", app: "app",
+			msg: "high precision", facility: 3, severity: 6,
+		},
+		{
+			// No PRI, no timestamp, no tag.
+			in: "just some text",
+			ts: syslogTestNow, msg: "just some text", facility: 1, severity: 5,
+		},
+	}
+	for _, tt := range tests {
+		m, err := testSyslogParser().Parse([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+		if m.Version != 0 || m.Facility != tt.facility || m.Severity != tt.severity {
+			t.Errorf("%q: version/facility/severity = %d/%d/%d", tt.in, m.Version, m.Facility, m.Severity)
+		}
+		if !m.Timestamp.Equal(tt.ts) {
+			t.Errorf("%q: timestamp = %v, want %v", tt.in, m.Timestamp, tt.ts)
+		}
+		if m.Hostname != tt.host || m.AppName != tt.app || m.ProcID != tt.pid || m.Message != tt.msg {
+			t.Errorf("%q: got host %q app %q pid %q msg %q", tt.in, m.Hostname, m.AppName, m.ProcID, m.Message)
+		}
+	}
+}
+
+func TestParseSyslogYearRollover(t *testing.T) {
+	p := &SyslogParser{Location: time.UTC, Now: func() time.Time {
+		return time.Date(2024, time.January, 1, 0, 0, 30, 0, time.UTC)
+	}}
+	m, err := p.Parse([]byte("<13>Dec 31 23:59:59 host app: late"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Timestamp.Year() != 2023 {
+		t.Errorf("timestamp = %v, want 2023", m.Timestamp)
+	}
+
+	// Feb 29 is only valid in leap years and is never moved to Mar 1.
+	for _, tt := range []struct {
+		now, want time.Time
+	}{
+		{time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, time.February, 29, 12, 0, 0, 0, time.UTC)},
+		{time.Date(2025, time.January, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, time.February, 29, 12, 0, 0, 0, time.UTC)},
+		{time.Date(2023, time.March, 10, 0, 0, 0, 0, time.UTC), time.Date(2023, time.March, 10, 0, 0, 0, 0, time.UTC)},
+	} {
+		p.Now = func() time.Time { return tt.now }
+		m, err := p.Parse([]byte("<13>Feb 29 12:00:00 host app: leap"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !m.Timestamp.Equal(tt.want) {
+			t.Errorf("now %v: timestamp = %v, want %v", tt.now, m.Timestamp, tt.want)
+		}
+	}
+}
+
+func TestSyslogParseLine(t *testing.T) {
+	berlin := time.FixedZone("CET", 3600)
+	p := &SyslogParser{Location: berlin, Now: func() time.Time { return syslogTestNow }, Year: 2021}
+	tests := []struct {
+		in      string
+		ts      time.Time
+		host    string
+		program string
+		pid     uint32
+		level   Level
+		msg     string
+	}{
+		{"Mar  1 08:15:02 web1 sshd[4242]: Accepted publickey for bob\n", time.Date(2021, 3, 1, 8, 15, 2, 0, berlin), "web1", "sshd", 4242, LevelInfo, "Accepted publickey for bob"},
+		{"Dec 31 23:59:59 web1 kernel: [ 1.5] eth0: link becomes ready", time.Date(2021, 12, 31, 23, 59, 59, 0, berlin), "web1", "kernel", 0, LevelInfo, "[ 1.5] eth0: link becomes ready"},
+		{"2024-03-01T08:15:02.123456+02:00 db2 postgres[77]: ERROR: relation does not exist", time.Date(2024, 3, 1, 6, 15, 2, 123456000, time.UTC), "db2", "postgres", 77, LevelError, "ERROR: relation does not exist"},
+		{"1 2024-03-01T08:15:02Z db2 app 12 - - started", time.Date(2024, 3, 1, 8, 15, 2, 0, time.UTC), "db2", "app", 12, LevelInfo, "started"},
+		{"<11>Mar  1 08:15:02 web1 cron[1]: failed", time.Date(2021, 3, 1, 8, 15, 2, 0, berlin), "web1", "cron", 1, LevelError, "failed"},
+		{"Feb 29 08:15:02 web1 cron[1]: leap", syslogTestNow, "", "", 0, LevelInfo, "Feb 29 08:15:02 web1 cron[1]: leap"},
+	}
+	for _, tt := range tests {
+		ev, err := p.ParseLine([]byte(tt.in))
+		if err != nil {
+			t.Fatalf("%q: %v", tt.in, err)
+		}
+		if !ev.TimeCreated.Equal(tt.ts) {
+			t.Errorf("%q: time %v, want %v", tt.in, ev.TimeCreated, tt.ts)
+		}
+		if ev.Computer != tt.host || ev.Provider != tt.program || ev.ProcessID != tt.pid || ev.Level != tt.level || ev.Message != tt.msg {
+			t.Errorf("%q: got host %q program %q pid %d level %v msg %q", tt.in, ev.Computer, ev.Provider, ev.ProcessID, ev.Level, ev.Message)
+		}
+	}
+	// Without PRI there is no facility or severity to report.
+	ev, _ := p.ParseLine([]byte(tests[0].in))
+	if _, ok := ev.Field("facility"); ok {
+		t.Error("facility of a line without PRI")
+	}
+	if _, err := p.ParseLine([]byte(" \n")); err == nil {
+		t.Error("parsed blank line")
+	}
+}
+
+func TestSyslogFileParser(t *testing.T) {
+	p := SyslogFileParser{Parser: *testSyslogParser()}
+	log := "Mar  9 10:00:00 host app[1]: one\nMar  9 10:00:01 host app[1]: two\nMar  9 10:00:02 host app[1]: th"
+	events := p.ParseEntry(&EventEntry{Name: "/var/log/syslog", Buffer: []byte(log)})
+	if len(events) != 2 || events[1].Message != "two" || events[0].Channel != "/var/log/syslog" {
+		t.Fatalf("got %+v", events)
+	}
+	if want := time.Date(2024, 3, 9, 10, 0, 0, 0, time.UTC); !events[0].TimeCreated.Equal(want) {
+		t.Fatalf("time %v", events[0].TimeCreated)
+	}
+	events = p.ParseEntry(&EventEntry{Name: "/var/log/syslog", Buffer: []byte(log + "ree\n")})
+	if len(events) != 1 || events[0].Message != "three" {
+		t.Fatalf("got %+v", events)
+	}
+	// After rotation the new file is read from its start.
+	events = p.ParseEntry(&EventEntry{Name: "/var/log/syslog", Buffer: []byte("Mar  9 11:00:00 host app[1]: new\n")})
+	if len(events) != 1 || events[0].Message != "new" {
+		t.Fatalf("got %+v", events)
+	}
+}
+
+func TestParseSyslogErrors(t *testing.T) {
+	for _, in := range []string{"", "\n", "<>x", "<192>x", "<1a>x"} {
+		if _, err := ParseSyslog([]byte(in)); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+	// Malformed RFC 5424 messages fall back to BSD parsing.
+	m, err := ParseSyslog([]byte("<13>1 not-a-time host app - - - msg"))
+	if err != nil || m.Version != 0 {
+		t.Errorf("fallback = %+v, %v", m, err)
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	in := "<13>first\n16 <13>second\nsplit<13>third\r\n"
+	r := bufio.NewReader(strings.NewReader(in))
+	for _, want := range []string{"<13>first\n", "<13>second\nsplit", "<13>third\r\n"} {
+		got, err := readSyslogFrame(r, 1024)
+		if err != nil || string(got) != want {
+			t.Fatalf("frame = %q, %v; want %q", got, err, want)
+		}
+	}
+	if _, err := readSyslogFrame(bufio.NewReader(strings.NewReader("99999 x")), 1024); err != errSyslogFrame {
+		t.Errorf("oversized frame error = %v", err)
+	}
+	long := strings.Repeat("x", 100) + "\n"
+	got, err := readSyslogFrame(bufio.NewReader(strings.NewReader(long)), 10)
+	if err != nil || string(got) != strings.Repeat("x", 10) {
+		t.Errorf("truncated frame = %q, %v", got, err)
+	}
+}
+
+func waitSyslogEntry(t *testing.T, n *EventNotifier) *EventEntry {
+	t.Helper()
+	select {
+	case e := <-n.EventLogChannel:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for syslog event")
+	}
+	return nil
+}
+
+func TestSyslogSource(t *testing.T) {
+	cfg := SyslogConfig{UDPAddr: "127.0.0.1:0", TCPAddr: "127.0.0.1:0"}
+	if runtime.GOOS != "windows" {
+		cfg.UnixPath = filepath.Join(t.TempDir(), "log.sock")
+	}
+	src := NewSyslogSource(cfg)
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("syslog", src); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.AddSource("syslog", NewSyslogSource(cfg)); err == nil {
+		t.Error("expected duplicate source error")
+	}
+
+	udp, err := net.Dial("udp", src.UDPAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	udp.Write([]byte("<11>1 2024-03-10T12:00:00Z h1 app 7 M1 - over udp"))
+	e := waitSyslogEntry(t, n)
+	if e.Name != "syslog" || e.Event == nil || e.Event.Message != "over udp" || e.Event.Level != LevelError {
+		t.Fatalf("unexpected udp entry %+v", e)
+	}
+	if peer, _ := e.Event.Field("peer"); peer != udp.LocalAddr().String() {
+		t.Errorf("peer = %q, want %q", peer, udp.LocalAddr())
+	}
+	// A message with an invalid PRI is kept as a raw line.
+	udp.Write([]byte("<999> bad PRI\n"))
+	e = waitSyslogEntry(t, n)
+	if e.Event.Message != "<999> bad PRI" || string(e.Buffer) != "<999> bad PRI\n" || e.Event.Provider != "syslog" {
+		t.Fatalf("unexpected raw entry %+v", e.Event)
+	}
+	if v, _ := e.Event.Field("parse_error"); v != errSyslogPRI.Error() {
+		t.Errorf("parse_error = %q", v)
+	}
+	if peer, _ := e.Event.Field("peer"); peer != udp.LocalAddr().String() {
+		t.Errorf("raw peer = %q", peer)
+	}
+
+	tcp, err := net.Dial("tcp", src.TCPAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	tcp.Write([]byte("<13>Mar 10 11:00:00 h2 lf: newline framed\n23 <13>Mar 10 11:00:00 x y"))
+	for _, want := range []string{"newline framed", "y"} {
+		if e := waitSyslogEntry(t, n); e.Event.Message != want {
+			t.Errorf("tcp message = %q, want %q", e.Event.Message, want)
+		}
+	}
+
+	if cfg.UnixPath != "" {
+		ux, err := net.Dial("unixgram", cfg.UnixPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ux.Close()
+		ux.Write([]byte("<86>Mar 10 11:00:00 sudo[5]: local"))
+		e := waitSyslogEntry(t, n)
+		if e.Event.Message != "local" || e.Event.Provider != "sudo" || e.Event.ProcessID != 5 {
+			t.Errorf("unexpected unix entry %+v", e.Event)
+		}
+	}
+
+	if err := n.RemoveWatcher("syslog"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := net.Dial("tcp", src.TCPAddr().String()); err == nil {
+		t.Error("tcp listener still open after RemoveWatcher")
+	}
+}
@@ -0,0 +1,465 @@
+package eventwatcher
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyslogMessage is a parsed RFC 3164 or RFC 5424 syslog message.
+type SyslogMessage struct {
+	Facility int
+	Severity int
+	// Version is 1 for RFC 5424 messages and 0 for BSD (RFC 3164) ones.
+	Version        int
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData []SyslogSDElement
+	Message        string
+}
+
+// SyslogSDElement is an RFC 5424 structured data element.
+type SyslogSDElement struct {
+	ID     string
+	Params []EventData
+}
+
+var (
+	errSyslogEmpty = errors.New("syslog: empty message")
+	errSyslogPRI   = errors.New("syslog: invalid PRI")
+	errSyslogSD    = errors.New("syslog: malformed structured data")
+	errSyslog5424  = errors.New("syslog: malformed RFC 5424 header")
+)
+
+// syslogDefaultPRI is user.notice, which RFC 3164 assigns to messages that
+// arrive without a PRI part.
+const syslogDefaultPRI = 13
+
+var syslogFacilities = [...]string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverities = [...]string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// SyslogFacilityName returns the conventional name of a syslog facility
+// code, e.g. "auth" for 4, or its number when it has none.
+func SyslogFacilityName(facility int) string {
+	if facility >= 0 && facility < len(syslogFacilities) {
+		return syslogFacilities[facility]
+	}
+	return strconv.Itoa(facility)
+}
+
+// SyslogSeverityName returns the conventional name of a syslog severity,
+// e.g. "err" for 3, or its number when it has none.
+func SyslogSeverityName(severity int) string {
+	if severity >= 0 && severity < len(syslogSeverities) {
+		return syslogSeverities[severity]
+	}
+	return strconv.Itoa(severity)
+}
+
+// SyslogParser parses syslog messages. The zero value is ready to use.
+type SyslogParser struct {
+	// Location is applied to timestamps without a zone offset; nil means
+	// time.Local.
+	Location *time.Location
+	// Now returns the current time. It is used for BSD timestamps, which
+	// carry no year, and for messages without a timestamp. Nil means
+	// time.Now.
+	Now func() time.Time
+	// Year, when set, is given to BSD timestamps instead of the year
+	// inferred from Now, e.g. when reading a log file of a known year.
+	Year int
+}
+
+// ParseSyslog parses msg with a zero SyslogParser.
+func ParseSyslog(msg []byte) (*SyslogMessage, error) {
+	var p SyslogParser
+	return p.Parse(msg)
+}
+
+// Parse parses an RFC 5424 or RFC 3164 message. Trailing line breaks and NUL
+// bytes are ignored. Messages without a PRI part are given user.notice, and
+// messages that are not valid RFC 5424 are parsed as BSD messages, which
+// never fails: text that does not follow the BSD layout becomes Message.
+func (p *SyslogParser) Parse(msg []byte) (*SyslogMessage, error) {
+	msg = bytes.TrimRight(msg, "\r\n\x00")
+	if len(msg) == 0 {
+		return nil, errSyslogEmpty
+	}
+	pri, rest, err := parseSyslogPRI(string(msg))
+	if err != nil {
+		return nil, err
+	}
+	m := &SyslogMessage{Facility: pri / 8, Severity: pri % 8}
+	if strings.HasPrefix(rest, "1 ") {
+		if err := p.parse5424(m, rest[2:]); err == nil {
+			m.Version = 1
+			return m, nil
+		}
+		*m = SyslogMessage{Facility: pri / 8, Severity: pri % 8}
+	}
+	p.parse3164(m, rest)
+	return m, nil
+}
+
+func (p *SyslogParser) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+func (p *SyslogParser) location() *time.Location {
+	if p.Location != nil {
+		return p.Location
+	}
+	return time.Local
+}
+
+// parseSyslogPRI splits "<PRI>" off s.
+func parseSyslogPRI(s string) (int, string, error) {
+	if s[0] != '<' {
+		return syslogDefaultPRI, s, nil
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 || !isDigits(s[1:end]) {
+		return 0, "", errSyslogPRI
+	}
+	pri, _ := strconv.Atoi(s[1:end])
+	if pri > 191 {
+		return 0, "", errSyslogPRI
+	}
+	return pri, s[end+1:], nil
+}
+
+// nextField splits the next space-terminated field off s.
+func nextField(s string) (field, rest string, ok bool) {
+	i := strings.IndexByte(s, ' ')
+	if i <= 0 {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+// nilValue maps the RFC 5424 NILVALUE to the empty string.
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// parse5424 parses everything after "<PRI>1 ".
+func (p *SyslogParser) parse5424(m *SyslogMessage, s string) error {
+	var fields [5]string
+	for i := range fields {
+		var ok bool
+		if fields[i], s, ok = nextField(s); !ok {
+			return errSyslog5424
+		}
+	}
+	if fields[0] != "-" {
+		ts, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return errSyslog5424
+		}
+		m.Timestamp = ts
+	} else {
+		m.Timestamp = p.now()
+	}
+	m.Hostname = nilValue(fields[1])
+	m.AppName = nilValue(fields[2])
+	m.ProcID = nilValue(fields[3])
+	m.MsgID = nilValue(fields[4])
+
+	sd, s, err := parseStructuredData(s)
+	if err != nil {
+		return err
+	}
+	m.StructuredData = sd
+	switch {
+	case s == "":
+	case s[0] == ' ':
+		m.Message = strings.TrimPrefix(s[1:], "\ufeff")
+	default:
+		return errSyslog5424
+	}
+	return nil
+}
+
+// parseStructuredData parses the STRUCTURED-DATA part at the start of s and
+// returns the remainder.
+func parseStructuredData(s string) ([]SyslogSDElement, string, error) {
+	if strings.HasPrefix(s, "-") {
+		return nil, s[1:], nil
+	}
+	if !strings.HasPrefix(s, "[") {
+		return nil, "", errSyslogSD
+	}
+	var elems []SyslogSDElement
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		end := strings.IndexAny(s, " ]")
+		if end <= 0 {
+			return nil, "", errSyslogSD
+		}
+		elem := SyslogSDElement{ID: s[:end]}
+		s = s[end:]
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+			eq := strings.Index(s, "=\"")
+			if eq <= 0 || strings.ContainsAny(s[:eq], " ]\"") {
+				return nil, "", errSyslogSD
+			}
+			name := s[:eq]
+			value, rest, ok := sdValue(s[eq+2:])
+			if !ok {
+				return nil, "", errSyslogSD
+			}
+			elem.Params = append(elem.Params, EventData{Name: name, Value: value})
+			s = rest
+		}
+		if !strings.HasPrefix(s, "]") {
+			return nil, "", errSyslogSD
+		}
+		s = s[1:]
+		elems = append(elems, elem)
+	}
+	return elems, s, nil
+}
+
+// sdValue reads a PARAM-VALUE up to its closing quote, undoing the \" \\
+// and \] escapes.
+func sdValue(s string) (value, rest string, ok bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), s[i+1:], true
+		case '\\':
+			if i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\' || s[i+1] == ']') {
+				i++
+				c = s[i]
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", false
+}
+
+// bsdTimestampLayouts are the timestamp formats accepted in BSD messages,
+// the traditional one first.
+var bsdTimestampLayouts = []string{
+	time.Stamp,
+	"Jan _2 2006 15:04:05",
+	time.StampMicro,
+}
+
+// parse3164 parses everything after "<PRI>" as "TIMESTAMP HOSTNAME
+// TAG[PID]: MSG". The hostname is only looked for after a timestamp, as
+// local senders such as /dev/log clients omit both.
+func (p *SyslogParser) parse3164(m *SyslogMessage, s string) {
+	ts, rest, ok := p.bsdTimestamp(s)
+	if ok {
+		m.Timestamp = ts
+		s = rest
+		if host, rest, ok := nextField(s); ok && !isSyslogTag(host) {
+			m.Hostname = host
+			s = rest
+		}
+	} else {
+		m.Timestamp = p.now()
+	}
+	m.AppName, m.ProcID, m.Message = splitSyslogTag(s)
+}
+
+// bsdTimestamp parses a BSD or RFC 3339 timestamp at the start of s.
+func (p *SyslogParser) bsdTimestamp(s string) (time.Time, string, bool) {
+	if field, rest, ok := nextField(s); ok && len(field) > 10 && field[4] == '-' {
+		if ts, err := time.Parse(time.RFC3339Nano, field); err == nil {
+			return ts, rest, true
+		}
+	}
+	for _, layout := range bsdTimestampLayouts {
+		if len(s) < len(layout) || (len(s) > len(layout) && s[len(layout)] != ' ') {
+			continue
+		}
+		ts, err := time.ParseInLocation(layout, s[:len(layout)], p.location())
+		if err != nil {
+			continue
+		}
+		if ts.Year() == 0 {
+			var ok bool
+			if ts, ok = p.inferYear(ts); !ok {
+				continue
+			}
+		}
+		return ts, strings.TrimPrefix(s[len(layout):], " "), true
+	}
+	return time.Time{}, "", false
+}
+
+// inferYear gives a timestamp without a year the configured Year, or else
+// the current year, or the previous one when that would put it more than a
+// month in the future, as happens for December messages read in January.
+// It fails for Feb 29 when that year is not a leap year.
+func (p *SyslogParser) inferYear(ts time.Time) (time.Time, bool) {
+	date := func(year int) time.Time {
+		return time.Date(year, ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), ts.Location())
+	}
+	year := p.Year
+	if year == 0 {
+		now := p.now().In(ts.Location())
+		year = now.Year()
+		if date(year).After(now.AddDate(0, 1, 0)) {
+			year--
+		}
+	}
+	t := date(year)
+	// time.Date turns Feb 29 of other years into Mar 1.
+	return t, t.Day() == ts.Day()
+}
+
+// isSyslogTag reports whether field looks like a "tag:" or "tag[pid]:"
+// rather than a hostname.
+func isSyslogTag(field string) bool {
+	return strings.HasSuffix(field, ":") || strings.ContainsRune(field, '[')
+}
+
+// splitSyslogTag splits "TAG[PID]: MSG" or "TAG: MSG". Text without such a
+// tag is returned whole as the message.
+func splitSyslogTag(s string) (tag, pid, msg string) {
+	end := strings.IndexAny(s, "[: ")
+	if end <= 0 || end > 48 {
+		return "", "", s
+	}
+	tag, rest := s[:end], s[end:]
+	if rest[0] == '[' {
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			return "", "", s
+		}
+		pid, rest = rest[1:end], rest[end+1:]
+		if strings.HasPrefix(rest, ":") {
+			rest = rest[1:]
+		}
+	} else if rest[0] == ':' {
+		rest = rest[1:]
+	} else {
+		return "", "", s
+	}
+	return tag, pid, strings.TrimPrefix(rest, " ")
+}
+
+// Event converts m to an Event. Facility, severity, procid and msgid are
+// stored as data items, followed by one "SD-ID.PARAM" item per structured
+// data parameter.
+func (m *SyslogMessage) Event() *Event {
+	return m.event(true)
+}
+
+// event converts m to an Event; pri reports whether the message had a PRI
+// part, without which the level is detected from the message and the
+// facility and severity are left out.
+func (m *SyslogMessage) event(pri bool) *Event {
+	ev := &Event{
+		Provider:    m.AppName,
+		Computer:    m.Hostname,
+		Level:       LevelFromSyslog(m.Severity),
+		TimeCreated: m.Timestamp,
+		Message:     m.Message,
+	}
+	if pid, err := strconv.ParseUint(m.ProcID, 10, 32); err == nil {
+		ev.ProcessID = uint32(pid)
+	}
+	if pri {
+		ev.SetField("facility", SyslogFacilityName(m.Facility))
+		ev.SetField("severity", SyslogSeverityName(m.Severity))
+	} else if ev.Level = DetectLevel(m.Message); ev.Level == LevelUnknown {
+		ev.Level = LevelInfo
+	}
+	if m.ProcID != "" {
+		ev.SetField("procid", m.ProcID)
+	}
+	if m.MsgID != "" {
+		ev.SetField("msgid", m.MsgID)
+	}
+	for _, sd := range m.StructuredData {
+		if len(sd.Params) == 0 {
+			ev.Data = append(ev.Data, EventData{Name: sd.ID})
+		}
+		for _, param := range sd.Params {
+			ev.Data = append(ev.Data, EventData{Name: sd.ID + "." + param.Name, Value: param.Value})
+		}
+	}
+	return ev
+}
+
+// ParseLine parses a line of a log file written by a syslog daemon, such
+// as /var/log/syslog, /var/log/messages or auth.log, into an Event with
+// the timestamp, host, program, pid and message. Such lines usually lack
+// the PRI part: the level is then detected from the message and defaults
+// to info. Lines in the RFC 5424 layout, with or without PRI, and with
+// RFC 3339 timestamps, as written by rsyslog's high precision formats, are
+// parsed too.
+func (p *SyslogParser) ParseLine(line []byte) (*Event, error) {
+	line = bytes.TrimRight(line, "\r\n\x00")
+	if len(line) > 0 && line[0] == '<' {
+		m, err := p.Parse(line)
+		if err != nil {
+			return nil, err
+		}
+		return m.Event(), nil
+	}
+	if len(bytes.TrimSpace(line)) == 0 {
+		return nil, errSyslogEmpty
+	}
+	m := &SyslogMessage{}
+	if s := string(line); !strings.HasPrefix(s, "1 ") || p.parse5424(m, s[2:]) != nil {
+		*m = SyslogMessage{}
+		p.parse3164(m, s)
+	} else {
+		m.Version = 1
+	}
+	return m.event(false), nil
+}
+
+// SyslogFileParser parses the syslog log files delivered whole by the Unix
+// file watcher. It is not safe for concurrent use.
+type SyslogFileParser struct {
+	Parser SyslogParser
+
+	offsets fileOffsets
+}
+
+// ParseEntry parses the lines of entry added since the last call for the
+// same file, skipping an unterminated last line until it is complete. A
+// file that shrank is parsed again from its start. The events have the
+// file as Channel.
+func (p *SyslogFileParser) ParseEntry(entry *EventEntry) []*Event {
+	if p.offsets == nil {
+		p.offsets = make(fileOffsets)
+	}
+	lines, _ := p.offsets.newLines(entry)
+	var events []*Event
+	for _, line := range lines {
+		if ev, err := p.Parser.ParseLine(line); err == nil {
+			ev.Channel = entry.Name
+			events = append(events, ev)
+		}
+	}
+	return events
+}
@@ -0,0 +1,184 @@
+package eventwatcher
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// syslogTLSHandshakeTimeout bounds the TLS handshake of a new connection.
+const syslogTLSHandshakeTimeout = 30 * time.Second
+
+// SyslogTLSConfig configures the RFC 5425 syslog-over-TLS listener of a
+// SyslogSource. The certificate, key and CA files are read again whenever
+// the process receives SIGHUP or ReloadTLS is called; connections that are
+// already established keep their session.
+type SyslogTLSConfig struct {
+	// Addr is the TCP address to listen on, e.g. ":6514".
+	Addr string
+	// CertFile and KeyFile hold the PEM encoded server certificate chain
+	// and private key.
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is a PEM bundle of the CAs issuing client certificates.
+	// When set, clients must present a certificate that verifies against
+	// it; otherwise client certificates are not requested.
+	ClientCAFile string
+	// MinVersion defaults to TLS 1.2, the version RFC 5425 mandates.
+	MinVersion uint16
+	// OnReload, when set, is called with the result of every reload
+	// triggered by SIGHUP. A failed reload keeps the previous certificates.
+	OnReload func(error)
+}
+
+// syslogTLS is the TLS listener of a SyslogSource and its reloadable
+// configuration.
+type syslogTLS struct {
+	cfg      *SyslogTLSConfig
+	listener net.Listener
+
+	mu     sync.RWMutex
+	config *tls.Config
+
+	hup  chan os.Signal
+	done chan struct{}
+	once sync.Once
+}
+
+func listenSyslogTLS(cfg *SyslogTLSConfig) (*syslogTLS, error) {
+	t := &syslogTLS{
+		cfg:  cfg,
+		hup:  make(chan os.Signal, 1),
+		done: make(chan struct{}),
+	}
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	t.listener = tls.NewListener(ln, &tls.Config{GetConfigForClient: t.configForClient})
+	signal.Notify(t.hup, syscall.SIGHUP)
+	go t.watchSignals()
+	return t, nil
+}
+
+// reload reads the certificate, key and CA files and swaps in the new
+// configuration.
+func (t *syslogTLS) reload() error {
+	cert, err := tls.LoadX509KeyPair(t.cfg.CertFile, t.cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   t.cfg.MinVersion,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if t.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(t.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("syslog: no certificates in " + t.cfg.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	t.mu.Lock()
+	t.config = config
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *syslogTLS) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.config, nil
+}
+
+func (t *syslogTLS) watchSignals() {
+	for {
+		select {
+		case <-t.hup:
+			err := t.reload()
+			if t.cfg.OnReload != nil {
+				t.cfg.OnReload(err)
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *syslogTLS) close() {
+	t.once.Do(func() {
+		signal.Stop(t.hup)
+		close(t.done)
+		t.listener.Close()
+	})
+}
+
+// TLSAddr returns the bound TLS address, or nil.
+func (s *SyslogSource) TLSAddr() net.Addr {
+	if s.tls == nil {
+		return nil
+	}
+	return s.tls.listener.Addr()
+}
+
+// ReloadTLS reads the TLS certificate, key and CA files again. On error
+// the previous configuration stays in use.
+func (s *SyslogSource) ReloadTLS() error {
+	if s.tls == nil {
+		return errors.New("syslog: TLS is not configured")
+	}
+	return s.tls.reload()
+}
+
+// handshakeSyslogTLS completes the handshake of conn and returns the
+// identity of the client certificate, if any, as data items: "tls.subject",
+// "tls.cn", "tls.san" (comma separated DNS, email and URI names) and
+// "tls.fingerprint" (hex SHA-256 of the certificate).
+func handshakeSyslogTLS(conn *tls.Conn) ([]EventData, error) {
+	conn.SetDeadline(time.Now().Add(syslogTLSHandshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, nil
+	}
+	cert := certs[0]
+	var names []string
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	sum := sha256.Sum256(cert.Raw)
+	identity := []EventData{
+		{Name: "tls.subject", Value: cert.Subject.String()},
+		{Name: "tls.cn", Value: cert.Subject.CommonName},
+	}
+	if len(names) > 0 {
+		identity = append(identity, EventData{Name: "tls.san", Value: strings.Join(names, ",")})
+	}
+	return append(identity, EventData{Name: "tls.fingerprint", Value: hex.EncodeToString(sum[:])}), nil
+}
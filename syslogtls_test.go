@@ -0,0 +1,254 @@
+package eventwatcher
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testPKI is a throwaway CA issuing server and client certificates.
+type testPKI struct {
+	t      *testing.T
+	dir    string
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caPool *x509.CertPool
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	p := &testPKI{t: t, dir: t.TempDir()}
+	p.caKey = p.newKey()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &p.caKey.PublicKey, p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	p.caPool = x509.NewCertPool()
+	p.caPool.AddCert(p.ca)
+	p.writePEM("ca.pem", "CERTIFICATE", der)
+	p.serial = 1
+	return p
+}
+
+func (p *testPKI) newKey() *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return key
+}
+
+func (p *testPKI) writePEM(name, typ string, der []byte) string {
+	path := filepath.Join(p.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		p.t.Fatal(err)
+	}
+	return path
+}
+
+// issue creates a certificate for cn, writes it and its key to
+// name.pem and name-key.pem, and returns it as a tls.Certificate.
+func (p *testPKI) issue(name, cn string, usage x509.ExtKeyUsage, dnsNames ...string) tls.Certificate {
+	p.t.Helper()
+	p.serial++
+	key := p.newKey()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(p.serial),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"eventwatcher"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.ca, &key.PublicKey, p.caKey)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	p.writePEM(name+".pem", "CERTIFICATE", der)
+	p.writePEM(name+"-key.pem", "EC PRIVATE KEY", keyDER)
+	cert, err := tls.LoadX509KeyPair(p.path(name+".pem"), p.path(name+"-key.pem"))
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return cert
+}
+
+func (p *testPKI) path(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+func startTLSSyslog(t *testing.T, pki *testPKI, onReload func(error)) (*SyslogSource, *EventNotifier) {
+	t.Helper()
+	pki.issue("server", "syslog.test", x509.ExtKeyUsageServerAuth, "syslog.test")
+	src := NewSyslogSource(SyslogConfig{TLS: &SyslogTLSConfig{
+		Addr:         "127.0.0.1:0",
+		CertFile:     pki.path("server.pem"),
+		KeyFile:      pki.path("server-key.pem"),
+		ClientCAFile: pki.path("ca.pem"),
+		OnReload:     onReload,
+	}})
+	n := NewEventNotifier(context.Background())
+	if err := n.AddSource("tls", src); err != nil {
+		t.Fatal(err)
+	}
+	return src, n
+}
+
+func dialTLSSyslog(src *SyslogSource, pki *testPKI, client *tls.Certificate) (*tls.Conn, error) {
+	cfg := &tls.Config{RootCAs: pki.caPool, ServerName: "syslog.test"}
+	if client != nil {
+		cfg.Certificates = []tls.Certificate{*client}
+	}
+	conn, err := tls.Dial("tcp", src.TLSAddr().String(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func TestSyslogTLSMutualAuth(t *testing.T) {
+	pki := newTestPKI(t)
+	src, n := startTLSSyslog(t, pki, nil)
+	defer n.Close()
+	client := pki.issue("client", "web01", x509.ExtKeyUsageClientAuth, "web01.example.com")
+
+	conn, err := dialTLSSyslog(src, pki, &client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	msg := "<14>1 2024-03-10T12:00:00Z web01 app - - - over tls"
+	fmt.Fprintf(conn, "%d %s", len(msg), msg)
+	e := waitSyslogEntry(t, n)
+	if e.Event.Message != "over tls" {
+		t.Fatalf("message = %q", e.Event.Message)
+	}
+	checks := map[string]string{
+		"tls.cn":      "web01",
+		"tls.subject": "CN=web01,O=eventwatcher",
+		"tls.san":     "web01.example.com",
+	}
+	for name, want := range checks {
+		if got, _ := e.Event.Field(name); got != want {
+			t.Errorf("field %s = %q, want %q", name, got, want)
+		}
+	}
+	if fp, _ := e.Event.Field("tls.fingerprint"); len(fp) != 64 {
+		t.Errorf("fingerprint = %q", fp)
+	}
+
+	// Clients without a certificate are refused.
+	if conn, err := dialTLSSyslog(src, pki, nil); err == nil {
+		conn.Write([]byte("10 <14>hello\n"))
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Error("connection without client certificate accepted")
+		}
+		conn.Close()
+	}
+
+	// Certificates from another CA are refused as well.
+	other := newTestPKI(t)
+	rogue := other.issue("client", "rogue", x509.ExtKeyUsageClientAuth)
+	if conn, err := dialTLSSyslog(src, pki, &rogue); err == nil {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Error("connection with foreign client certificate accepted")
+		}
+		conn.Close()
+	}
+}
+
+func serverCertSerial(t *testing.T, src *SyslogSource, pki *testPKI, client *tls.Certificate) int64 {
+	t.Helper()
+	conn, err := dialTLSSyslog(src, pki, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestSyslogTLSReload(t *testing.T) {
+	pki := newTestPKI(t)
+	reloaded := make(chan error, 1)
+	src, n := startTLSSyslog(t, pki, func(err error) { reloaded <- err })
+	defer n.Close()
+	client := pki.issue("client", "web01", x509.ExtKeyUsageClientAuth)
+	first := serverCertSerial(t, src, pki, &client)
+
+	pki.issue("server", "syslog.test", x509.ExtKeyUsageServerAuth, "syslog.test")
+	if err := src.ReloadTLS(); err != nil {
+		t.Fatal(err)
+	}
+	second := serverCertSerial(t, src, pki, &client)
+	if second == first {
+		t.Fatalf("server certificate not replaced by ReloadTLS")
+	}
+
+	// A broken key file keeps the previous certificate.
+	os.WriteFile(pki.path("server-key.pem"), []byte("garbage"), 0600)
+	if err := src.ReloadTLS(); err == nil {
+		t.Fatal("expected reload error")
+	}
+	if got := serverCertSerial(t, src, pki, &client); got != second {
+		t.Fatalf("serial after failed reload = %d, want %d", got, second)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	pki.issue("server", "syslog.test", x509.ExtKeyUsageServerAuth, "syslog.test")
+	p, _ := os.FindProcess(os.Getpid())
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatalf("SIGHUP reload: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("SIGHUP did not reload the certificates")
+	}
+	if got := serverCertSerial(t, src, pki, &client); got == second {
+		t.Fatal("server certificate not replaced on SIGHUP")
+	}
+}
@@ -0,0 +1,194 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultTailPollInterval is how often a followed file is checked for new
+// data once its end was reached.
+const DefaultTailPollInterval = 250 * time.Millisecond
+
+// fileTailer reads the lines appended to a file. It follows the path across
+// rotation, finishing the old file before switching to the new one, and
+// starts over when the file is truncated.
+type fileTailer struct {
+	path    string
+	poll    time.Duration
+	f       *os.File
+	r       *bufio.Reader
+	offset  int64
+	partial []byte
+	// rotated is the file now at path, opened once the current one was
+	// renamed away; it is switched to after the current one is drained.
+	rotated *os.File
+}
+
+// openFileTailer opens path, positioned at its end unless fromStart is set.
+func openFileTailer(path string, fromStart bool, poll time.Duration) (*fileTailer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if poll <= 0 {
+		poll = DefaultTailPollInterval
+	}
+	t := &fileTailer{path: path, poll: poll, f: f, r: bufio.NewReader(f)}
+	if !fromStart {
+		if t.offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// next returns the next line without its line ending, waiting for it to be
+// written until ctx is done.
+func (t *fileTailer) next(ctx context.Context) ([]byte, error) {
+	for {
+		b, err := t.r.ReadBytes('\n')
+		t.offset += int64(len(b))
+		t.partial = append(t.partial, b...)
+		if err == nil {
+			return t.take(), nil
+		}
+		if err != io.EOF {
+			return nil, err
+		}
+
+		if t.rotated != nil {
+			// The old file is drained; an unterminated last line is complete.
+			t.switchFile()
+			if len(t.partial) > 0 {
+				return t.take(), nil
+			}
+			continue
+		}
+		if err := t.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// nextRecord returns the next record of size bytes of a file of fixed size
+// records, waiting for it to be written until ctx is done. An incomplete
+// record at the end of a rotated file is dropped.
+func (t *fileTailer) nextRecord(ctx context.Context, size int) ([]byte, error) {
+	for {
+		b := make([]byte, size-len(t.partial))
+		n, err := io.ReadFull(t.r, b)
+		t.offset += int64(n)
+		t.partial = append(t.partial, b[:n]...)
+		if err == nil {
+			rec := t.partial
+			t.partial = nil
+			return rec, nil
+		}
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+
+		if t.rotated != nil {
+			t.switchFile()
+			t.partial = nil
+			continue
+		}
+		if err := t.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// switchFile continues with the file that replaced the drained one.
+func (t *fileTailer) switchFile() {
+	t.f.Close()
+	t.f, t.rotated, t.offset = t.rotated, nil, 0
+	t.r.Reset(t.f)
+}
+
+// wait returns once the file may have more data, right away when it was
+// rotated or truncated and after the poll interval otherwise.
+func (t *fileTailer) wait(ctx context.Context) error {
+	if t.checkPath() {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(t.poll):
+		return nil
+	}
+}
+
+func (t *fileTailer) take() []byte {
+	line := bytes.TrimRight(t.partial, "\r\n")
+	t.partial = nil
+	return line
+}
+
+// checkPath looks for rotation and truncation at the end of the file and
+// reports whether reading should be retried right away.
+func (t *fileTailer) checkPath() bool {
+	cur, err := t.f.Stat()
+	if err != nil {
+		return false
+	}
+	fi, err := os.Stat(t.path)
+	if err == nil && !os.SameFile(fi, cur) {
+		if f, err := os.Open(t.path); err == nil {
+			t.rotated = f
+			// Read the old file once more for lines written before the
+			// rename was noticed.
+			return true
+		}
+		return false
+	}
+	if cur.Size() < t.offset {
+		if _, err := t.f.Seek(0, io.SeekStart); err == nil {
+			t.offset, t.partial = 0, nil
+			t.r.Reset(t.f)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fileTailer) close() {
+	t.f.Close()
+	if t.rotated != nil {
+		t.rotated.Close()
+	}
+}
+
+// fileOffsets tracks how much of each file delivered whole by the Unix
+// file watcher, whose entries hold the file content, was parsed.
+type fileOffsets map[string]int
+
+// newLines returns the complete lines of entry added since the last call.
+// An unterminated last line is left for the next call. A file that shrank
+// was replaced or truncated and is read from its start, which restarted
+// reports.
+func (o fileOffsets) newLines(entry *EventEntry) (lines [][]byte, restarted bool) {
+	b := entry.Buffer
+	start := o[entry.Name]
+	if len(b) < start {
+		start, restarted = 0, true
+	}
+	end := bytes.LastIndexByte(b, '\n') + 1
+	if end <= start {
+		o[entry.Name] = start
+		return nil, restarted
+	}
+	for _, line := range bytes.SplitAfter(b[start:end], []byte("\n")) {
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	o[entry.Name] = end
+	return lines, restarted
+}
@@ -0,0 +1,224 @@
+package eventwatcher
+
+import (
+	"strconv"
+	"strings"
+)
+
+// diffMaxEdits bounds the work of diffLines; inputs further apart than that
+// are diffed as a replacement of everything between their common prefix
+// and suffix.
+const diffMaxEdits = 1000
+
+// diffOp is a line of an edit script: kind is ' ' for a line kept, '-' for
+// a line of a deleted and '+' for a line of b inserted; a and b are the
+// line indexes.
+type diffOp struct {
+	kind byte
+	a, b int
+}
+
+// splitLines splits s after each newline, keeping the newlines so that a
+// missing one at the end of the text shows as a change.
+func splitLines(s string) []string {
+	var lines []string
+	for s != "" {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			lines = append(lines, s)
+			break
+		}
+		lines, s = append(lines, s[:i+1]), s[i+1:]
+	}
+	return lines
+}
+
+// diffLines computes a shortest edit script from a to b with Myers'
+// algorithm.
+func diffLines(a, b []string) []diffOp {
+	var ops []diffOp
+	// Common prefix and suffix are kept without searching.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	for i := 0; i < pre; i++ {
+		ops = append(ops, diffOp{' ', i, i})
+	}
+	ops = append(ops, myers(a[pre:len(a)-suf], b[pre:len(b)-suf], pre)...)
+	for i := 0; i < suf; i++ {
+		ops = append(ops, diffOp{' ', len(a) - suf + i, len(b) - suf + i})
+	}
+	return ops
+}
+
+// myers diffs a and b, whose lines are numbered from off in both inputs.
+func myers(a, b []string, off int) []diffOp {
+	n, m := len(a), len(b)
+	replace := func() []diffOp {
+		ops := make([]diffOp, 0, n+m)
+		for i := range a {
+			ops = append(ops, diffOp{'-', off + i, off})
+		}
+		for j := range b {
+			ops = append(ops, diffOp{'+', off + n, off + j})
+		}
+		return ops
+	}
+	if n == 0 || m == 0 {
+		return replace()
+	}
+	max := n + m
+	if max > 2*diffMaxEdits {
+		max = 2 * diffMaxEdits
+	}
+	// v[k+max] is the furthest x reached on diagonal k; trace[d] holds
+	// v[-d..d] before step d.
+	v := make([]int, 2*max+2)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[max-d:max+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+max] < v[k+1+max]) {
+				x = v[k+1+max]
+			} else {
+				x = v[k-1+max] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+max] = x
+			if x >= n && y >= m {
+				return myersBacktrack(trace, n, m, off)
+			}
+		}
+	}
+	return replace()
+}
+
+func myersBacktrack(trace [][]int, n, m, off int) []diffOp {
+	var rev []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		// trace[d] starts at diagonal -d.
+		v := func(k int) int { return trace[d][k+d] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v(k-1) < v(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			rev = append(rev, diffOp{' ', off + x, off + y})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				rev = append(rev, diffOp{'+', off + x, off + y})
+			} else {
+				x--
+				rev = append(rev, diffOp{'-', off + x, off + y})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	ops := make([]diffOp, len(rev))
+	for i, op := range rev {
+		ops[len(rev)-1-i] = op
+	}
+	return ops
+}
+
+// UnifiedDiff returns the differences between the texts a and b in the
+// unified format of diff -u, with context lines around each change. It
+// returns "" when the texts are equal.
+func UnifiedDiff(aName, bName, a, b string, context int) string {
+	if a == b {
+		return ""
+	}
+	al, bl := splitLines(a), splitLines(b)
+	ops := diffLines(al, bl)
+
+	var sb strings.Builder
+	sb.WriteString("--- " + aName + "\n+++ " + bName + "\n")
+	line := func(prefix byte, s string) {
+		sb.WriteByte(prefix)
+		sb.WriteString(s)
+		if !strings.HasSuffix(s, "\n") {
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	for i := 0; i < len(ops); {
+		// Find the next change and extend the hunk while the changes are
+		// at most 2*context lines apart.
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		stop := end + context
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+		hunk := ops[start:stop]
+		var aCount, bCount int
+		for _, op := range hunk {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		sb.WriteString("@@ -" + hunkRange(hunk[0].a, aCount) + " +" + hunkRange(hunk[0].b, bCount) + " @@\n")
+		for _, op := range hunk {
+			switch op.kind {
+			case ' ':
+				line(' ', al[op.a])
+			case '-':
+				line('-', al[op.a])
+			case '+':
+				line('+', bl[op.b])
+			}
+		}
+		i = stop
+	}
+	return sb.String()
+}
+
+// hunkRange formats the start line and count of a hunk; an empty range
+// starts at the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return strconv.Itoa(start) + ",0"
+	}
+	if count == 1 {
+		return strconv.Itoa(start + 1)
+	}
+	return strconv.Itoa(start+1) + "," + strconv.Itoa(count)
+}
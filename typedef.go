@@ -9,6 +9,7 @@ const (
 	InvalidHandle = uintptr(0)
 
 	ERROR_HANDLE_EOF          syscall.Errno = 38
+	ERROR_INVALID_PARAMETER   syscall.Errno = 87
 	ERROR_INSUFFICIENT_BUFFER syscall.Errno = 122
 	ERROR_NO_MORE_ITEMS       syscall.Errno = 259
 	NO_ERROR                                = 0
@@ -31,3 +32,11 @@ const (
 	EVENTLOG_FORWARDS_READ  = 0x0004
 	EVENTLOG_BACKWARDS_READ = 0x0008
 )
+
+const (
+	// https://learn.microsoft.com/zh-cn/windows/win32/api/synchapi/nf-synchapi-waitformultipleobjects
+	WAIT_OBJECT_0 = 0x00000000
+	WAIT_TIMEOUT  = 0x00000102
+	WAIT_FAILED   = 0xFFFFFFFF
+	INFINITE      = 0xFFFFFFFF
+)
@@ -0,0 +1,189 @@
+package eventwatcher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// DefaultStreamLineSize limits the size of a line read from a FIFO or a
+// Unix stream socket; longer lines are truncated.
+const DefaultStreamLineSize = 64 * 1024
+
+// UnixStreamConfig configures a UnixStreamSource.
+type UnixStreamConfig struct {
+	// Path of the socket. A stale socket at the path is replaced, and the
+	// socket is removed again by Close.
+	Path string
+	// Mode, when set, is applied to the socket file, e.g. 0o660 to let a
+	// group of applications connect.
+	Mode os.FileMode
+	// Provider of the events; defaults to the base name of Path.
+	Provider string
+	// MaxLineSize defaults to DefaultStreamLineSize.
+	MaxLineSize int
+}
+
+// UnixStreamSource is a Source listening on a Unix stream socket. Every
+// connection is read on its own, one event per LF terminated line, so lines
+// of concurrent writers never mix. Where the platform reports the peer's
+// credentials (SO_PEERCRED on Linux, LOCAL_PEERCRED on macOS), they are
+// attached to each event as "peer.pid", "peer.uid" and "peer.gid" and set
+// ProcessID and UserID.
+type UnixStreamSource struct {
+	cfg UnixStreamConfig
+	ln  net.Listener
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewUnixStreamSource creates a UnixStreamSource; its socket is bound by
+// Init.
+func NewUnixStreamSource(cfg UnixStreamConfig) *UnixStreamSource {
+	if cfg.MaxLineSize <= 0 {
+		cfg.MaxLineSize = DefaultStreamLineSize
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = filepath.Base(cfg.Path)
+	}
+	return &UnixStreamSource{
+		cfg:   cfg,
+		conns: make(map[net.Conn]struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Init binds the socket.
+func (s *UnixStreamSource) Init() error {
+	if err := removeStaleSocket(s.cfg.Path); err != nil {
+		return err
+	}
+	ln, err := net.Listen("unix", s.cfg.Path)
+	if err != nil {
+		return err
+	}
+	if s.cfg.Mode != 0 {
+		if err := os.Chmod(s.cfg.Path, s.cfg.Mode); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+	s.ln = ln
+	return nil
+}
+
+// Listen serves connections until ctx is done or Close is called.
+func (s *UnixStreamSource) Listen(ctx context.Context, emit EmitFunc) {
+	s.wg.Add(1)
+	go s.accept(ctx, emit)
+	select {
+	case <-ctx.Done():
+	case <-s.done:
+	}
+	s.Close()
+	s.wg.Wait()
+}
+
+// Close closes the socket and all connections.
+func (s *UnixStreamSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	if s.ln != nil {
+		// Closing a UnixListener created by Listen removes the socket file.
+		s.ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *UnixStreamSource) accept(ctx context.Context, emit EmitFunc) {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return
+		}
+		s.wg.Add(1)
+		go s.serveConn(ctx, conn, emit)
+	}
+}
+
+func (s *UnixStreamSource) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *UnixStreamSource) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+func (s *UnixStreamSource) serveConn(ctx context.Context, conn net.Conn, emit EmitFunc) {
+	defer s.wg.Done()
+	defer s.untrack(conn)
+	var cred *peerCred
+	if uc, ok := conn.(*net.UnixConn); ok {
+		cred = unixPeerCred(uc)
+	}
+	r := bufio.NewReader(conn)
+	for {
+		line, err := readLimitedLine(r, s.cfg.MaxLineSize)
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 {
+			ev := lineEvent(s.cfg.Provider, string(line), LevelInfo)
+			cred.apply(ev)
+			if emit(ctx, &EventEntry{Buffer: line, Event: ev}) != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// peerCred holds the credentials of the process at the other end of a Unix
+// socket. Pid is 0 where the platform does not report it.
+type peerCred struct {
+	Pid, Uid, Gid uint32
+}
+
+// apply stores the credentials in ev; a nil c leaves ev unchanged.
+func (c *peerCred) apply(ev *Event) {
+	if c == nil {
+		return
+	}
+	if c.Pid != 0 {
+		ev.ProcessID = c.Pid
+		ev.SetField("peer.pid", strconv.FormatUint(uint64(c.Pid), 10))
+	}
+	ev.UserID = strconv.FormatUint(uint64(c.Uid), 10)
+	ev.SetField("peer.uid", ev.UserID)
+	ev.SetField("peer.gid", strconv.FormatUint(uint64(c.Gid), 10))
+}
@@ -0,0 +1,68 @@
+package eventwatcher
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestUnixStreamSource(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets")
+	}
+	path := filepath.Join(t.TempDir(), "app.sock")
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	if err := n.AddSource("app", NewUnixStreamSource(UnixStreamConfig{Path: path, Mode: 0o660})); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o660 {
+		t.Fatalf("socket mode: %v %v", fi, err)
+	}
+
+	// Interleaved writes of two clients must not mix their lines.
+	a, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	a.Write([]byte("first half "))
+	b.Write([]byte("[ERROR] from b\n"))
+	e := waitSyslogEntry(t, n)
+	if e.Name != "app" || e.Event.Message != "[ERROR] from b" || e.Event.Level != LevelError || e.Event.Provider != "app.sock" {
+		t.Fatalf("b: %+v", e.Event)
+	}
+	a.Write([]byte("second half\r\n"))
+	e = waitSyslogEntry(t, n)
+	if e.Event.Message != "first half second half" || e.Event.Level != LevelInfo {
+		t.Fatalf("a: %+v", e.Event)
+	}
+
+	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
+		if v, _ := e.Event.Field("peer.pid"); v != strconv.Itoa(os.Getpid()) || e.Event.ProcessID != uint32(os.Getpid()) {
+			t.Errorf("peer.pid = %q", v)
+		}
+		if v, _ := e.Event.Field("peer.uid"); v != strconv.Itoa(os.Getuid()) || e.Event.UserID != v {
+			t.Errorf("peer.uid = %q", v)
+		}
+		if v, _ := e.Event.Field("peer.gid"); v == "" {
+			t.Error("peer.gid missing")
+		}
+	}
+
+	if err := n.RemoveWatcher("app"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket not removed: %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+package eventwatcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UtmpRecordSize is the size of a glibc struct utmp on 64-bit Linux.
+const UtmpRecordSize = 384
+
+// DefaultUtmpPaths are the login accounting files of Linux systems.
+var DefaultUtmpPaths = []string{"/var/log/wtmp", "/var/log/btmp"}
+
+// The record types of struct utmp.
+const (
+	UtmpEmpty        = 0
+	UtmpRunLevel     = 1
+	UtmpBootTime     = 2
+	UtmpNewTime      = 3
+	UtmpOldTime      = 4
+	UtmpInitProcess  = 5
+	UtmpLoginProcess = 6
+	UtmpUserProcess  = 7
+	UtmpDeadProcess  = 8
+	UtmpAccounting   = 9
+)
+
+// UtmpRecord is a record of a utmp, wtmp or btmp file.
+type UtmpRecord struct {
+	Type int
+	PID  int
+	// Line is the terminal, such as "pts/0" or "ssh:notty".
+	Line string
+	ID   string
+	User string
+	// Host is the remote host, or the kernel version of boot records.
+	Host string
+	// Termination and Exit are the status of a dead process.
+	Termination int
+	Exit        int
+	Session     int
+	Time        time.Time
+	// Addr is the remote address, nil when unknown.
+	Addr net.IP
+}
+
+// ParseUtmpRecord decodes a glibc struct utmp as written on little-endian
+// 64-bit Linux, where its time is two 32-bit fields.
+func ParseUtmpRecord(b []byte) (UtmpRecord, error) {
+	if len(b) < UtmpRecordSize {
+		return UtmpRecord{}, errors.New("utmp: short record")
+	}
+	le := binary.LittleEndian
+	r := UtmpRecord{
+		Type:        int(int16(le.Uint16(b[0:]))),
+		PID:         int(int32(le.Uint32(b[4:]))),
+		Line:        utmpString(b[8:40]),
+		ID:          utmpString(b[40:44]),
+		User:        utmpString(b[44:76]),
+		Host:        utmpString(b[76:332]),
+		Termination: int(int16(le.Uint16(b[332:]))),
+		Exit:        int(int16(le.Uint16(b[334:]))),
+		Session:     int(int32(le.Uint32(b[336:]))),
+		Time:        time.Unix(int64(int32(le.Uint32(b[340:]))), int64(int32(le.Uint32(b[344:])))*1000),
+	}
+	if r.Type < UtmpEmpty || r.Type > UtmpAccounting {
+		return UtmpRecord{}, errors.New("utmp: invalid record type " + strconv.Itoa(r.Type))
+	}
+	addr := b[348:364]
+	switch {
+	case bytes.Equal(addr, make([]byte, 16)):
+	case bytes.Equal(addr[4:], make([]byte, 12)):
+		r.Addr = net.IP(append([]byte(nil), addr[:4]...))
+	default:
+		r.Addr = net.IP(append([]byte(nil), addr...))
+	}
+	return r, nil
+}
+
+func utmpString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// The actions reported by UtmpEvent.
+const (
+	UtmpActionLogin       = "login"
+	UtmpActionLogout      = "logout"
+	UtmpActionFailedLogin = "failed_login"
+	UtmpActionBoot        = "boot"
+	UtmpActionShutdown    = "shutdown"
+	UtmpActionRunLevel    = "runlevel"
+	UtmpActionClockChange = "clock_change"
+)
+
+// UtmpAction returns the action a record stands for, or "" for records of
+// no interest such as getty processes. Every record of a btmp file, where
+// failed is set, is a failed login.
+func UtmpAction(r *UtmpRecord, failed bool) string {
+	if failed {
+		return UtmpActionFailedLogin
+	}
+	switch r.Type {
+	case UtmpUserProcess:
+		return UtmpActionLogin
+	case UtmpDeadProcess:
+		return UtmpActionLogout
+	case UtmpBootTime:
+		return UtmpActionBoot
+	case UtmpRunLevel:
+		if r.User == "shutdown" {
+			return UtmpActionShutdown
+		}
+		return UtmpActionRunLevel
+	case UtmpNewTime:
+		return UtmpActionClockChange
+	}
+	return ""
+}
+
+// UtmpEvent converts a record to an Event from the "login" provider, or
+// returns nil for records of no interest. The action, user, tty, host,
+// address and pid are stored as data items. Logout records usually lack
+// the user, which the caller may fill in from the login on the same tty.
+func UtmpEvent(r *UtmpRecord, failed bool) *Event {
+	action := UtmpAction(r, failed)
+	if action == "" {
+		return nil
+	}
+	ev := &Event{Provider: "login", Level: LevelInfo, TimeCreated: r.Time, ProcessID: uint32(r.PID)}
+	if action == UtmpActionFailedLogin {
+		ev.Level = LevelWarning
+	}
+	ev.SetField("action", action)
+	for _, f := range []struct{ name, value string }{
+		{"user", r.User}, {"tty", r.Line}, {"host", r.Host}, {"id", r.ID},
+	} {
+		if f.value != "" {
+			ev.SetField(f.name, f.value)
+		}
+	}
+	if r.Addr != nil {
+		ev.SetField("addr", r.Addr.String())
+	}
+	if r.PID != 0 {
+		ev.SetField("pid", strconv.Itoa(r.PID))
+	}
+	if r.Session != 0 {
+		ev.SetField("session", strconv.Itoa(r.Session))
+	}
+	if action == UtmpActionLogout && (r.Termination != 0 || r.Exit != 0) {
+		ev.SetField("exit", strconv.Itoa(r.Exit))
+		ev.SetField("termination", strconv.Itoa(r.Termination))
+	}
+
+	from := ""
+	if r.Host != "" {
+		from = " from " + r.Host
+	}
+	switch action {
+	case UtmpActionLogin:
+		ev.Message = "login " + r.User + " on " + r.Line + from
+	case UtmpActionLogout:
+		ev.Message = "logout " + strings.TrimSpace(r.User+" on "+r.Line)
+	case UtmpActionFailedLogin:
+		ev.Message = "failed login " + r.User + " on " + r.Line + from
+	case UtmpActionBoot:
+		ev.Message = "system boot"
+		if r.Host != "" {
+			ev.Message += " (" + r.Host + ")"
+		}
+	case UtmpActionShutdown:
+		ev.Message = "system shutdown"
+	case UtmpActionRunLevel:
+		// The pid holds the new run level and 256 times the previous one.
+		ev.Message = "run level changed"
+		if lvl := r.PID % 256; lvl > ' ' && lvl < 0x7f {
+			ev.Message = "run level " + string(rune(lvl))
+			ev.SetField("runlevel", string(rune(lvl)))
+		}
+	case UtmpActionClockChange:
+		ev.Message = "system clock changed"
+	}
+	return ev
+}
+
+// UtmpConfig configures a UtmpSource.
+type UtmpConfig struct {
+	// Paths default to DefaultUtmpPaths. Files named btmp, such as
+	// "/var/log/btmp" or "btmp.1", hold failed logins.
+	Paths []string
+	// FromStart reads the records already in the files.
+	FromStart    bool
+	PollInterval time.Duration
+}
+
+// UtmpSource is a Source reporting the records appended to login
+// accounting files: logins, logouts, boots, shutdowns and failed logins.
+// Files are followed across rotation.
+type UtmpSource struct {
+	cfg     UtmpConfig
+	tailers map[string]*fileTailer
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+}
+
+// NewUtmpSource creates a UtmpSource.
+func NewUtmpSource(cfg UtmpConfig) *UtmpSource {
+	if len(cfg.Paths) == 0 {
+		cfg.Paths = DefaultUtmpPaths
+	}
+	return &UtmpSource{cfg: cfg, done: make(chan struct{})}
+}
+
+// Init opens the files. Files that cannot be opened, such as btmp when not
+// running as root, are skipped unless none can be.
+func (s *UtmpSource) Init() error {
+	s.tailers = make(map[string]*fileTailer)
+	var err error
+	for _, path := range s.cfg.Paths {
+		t, terr := openFileTailer(path, s.cfg.FromStart, s.cfg.PollInterval)
+		if terr != nil {
+			err = terr
+			continue
+		}
+		s.tailers[path] = t
+	}
+	if len(s.tailers) == 0 {
+		return err
+	}
+	return nil
+}
+
+// Listen emits records until ctx is done or Close is called.
+func (s *UtmpSource) Listen(ctx context.Context, emit EmitFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer s.Close()
+	defer cancel()
+
+	s.mu.Lock()
+	tailers := s.tailers
+	s.tailers = nil
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for path, t := range tailers {
+		wg.Add(1)
+		go func(path string, t *fileTailer) {
+			defer wg.Done()
+			defer t.close()
+			s.follow(ctx, path, t, emit)
+		}(path, t)
+	}
+	select {
+	case <-ctx.Done():
+	case <-s.done:
+	}
+	cancel()
+	wg.Wait()
+}
+
+func (s *UtmpSource) follow(ctx context.Context, path string, t *fileTailer, emit EmitFunc) {
+	failed := strings.HasPrefix(filepath.Base(path), "btmp")
+	// users maps the ttys to the users logged in, for logouts.
+	users := make(map[string]string)
+	for {
+		b, err := t.nextRecord(ctx, UtmpRecordSize)
+		if err != nil {
+			return
+		}
+		r, err := ParseUtmpRecord(b)
+		if err != nil {
+			continue
+		}
+		switch {
+		case failed:
+		case r.Type == UtmpUserProcess:
+			users[r.Line] = r.User
+		case r.Type == UtmpDeadProcess && r.User == "":
+			r.User = users[r.Line]
+			delete(users, r.Line)
+		}
+		ev := UtmpEvent(&r, failed)
+		if ev == nil {
+			continue
+		}
+		ev.Channel = filepath.Base(path)
+		if emit(ctx, &EventEntry{Buffer: []byte(ev.Message), Event: ev}) != nil {
+			return
+		}
+	}
+}
+
+// Close stops the source.
+func (s *UtmpSource) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	// Files opened by Init are only closed here when Listen never ran.
+	for _, t := range s.tailers {
+		t.close()
+	}
+	s.tailers = nil
+}
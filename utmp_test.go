@@ -0,0 +1,138 @@
+package eventwatcher
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// utmpBytes encodes a record as glibc does on x86_64.
+func utmpBytes(r UtmpRecord) []byte {
+	b := make([]byte, UtmpRecordSize)
+	le := binary.LittleEndian
+	le.PutUint16(b[0:], uint16(r.Type))
+	le.PutUint32(b[4:], uint32(r.PID))
+	copy(b[8:40], r.Line)
+	copy(b[40:44], r.ID)
+	copy(b[44:76], r.User)
+	copy(b[76:332], r.Host)
+	le.PutUint16(b[332:], uint16(r.Termination))
+	le.PutUint16(b[334:], uint16(r.Exit))
+	le.PutUint32(b[336:], uint32(r.Session))
+	le.PutUint32(b[340:], uint32(r.Time.Unix()))
+	le.PutUint32(b[344:], uint32(r.Time.Nanosecond()/1000))
+	if ip4 := r.Addr.To4(); ip4 != nil {
+		copy(b[348:], ip4)
+	} else {
+		copy(b[348:], r.Addr)
+	}
+	return b
+}
+
+func TestParseUtmpRecord(t *testing.T) {
+	want := UtmpRecord{
+		Type: UtmpUserProcess, PID: 4242, Line: "pts/0", ID: "ts/0", User: "alice",
+		Host: "10.0.0.5", Session: 7, Time: time.Unix(1700000000, 123000), Addr: net.IPv4(10, 0, 0, 5).To4(),
+	}
+	got, err := ParseUtmpRecord(utmpBytes(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+	want.Addr = net.ParseIP("2001:db8::1")
+	if got, _ := ParseUtmpRecord(utmpBytes(want)); !got.Addr.Equal(want.Addr) {
+		t.Fatalf("addr %v", got.Addr)
+	}
+	if _, err := ParseUtmpRecord(make([]byte, 100)); err == nil {
+		t.Fatal("parsed short record")
+	}
+	if _, err := ParseUtmpRecord(utmpBytes(UtmpRecord{Type: 42})); err == nil {
+		t.Fatal("parsed invalid type")
+	}
+}
+
+func TestUtmpEvent(t *testing.T) {
+	tests := []struct {
+		r       UtmpRecord
+		failed  bool
+		message string
+	}{
+		{UtmpRecord{Type: UtmpUserProcess, User: "alice", Line: "pts/0", Host: "10.0.0.5"}, false, "login alice on pts/0 from 10.0.0.5"},
+		{UtmpRecord{Type: UtmpDeadProcess, Line: "pts/0"}, false, "logout on pts/0"},
+		{UtmpRecord{Type: UtmpBootTime, User: "reboot", Line: "~", Host: "6.1.0-13-amd64"}, false, "system boot (6.1.0-13-amd64)"},
+		{UtmpRecord{Type: UtmpRunLevel, User: "shutdown", Line: "~"}, false, "system shutdown"},
+		{UtmpRecord{Type: UtmpRunLevel, User: "runlevel", Line: "~", PID: '5'}, false, "run level 5"},
+		{UtmpRecord{Type: UtmpLoginProcess, User: "admin", Line: "ssh:notty", Host: "203.0.113.9"}, true, "failed login admin on ssh:notty from 203.0.113.9"},
+		{UtmpRecord{Type: UtmpLoginProcess, User: "LOGIN", Line: "tty1"}, false, ""},
+	}
+	for _, tt := range tests {
+		ev := UtmpEvent(&tt.r, tt.failed)
+		if tt.message == "" {
+			if ev != nil {
+				t.Errorf("got %q for type %d", ev.Message, tt.r.Type)
+			}
+			continue
+		}
+		if ev == nil || ev.Message != tt.message {
+			t.Errorf("got %+v, want %q", ev, tt.message)
+		}
+	}
+	ev := UtmpEvent(&tests[5].r, true)
+	if ev.Level != LevelWarning {
+		t.Fatalf("level %v", ev.Level)
+	}
+	if v, _ := ev.Field("action"); v != UtmpActionFailedLogin {
+		t.Fatalf("action %q", v)
+	}
+}
+
+func TestUtmpSource(t *testing.T) {
+	dir := t.TempDir()
+	wtmp, btmp := filepath.Join(dir, "wtmp"), filepath.Join(dir, "btmp")
+	boot := UtmpRecord{Type: UtmpBootTime, User: "reboot", Line: "~", Time: time.Unix(1700000000, 0)}
+	os.WriteFile(wtmp, utmpBytes(boot), 0o644)
+	os.WriteFile(btmp, nil, 0o600)
+
+	n := NewEventNotifier(context.Background())
+	defer n.Close()
+	src := NewUtmpSource(UtmpConfig{Paths: []string{wtmp, btmp}, FromStart: true, PollInterval: 10 * time.Millisecond})
+	if err := n.AddSource("utmp", src); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitSyslogEntry(t, n); e.Event.Message != "system boot" || !e.Event.TimeCreated.Equal(boot.Time) {
+		t.Fatalf("got %q at %v", e.Event.Message, e.Event.TimeCreated)
+	}
+
+	f, _ := os.OpenFile(wtmp, os.O_WRONLY|os.O_APPEND, 0)
+	defer f.Close()
+	login := utmpBytes(UtmpRecord{Type: UtmpUserProcess, PID: 100, User: "bob", Line: "pts/1", Host: "example.com"})
+	// A record written in two parts is read once complete.
+	f.Write(login[:100])
+	time.Sleep(50 * time.Millisecond)
+	f.Write(login[100:])
+	e := waitSyslogEntry(t, n)
+	if e.Event.Message != "login bob on pts/1 from example.com" || e.Event.Channel != "wtmp" {
+		t.Fatalf("got %q on %s", e.Event.Message, e.Event.Channel)
+	}
+	// The user of a logout is the one logged in on the tty.
+	f.Write(utmpBytes(UtmpRecord{Type: UtmpDeadProcess, PID: 100, Line: "pts/1"}))
+	e = waitSyslogEntry(t, n)
+	if v, _ := e.Event.Field("user"); v != "bob" || e.Event.Message != "logout bob on pts/1" {
+		t.Fatalf("got %q, user %q", e.Event.Message, v)
+	}
+
+	b, _ := os.OpenFile(btmp, os.O_WRONLY|os.O_APPEND, 0)
+	defer b.Close()
+	b.Write(utmpBytes(UtmpRecord{Type: UtmpLoginProcess, User: "root", Line: "ssh:notty", Host: "198.51.100.1"}))
+	e = waitSyslogEntry(t, n)
+	if v, _ := e.Event.Field("action"); v != UtmpActionFailedLogin || e.Event.Channel != "btmp" {
+		t.Fatalf("got %q on %s", v, e.Event.Channel)
+	}
+}
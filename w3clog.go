@@ -0,0 +1,376 @@
+package eventwatcher
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errW3CNoFields = errors.New("w3c: data line before the fields are known")
+	errW3CFields   = errors.New("w3c: field count does not match #Fields")
+	errW3CQuote    = errors.New("w3c: unterminated quoted string")
+)
+
+// W3CField is a field of a W3C extended log line. Value is nil for the
+// "-" placeholder, time.Time for dates, time.Duration since midnight for
+// times, int64 or float64 for numeric fields such as sc-status, sc-bytes
+// and time-taken, and string otherwise.
+type W3CField struct {
+	Name  string
+	Value interface{}
+	// Text is the field as written, unquoted.
+	Text string
+}
+
+// W3CRecord is a data line of a W3C extended log.
+type W3CRecord struct {
+	// Software and Version are from the #Software and #Version directives.
+	Software string
+	Version  string
+	// Time is the date and time fields of the line; a missing date is
+	// taken from the #Date directive.
+	Time   time.Time
+	Fields []W3CField
+	Line   string
+	// DHCP is set for lines of Windows DHCP server audit logs.
+	DHCP bool
+}
+
+// Field returns the value of the named field.
+func (r *W3CRecord) Field(name string) (interface{}, bool) {
+	for _, f := range r.Fields {
+		if f.Name == name {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+// W3CParser parses W3C extended log files, as written by IIS and many
+// proxies, and the similar audit logs of Windows DHCP servers, whose
+// column header line ("ID,Date,Time,Description,...") stands for the
+// #Fields directive and whose lines are comma separated.
+//
+// The directives are tracked per file, as they may change within a file
+// when the server restarts or its configuration changes. A W3CParser is
+// not safe for concurrent use.
+type W3CParser struct {
+	// Location is applied to DHCP log times, which are local; nil means
+	// time.Local. W3C times are UTC.
+	Location *time.Location
+
+	files   map[string]*w3cFile
+	offsets fileOffsets
+}
+
+type w3cFile struct {
+	fields   []string
+	dhcp     bool
+	date     time.Time
+	software string
+	version  string
+}
+
+func (p *W3CParser) file(name string) *w3cFile {
+	if p.files == nil {
+		p.files = make(map[string]*w3cFile)
+	}
+	f := p.files[name]
+	if f == nil {
+		f = &w3cFile{}
+		p.files[name] = f
+	}
+	return f
+}
+
+// Reset forgets the directives of a file and how much of it ParseEntry
+// parsed, such as when it was replaced.
+func (p *W3CParser) Reset(file string) {
+	delete(p.files, file)
+	delete(p.offsets, file)
+}
+
+// ParseLine parses a line of file. Directive, header and blank lines
+// update the state of the file and return a nil record.
+func (p *W3CParser) ParseLine(file string, line []byte) (*W3CRecord, error) {
+	s := strings.TrimRight(string(line), "\r\n")
+	f := p.file(file)
+	if strings.HasPrefix(s, "#") {
+		f.directive(s[1:])
+		return nil, nil
+	}
+	if strings.HasPrefix(s, "ID,Date,Time,") {
+		f.fields, f.dhcp = nil, true
+		for _, name := range strings.Split(s, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			f.fields = append(f.fields, strings.ReplaceAll(name, " ", "-"))
+		}
+		return nil, nil
+	}
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	if f.fields == nil {
+		// Such as the preamble of DHCP logs.
+		return nil, errW3CNoFields
+	}
+
+	var values []string
+	if f.dhcp {
+		values = strings.Split(s, ",")
+		// Later Windows versions add columns at the end.
+		for len(values) < len(f.fields) {
+			values = append(values, "")
+		}
+	} else {
+		var err error
+		if values, err = splitW3C(s); err != nil {
+			return nil, err
+		}
+	}
+	if len(values) != len(f.fields) {
+		return nil, errW3CFields
+	}
+	r := &W3CRecord{Software: f.software, Version: f.version, Line: s, DHCP: f.dhcp}
+	date, clock := f.date, time.Duration(-1)
+	for i, name := range f.fields {
+		v := p.value(f, name, values[i])
+		switch v := v.(type) {
+		case time.Time:
+			date = v
+		case time.Duration:
+			clock = v
+		}
+		text := values[i]
+		if s, ok := v.(string); ok {
+			text = s
+		}
+		r.Fields = append(r.Fields, W3CField{Name: name, Value: v, Text: text})
+	}
+	if !date.IsZero() {
+		r.Time = date
+		if clock >= 0 {
+			y, m, d := date.Date()
+			r.Time = time.Date(y, m, d, 0, 0, 0, 0, date.Location()).Add(clock)
+		}
+	}
+	return r, nil
+}
+
+// directive applies a #Name: value line.
+func (f *w3cFile) directive(s string) {
+	name, value := s, ""
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		name, value = s[:i], strings.TrimSpace(s[i+1:])
+	}
+	switch name {
+	case "Fields":
+		f.fields, f.dhcp = strings.Fields(value), false
+	case "Date":
+		if t, err := time.Parse("2006-01-02 15:04:05", value); err == nil {
+			f.date = t
+		}
+	case "Software":
+		f.software = value
+	case "Version":
+		f.version = value
+	}
+}
+
+// value types a field by its identifier.
+func (p *W3CParser) value(f *w3cFile, name, s string) interface{} {
+	if f.dhcp {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return nil
+		}
+		loc := p.Location
+		if loc == nil {
+			loc = time.Local
+		}
+		switch name {
+		case "id":
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return n
+			}
+		case "date":
+			if t, err := time.ParseInLocation("01/02/06", s, loc); err == nil {
+				return t
+			}
+		case "time":
+			if d, ok := parseW3CClock(s); ok {
+				return d
+			}
+		}
+		return s
+	}
+
+	if s == "-" {
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '"' {
+		return strings.ReplaceAll(s[1:len(s)-1], "\"\"", "\"")
+	}
+	// Prefixed identifiers such as cs-bytes are typed by their suffix.
+	id := name
+	if i := strings.IndexByte(name, '-'); i >= 0 && i <= 3 && strings.Trim(name[:i], "csrx") == "" {
+		id = name[i+1:]
+	}
+	switch id {
+	case "date":
+		if t, err := time.Parse("2006-01-02", s); err == nil {
+			return t
+		}
+	case "time":
+		if d, ok := parseW3CClock(s); ok {
+			return d
+		}
+	case "bytes", "status", "substatus", "win32-status", "port", "count", "time-taken", "cached":
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+		if x, err := strconv.ParseFloat(s, 64); err == nil {
+			return x
+		}
+	}
+	return s
+}
+
+// parseW3CClock parses a time of day, hh:mm or hh:mm:ss with an optional
+// fraction.
+func parseW3CClock(s string) (time.Duration, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || h > 23 || m > 59 {
+		return 0, false
+	}
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+	if len(parts) == 3 {
+		sec, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || sec >= 61 {
+			return 0, false
+		}
+		d += time.Duration(sec * float64(time.Second))
+	}
+	return d, true
+}
+
+// splitW3C splits a data line at spaces and tabs, keeping quoted strings,
+// where "" stands for a quote, in one field.
+func splitW3C(s string) ([]string, error) {
+	var values []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return values, nil
+		}
+		end := strings.IndexAny(s, " \t")
+		if s[0] == '"' {
+			end = -1
+			for i := 1; i < len(s); i++ {
+				if s[i] != '"' {
+					continue
+				}
+				if i+1 < len(s) && s[i+1] == '"' {
+					i++
+					continue
+				}
+				end = i + 1
+				break
+			}
+			if end < 0 {
+				return nil, errW3CQuote
+			}
+		}
+		if end < 0 {
+			end = len(s)
+		}
+		values = append(values, s[:end])
+		s = s[end:]
+	}
+}
+
+// ParseEntry parses the new lines of a file delivered by the file watcher,
+// whose Buffer holds the whole file each time it changed. The lines parsed
+// before are skipped, as is an unterminated last line until it is
+// complete; a file that shrank is parsed again from its start. Lines that
+// cannot be parsed are skipped.
+func (p *W3CParser) ParseEntry(entry *EventEntry) []*Event {
+	if p.offsets == nil {
+		p.offsets = make(fileOffsets)
+	}
+	lines, restarted := p.offsets.newLines(entry)
+	if restarted {
+		delete(p.files, entry.Name)
+	}
+	var events []*Event
+	for _, line := range lines {
+		if r, err := p.ParseLine(entry.Name, line); err == nil && r != nil {
+			ev := r.Event()
+			ev.Channel = entry.Name
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// Event converts the record to an Event from the "w3c" provider, or "dhcp"
+// for DHCP logs, with the fields as data items and the line as message.
+// The level is derived from the HTTP status or the DHCP event ID.
+func (r *W3CRecord) Event() *Event {
+	ev := &Event{Provider: "w3c", Level: LevelInfo, TimeCreated: r.Time, Message: r.Line}
+	if r.DHCP {
+		ev.Provider = "dhcp"
+	}
+	if ev.TimeCreated.IsZero() {
+		ev.TimeCreated = time.Now()
+	}
+	if r.Software != "" {
+		ev.Data = append(ev.Data, EventData{Name: "software", Value: r.Software})
+	}
+	for _, f := range r.Fields {
+		if f.Value != nil {
+			ev.Data = append(ev.Data, EventData{Name: f.Name, Value: f.Text})
+		}
+	}
+	if v, ok := r.Field("s-computername"); ok && v != nil {
+		ev.Computer = v.(string)
+	}
+	if v, ok := r.Field("sc-status"); ok {
+		if status, ok := v.(int64); ok {
+			switch {
+			case status >= 500:
+				ev.Level = LevelError
+			case status >= 400:
+				ev.Level = LevelWarning
+			}
+		}
+	}
+	if v, ok := r.Field("id"); ok && r.DHCP {
+		if id, ok := v.(int64); ok {
+			ev.EventID = uint32(id)
+			ev.Level = dhcpLevel(id)
+		}
+	}
+	return ev
+}
+
+// dhcpLevel maps the audit log event IDs of Windows DHCP servers to
+// levels: exhausted scopes and failed DNS updates and authorizations are
+// errors, denied and dropped requests, addresses in use and rogue
+// detection problems warnings.
+func dhcpLevel(id int64) Level {
+	switch id {
+	case 14, 22, 31, 34, 35, 54, 56:
+		return LevelError
+	case 2, 13, 15, 33, 36, 50, 58, 59, 60, 62, 64:
+		return LevelWarning
+	}
+	return LevelInfo
+}
@@ -0,0 +1,165 @@
+package eventwatcher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const iisLog = `#Software: Microsoft Internet Information Services 10.0
+#Version: 1.0
+#Date: 2024-03-01 00:00:01
+#Fields: date time s-ip cs-method cs-uri-stem cs-uri-query s-port cs-username c-ip cs(User-Agent) sc-status sc-substatus sc-win32-status time-taken
+2024-03-01 00:00:01 10.0.0.1 GET /index.html - 443 - 203.0.113.7 Mozilla/5.0+(Windows+NT+10.0) 200 0 0 15
+2024-03-01 00:00:02 10.0.0.1 POST /api/login user=a 443 bob 203.0.113.7 curl/8.0 500 19 13 1203
+`
+
+func TestW3CParser(t *testing.T) {
+	var p W3CParser
+	var records []*W3CRecord
+	for _, line := range strings.SplitAfter(iisLog, "\n") {
+		r, err := p.ParseLine("u_ex240301.log", []byte(line))
+		if err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		if r != nil {
+			records = append(records, r)
+		}
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records", len(records))
+	}
+	r := records[1]
+	if want := time.Date(2024, 3, 1, 0, 0, 2, 0, time.UTC); !r.Time.Equal(want) {
+		t.Fatalf("time %v", r.Time)
+	}
+	if r.Software != "Microsoft Internet Information Services 10.0" || r.Version != "1.0" {
+		t.Fatalf("software %q %q", r.Software, r.Version)
+	}
+	for name, want := range map[string]interface{}{
+		"sc-status":      int64(500),
+		"s-port":         int64(443),
+		"time-taken":     int64(1203),
+		"cs-method":      "POST",
+		"cs-uri-query":   "user=a",
+		"cs(User-Agent)": "curl/8.0",
+		"time":           2 * time.Second,
+	} {
+		if v, _ := r.Field(name); v != want {
+			t.Errorf("%s = %#v, want %#v", name, v, want)
+		}
+	}
+	if v, ok := records[0].Field("cs-username"); !ok || v != nil {
+		t.Errorf("cs-username = %#v", v)
+	}
+
+	// The fields may change mid-file, and quoted strings hold spaces.
+	for _, line := range []string{
+		"#Fields: time c-ip cs-method sc-bytes x-comment",
+		`00:10:00.5 198.51.100.2 GET 1.5 "say ""hi"" there"`,
+	} {
+		var err error
+		r, err = p.ParseLine("u_ex240301.log", []byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := time.Date(2024, 3, 1, 0, 10, 0, 5e8, time.UTC); !r.Time.Equal(want) {
+		t.Fatalf("time %v", r.Time)
+	}
+	if v, _ := r.Field("x-comment"); v != `say "hi" there` {
+		t.Fatalf("comment %#v", v)
+	}
+	if v, _ := r.Field("sc-bytes"); v != 1.5 {
+		t.Fatalf("sc-bytes %#v", v)
+	}
+
+	// Each file has its own directives.
+	if _, err := p.ParseLine("other.log", []byte("2024-03-01 00:00:01 GET")); err == nil {
+		t.Fatal("parsed line without fields")
+	}
+	if _, err := p.ParseLine("u_ex240301.log", []byte("00:00:01 1.2.3.4")); err == nil {
+		t.Fatal("parsed line with missing fields")
+	}
+}
+
+func TestW3CParserDHCP(t *testing.T) {
+	loc := time.FixedZone("CET", 3600)
+	p := W3CParser{Location: loc}
+	log := "\t\tMicrosoft DHCP Service Activity Log\r\n\r\n" +
+		"Event ID  Meaning\r\n00\tThe log was started.\r\n\r\n" +
+		"ID,Date,Time,Description,IP Address,Host Name,MAC Address,User Name, TransactionID, QResult,Probationtime, CorrelationID,Dhcid\r\n" +
+		"10,03/01/24,08:15:30,Assign,192.168.1.50,laptop.corp.example,AABBCCDDEEFF,,123456,0,,,\r\n" +
+		"14,03/01/24,08:16:00,NACK,,,112233445566,,0,6,,,\r\n"
+	events := p.ParseEntry(&EventEntry{Name: `C:\Windows\System32\dhcp\DhcpSrvLog-Fri.log`, Buffer: []byte(log)})
+	if len(events) != 2 {
+		t.Fatalf("got %d events", len(events))
+	}
+	ev := events[0]
+	if ev.Provider != "dhcp" || ev.EventID != 10 || ev.Level != LevelInfo {
+		t.Fatalf("got %+v", ev)
+	}
+	if want := time.Date(2024, 3, 1, 8, 15, 30, 0, loc); !ev.TimeCreated.Equal(want) {
+		t.Fatalf("time %v", ev.TimeCreated)
+	}
+	for name, want := range map[string]string{"ip-address": "192.168.1.50", "host-name": "laptop.corp.example", "transactionid": "123456"} {
+		if v, _ := ev.Field(name); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+	if events[1].Level != LevelError {
+		t.Fatalf("level %v", events[1].Level)
+	}
+}
+
+func TestW3CParserEntry(t *testing.T) {
+	var p W3CParser
+	name := "/var/log/iis/u_ex240301.log"
+	// The file watcher sends the whole file; a partial line waits.
+	cut := strings.Index(iisLog, "2024-03-01 00:00:02") + 10
+	events := p.ParseEntry(&EventEntry{Name: name, Buffer: []byte(iisLog[:cut])})
+	if len(events) != 1 || events[0].Channel != name {
+		t.Fatalf("got %d events", len(events))
+	}
+	if v, _ := events[0].Field("cs-uri-stem"); v != "/index.html" {
+		t.Fatalf("cs-uri-stem %q", v)
+	}
+	if _, ok := events[0].Field("cs-username"); ok {
+		t.Fatal("placeholder stored")
+	}
+	events = p.ParseEntry(&EventEntry{Name: name, Buffer: []byte(iisLog)})
+	if len(events) != 1 || events[0].Level != LevelError {
+		t.Fatalf("got %+v", events)
+	}
+	if got := p.ParseEntry(&EventEntry{Name: name, Buffer: []byte(iisLog)}); got != nil {
+		t.Fatalf("got %d events again", len(got))
+	}
+	// A file that shrank was replaced and is read again.
+	events = p.ParseEntry(&EventEntry{Name: name, Buffer: []byte(iisLog[:strings.Index(iisLog, "2024-03-01 00:00:02")])})
+	var got []string
+	for _, ev := range events {
+		got = append(got, ev.Message)
+	}
+	if want := []string{strings.Split(iisLog, "\n")[4]}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestW3CParserReset(t *testing.T) {
+	var p W3CParser
+	name := "/var/log/iis/u_ex240301.log"
+	if got := p.ParseEntry(&EventEntry{Name: name, Buffer: []byte(iisLog)}); len(got) != 2 {
+		t.Fatalf("got %d events", len(got))
+	}
+	// A replacement larger than the old file is read from its start once
+	// the caller reset it.
+	p.Reset(name)
+	events := p.ParseEntry(&EventEntry{Name: name, Buffer: []byte(iisLog + iisLog)})
+	if len(events) != 4 {
+		t.Fatalf("got %d events after Reset", len(events))
+	}
+	if v, _ := events[0].Field("cs-uri-stem"); v != "/index.html" {
+		t.Fatalf("cs-uri-stem %q", v)
+	}
+}